@@ -0,0 +1,219 @@
+// Package client calls the dewallet chaincode through fabric-sdk-go,
+// building and signing its requests the way the chaincode verifies them
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-sdk-go/pkg/client/channel"
+)
+
+// Client calls the chaincode on a channel
+type Client struct {
+	channel   *channel.Client
+	chaincode string
+}
+
+// New returns a client of the chaincode named chaincode on the channel of cc
+func New(cc *channel.Client, chaincode string) *Client {
+	return &Client{channel: cc, chaincode: chaincode}
+}
+
+func toArgs(args []string) [][]byte {
+	b := make([][]byte, len(args))
+	for k, a := range args {
+		b[k] = []byte(a)
+	}
+
+	return b
+}
+
+// Invoke submits a transaction signed by s and decodes its result into res
+func (c *Client) Invoke(s *Signer, function string, req interface{}, res interface{}) error {
+	args, err := s.SignRequest(req)
+	if err != nil {
+		return err
+	}
+
+	r, err := c.channel.Execute(channel.Request{ChaincodeID: c.chaincode, Fcn: function, Args: toArgs(args)})
+	if err != nil {
+		return errors.New(fmt.Sprintf("%s failed %s", function, err))
+	}
+
+	return decode(function, r.Payload, res)
+}
+
+// Query evaluates a read-only function with its JSON request and decodes its result into res
+func (c *Client) Query(function string, req interface{}, res interface{}) error {
+	reqBytes, err := json.Marshal(req)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error in encoding request %s", err))
+	}
+
+	r, err := c.channel.Query(channel.Request{ChaincodeID: c.chaincode, Fcn: function, Args: [][]byte{reqBytes}})
+	if err != nil {
+		return errors.New(fmt.Sprintf("%s failed %s", function, err))
+	}
+
+	return decode(function, r.Payload, res)
+}
+
+func decode(function string, payload []byte, res interface{}) error {
+	if res == nil || len(payload) == 0 {
+		return nil
+	}
+
+	err := json.Unmarshal(payload, res)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error in parsing %s result %s", function, err))
+	}
+
+	return nil
+}
+
+// RegisterIdentity registers i, signed with the signing key of i,
+// its keys are changed with RotateKeys
+func (c *Client) RegisterIdentity(s *Signer, i Identity) error {
+	if i.SPublicKey == "" {
+		publicKey, err := s.PublicKey()
+		if err != nil {
+			return err
+		}
+		i.SPublicKey = publicKey
+	}
+
+	return c.Invoke(s, "Register", i, nil)
+}
+
+// RotateKeys replaces the keys of the user of s with those of req, signed
+// by s, and proves the possession of the new signing key with next
+func (c *Client) RotateKeys(s *Signer, next *Signer, req RotateKeysRequest) error {
+	if req.SPublicKey == "" {
+		publicKey, err := next.PublicKey()
+		if err != nil {
+			return err
+		}
+		req.SPublicKey = publicKey
+	}
+
+	proof, err := next.Sign(RotationProofMessage(req))
+	if err != nil {
+		return err
+	}
+	req.Proof = hex.EncodeToString(proof)
+	req.ProofAlg = next.Alg
+
+	return c.Invoke(s, "RotateKeys", req, nil)
+}
+
+// RotationProofMessage is what the new signing key signs, see RotateKeys,
+// and the new post-quantum key, see RotateKeysRequest.QProof
+func RotationProofMessage(req RotateKeysRequest) []byte {
+	fields, _ := json.Marshal(append([]string{req.PublicKey, req.EPublicKey, req.SPublicKey, req.QPublicKey, req.QAlgorithm}, req.CertificateChain...))
+	h := sha256.Sum256(fields)
+
+	return []byte(strings.Join([]string{"rotate", req.Username, hex.EncodeToString(h[:])}, "\n"))
+}
+
+// UpdateUserData replaces the data of the user signing
+func (c *Client) UpdateUserData(s *Signer, req UpdateUserDataRequest) error {
+	return c.Invoke(s, "UpdateUserData", req, nil)
+}
+
+// UploadUserData replaces the data of the user signing in chunks of
+// chunkSize bytes, for data too large for one transaction, req.Data
+// is split and req.DataHash is computed when empty
+func (c *Client) UploadUserData(s *Signer, req UpdateUserDataRequest, chunkSize int) error {
+	if chunkSize < 1 {
+		return errors.New("Chunk size must be positive")
+	}
+
+	if req.DataHash == "" {
+		h := sha256.Sum256([]byte(req.Data))
+		req.DataHash = hex.EncodeToString(h[:])
+	}
+
+	var u Upload
+	err := c.Invoke(s, "BeginDataUpload", map[string]interface{}{
+		"username":  req.Username,
+		"slot":      req.Slot,
+		"schema":    req.Schema,
+		"retention": req.Retention,
+		"size":      len(req.Data),
+		"dataHash":  req.DataHash,
+	}, &u)
+	if err != nil {
+		return err
+	}
+
+	chunks := 0
+	for start := 0; start < len(req.Data); start += chunkSize {
+		end := start + chunkSize
+		if end > len(req.Data) {
+			end = len(req.Data)
+		}
+
+		err = c.Invoke(s, "AppendDataChunk", map[string]interface{}{
+			"username": req.Username,
+			"uploadId": u.ID,
+			"index":    chunks,
+			"chunk":    req.Data[start:end],
+		}, nil)
+		if err != nil {
+			return err
+		}
+		chunks++
+	}
+
+	return c.Invoke(s, "CommitDataUpload", map[string]interface{}{
+		"username": req.Username,
+		"uploadId": u.ID,
+		"chunks":   chunks,
+	}, nil)
+}
+
+// ShareData gives the data of req.Username, the user signing, to req.Owner
+// with the data key wrapped for its encryption key
+func (c *Client) ShareData(s *Signer, req AddKeyRequest) (AddKeyResponse, error) {
+	var res AddKeyResponse
+	err := c.Invoke(s, "AddKey", req, &res)
+	return res, err
+}
+
+// GetPublicKey returns the public keys of a username or DID
+func (c *Client) GetPublicKey(username string) (GetPublicKeyResponse, error) {
+	var res GetPublicKeyResponse
+	err := c.Query("GetPublicKey", map[string]string{"username": username}, &res)
+	return res, err
+}
+
+// GetUserData reads the data of req.Username as req.Owner, the user
+// signing, with a fresh session token, the user itself when no owner is given
+func (c *Client) GetUserData(s *Signer, req GetUserDataRequest) (GetUserDataResponse, error) {
+	var res GetUserDataResponse
+
+	if req.Owner == "" {
+		req.Owner = req.Username
+	}
+
+	token, err := s.SessionToken(req.Owner)
+	if err != nil {
+		return res, err
+	}
+	req.Token = token
+
+	err = c.Query("GetUserData", req, &res)
+	return res, err
+}
+
+// GetVerificationSummary returns the verification level of a user
+func (c *Client) GetVerificationSummary(req GetVerificationSummaryRequest) (VerificationSummary, error) {
+	var res VerificationSummary
+	err := c.Query("GetVerificationSummary", req, &res)
+	return res, err
+}
@@ -0,0 +1,229 @@
+package client
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// signatureEncodingHex is how signatures are sent, the chaincode default
+const signatureEncodingHex = "hex"
+
+// nonceBytes is the size of the random nonce, hex encoded it stays within
+// the 64 characters the chaincode accepts
+const nonceBytes = 16
+
+// sessionAudience and sessionLifetime are what the chaincode
+// expects of a session token
+const (
+	sessionAudience = "dewallet"
+	sessionLifetime = 300
+)
+
+// Envelope is the first argument of every mutating function of the
+// chaincode, the second is the signature over its exact bytes
+type Envelope struct {
+	Alg               string          `json:"alg"`
+	KeyID             string          `json:"keyId"`
+	Nonce             string          `json:"nonce"`
+	Timestamp         int64           `json:"timestamp"`
+	SignatureEncoding string          `json:"signatureEncoding"`
+	Delegate          string          `json:"delegate,omitempty"`
+	Payload           json.RawMessage `json:"payload"`
+}
+
+// Signer signs requests with the signing key of an identity,
+// the key registered as its sPublicKey
+type Signer struct {
+	Alg string
+	Key crypto.Signer
+
+	// Delegate is set when signing on behalf of another user
+	Delegate string
+}
+
+var algHashes = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+	"PS256": crypto.SHA256,
+	"PS384": crypto.SHA384,
+	"PS512": crypto.SHA512,
+	"ES256": crypto.SHA256,
+	"ES384": crypto.SHA384,
+	"ES512": crypto.SHA512,
+	"EdDSA": 0,
+}
+
+// NewSigner picks the algorithm from the key, RS256, ES256, ES384, ES512 or EdDSA
+func NewSigner(key crypto.Signer) (*Signer, error) {
+	switch pk := key.Public().(type) {
+	case *rsa.PublicKey:
+		return &Signer{Alg: "RS256", Key: key}, nil
+	case *ecdsa.PublicKey:
+		return &Signer{Alg: fmt.Sprintf("ES%d", ecdsaHashBits(pk.Curve.Params().BitSize)), Key: key}, nil
+	case ed25519.PublicKey:
+		return &Signer{Alg: "EdDSA", Key: key}, nil
+	}
+
+	return nil, errors.New("Unsupported signing key")
+}
+
+func ecdsaHashBits(curveBits int) int {
+	if curveBits == 521 {
+		return 512
+	}
+
+	return curveBits
+}
+
+// PublicKey is the base64 PKIX encoding of the key, as registered
+func (s *Signer) PublicKey() (string, error) {
+	pkBytes, err := x509.MarshalPKIXPublicKey(s.Key.Public())
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Error in encoding public key %s", err))
+	}
+
+	return base64.StdEncoding.EncodeToString(pkBytes), nil
+}
+
+// KeyID is the fingerprint the chaincode identifies the key by
+func (s *Signer) KeyID() (string, error) {
+	publicKey, err := s.PublicKey()
+	if err != nil {
+		return "", err
+	}
+
+	pkBytes, _ := base64.StdEncoding.DecodeString(publicKey)
+	h := sha256.Sum256(pkBytes)
+	return hex.EncodeToString(h[:]), nil
+}
+
+// Sign signs m the way the chaincode verifies alg, ECDSA signatures
+// are the fixed size r and s rather than ASN.1
+func (s *Signer) Sign(m []byte) ([]byte, error) {
+	hash, ok := algHashes[s.Alg]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Unsupported signature algorithm %s", s.Alg))
+	}
+
+	digest := m
+	if hash != 0 {
+		h := hash.New()
+		h.Write(m)
+		digest = h.Sum(nil)
+	}
+
+	var opts crypto.SignerOpts = hash
+	if s.Alg[:2] == "PS" {
+		opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: hash}
+	}
+
+	sig, err := s.Key.Sign(rand.Reader, digest, opts)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error in signing %s", err))
+	}
+
+	if s.Alg[:2] != "ES" {
+		return sig, nil
+	}
+
+	var esig struct{ R, S *big.Int }
+	_, err = asn1.Unmarshal(sig, &esig)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error in decoding ECDSA signature %s", err))
+	}
+
+	size := (s.Key.Public().(*ecdsa.PublicKey).Curve.Params().BitSize + 7) / 8
+	raw := make([]byte, 2*size)
+	esig.R.FillBytes(raw[:size])
+	esig.S.FillBytes(raw[size:])
+
+	return raw, nil
+}
+
+// nonce is random so a request can't be replayed
+func nonce() (string, error) {
+	b := make([]byte, nonceBytes)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Error in generating nonce %s", err))
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// SignRequest wraps the request in an envelope with a fresh nonce and the
+// current time and signs it, the two strings are the function arguments.
+// The envelope is marshalled once and those bytes are signed, so
+// the chaincode verifies exactly what was signed
+func (s *Signer) SignRequest(req interface{}) ([]string, error) {
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error in encoding request %s", err))
+	}
+
+	keyID, err := s.KeyID()
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := nonce()
+	if err != nil {
+		return nil, err
+	}
+
+	envBytes, err := json.Marshal(Envelope{
+		Alg:               s.Alg,
+		KeyID:             keyID,
+		Nonce:             n,
+		Timestamp:         time.Now().Unix(),
+		SignatureEncoding: signatureEncodingHex,
+		Delegate:          s.Delegate,
+		Payload:           payload,
+	})
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error in encoding envelope %s", err))
+	}
+
+	sig, err := s.Sign(envBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{string(envBytes), hex.EncodeToString(sig)}, nil
+}
+
+// SessionToken is a JWT proving username holds the key, for the
+// queries that take a token
+func (s *Signer) SessionToken(username string) (string, error) {
+	now := time.Now().Unix()
+
+	h, _ := json.Marshal(map[string]string{"alg": s.Alg, "typ": "JWT"})
+	c, _ := json.Marshal(map[string]interface{}{
+		"sub": username,
+		"aud": sessionAudience,
+		"iat": now,
+		"exp": now + sessionLifetime,
+	})
+
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	sig, err := s.Sign([]byte(signed))
+	if err != nil {
+		return "", err
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
@@ -0,0 +1,166 @@
+package client
+
+// The requests and responses mirror those of the chaincode,
+// only the fields a client sets or reads are kept
+
+// Identity is the Register request, the chaincode sets
+// the org, creator and registration time
+type Identity struct {
+	Username   string `json:"username"`
+	PublicKey  string `json:"publicKey"`
+	EPublicKey string `json:"ePublicKey"`
+	SPublicKey string `json:"sPublicKey"`
+	QPublicKey string `json:"qPublicKey,omitempty"`
+	QAlgorithm string `json:"qAlgorithm,omitempty"`
+	Data       string `json:"data"`
+	DataHash   string `json:"dataHash"`
+	Collection string `json:"collection,omitempty"`
+
+	PublicAttributes map[string]string `json:"publicAttributes,omitempty"`
+	EmailHash        string            `json:"emailHash,omitempty"`
+	CertificateChain []string          `json:"certificateChain,omitempty"`
+	Commitments      map[string]string `json:"commitments,omitempty"`
+}
+
+// RotateKeysRequest are the new keys of a user, the proof
+// is filled in by Client.RotateKeys
+type RotateKeysRequest struct {
+	Username   string `json:"username"`
+	PublicKey  string `json:"publicKey"`
+	EPublicKey string `json:"ePublicKey"`
+	SPublicKey string `json:"sPublicKey"`
+	QPublicKey string `json:"qPublicKey,omitempty"`
+	QAlgorithm string `json:"qAlgorithm,omitempty"`
+
+	CertificateChain []string `json:"certificateChain,omitempty"`
+
+	Proof    string `json:"proof"`
+	ProofAlg string `json:"proofAlg"`
+
+	// QProof is the hex signature of RotationProofMessage with the new
+	// post-quantum key, required with a QPublicKey
+	QProof string `json:"qProof,omitempty"`
+}
+
+// SchemaRef names the schema data is written under
+type SchemaRef struct {
+	ID      string `json:"id"`
+	Version string `json:"version"`
+}
+
+// DataPointer declares data stored off-chain
+type DataPointer struct {
+	URI  string `json:"uri"`
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+}
+
+// UpdateUserDataRequest replaces the data of a user, of a slot when given
+type UpdateUserDataRequest struct {
+	Username  string       `json:"username"`
+	Slot      string       `json:"slot,omitempty"`
+	Data      string       `json:"data"`
+	DataHash  string       `json:"dataHash"`
+	Schema    SchemaRef    `json:"schema"`
+	Pointer   *DataPointer `json:"pointer,omitempty"`
+	Retention int64        `json:"retention,omitempty"`
+
+	Commitments map[string]string `json:"commitments,omitempty"`
+}
+
+// Upload is a data upload in progress, see Client.UploadUserData
+type Upload struct {
+	ID        string `json:"id"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+// ConsentTerms are recorded along a shared key
+type ConsentTerms struct {
+	Purpose   string `json:"purpose"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+// AddKeyRequest shares the data of Username with Owner, Key is the data
+// key wrapped for the encryption key of Owner
+type AddKeyRequest struct {
+	Username  string `json:"username"`
+	Owner     string `json:"owner"`
+	Key       string `json:"key"`
+	KeyHash   string `json:"keyHash"`
+	Attribute string `json:"attribute,omitempty"`
+	Slot      string `json:"slot,omitempty"`
+	NotBefore int64  `json:"notBefore,omitempty"`
+	NotAfter  int64  `json:"notAfter,omitempty"`
+	Purpose   string `json:"purpose,omitempty"`
+
+	Consent *ConsentTerms `json:"consent,omitempty"`
+}
+
+// AddKeyResponse is the key as stored, Evicted are the keys removed
+// to make room for it when the identity was at its grant cap
+type AddKeyResponse struct {
+	Owner   string         `json:"owner"`
+	Key     string         `json:"key"`
+	Evicted []EvictedGrant `json:"evicted,omitempty"`
+}
+
+// EvictedGrant is a key removed at the grant cap
+type EvictedGrant struct {
+	Username  string `json:"username"`
+	Owner     string `json:"owner"`
+	Attribute string `json:"attribute,omitempty"`
+	Slot      string `json:"slot"`
+	NotAfter  int64  `json:"notAfter"`
+}
+
+// GetPublicKeyResponse are the public keys of a user, Channel is
+// the sibling channel it was found on
+type GetPublicKeyResponse struct {
+	PublicKey  string `json:"publicKey"`
+	EPublicKey string `json:"ePublicKey"`
+	Channel    string `json:"channel,omitempty"`
+}
+
+// GetUserDataRequest reads the data of Username as Owner,
+// Token is a session of Owner, required with an Owner. Fields are the JSON names of the
+// fields of the response to return, every field when empty
+type GetUserDataRequest struct {
+	Username string   `json:"username"`
+	Slot     string   `json:"slot,omitempty"`
+	Owner    string   `json:"owner,omitempty"`
+	Token    string   `json:"token"`
+	Purpose  string   `json:"purpose,omitempty"`
+	Fields   []string `json:"fields,omitempty"`
+}
+
+// GetUserDataResponse is the encrypted data and the wrapped key to read it
+type GetUserDataResponse struct {
+	PublicKey  string            `json:"publicKey"`
+	EPublicKey string            `json:"ePublicKey"`
+	SPublicKey string            `json:"sPublicKey"`
+	Data       string            `json:"data"`
+	DataHash   string            `json:"dataHash"`
+	Key        string            `json:"key"`
+	NoGrant    bool              `json:"noGrant,omitempty"`
+	Pointer    *DataPointer      `json:"pointer,omitempty"`
+	Entries    []string          `json:"entries,omitempty"`
+	Attributes map[string]string `json:"attributes"`
+}
+
+// GetVerificationSummaryRequest asks the level of Username by the
+// verifiers the relying party trusts, every verifier when empty
+type GetVerificationSummaryRequest struct {
+	Username  string   `json:"username"`
+	Verifiers []string `json:"verifiers,omitempty"`
+	MinLevel  string   `json:"minLevel,omitempty"`
+}
+
+// VerificationSummary is the verification status of a user,
+// ExpiresAt is when its level drops, never when 0
+type VerificationSummary struct {
+	Username     string   `json:"username"`
+	Level        string   `json:"level"`
+	VerifierOrgs []string `json:"verifierOrgs"`
+	ExpiresAt    int64    `json:"expiresAt"`
+	MeetsLevel   bool     `json:"meetsLevel"`
+}
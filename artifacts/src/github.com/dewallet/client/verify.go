@@ -0,0 +1,74 @@
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// Verify checks the signature sig of m with a base64 PKIX public key the way
+// the chaincode does for alg, to check what a client signs before sending it
+func Verify(alg string, publicKey string, m []byte, sig []byte) error {
+	hash, ok := algHashes[alg]
+	if !ok {
+		return errors.New(fmt.Sprintf("Unsupported signature algorithm %s", alg))
+	}
+
+	pkBytes, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error in decoding public key %s", err))
+	}
+
+	pk, err := x509.ParsePKIXPublicKey(pkBytes)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error in parsing public key %s", err))
+	}
+
+	digest := m
+	if hash != 0 {
+		h := hash.New()
+		h.Write(m)
+		digest = h.Sum(nil)
+	}
+
+	switch pk := pk.(type) {
+	case *rsa.PublicKey:
+		if alg[:2] == "PS" {
+			return rsa.VerifyPSS(pk, hash, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+		if alg[:2] != "RS" {
+			return errors.New(fmt.Sprintf("Key is not for %s", alg))
+		}
+		return rsa.VerifyPKCS1v15(pk, hash, digest, sig)
+	case *ecdsa.PublicKey:
+		bits := pk.Curve.Params().BitSize
+		if alg != fmt.Sprintf("ES%d", ecdsaHashBits(bits)) {
+			return errors.New(fmt.Sprintf("Key curve does not match %s", alg))
+		}
+		size := (bits + 7) / 8
+		if len(sig) != 2*size {
+			return errors.New("Invalid ECDSA signature length")
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(pk, digest, r, s) {
+			return errors.New("ECDSA signature mismatch")
+		}
+		return nil
+	case ed25519.PublicKey:
+		if alg != "EdDSA" {
+			return errors.New(fmt.Sprintf("Key is not for %s", alg))
+		}
+		if !ed25519.Verify(pk, m, sig) {
+			return errors.New("Ed25519 signature mismatch")
+		}
+		return nil
+	}
+
+	return errors.New("Unsupported public key")
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Key slots of an identity kept in the keystore, named as the chaincode names them
+const (
+	slotIdentity   = "publicKey"
+	slotEncryption = "ePublicKey"
+	slotSigning    = "sPublicKey"
+)
+
+// keystore keeps the private keys of users as PKCS #8 PEM files,
+// one directory per user and one file per key slot
+type keystore struct {
+	dir string
+}
+
+func (ks keystore) path(username string, slot string) string {
+	return filepath.Join(ks.dir, username, slot+".pem")
+}
+
+// generateKey makes a key for the slot, RSA for the encryption key since
+// it wraps data keys and P-256 for the others
+func generateKey(slot string) (crypto.Signer, error) {
+	if slot == slotEncryption {
+		return rsa.GenerateKey(rand.Reader, 2048)
+	}
+
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+// load reads the key of the slot of username
+func (ks keystore) load(username string, slot string) (crypto.Signer, error) {
+	b, err := ioutil.ReadFile(ks.path(username, slot))
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New(fmt.Sprintf("%s of %s is not PEM", slot, username))
+	}
+
+	k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error in parsing %s of %s %s", slot, username, err))
+	}
+
+	s, ok := k.(crypto.Signer)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("%s of %s can't sign", slot, username))
+	}
+
+	return s, nil
+}
+
+// save writes the key of the slot of username, readable by the owner only
+func (ks keystore) save(username string, slot string, k crypto.Signer) error {
+	der, err := x509.MarshalPKCS8PrivateKey(k)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Join(ks.dir, username), 0700)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(ks.path(username, slot), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+}
+
+// loadOrGenerate reads the key of the slot of username, generating
+// and saving one when there is none yet
+func (ks keystore) loadOrGenerate(username string, slot string) (crypto.Signer, error) {
+	k, err := ks.load(username, slot)
+	if err == nil || !os.IsNotExist(err) {
+		return k, err
+	}
+
+	k, err = generateKey(slot)
+	if err != nil {
+		return nil, err
+	}
+
+	return k, ks.save(username, slot, k)
+}
+
+// publicKey is the base64 PKIX encoding the chaincode registers
+func publicKey(k crypto.Signer) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(k.Public())
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(der), nil
+}
@@ -0,0 +1,325 @@
+// Command dewallet-cli registers identities and shares and reads their
+// data through the dewallet chaincode, for operators and integration tests.
+//
+//	dewallet-cli [-profile connection.yaml -channel mychannel ...] command [flags]
+//
+// Keys are generated on register and kept in the keystore directory.
+package main
+
+import (
+	"crypto"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dewallet/client"
+	"github.com/hyperledger/fabric-sdk-go/pkg/client/channel"
+	"github.com/hyperledger/fabric-sdk-go/pkg/core/config"
+	"github.com/hyperledger/fabric-sdk-go/pkg/fabsdk"
+)
+
+var (
+	profile   = flag.String("profile", "connection.yaml", "connection profile of the network")
+	channelID = flag.String("channel", "mychannel", "channel the chaincode is on")
+	chaincode = flag.String("chaincode", "dewallet", "name of the chaincode")
+	org       = flag.String("org", "Org1", "org of the Fabric user")
+	user      = flag.String("user", "User1", "Fabric user submitting the transactions")
+	keysDir   = flag.String("keystore", filepath.Join(os.Getenv("HOME"), ".dewallet"), "directory of the identity keys")
+)
+
+// commands by name, each parses its own flags
+var commands = map[string]func(c *client.Client, ks keystore, args []string) error{
+	"register":   register,
+	"rotate-key": rotateKey,
+	"add-key":    addKey,
+	"get-data":   getData,
+	"verify":     verify,
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: dewallet-cli [flags] register|rotate-key|add-key|get-data|verify [command flags]")
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+
+	sdk, err := fabsdk.New(config.FromFile(*profile))
+	if err != nil {
+		fail(errors.New(fmt.Sprintf("Error in loading connection profile %s", err)))
+	}
+	defer sdk.Close()
+
+	cc, err := channel.New(sdk.ChannelContext(*channelID, fabsdk.WithUser(*user), fabsdk.WithOrg(*org)))
+	if err != nil {
+		fail(errors.New(fmt.Sprintf("Error in connecting to channel %s", err)))
+	}
+
+	err = cmd(client.New(cc, *chaincode), keystore{dir: *keysDir}, flag.Args()[1:])
+	if err != nil {
+		fail(err)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
+
+// printJSON writes a result as indented JSON
+func printJSON(v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(b))
+	return nil
+}
+
+// signer signs as username with its signing key
+func signer(ks keystore, username string) (*client.Signer, error) {
+	k, err := ks.load(username, slotSigning)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("No signing key for %s %s", username, err))
+	}
+
+	return client.NewSigner(k)
+}
+
+// identity is the Register request of username with the keys
+// of the keystore, the keys given replacing those of their slot
+func identity(ks keystore, username string, keys map[string]crypto.Signer) (client.Identity, error) {
+	i := client.Identity{Username: username}
+
+	for slot, pk := range map[string]*string{
+		slotIdentity:   &i.PublicKey,
+		slotEncryption: &i.EPublicKey,
+		slotSigning:    &i.SPublicKey,
+	} {
+		var err error
+		k, ok := keys[slot]
+		if !ok {
+			k, err = ks.loadOrGenerate(username, slot)
+			if err != nil {
+				return i, err
+			}
+		}
+
+		*pk, err = publicKey(k)
+		if err != nil {
+			return i, err
+		}
+	}
+
+	return i, nil
+}
+
+func register(c *client.Client, ks keystore, args []string) error {
+	fs := flag.NewFlagSet("register", flag.ExitOnError)
+	username := fs.String("username", "", "username to register")
+	dataFile := fs.String("data", "", "file of the encrypted data of the user")
+	emailHash := fs.String("email-hash", "", "hex SHA-256 of the lowercased email")
+	fs.Parse(args)
+
+	if *username == "" {
+		return errors.New("Missing -username")
+	}
+
+	i, err := identity(ks, *username, nil)
+	if err != nil {
+		return err
+	}
+	i.EmailHash = *emailHash
+
+	if *dataFile != "" {
+		data, err := ioutil.ReadFile(*dataFile)
+		if err != nil {
+			return err
+		}
+		i.Data = string(data)
+	}
+
+	s, err := signer(ks, *username)
+	if err != nil {
+		return err
+	}
+
+	err = c.RegisterIdentity(s, i)
+	if err != nil {
+		return err
+	}
+
+	return printJSON(i)
+}
+
+// rotateKey replaces a key of the user in a slot, signed by the current
+// signing key, the keystore is only changed once the chaincode accepted the key
+func rotateKey(c *client.Client, ks keystore, args []string) error {
+	fs := flag.NewFlagSet("rotate-key", flag.ExitOnError)
+	username := fs.String("username", "", "username whose key rotates")
+	slot := fs.String("slot", slotSigning, "slot of the key, publicKey, ePublicKey or sPublicKey")
+	fs.Parse(args)
+
+	if *username == "" {
+		return errors.New("Missing -username")
+	}
+	if *slot != slotIdentity && *slot != slotEncryption && *slot != slotSigning {
+		return errors.New(fmt.Sprintf("Unknown slot %s", *slot))
+	}
+
+	k, err := generateKey(*slot)
+	if err != nil {
+		return err
+	}
+
+	i, err := identity(ks, *username, map[string]crypto.Signer{*slot: k})
+	if err != nil {
+		return err
+	}
+
+	s, err := signer(ks, *username)
+	if err != nil {
+		return err
+	}
+	next := s
+	if *slot == slotSigning {
+		next, err = client.NewSigner(k)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = c.RotateKeys(s, next, client.RotateKeysRequest{
+		Username:   i.Username,
+		PublicKey:  i.PublicKey,
+		EPublicKey: i.EPublicKey,
+		SPublicKey: i.SPublicKey,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = ks.save(*username, *slot, k)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Key was rotated but not saved %s", err))
+	}
+
+	return printJSON(i)
+}
+
+func addKey(c *client.Client, ks keystore, args []string) error {
+	fs := flag.NewFlagSet("add-key", flag.ExitOnError)
+	username := fs.String("username", "", "user sharing its data")
+	owner := fs.String("for", "", "user the data is shared with")
+	key := fs.String("key", "", "data key wrapped for the encryption key of the user it is for")
+	attribute := fs.String("attribute", "", "attribute the key is for")
+	slot := fs.String("slot", "", "data slot the key is for")
+	purpose := fs.String("purpose", "", "purpose of the sharing")
+	notAfter := fs.Int64("not-after", 0, "unix time the key expires at")
+	fs.Parse(args)
+
+	if *username == "" || *owner == "" || *key == "" {
+		return errors.New("Missing -username, -for or -key")
+	}
+
+	s, err := signer(ks, *username)
+	if err != nil {
+		return err
+	}
+
+	res, err := c.ShareData(s, client.AddKeyRequest{
+		Username:  *username,
+		Owner:     *owner,
+		Key:       *key,
+		Attribute: *attribute,
+		Slot:      *slot,
+		Purpose:   *purpose,
+		NotAfter:  *notAfter,
+	})
+	if err != nil {
+		return err
+	}
+
+	return printJSON(res)
+}
+
+func getData(c *client.Client, ks keystore, args []string) error {
+	fs := flag.NewFlagSet("get-data", flag.ExitOnError)
+	username := fs.String("username", "", "user whose data is read")
+	as := fs.String("as", "", "user reading the data, the user itself when not given")
+	slot := fs.String("slot", "", "data slot to read")
+	purpose := fs.String("purpose", "", "purpose of the read")
+	fs.Parse(args)
+
+	if *username == "" {
+		return errors.New("Missing -username")
+	}
+	if *as == "" {
+		*as = *username
+	}
+
+	s, err := signer(ks, *as)
+	if err != nil {
+		return err
+	}
+
+	res, err := c.GetUserData(s, client.GetUserDataRequest{Username: *username, Owner: *as, Slot: *slot, Purpose: *purpose})
+	if err != nil {
+		return err
+	}
+
+	return printJSON(res)
+}
+
+// verify prints the verification level of a user, and fails
+// when a level is required that the user doesn't meet
+func verify(c *client.Client, ks keystore, args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	username := fs.String("username", "", "user to check")
+	verifiers := fs.String("verifiers", "", "comma separated verifiers trusted, every verifier when not given")
+	minLevel := fs.String("min-level", "", "level required")
+	fs.Parse(args)
+
+	if *username == "" {
+		return errors.New("Missing -username")
+	}
+
+	req := client.GetVerificationSummaryRequest{Username: *username, MinLevel: *minLevel}
+	if *verifiers != "" {
+		req.Verifiers = strings.Split(*verifiers, ",")
+	}
+
+	res, err := c.GetVerificationSummary(req)
+	if err != nil {
+		return err
+	}
+
+	err = printJSON(res)
+	if err != nil {
+		return err
+	}
+
+	if *minLevel != "" && !res.MeetsLevel {
+		return errors.New(fmt.Sprintf("%s doesn't meet level %s", *username, *minLevel))
+	}
+
+	return nil
+}
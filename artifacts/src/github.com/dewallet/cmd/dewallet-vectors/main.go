@@ -0,0 +1,361 @@
+// Command dewallet-vectors checks the golden signature vectors of the
+// dewallet chaincode, signed envelopes of fixture identities for every
+// algorithm a request may declare and every post-quantum algorithm of a
+// hybrid identity, so client authors can check their envelopes and
+// signatures without a network. The chaincode runs the same vectors
+// through Register in its tests, see vectors_test.go.
+//
+//	dewallet-vectors [-vectors testdata/vectors.json] [-generate]
+//
+// With -generate new fixture keys are made and the vectors written again.
+// The envelopes have a fixed nonce and timestamp, the chaincode would
+// reject them as stale, they only pin the signing and encoding.
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudflare/circl/sign"
+	"github.com/cloudflare/circl/sign/mldsa/mldsa44"
+	"github.com/cloudflare/circl/sign/mldsa/mldsa65"
+	"github.com/cloudflare/circl/sign/mldsa/mldsa87"
+	"github.com/dewallet/client"
+)
+
+var (
+	vectorsPath = flag.String("vectors", filepath.Join("testdata", "vectors.json"), "file of the vectors")
+	generate    = flag.Bool("generate", false, "generate new fixture keys and vectors")
+)
+
+// Fixed values of the envelopes of the vectors
+const (
+	fixtureNonce     = "000102030405060708090a0b0c0d0e0f"
+	fixtureTimestamp = 1700000000
+)
+
+// vector is a signed Register envelope of a fixture identity, PrivateKey is
+// the base64 PKCS #8 of its signing key, a fixture never used elsewhere.
+// PublicKey is its identity and signing key, EPublicKey a P-256 encryption key
+type vector struct {
+	Alg        string `json:"alg"`
+	Username   string `json:"username"`
+	PublicKey  string `json:"publicKey"`
+	EPublicKey string `json:"ePublicKey"`
+	PrivateKey string `json:"privateKey"`
+	KeyID      string `json:"keyId"`
+
+	// QAlgorithm, QPublicKey and QPrivateKey are the post-quantum key of a
+	// hybrid identity, in the raw encoding of the algorithm, base64
+	QAlgorithm  string `json:"qAlgorithm,omitempty"`
+	QPublicKey  string `json:"qPublicKey,omitempty"`
+	QPrivateKey string `json:"qPrivateKey,omitempty"`
+
+	// Envelope is the first argument of Register and Signature,
+	// hex encoded, the second, signed over its exact bytes. QSignature is
+	// the post-quantum signature of a hybrid identity, the third
+	Envelope   string `json:"envelope"`
+	Signature  string `json:"signature"`
+	QSignature string `json:"qSignature,omitempty"`
+}
+
+// algorithms are the algorithms of the signing keys of the vectors
+var algorithms = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// postQuantumSchemes are the algorithms of the post-quantum keys of the
+// vectors of hybrid identities, their signing keys are ES256
+var postQuantumSchemes = []sign.Scheme{mldsa44.Scheme(), mldsa65.Scheme(), mldsa87.Scheme()}
+
+// hybridAlg is the algorithm of the signing keys of the hybrid identities
+const hybridAlg = "ES256"
+
+func generateKey(alg string) (crypto.Signer, error) {
+	switch alg[:2] {
+	case "RS", "PS":
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case "ES":
+		curves := map[string]elliptic.Curve{"ES256": elliptic.P256(), "ES384": elliptic.P384(), "ES512": elliptic.P521()}
+		return ecdsa.GenerateKey(curves[alg], rand.Reader)
+	}
+
+	_, k, err := ed25519.GenerateKey(rand.Reader)
+	return k, err
+}
+
+// newVector signs the Register envelope of a fixture identity with a new key,
+// and with a new post-quantum key when scheme is given
+func newVector(alg string, scheme sign.Scheme) (vector, error) {
+	k, err := generateKey(alg)
+	if err != nil {
+		return vector{}, err
+	}
+
+	ek, err := generateKey("ES256")
+	if err != nil {
+		return vector{}, err
+	}
+	ePublicKey, err := (&client.Signer{Key: ek}).PublicKey()
+	if err != nil {
+		return vector{}, err
+	}
+
+	s := &client.Signer{Alg: alg, Key: k}
+
+	publicKey, err := s.PublicKey()
+	if err != nil {
+		return vector{}, err
+	}
+
+	keyID, err := s.KeyID()
+	if err != nil {
+		return vector{}, err
+	}
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(k)
+	if err != nil {
+		return vector{}, err
+	}
+
+	v := vector{
+		Alg:        alg,
+		Username:   "fixture-" + alg,
+		PublicKey:  publicKey,
+		EPublicKey: ePublicKey,
+		PrivateKey: base64.StdEncoding.EncodeToString(pkcs8),
+		KeyID:      keyID,
+	}
+
+	var qk sign.PrivateKey
+	if scheme != nil {
+		qpk, qsk, err := scheme.GenerateKey()
+		if err != nil {
+			return vector{}, err
+		}
+		qk = qsk
+
+		qpkBytes, _ := qpk.MarshalBinary()
+		qskBytes, _ := qsk.MarshalBinary()
+
+		v.Username = "fixture-" + scheme.Name()
+		v.QAlgorithm = scheme.Name()
+		v.QPublicKey = base64.StdEncoding.EncodeToString(qpkBytes)
+		v.QPrivateKey = base64.StdEncoding.EncodeToString(qskBytes)
+	}
+
+	payload, _ := json.Marshal(client.Identity{
+		Username:   v.Username,
+		PublicKey:  publicKey,
+		EPublicKey: ePublicKey,
+		SPublicKey: publicKey,
+		QPublicKey: v.QPublicKey,
+		QAlgorithm: v.QAlgorithm,
+		DataHash:   hex.EncodeToString(make([]byte, sha256.Size)),
+	})
+	envBytes, _ := json.Marshal(client.Envelope{
+		Alg:               alg,
+		KeyID:             keyID,
+		Nonce:             fixtureNonce,
+		Timestamp:         fixtureTimestamp,
+		SignatureEncoding: "hex",
+		Payload:           payload,
+	})
+	v.Envelope = string(envBytes)
+
+	sig, err := s.Sign(envBytes)
+	if err != nil {
+		return vector{}, err
+	}
+	v.Signature = hex.EncodeToString(sig)
+
+	if scheme != nil {
+		v.QSignature = hex.EncodeToString(scheme.Sign(qk, envBytes, nil))
+	}
+
+	return v, nil
+}
+
+// schemeByName is the post-quantum scheme of the vectors named name
+func schemeByName(name string) (sign.Scheme, error) {
+	for _, scheme := range postQuantumSchemes {
+		if scheme.Name() == name {
+			return scheme, nil
+		}
+	}
+
+	return nil, errors.New(fmt.Sprintf("Unsupported post-quantum algorithm %s", name))
+}
+
+// checkPostQuantum verifies the post-quantum signature of a hybrid vector,
+// and that its key pair agrees
+func checkPostQuantum(v vector) error {
+	scheme, err := schemeByName(v.QAlgorithm)
+	if err != nil {
+		return err
+	}
+
+	qpkBytes, err := base64.StdEncoding.DecodeString(v.QPublicKey)
+	if err != nil {
+		return err
+	}
+	qpk, err := scheme.UnmarshalBinaryPublicKey(qpkBytes)
+	if err != nil {
+		return err
+	}
+
+	qskBytes, err := base64.StdEncoding.DecodeString(v.QPrivateKey)
+	if err != nil {
+		return err
+	}
+	qsk, err := scheme.UnmarshalBinaryPrivateKey(qskBytes)
+	if err != nil {
+		return err
+	}
+	if !qpk.Equal(qsk.Public()) {
+		return errors.New("Post-quantum public key doesn't match the private key")
+	}
+
+	sig, err := hex.DecodeString(v.QSignature)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error in decoding post-quantum signature %s", err))
+	}
+	if !scheme.Verify(qpk, []byte(v.Envelope), sig, nil) {
+		return errors.New(fmt.Sprintf("%s signature mismatch", scheme.Name()))
+	}
+
+	return nil
+}
+
+// check verifies a vector as the chaincode would, and that its
+// key pair and key id agree
+func check(v vector) error {
+	pkcs8, err := base64.StdEncoding.DecodeString(v.PrivateKey)
+	if err != nil {
+		return err
+	}
+
+	k, err := x509.ParsePKCS8PrivateKey(pkcs8)
+	if err != nil {
+		return err
+	}
+
+	signer, ok := k.(crypto.Signer)
+	if !ok {
+		return errors.New("Private key can't sign")
+	}
+
+	s := &client.Signer{Alg: v.Alg, Key: signer}
+
+	publicKey, err := s.PublicKey()
+	if err != nil {
+		return err
+	}
+	if publicKey != v.PublicKey {
+		return errors.New("Public key doesn't match the private key")
+	}
+
+	keyID, _ := s.KeyID()
+	if keyID != v.KeyID {
+		return errors.New("Key id isn't the fingerprint of the public key")
+	}
+
+	var env client.Envelope
+	err = json.Unmarshal([]byte(v.Envelope), &env)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error in parsing envelope %s", err))
+	}
+	if env.Alg != v.Alg || env.KeyID != v.KeyID {
+		return errors.New("Envelope doesn't declare the algorithm and key of the vector")
+	}
+
+	sig, err := hex.DecodeString(v.Signature)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error in decoding signature %s", err))
+	}
+
+	err = client.Verify(v.Alg, v.PublicKey, []byte(v.Envelope), sig)
+	if err != nil || v.QAlgorithm == "" {
+		return err
+	}
+
+	return checkPostQuantum(v)
+}
+
+// name is the algorithm a vector covers
+func (v vector) name() string {
+	return strings.TrimSpace(v.Alg + " " + v.QAlgorithm)
+}
+
+func main() {
+	flag.Parse()
+
+	if *generate {
+		vectors := []vector{}
+		for _, alg := range algorithms {
+			v, err := newVector(alg, nil)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, alg, err)
+				os.Exit(1)
+			}
+			vectors = append(vectors, v)
+		}
+		for _, scheme := range postQuantumSchemes {
+			v, err := newVector(hybridAlg, scheme)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, scheme.Name(), err)
+				os.Exit(1)
+			}
+			vectors = append(vectors, v)
+		}
+
+		b, _ := json.MarshalIndent(vectors, "", "  ")
+		err := ioutil.WriteFile(*vectorsPath, append(b, '\n'), 0644)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	b, err := ioutil.ReadFile(*vectorsPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	var vectors []vector
+	err = json.Unmarshal(b, &vectors)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	failed := false
+	for _, v := range vectors {
+		err = check(v)
+		if err != nil {
+			fmt.Printf("FAIL %s %s\n", v.name(), err)
+			failed = true
+			continue
+		}
+		fmt.Printf("ok   %s\n", v.name())
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
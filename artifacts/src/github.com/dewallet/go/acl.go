@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// aclObjectType is the composite key of the access control list
+const aclObjectType = "acl"
+
+// ACLRule is what the creator of a transaction needs to call a function,
+// every condition that is set must hold
+type ACLRule struct {
+	// Admin requires an admin, see requireAdmin
+	Admin bool `json:"admin"`
+	// MSPs are the orgs allowed to call the function
+	MSPs []string `json:"msps"`
+	// Attributes are the certificate attributes required with their value
+	Attributes map[string]string `json:"attributes"`
+}
+
+// ACL maps function names to their rule,
+// functions without a rule can be called by any channel member
+type ACL map[string]ACLRule
+
+// getACL reads the access control list, empty when none was set,
+// a tenant without a list of its own has the deployment one like with getConfig
+func getACL(stub shim.ChaincodeStubInterface) (ACL, error) {
+	acl := ACL{}
+
+	key, err := stub.CreateCompositeKey(aclObjectType, []string{})
+	if err != nil {
+		return acl, err
+	}
+
+	aBytes, err := stub.GetState(key)
+	if err != nil {
+		return acl, errors.New("Failed to get state")
+	}
+	if aBytes == nil {
+		if ts, ok := tenantOf(stub); ok {
+			return getACL(ts.ChaincodeStubInterface)
+		}
+		return acl, nil
+	}
+
+	err = json.Unmarshal(aBytes, &acl)
+	if err != nil {
+		return acl, errors.New(fmt.Sprintf("Error in parsing ACL %s", err))
+	}
+
+	return acl, nil
+}
+
+// checkACL checks the rule of the function against the creator of the transaction
+func (t *DewalletChaincode) checkACL(stub shim.ChaincodeStubInterface, function string) error {
+	acl, err := getACL(stub)
+	if err != nil {
+		return err
+	}
+
+	r, ok := acl[function]
+	if !ok {
+		return nil
+	}
+
+	if r.Admin {
+		err = t.requireAdmin(stub)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(r.MSPs) > 0 {
+		mspID, err := cid.GetMSPID(stub)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Failed to get MSP ID %s", err))
+		}
+		if !containsString(r.MSPs, mspID) {
+			return errors.New(fmt.Sprintf("MSP %s can't call %s", mspID, function))
+		}
+	}
+
+	for name, value := range r.Attributes {
+		err = cid.AssertAttributeValue(stub, name, value)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Client can't call %s %s", function, err))
+		}
+	}
+
+	return nil
+}
+
+// SetACL will replace the access control list, admin only
+func (t *DewalletChaincode) SetACL(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Setting access control list")
+
+	err := t.requireAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var acl ACL
+	err = json.Unmarshal([]byte(args[0]), &acl)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't parse ACL %s", err))
+	}
+
+	key, err := stub.CreateCompositeKey(aclObjectType, []string{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	aBytes, _ := marshal(acl)
+	err = stub.PutState(key, aBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(aBytes)
+}
+
+// GetACL will query the blockchain
+// and return the access control list
+func (t *DewalletChaincode) GetACL(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Querying access control list")
+
+	acl, err := getACL(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	aBytes, _ := marshal(acl)
+
+	return shim.Success(aBytes)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// requireAdmin checks that the creator of the transaction belongs to an admin org
+// and, when an admin attribute is configured, that its certificate has the attribute
+func (t *DewalletChaincode) requireAdmin(stub shim.ChaincodeStubInterface) error {
+	c, err := getConfig(stub)
+	if err != nil {
+		return err
+	}
+
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Failed to get MSP ID %s", err))
+	}
+
+	if !containsString(c.AdminMSPs, mspID) {
+		return errors.New(fmt.Sprintf("MSP %s is not an admin org", mspID))
+	}
+
+	if c.AdminAttribute == "" {
+		return nil
+	}
+
+	// attributes are issued by the CA of each org, they are only
+	// trusted from the admin orgs
+	err = cid.AssertAttributeValue(stub, c.AdminAttribute, "true")
+	if err != nil {
+		return errors.New(fmt.Sprintf("Client is not an admin %s", err))
+	}
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/cloudflare/circl/sign"
+	"github.com/cloudflare/circl/sign/mldsa/mldsa44"
+	"github.com/cloudflare/circl/sign/mldsa/mldsa65"
+	"github.com/cloudflare/circl/sign/mldsa/mldsa87"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// defaultSignatureAlgorithm is used when a request declares no algorithm,
+// it is the original PKCS #1 v1.5 with SHA-256 scheme
+const defaultSignatureAlgorithm = "RS256"
+
+// signatureVerifier verifies the signature s of the message m with
+// a base64 public key in the encoding expected by its algorithm
+type signatureVerifier func(stub shim.ChaincodeStubInterface, publicKey string, m []byte, s []byte) error
+
+// signatureAlgorithms is the registry of algorithms a request
+// may declare in its signatureAlgorithm field
+var signatureAlgorithms = map[string]signatureVerifier{}
+
+// postQuantumAlgorithms are the registered algorithms accepted
+// for the post-quantum key of a hybrid identity
+var postQuantumAlgorithms = map[string]sign.Scheme{}
+
+func init() {
+	for alg := range jwsHashes {
+		registerSignatureAlgorithm(alg, pkixVerifier(alg))
+	}
+
+	for _, scheme := range []sign.Scheme{mldsa44.Scheme(), mldsa65.Scheme(), mldsa87.Scheme()} {
+		registerSignatureAlgorithm(scheme.Name(), schemeVerifier(scheme))
+		postQuantumAlgorithms[scheme.Name()] = scheme
+	}
+}
+
+// registerSignatureAlgorithm adds an algorithm to the registry
+func registerSignatureAlgorithm(name string, v signatureVerifier) {
+	if _, ok := signatureAlgorithms[name]; ok {
+		panic(fmt.Sprintf("signature algorithm %s registered twice", name))
+	}
+
+	signatureAlgorithms[name] = v
+}
+
+// verifyWithAlgorithm looks up the algorithm in the registry and verifies with it
+func verifyWithAlgorithm(stub shim.ChaincodeStubInterface, alg string, publicKey string, m []byte, s []byte) error {
+	if alg == "" {
+		alg = defaultSignatureAlgorithm
+	}
+
+	v, ok := signatureAlgorithms[alg]
+	if !ok {
+		return errors.New(fmt.Sprintf("Unsupported signature algorithm %s", alg))
+	}
+
+	err := v(stub, publicKey, m, s)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error in verifying signature %s", err))
+	}
+
+	return nil
+}
+
+// pkixVerifier verifies with a JWS algorithm and a PKIX public key
+func pkixVerifier(alg string) signatureVerifier {
+	return func(stub shim.ChaincodeStubInterface, publicKey string, m []byte, s []byte) error {
+		pk, err := parsePublicKey(stub, publicKey)
+		if err != nil {
+			return err
+		}
+
+		return verifyJWSSignature(alg, pk, m, s)
+	}
+}
+
+// schemeVerifier verifies with a circl scheme and its raw public key encoding
+func schemeVerifier(scheme sign.Scheme) signatureVerifier {
+	return func(stub shim.ChaincodeStubInterface, publicKey string, m []byte, s []byte) error {
+		pk, err := parseSchemePublicKey(scheme, publicKey)
+		if err != nil {
+			return err
+		}
+
+		if len(s) != scheme.SignatureSize() || !scheme.Verify(pk, m, s, nil) {
+			return errors.New(fmt.Sprintf("%s signature mismatch", scheme.Name()))
+		}
+
+		return nil
+	}
+}
+
+func parseSchemePublicKey(scheme sign.Scheme, publicKey string) (sign.PublicKey, error) {
+	pkBytes, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error in decoding key %s %s", publicKey, err))
+	}
+
+	pk, err := scheme.UnmarshalBinaryPublicKey(pkBytes)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error in parsing %s key %s", scheme.Name(), err))
+	}
+
+	return pk, nil
+}
+
+// validatePostQuantumKey checks the post-quantum half of a hybrid identity
+func validatePostQuantumKey(alg string, publicKey string) error {
+	scheme, ok := postQuantumAlgorithms[alg]
+	if !ok {
+		return errors.New(fmt.Sprintf("Unsupported post-quantum algorithm %s", alg))
+	}
+
+	_, err := parseSchemePublicKey(scheme, publicKey)
+	return err
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Attestation is a statement about an identity co-signed by verifiers,
+// Signature is the BLS aggregate of every verifier signature
+type Attestation struct {
+	Statement string   `json:"statement"`
+	Verifiers []string `json:"verifiers"`
+	Signature string   `json:"signature"`
+	TxID      string   `json:"txId"`
+}
+
+// attestationMessage is what every verifier signs for an attestation
+func attestationMessage(username string, statement string) []byte {
+	m, _ := marshal(struct {
+		Username  string `json:"username"`
+		Statement string `json:"statement"`
+	}{username, statement})
+
+	return m
+}
+
+type setBLSKeyRequest struct {
+	Username          string `json:"username"`
+	BPublicKey        string `json:"bPublicKey"`
+	ProofOfPossession string `json:"proofOfPossession"`
+}
+
+// SetBLSKey will register the BLS key a verifier uses
+// to co-sign attestations
+func (t *DewalletChaincode) SetBLSKey(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Setting BLS key of user")
+
+	var r setBLSKeyRequest
+	env, err := t.ParseRequest(args, &r)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't parse request %s", err))
+	}
+
+	i, err := getIdentity(stub, r.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = t.VerifyRequest(stub, args, env, i)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't verify signature %s", err))
+	}
+
+	err = verifyBLSProofOfPossession(r.BPublicKey, r.ProofOfPossession)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't verify proof of possession %s", err))
+	}
+
+	prev := i
+	i.BPublicKey = r.BPublicKey
+
+	err = updateIndexes(stub, prev, i)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	iBytes, err := putIdentity(stub, i)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(iBytes)
+}
+
+type addAttestationRequest struct {
+	Username  string   `json:"username"`
+	Statement string   `json:"statement"`
+	Verifiers []string `json:"verifiers"`
+	Signature string   `json:"signature"`
+}
+
+// AddAttestation will verify the aggregated signature of the verifiers,
+// who must all be approved, and attach the attestation to the identity
+func (t *DewalletChaincode) AddAttestation(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Adding attestation of user")
+
+	var r addAttestationRequest
+	json.Unmarshal([]byte(args[0]), &r)
+
+	i, err := getIdentity(stub, r.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var publicKeys []string
+	for k, v := range r.Verifiers {
+		if containsString(r.Verifiers[:k], v) {
+			return shim.Error(fmt.Sprintf("Verifier %s listed twice", v))
+		}
+
+		vi, err := getIdentity(stub, v)
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Can't get verifier %s %s", v, err))
+		}
+		if vi.BPublicKey == "" {
+			return shim.Error(fmt.Sprintf("Verifier %s has no BLS key", v))
+		}
+
+		err = requireVerifier(stub, vi)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		publicKeys = append(publicKeys, vi.BPublicKey)
+	}
+
+	err = verifyBLSAggregate(publicKeys, attestationMessage(r.Username, r.Statement), r.Signature)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't verify attestation %s", err))
+	}
+
+	a := Attestation{
+		Statement: r.Statement,
+		Verifiers: r.Verifiers,
+		Signature: r.Signature,
+		TxID:      stub.GetTxID(),
+	}
+
+	i.Attestations = append(i.Attestations, a)
+
+	err = auditCreator(stub, i.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	_, err = putIdentity(stub, i)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	aBytes, _ := marshal(a)
+
+	return shim.Success(aBytes)
+}
+
+type getAttestationsRequest struct {
+	Username string `json:"username"`
+}
+
+// GetAttestations will query the blockchain
+// and return the attestations of a user
+func (t *DewalletChaincode) GetAttestations(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Querying attestations of user")
+
+	var req getAttestationsRequest
+	json.Unmarshal([]byte(args[0]), &req)
+
+	i, err := getIdentity(stub, req.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if i.Attestations == nil {
+		i.Attestations = []Attestation{}
+	}
+
+	resBytes, _ := marshal(i.Attestations)
+
+	return shim.Success(resBytes)
+}
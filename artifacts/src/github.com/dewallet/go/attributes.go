@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// transientAttributes carries the JSON map of attribute ciphertexts in private data mode
+const transientAttributes = "attributes"
+
+// privateAttributeObjectType prefixes the private data keys of attributes
+const privateAttributeObjectType = "attr"
+
+// attributeNamePattern restricts attribute names such as name, dob, address or kycDoc
+var attributeNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Attribute is one independently encrypted field of the user data,
+// Value is empty in private data mode where the ciphertext is in the collection
+type Attribute struct {
+	Value  string    `json:"value"`
+	Hash   string    `json:"hash"`
+	Schema SchemaRef `json:"schema"`
+}
+
+// privateAttributeKey is the private data key of an attribute
+func privateAttributeKey(stub shim.ChaincodeStubInterface, username string, name string) (string, error) {
+	return stub.CreateCompositeKey(privateAttributeObjectType, []string{username, name})
+}
+
+type updateUserAttributesRequest struct {
+	Username         string               `json:"username"`
+	Attributes       map[string]string    `json:"attributes"`
+	AttributeHashes  map[string]string    `json:"attributeHashes"`
+	RemoveAttributes []string             `json:"removeAttributes"`
+	Schemas          map[string]SchemaRef `json:"schemas"`
+}
+
+// UpdateUserAttributes will set or remove some encrypted attributes
+// of the user and leave the others untouched
+func (t *DewalletChaincode) UpdateUserAttributes(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Updating attributes of user")
+
+	var r updateUserAttributesRequest
+	env, err := t.ParseRequest(args, &r)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't parse request %s", err))
+	}
+
+	i, err := getIdentity(stub, r.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = t.VerifyRequest(stub, args, env, i)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't verify signature %s", err))
+	}
+
+	if i.Attributes == nil {
+		i.Attributes = map[string]Attribute{}
+	}
+
+	for _, ref := range r.Schemas {
+		err = validateSchemaRef(stub, ref)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	l, err := getLimits(stub, i)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if i.Collection != "" {
+		err = t.putPrivateAttributes(stub, i, l, r.AttributeHashes)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		for name, hash := range r.AttributeHashes {
+			i.Attributes[name] = Attribute{Hash: hash, Schema: r.Schemas[name]}
+		}
+	} else {
+		for name, value := range r.Attributes {
+			if !attributeNamePattern.MatchString(name) {
+				return shim.Error(fmt.Sprintf("Invalid attribute name %s", name))
+			}
+			err = l.checkData(name, value)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			i.Attributes[name] = Attribute{Value: value, Hash: sha256Hex([]byte(value)), Schema: r.Schemas[name]}
+		}
+	}
+
+	for _, name := range r.RemoveAttributes {
+		delete(i.Attributes, name)
+
+		if i.Collection != "" {
+			aKey, err := privateAttributeKey(stub, i.Username, name)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+
+			err = stub.DelPrivateData(i.Collection, aKey)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+		}
+	}
+
+	iBytes, err := putIdentity(stub, i)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(iBytes)
+}
+
+// putPrivateAttributes writes the transient attribute ciphertexts
+// to the collection of the identity after checking the signed hashes
+func (t *DewalletChaincode) putPrivateAttributes(stub shim.ChaincodeStubInterface, i Identity, l Limits, hashes map[string]string) error {
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	tm, err := stub.GetTransient()
+	if err != nil {
+		return errors.New("Failed to get transient map")
+	}
+
+	var values map[string]string
+	err = json.Unmarshal(tm[transientAttributes], &values)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error in parsing transient attributes %s", err))
+	}
+
+	for name, hash := range hashes {
+		if !attributeNamePattern.MatchString(name) {
+			return errors.New(fmt.Sprintf("Invalid attribute name %s", name))
+		}
+
+		value, ok := values[name]
+		if !ok || sha256Hex([]byte(value)) != hash {
+			return errors.New(fmt.Sprintf("Transient attribute %s does not match the signed hash", name))
+		}
+
+		err = l.checkData(name, value)
+		if err != nil {
+			return err
+		}
+
+		aKey, err := privateAttributeKey(stub, i.Username, name)
+		if err != nil {
+			return err
+		}
+
+		err = stub.PutPrivateData(i.Collection, aKey, []byte(value))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getPrivateAttributes reads the attribute ciphertexts of the identity from its collection
+func (t *DewalletChaincode) getPrivateAttributes(stub shim.ChaincodeStubInterface, i Identity) (map[string]string, error) {
+	values := map[string]string{}
+
+	for name := range i.Attributes {
+		aKey, err := privateAttributeKey(stub, i.Username, name)
+		if err != nil {
+			return nil, err
+		}
+
+		v, err := stub.GetPrivateData(i.Collection, aKey)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Failed to get private data %s", err))
+		}
+
+		values[name] = string(v)
+	}
+
+	return values, nil
+}
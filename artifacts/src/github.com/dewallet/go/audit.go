@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// auditObjectType prefixes the access log entries by username,
+// then by zero padded timestamp so they are listed in order
+const auditObjectType = "audit"
+
+// AuditEntry records that Actor called Function on an identity,
+// Actor is a username, or the client ID when no user signed
+type AuditEntry struct {
+	Actor     string `json:"actor"`
+	Function  string `json:"function"`
+	Purpose   string `json:"purpose,omitempty"`
+	TxID      string `json:"txId"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// audit adds an entry to the access log of username,
+// only transactions that are committed leave one
+func audit(stub shim.ChaincodeStubInterface, username string, actor string, purpose string) error {
+	now, err := txSeconds(stub)
+	if err != nil {
+		return err
+	}
+
+	key, err := stub.CreateCompositeKey(auditObjectType, []string{username, fmt.Sprintf("%020d", now), stub.GetTxID()})
+	if err != nil {
+		return err
+	}
+
+	e := AuditEntry{
+		Actor:     actor,
+		Function:  invokedFunction(stub),
+		Purpose:   purpose,
+		TxID:      stub.GetTxID(),
+		Timestamp: now,
+	}
+
+	eBytes, _ := marshal(e)
+	return stub.PutState(key, eBytes)
+}
+
+// auditCreator adds an entry to the access log of username
+// for a transaction no user signed, such as an admin sweep
+func auditCreator(stub shim.ChaincodeStubInterface, username string) error {
+	id, err := creatorID(stub)
+	if err != nil {
+		return err
+	}
+
+	return audit(stub, username, id, "")
+}
+
+// requestPurpose is the purpose a signed request states, if any
+func requestPurpose(env signedEnvelope) string {
+	var r struct {
+		Purpose string `json:"purpose"`
+	}
+	json.Unmarshal(env.Payload, &r)
+
+	return r.Purpose
+}
+
+type getAccessLogRequest struct {
+	Username string `json:"username"`
+	Token    string `json:"token"`
+
+	pageRequest
+}
+
+type getAccessLogResponse struct {
+	Entries []AuditEntry `json:"entries"`
+
+	pageResponse
+}
+
+// GetAccessLog will page through who changed or read the identity of a user,
+// oldest first, the user proves itself with a session token
+func (t *DewalletChaincode) GetAccessLog(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Querying access log of user")
+
+	var req getAccessLogRequest
+	json.Unmarshal([]byte(args[0]), &req)
+
+	err := resolveHandles(stub, &req.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = t.VerifySession(stub, req.Token, req.Username)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't verify session %s", err))
+	}
+
+	size, err := req.size()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	it, m, err := stub.GetStateByPartialCompositeKeyWithPagination(auditObjectType, []string{req.Username}, size, req.Bookmark)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't query access log %s", err))
+	}
+	defer it.Close()
+
+	res := getAccessLogResponse{Entries: []AuditEntry{}, pageResponse: newPageResponse(m)}
+	for it.HasNext() {
+		kv, err := it.Next()
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Can't query access log %s", err))
+		}
+
+		var e AuditEntry
+		err = json.Unmarshal(kv.Value, &e)
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Error in parsing audit entry %s", err))
+		}
+
+		res.Entries = append(res.Entries, e)
+	}
+
+	resBytes, _ := marshal(res)
+
+	return shim.Success(resBytes)
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// disclosurePolicyObjectType prefixes the disclosure policies by username and scope
+const disclosurePolicyObjectType = "discpolicy"
+
+// disclosureScopeIdentity is the scope of the policy of a whole identity,
+// the policy of a slot has the scope of a consent, see consentScope
+const disclosureScopeIdentity = "identity"
+
+// maxPolicyRules bounds the rules of a policy and the values of a condition
+const maxPolicyRules = 16
+
+// DisclosurePolicy decides who is disclosed the wrapped keys of an identity
+// or of a slot, a disclosure is allowed when any of the rules matches
+type DisclosurePolicy struct {
+	Rules []PolicyRule `json:"rules"`
+}
+
+// PolicyRule matches a disclosure when all of its conditions hold,
+// a condition left empty always holds
+// Orgs, Verified and MinLevel are about the requester, whose level
+// only counts the active verifications of Verifiers when given,
+// From and Until bound the transaction time in unix seconds
+type PolicyRule struct {
+	Orgs      []string `json:"orgs,omitempty"`
+	Verified  []string `json:"verified,omitempty"`
+	MinLevel  string   `json:"minLevel,omitempty"`
+	Verifiers []string `json:"verifiers,omitempty"`
+	Purposes  []string `json:"purposes,omitempty"`
+	From      int64    `json:"from,omitempty"`
+	Until     int64    `json:"until,omitempty"`
+}
+
+// disclosureRequest is what the authorizer decides on
+type disclosureRequest struct {
+	Requester Identity
+	Purpose   string
+	Now       int64
+}
+
+func (r PolicyRule) validate() error {
+	if len(r.Orgs) > maxPolicyRules || len(r.Verified) > maxPolicyRules || len(r.Verifiers) > maxPolicyRules || len(r.Purposes) > maxPolicyRules {
+		return errors.New(fmt.Sprintf("A rule condition has at most %d values", maxPolicyRules))
+	}
+	if r.Until != 0 && r.Until <= r.From {
+		return errors.New("A rule ends before it starts")
+	}
+	if r.MinLevel != "" && verificationRank(r.MinLevel) < 0 {
+		return errors.New(fmt.Sprintf("Unsupported verification level %s", r.MinLevel))
+	}
+
+	return nil
+}
+
+func (r PolicyRule) matches(d disclosureRequest) bool {
+	if len(r.Orgs) > 0 && !containsString(r.Orgs, d.Requester.Org) {
+		return false
+	}
+	level := d.Requester.verificationLevelBy(d.Now, r.Verifiers)
+	if len(r.Verified) > 0 && !containsString(r.Verified, level) {
+		return false
+	}
+	if !atLeast(level, r.MinLevel) {
+		return false
+	}
+	if len(r.Purposes) > 0 && !containsString(r.Purposes, d.Purpose) {
+		return false
+	}
+	if r.From != 0 && d.Now < r.From {
+		return false
+	}
+	if r.Until != 0 && d.Now >= r.Until {
+		return false
+	}
+
+	return true
+}
+
+func (p DisclosurePolicy) allows(d disclosureRequest) bool {
+	for _, r := range p.Rules {
+		if r.matches(d) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getDisclosurePolicy reads the policy of a scope of username, nil when there is none
+func getDisclosurePolicy(stub shim.ChaincodeStubInterface, username string, scope string) (*DisclosurePolicy, error) {
+	key, err := stub.CreateCompositeKey(disclosurePolicyObjectType, []string{username, scope})
+	if err != nil {
+		return nil, err
+	}
+
+	pBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get state")
+	}
+	if pBytes == nil {
+		return nil, nil
+	}
+
+	var p DisclosurePolicy
+	err = json.Unmarshal(pBytes, &p)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error in parsing disclosure policy %s", err))
+	}
+
+	return &p, nil
+}
+
+// authorizeDisclosure is the authorizer run before wrapped keys of the identity
+// are disclosed, scope is the identity or a slot, the policy of a slot
+// is used when it has one, the policy of the identity otherwise
+func authorizeDisclosure(stub shim.ChaincodeStubInterface, i Identity, scope string, d disclosureRequest) error {
+	p, err := getDisclosurePolicy(stub, i.Username, scope)
+	if err != nil {
+		return err
+	}
+	if p == nil && scope != disclosureScopeIdentity {
+		p, err = getDisclosurePolicy(stub, i.Username, disclosureScopeIdentity)
+		if err != nil {
+			return err
+		}
+	}
+
+	if p == nil || p.allows(d) {
+		return nil
+	}
+
+	return errors.New(fmt.Sprintf("Disclosure to %s is denied by the policy of %s", d.Requester.Username, i.Username))
+}
+
+type setDisclosurePolicyRequest struct {
+	Username string `json:"username"`
+
+	// Slot is the slot the policy is for, the identity when not given
+	Slot *string `json:"slot"`
+
+	Policy DisclosurePolicy `json:"policy"`
+}
+
+// SetDisclosurePolicy will replace the disclosure policy of the user,
+// or of one of its slots, a policy without rules is removed
+func (t *DewalletChaincode) SetDisclosurePolicy(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Setting disclosure policy of user")
+
+	var r setDisclosurePolicyRequest
+	env, err := t.ParseRequest(args, &r)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't parse request %s", err))
+	}
+
+	i, err := getIdentity(stub, r.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = t.VerifyRequest(stub, args, env, i)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't verify signature %s", err))
+	}
+
+	scope := disclosureScopeIdentity
+	if r.Slot != nil {
+		if _, ok := i.slot(*r.Slot); !ok {
+			return shim.Error(fmt.Sprintf("Slot %s not found", *r.Slot))
+		}
+		scope = consentScope("", *r.Slot)
+	}
+
+	if len(r.Policy.Rules) > maxPolicyRules {
+		return shim.Error(fmt.Sprintf("A policy has at most %d rules", maxPolicyRules))
+	}
+	for _, rule := range r.Policy.Rules {
+		err = rule.validate()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	key, err := stub.CreateCompositeKey(disclosurePolicyObjectType, []string{i.Username, scope})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if len(r.Policy.Rules) == 0 {
+		err = stub.DelState(key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		return shim.Success(nil)
+	}
+
+	pBytes, _ := marshal(r.Policy)
+	err = stub.PutState(key, pBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(pBytes)
+}
+
+type getDisclosurePolicyRequest struct {
+	Username string  `json:"username"`
+	Slot     *string `json:"slot"`
+}
+
+// GetDisclosurePolicy will query the blockchain
+// and return the disclosure policy of a user or of one of its slots
+func (t *DewalletChaincode) GetDisclosurePolicy(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Querying disclosure policy of user")
+
+	var req getDisclosurePolicyRequest
+	json.Unmarshal([]byte(args[0]), &req)
+
+	err := resolveHandles(stub, &req.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	scope := disclosureScopeIdentity
+	if req.Slot != nil {
+		scope = consentScope("", *req.Slot)
+	}
+
+	p, err := getDisclosurePolicy(stub, req.Username, scope)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if p == nil {
+		p = &DisclosurePolicy{Rules: []PolicyRule{}}
+	}
+
+	pBytes, _ := marshal(p)
+
+	return shim.Success(pBytes)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// maxBatchOperations bounds the operations of a batch
+const maxBatchOperations = 20
+
+// errBatchRichQuery refuses the rich queries of the operations of a batch
+var errBatchRichQuery = errors.New("Rich queries don't see the writes of a batch")
+
+// batchStub collects the events of the operations of a batch, see Batch,
+// and gives each operation its own function and arguments so delegations
+// and the access log see the operation rather than Batch.
+// The operations read the writes of the previous ones from the state
+// cache of the invocation, see stateCache. Rich queries, which can't see
+// them, are refused and the handlers fall back to their range queries
+type batchStub struct {
+	shim.ChaincodeStubInterface
+
+	function string
+	args     []string
+
+	events []batchedEvent
+}
+
+func newBatchStub(stub shim.ChaincodeStubInterface) *batchStub {
+	return &batchStub{ChaincodeStubInterface: stub}
+}
+
+// GetFunctionAndParameters is the function and arguments of the operation running
+func (s *batchStub) GetFunctionAndParameters() (string, []string) {
+	return s.function, s.args
+}
+
+func (s *batchStub) GetQueryResult(query string) (shim.StateQueryIteratorInterface, error) {
+	return nil, errBatchRichQuery
+}
+
+func (s *batchStub) GetQueryResultWithPagination(query string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	return nil, nil, errBatchRichQuery
+}
+
+func (s *batchStub) SetEvent(name string, payload []byte) error {
+	s.events = append(s.events, batchedEvent{Name: name, Payload: payload})
+	return nil
+}
+
+type batchOperation struct {
+	Function string   `json:"function"`
+	Args     []string `json:"args"`
+}
+
+type batchRequest struct {
+	Operations []batchOperation `json:"operations"`
+}
+
+type batchResult struct {
+	Function string          `json:"function"`
+	Payload  json.RawMessage `json:"payload"`
+}
+
+type batchResponse struct {
+	Results []batchResult `json:"results"`
+}
+
+// Batch will run a list of operations in the transaction, each with its
+// own arguments, signed and checked as if invoked alone. The batch fails
+// as a whole when an operation fails, their results are returned in order
+func (t *DewalletChaincode) Batch(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Running a batch of operations")
+
+	var req batchRequest
+	err := json.Unmarshal([]byte(args[0]), &req)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Error in parsing batch %s", err))
+	}
+
+	if len(req.Operations) == 0 || len(req.Operations) > maxBatchOperations {
+		return shim.Error(fmt.Sprintf("A batch has 1 to %d operations", maxBatchOperations))
+	}
+
+	bs := newBatchStub(stub)
+
+	res := batchResponse{Results: []batchResult{}}
+	for k, op := range req.Operations {
+		if op.Function == "Batch" {
+			return shim.Error("Batches can't be nested")
+		}
+
+		opArgs, err := decodeCOSEArgs(op.Args)
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Can't decode COSE request of operation %d %s", k, err))
+		}
+		if len(opArgs) == 0 {
+			return shim.Error(fmt.Sprintf("Operation %d has no arguments", k))
+		}
+
+		bs.function, bs.args = op.Function, opArgs
+
+		err = t.checkACL(bs, op.Function)
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Access denied to operation %d %s", k, err))
+		}
+
+		err = checkGlobalFreeze(bs, op.Function)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		r := t.dispatch(bs, op.Function, opArgs)
+		if r.Status != shim.OK {
+			return shim.Error(fmt.Sprintf("Operation %d %s failed %s", k, op.Function, r.Message))
+		}
+
+		err = t.checkRateLimit(bs, op.Function)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		payload := json.RawMessage(r.Payload)
+		if len(r.Payload) == 0 || !json.Valid(r.Payload) {
+			payload, _ = marshal(string(r.Payload))
+		}
+		res.Results = append(res.Results, batchResult{Function: op.Function, Payload: payload})
+	}
+
+	if len(bs.events) == 1 {
+		err = stub.SetEvent(bs.events[0].Name, bs.events[0].Payload)
+	} else if len(bs.events) > 1 {
+		err = emitEvent(stub, &BatchEvent{Events: bs.events})
+	}
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resBytes, _ := marshal(res)
+
+	return shim.Success(resBytes)
+}
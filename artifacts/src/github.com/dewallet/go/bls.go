@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	bls12381 "github.com/kilic/bls12-381"
+)
+
+// Domain separation tags of the IETF BLS proof of possession ciphersuite,
+// public keys live in G1 and signatures in G2
+var (
+	blsSignatureDST = []byte("BLS_SIG_BLS12381G2_XMD:SHA-256_SSWU_RO_POP_")
+	blsPopDST       = []byte("BLS_POP_BLS12381G2_XMD:SHA-256_SSWU_RO_POP_")
+)
+
+// parseBLSPublicKey parses a base64 encoded compressed G1 public key
+func parseBLSPublicKey(publicKey string) (*bls12381.PointG1, error) {
+	pkBytes, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error in decoding BLS key %s", err))
+	}
+
+	g1 := bls12381.NewG1()
+	pk, err := g1.FromCompressed(pkBytes)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error in parsing BLS key %s", err))
+	}
+	if g1.IsZero(pk) {
+		return nil, errors.New("BLS key is the identity point")
+	}
+
+	return pk, nil
+}
+
+// parseBLSSignature parses a base64 encoded compressed G2 signature
+func parseBLSSignature(signature string) (*bls12381.PointG2, error) {
+	sBytes, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error in decoding BLS signature %s", err))
+	}
+
+	s, err := bls12381.NewG2().FromCompressed(sBytes)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error in parsing BLS signature %s", err))
+	}
+
+	return s, nil
+}
+
+// blsVerify checks e(pk, H(m)) == e(g1, s)
+func blsVerify(pk *bls12381.PointG1, m []byte, s *bls12381.PointG2, dst []byte) error {
+	h, err := bls12381.NewG2().HashToCurve(m, dst)
+	if err != nil {
+		return err
+	}
+
+	e := bls12381.NewEngine()
+	e.AddPair(pk, h)
+	e.AddPairInv(e.G1.One(), s)
+	if !e.Check() {
+		return errors.New("BLS signature mismatch")
+	}
+
+	return nil
+}
+
+// verifyBLSProofOfPossession checks the proof that the holder of a BLS key
+// knows its secret, which rules out rogue key attacks on aggregation
+func verifyBLSProofOfPossession(publicKey string, proof string) error {
+	pk, err := parseBLSPublicKey(publicKey)
+	if err != nil {
+		return err
+	}
+
+	s, err := parseBLSSignature(proof)
+	if err != nil {
+		return err
+	}
+
+	return blsVerify(pk, bls12381.NewG1().ToCompressed(pk), s, blsPopDST)
+}
+
+// verifyBLSAggregate verifies a signature aggregated by several
+// signers over the same message (FastAggregateVerify)
+func verifyBLSAggregate(publicKeys []string, m []byte, signature string) error {
+	if len(publicKeys) == 0 {
+		return errors.New("No BLS keys to aggregate")
+	}
+
+	g1 := bls12381.NewG1()
+	agg := g1.Zero()
+	for _, publicKey := range publicKeys {
+		pk, err := parseBLSPublicKey(publicKey)
+		if err != nil {
+			return err
+		}
+		g1.Add(agg, agg, pk)
+	}
+
+	s, err := parseBLSSignature(signature)
+	if err != nil {
+		return err
+	}
+
+	return blsVerify(agg, m, s, blsSignatureDST)
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// capabilityObjectType prefixes the capabilities by subject,
+// privateCapabilityObjectType the private data keys of their wrapped keys
+const (
+	capabilityObjectType        = "capability"
+	privateCapabilityObjectType = "capkey"
+)
+
+// maxCapabilityUses bounds how many times a capability can be redeemed
+const maxCapabilityUses = 100
+
+// Capability is a token signed by Subject that lets Redeemer redeem the
+// wrapped key it carries without a standing grant. The key is wrapped for
+// the encryption key of Redeemer and a redemption is signed by Redeemer,
+// so it discloses nothing to anyone else. Remaining counts the redemptions
+// committed, a redemption only evaluated isn't counted
+type Capability struct {
+	ID        string `json:"id"`
+	Subject   string `json:"subject"`
+	Redeemer  string `json:"redeemer"`
+	Key       string `json:"key"`
+	KeyHash   string `json:"keyHash"`
+	Attribute string `json:"attribute,omitempty"`
+	Slot      string `json:"slot"`
+	Uses      int    `json:"uses"`
+	Remaining int    `json:"remaining"`
+	ExpiresAt int64  `json:"expiresAt"`
+	Revoked   bool   `json:"revoked"`
+}
+
+func capabilityKey(stub shim.ChaincodeStubInterface, subject string, id string) (string, error) {
+	return stub.CreateCompositeKey(capabilityObjectType, []string{subject, id})
+}
+
+func putCapability(stub shim.ChaincodeStubInterface, c Capability) error {
+	key, err := capabilityKey(stub, c.Subject, c.ID)
+	if err != nil {
+		return err
+	}
+
+	cBytes, _ := marshal(c)
+	return stub.PutState(key, cBytes)
+}
+
+// getCapability reads a capability of subject, nil when there is none
+func getCapability(stub shim.ChaincodeStubInterface, subject string, id string) (*Capability, error) {
+	key, err := capabilityKey(stub, subject, id)
+	if err != nil {
+		return nil, err
+	}
+
+	cBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get state")
+	}
+	if cBytes == nil {
+		return nil, nil
+	}
+
+	var c Capability
+	err = json.Unmarshal(cBytes, &c)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error in parsing capability %s", err))
+	}
+
+	return &c, nil
+}
+
+type issueCapabilityRequest struct {
+	Username  string `json:"username"`
+	Redeemer  string `json:"redeemer"`
+	Key       string `json:"key"`
+	KeyHash   string `json:"keyHash"`
+	Attribute string `json:"attribute"`
+	Slot      string `json:"slot"`
+	Uses      int    `json:"uses"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+// IssueCapability will record a capability of the user for the key of an
+// attribute or a slot, wrapped for the redeemer and redeemable Uses times by it,
+// in private data mode the key is passed in the transient map like with AddKey
+func (t *DewalletChaincode) IssueCapability(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Issuing capability of user")
+
+	var r issueCapabilityRequest
+	env, err := t.ParseRequest(args, &r)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't parse request %s", err))
+	}
+
+	i, err := getIdentity(stub, r.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = t.VerifyRequest(stub, args, env, i)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't verify signature %s", err))
+	}
+
+	if r.Attribute != "" && !attributeNamePattern.MatchString(r.Attribute) {
+		return shim.Error(fmt.Sprintf("Invalid attribute name %s", r.Attribute))
+	}
+	if r.Slot != "" && !slotNamePattern.MatchString(r.Slot) {
+		return shim.Error(fmt.Sprintf("Invalid slot name %s", r.Slot))
+	}
+	if r.Attribute != "" && r.Slot != "" {
+		return shim.Error("A capability is scoped to an attribute or a slot, not both")
+	}
+	if r.Uses < 1 || r.Uses > maxCapabilityUses {
+		return shim.Error(fmt.Sprintf("Uses must be between 1 and %d", maxCapabilityUses))
+	}
+
+	err = resolveHandles(stub, &r.Redeemer)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if r.Redeemer == "" || r.Redeemer == i.Username {
+		return shim.Error("A capability is redeemed by another user")
+	}
+
+	_, err = getIdentity(stub, r.Redeemer)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't get redeemer %s %s", r.Redeemer, err))
+	}
+
+	err = checkGrantPolicy(stub, i, r.Redeemer)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	now, err := txSeconds(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if r.ExpiresAt != 0 && r.ExpiresAt <= now {
+		return shim.Error("Capability expires in the past")
+	}
+
+	l, err := getLimits(stub, i)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	c := Capability{
+		ID:        stub.GetTxID(),
+		Subject:   i.Username,
+		Redeemer:  r.Redeemer,
+		Key:       r.Key,
+		Attribute: r.Attribute,
+		Slot:      r.Slot,
+		Uses:      r.Uses,
+		Remaining: r.Uses,
+		ExpiresAt: r.ExpiresAt,
+	}
+
+	if i.Collection != "" {
+		k, err := transientField(stub, transientKey, r.KeyHash)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		err = l.checkKey(transientKey, string(k))
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		pKey, err := stub.CreateCompositeKey(privateCapabilityObjectType, []string{i.Username, c.ID})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		err = stub.PutPrivateData(i.Collection, pKey, k)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		c.Key = ""
+		c.KeyHash = r.KeyHash
+	} else {
+		err = l.checkKey("key", r.Key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	err = putCapability(stub, c)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	cBytes, _ := marshal(c)
+
+	return shim.Success(cBytes)
+}
+
+type revokeCapabilityRequest struct {
+	Username string `json:"username"`
+	ID       string `json:"id"`
+}
+
+// RevokeCapability will stop a capability of the user from being redeemed
+func (t *DewalletChaincode) RevokeCapability(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Revoking capability of user")
+
+	var r revokeCapabilityRequest
+	env, err := t.ParseRequest(args, &r)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't parse request %s", err))
+	}
+
+	i, err := getIdentity(stub, r.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = t.VerifyRequest(stub, args, env, i)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't verify signature %s", err))
+	}
+
+	c, err := getCapability(stub, i.Username, r.ID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if c == nil {
+		return shim.Error("Capability not found")
+	}
+
+	c.Revoked = true
+
+	err = putCapability(stub, *c)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	cBytes, _ := marshal(c)
+
+	return shim.Success(cBytes)
+}
+
+type redeemCapabilityRequest struct {
+	Redeemer string `json:"redeemer"`
+	Subject  string `json:"subject"`
+	ID       string `json:"id"`
+}
+
+type redeemCapabilityResponse struct {
+	Key       string `json:"key"`
+	Data      string `json:"data"`
+	DataHash  string `json:"dataHash"`
+	Remaining int    `json:"remaining"`
+
+	Pointer *DataPointer `json:"pointer,omitempty"`
+}
+
+// RedeemCapability will use up one redemption of a capability and return
+// the wrapped key it carries with the data it is for, the request is
+// signed by the redeemer the capability was issued to
+func (t *DewalletChaincode) RedeemCapability(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Redeeming capability")
+
+	var req redeemCapabilityRequest
+	env, err := t.ParseRequest(args, &req)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't parse request %s", err))
+	}
+
+	ri, err := getIdentity(stub, req.Redeemer)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = t.VerifyRequest(stub, args, env, ri)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't verify signature %s", err))
+	}
+
+	err = resolveHandles(stub, &req.Subject)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	c, err := getCapability(stub, req.Subject, req.ID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if c == nil || c.Redeemer != ri.Username {
+		return shim.Error("Capability not found")
+	}
+
+	now, err := txSeconds(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if c.Revoked {
+		return shim.Error("Capability is revoked")
+	}
+	if c.ExpiresAt != 0 && now >= c.ExpiresAt {
+		return shim.Error("Capability has expired")
+	}
+	if c.Remaining < 1 {
+		return shim.Error("Capability is used up")
+	}
+
+	i, err := getIdentity(stub, c.Subject)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	res := redeemCapabilityResponse{Key: c.Key}
+
+	if c.Attribute != "" {
+		a, ok := i.Attributes[c.Attribute]
+		if !ok {
+			return shim.Error(fmt.Sprintf("Attribute %s not found", c.Attribute))
+		}
+		res.Data = a.Value
+		res.DataHash = a.Hash
+
+		if i.Collection != "" {
+			aKey, err := privateAttributeKey(stub, i.Username, c.Attribute)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+
+			v, err := stub.GetPrivateData(i.Collection, aKey)
+			if err != nil {
+				return shim.Error(fmt.Sprintf("Failed to get private data %s", err))
+			}
+			res.Data = string(v)
+		}
+	} else {
+		s, ok := i.slot(c.Slot)
+		if !ok || s.expired(now) {
+			return shim.Error(fmt.Sprintf("Slot %s not found", c.Slot))
+		}
+		res.DataHash = s.Hash
+		res.Pointer = s.Pointer
+
+		if i.Collection == "" {
+			res.Data, err = getUserData(stub, i, c.Slot)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+		} else {
+			dKey, err := privateDataKey(stub, i.Username, c.Slot)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+
+			data, err := getStoredPrivateData(stub, i.Collection, dKey)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			res.Data = string(data)
+		}
+	}
+
+	if i.Collection != "" {
+		pKey, err := stub.CreateCompositeKey(privateCapabilityObjectType, []string{i.Username, c.ID})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		k, err := stub.GetPrivateData(i.Collection, pKey)
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Failed to get private data %s", err))
+		}
+		res.Key = string(k)
+	}
+
+	err = audit(stub, i.Username, ri.Username, "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	c.Remaining--
+	res.Remaining = c.Remaining
+
+	err = putCapability(stub, *c)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resBytes, _ := marshal(res)
+
+	return shim.Success(resBytes)
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"unicode/utf8"
+)
+
+// Major types of RFC 8949
+const (
+	cborUnsigned = 0
+	cborNegative = 1
+	cborBytes    = 2
+	cborText     = 3
+	cborArray    = 4
+	cborMap      = 5
+	cborTagged   = 6
+	cborSimple   = 7
+)
+
+// cborMaxDepth bounds the nesting of decoded items
+const cborMaxDepth = 16
+
+// cborTag is a tagged item, the content is kept decoded
+type cborTag struct {
+	Number  uint64
+	Content interface{}
+}
+
+// cborDecoder decodes the subset of CBOR needed for requests:
+// definite length items only, integers must fit in an int64
+type cborDecoder struct {
+	data []byte
+	pos  int
+}
+
+// decodeCBOR decodes exactly one CBOR item from data
+func decodeCBOR(data []byte) (interface{}, error) {
+	d := cborDecoder{data: data}
+
+	v, err := d.decode(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(data) {
+		return nil, errors.New("Trailing bytes after CBOR item")
+	}
+
+	return v, nil
+}
+
+func (d *cborDecoder) head() (byte, byte, uint64, error) {
+	if d.pos >= len(d.data) {
+		return 0, 0, 0, errors.New("Unexpected end of CBOR data")
+	}
+
+	b := d.data[d.pos]
+	d.pos++
+	major, info := b>>5, b&0x1f
+
+	var size int
+	switch {
+	case info < 24:
+		return major, info, uint64(info), nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	case info == 31:
+		return 0, 0, 0, errors.New("Indefinite length CBOR items are not supported")
+	default:
+		return 0, 0, 0, errors.New(fmt.Sprintf("Invalid CBOR additional information %d", info))
+	}
+
+	if len(d.data)-d.pos < size {
+		return 0, 0, 0, errors.New("Unexpected end of CBOR data")
+	}
+
+	var n uint64
+	for _, c := range d.data[d.pos : d.pos+size] {
+		n = n<<8 | uint64(c)
+	}
+	d.pos += size
+
+	return major, info, n, nil
+}
+
+func (d *cborDecoder) bytes(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, errors.New("Unexpected end of CBOR data")
+	}
+
+	b := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+
+	return b, nil
+}
+
+func (d *cborDecoder) decode(depth int) (interface{}, error) {
+	if depth > cborMaxDepth {
+		return nil, errors.New("CBOR item is nested too deeply")
+	}
+
+	major, info, n, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case cborUnsigned:
+		if n > math.MaxInt64 {
+			return nil, errors.New("CBOR integer overflows int64")
+		}
+		return int64(n), nil
+	case cborNegative:
+		if n > math.MaxInt64 {
+			return nil, errors.New("CBOR integer overflows int64")
+		}
+		return -1 - int64(n), nil
+	case cborBytes:
+		b, err := d.bytes(n)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{}, b...), nil
+	case cborText:
+		b, err := d.bytes(n)
+		if err != nil {
+			return nil, err
+		}
+		if !utf8.Valid(b) {
+			return nil, errors.New("CBOR text is not valid UTF-8")
+		}
+		return string(b), nil
+	case cborArray:
+		// every item takes at least one byte
+		if n > uint64(len(d.data)-d.pos) {
+			return nil, errors.New("Unexpected end of CBOR data")
+		}
+		a := make([]interface{}, 0, n)
+		for k := uint64(0); k < n; k++ {
+			v, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, v)
+		}
+		return a, nil
+	case cborMap:
+		if n > uint64(len(d.data)-d.pos)/2 {
+			return nil, errors.New("Unexpected end of CBOR data")
+		}
+		m := make(map[interface{}]interface{}, n)
+		for k := uint64(0); k < n; k++ {
+			key, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, errors.New("CBOR map keys must be integers or text")
+			}
+			if _, ok := m[key]; ok {
+				return nil, errors.New(fmt.Sprintf("Duplicate CBOR map key %v", key))
+			}
+			v, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = v
+		}
+		return m, nil
+	case cborTagged:
+		v, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return cborTag{Number: n, Content: v}, nil
+	default:
+		switch info {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22, 23:
+			return nil, nil
+		case 25:
+			return float16ToFloat64(uint16(n)), nil
+		case 26:
+			return float64(math.Float32frombits(uint32(n))), nil
+		case 27:
+			return math.Float64frombits(n), nil
+		}
+		return nil, errors.New(fmt.Sprintf("Unsupported CBOR simple value %d", n))
+	}
+}
+
+func float16ToFloat64(h uint16) float64 {
+	sign := 1.0
+	if h&0x8000 != 0 {
+		sign = -1.0
+	}
+	exp := int(h>>10) & 0x1f
+	frac := float64(h & 0x3ff)
+
+	switch exp {
+	case 0:
+		return sign * math.Ldexp(frac, -24)
+	case 0x1f:
+		if frac == 0 {
+			return math.Inf(int(sign))
+		}
+		return math.NaN()
+	}
+
+	return sign * math.Ldexp(frac+1024, exp-25)
+}
+
+// cborToJSON converts a decoded CBOR item to a value encoding/json can marshal,
+// byte strings become standard base64 like the keys and data of JSON requests
+func cborToJSON(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v), nil
+	case []interface{}:
+		a := make([]interface{}, len(v))
+		for k, item := range v {
+			c, err := cborToJSON(item)
+			if err != nil {
+				return nil, err
+			}
+			a[k] = c
+		}
+		return a, nil
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			s, ok := key.(string)
+			if !ok {
+				return nil, errors.New("CBOR request map keys must be text")
+			}
+			c, err := cborToJSON(item)
+			if err != nil {
+				return nil, err
+			}
+			m[s] = c
+		}
+		return m, nil
+	case cborTag:
+		return nil, errors.New(fmt.Sprintf("Unsupported CBOR tag %d in request", v.Number))
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, errors.New("CBOR request contains a non finite number")
+		}
+		return v, nil
+	default:
+		return v, nil
+	}
+}
+
+// appendCBORHead appends the encoded head of an item in its shortest form
+func appendCBORHead(b []byte, major byte, n uint64) []byte {
+	var info byte
+	var size uint
+
+	switch {
+	case n < 24:
+		return append(b, major<<5|byte(n))
+	case n <= math.MaxUint8:
+		info, size = 24, 1
+	case n <= math.MaxUint16:
+		info, size = 25, 2
+	case n <= math.MaxUint32:
+		info, size = 26, 4
+	default:
+		info, size = 27, 8
+	}
+
+	b = append(b, major<<5|info)
+	for k := size; k > 0; k-- {
+		b = append(b, byte(n>>(8*(k-1))))
+	}
+
+	return b
+}
+
+func appendCBORBytes(b []byte, v []byte) []byte {
+	b = appendCBORHead(b, cborBytes, uint64(len(v)))
+	return append(b, v...)
+}
+
+func appendCBORText(b []byte, v string) []byte {
+	b = appendCBORHead(b, cborText, uint64(len(v)))
+	return append(b, v...)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// changeObjectType prefixes the change index by transaction timestamp,
+// transaction and username, an identity is indexed at its last write
+// and the keys it gave since, see appendChange
+const changeObjectType = "change"
+
+// lastChangeObjectType prefixes the key of the last change of each identity
+const lastChangeObjectType = "lastchange"
+
+// Change is an entry of the change feed, Cursor resumes the feed after it
+type Change struct {
+	Username  string `json:"username"`
+	TxID      string `json:"txId"`
+	Timestamp int64  `json:"timestamp"`
+	Cursor    string `json:"cursor"`
+}
+
+// changeSequence is the transaction timestamp in nanoseconds, padded
+// so the change keys sort by it
+func changeSequence(seconds int64, nanos int32) string {
+	return fmt.Sprintf("%020d", seconds*1000000000+int64(nanos))
+}
+
+// appendChange adds an entry for the identity at the end of the change feed
+// and returns its key, AddKey only appends so concurrent grants don't
+// conflict, the identity may then have more than one entry
+func appendChange(stub shim.ChaincodeStubInterface, username string) (string, error) {
+	ts, err := stub.GetTxTimestamp()
+	if err != nil {
+		return "", errors.New("Failed to get transaction timestamp")
+	}
+
+	seq := changeSequence(ts.Seconds, ts.Nanos)
+	key, err := stub.CreateCompositeKey(changeObjectType, []string{seq, stub.GetTxID(), username})
+	if err != nil {
+		return "", err
+	}
+
+	cBytes, _ := marshal(Change{Username: username, TxID: stub.GetTxID(), Timestamp: ts.Seconds, Cursor: seq + "." + stub.GetTxID()})
+	return key, stub.PutState(key, cBytes)
+}
+
+// recordChange moves the identity to the end of the change feed
+func recordChange(stub shim.ChaincodeStubInterface, username string) error {
+	lastKey, err := stub.CreateCompositeKey(lastChangeObjectType, []string{username})
+	if err != nil {
+		return err
+	}
+
+	prev, err := stub.GetState(lastKey)
+	if err != nil {
+		return errors.New("Failed to get state")
+	}
+	if prev != nil {
+		err = stub.DelState(string(prev))
+		if err != nil {
+			return err
+		}
+	}
+
+	key, err := appendChange(stub, username)
+	if err != nil {
+		return err
+	}
+
+	return stub.PutState(lastKey, []byte(key))
+}
+
+type getChangesSinceRequest struct {
+	// Since is the cursor of the last change synced, or else
+	// SinceTimestamp the unix time changes are wanted after
+	Since          string `json:"since"`
+	SinceTimestamp int64  `json:"sinceTimestamp"`
+
+	pageRequest
+}
+
+type getChangesSinceResponse struct {
+	Changes    []Change   `json:"changes"`
+	Identities []Identity `json:"identities"`
+	// Checkpoint is the cursor to sync from next time
+	Checkpoint string `json:"checkpoint"`
+
+	pageResponse
+}
+
+// changesStartKey is the first change key after the checkpoint of the request,
+// a range query resumes from the key its bookmark is so it starts the page
+func changesStartKey(stub shim.ChaincodeStubInterface, req getChangesSinceRequest) (string, error) {
+	if req.Since == "" {
+		return stub.CreateCompositeKey(changeObjectType, []string{changeSequence(req.SinceTimestamp+1, 0)})
+	}
+
+	parts := strings.SplitN(req.Since, ".", 2)
+	if len(parts) != 2 {
+		return "", errors.New(fmt.Sprintf("Invalid cursor %s", req.Since))
+	}
+
+	// the keys of the transaction of the cursor sort before this one
+	return stub.CreateCompositeKey(changeObjectType, []string{parts[0], parts[1] + "\x01"})
+}
+
+// GetChangesSince will page through the identities changed after a checkpoint,
+// for read models to sync incrementally after a first ExportIdentities.
+// Transaction timestamps are set by clients, a reader may resume from a
+// checkpoint a few minutes back and skip the transactions it has seen.
+// No ciphertext is returned, admin only
+func (t *DewalletChaincode) GetChangesSince(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Querying identity changes")
+
+	err := t.requireAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var req getChangesSinceRequest
+	json.Unmarshal([]byte(args[0]), &req)
+
+	size, err := req.size()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	bookmark := req.Bookmark
+	if bookmark == "" {
+		bookmark, err = changesStartKey(stub, req)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	it, m, err := stub.GetStateByPartialCompositeKeyWithPagination(changeObjectType, []string{}, size, bookmark)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't query changes %s", err))
+	}
+	defer it.Close()
+
+	res := getChangesSinceResponse{Changes: []Change{}, Identities: []Identity{}, Checkpoint: req.Since, pageResponse: newPageResponse(m)}
+	for it.HasNext() {
+		kv, err := it.Next()
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Can't query changes %s", err))
+		}
+
+		var c Change
+		err = json.Unmarshal(kv.Value, &c)
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Error in parsing change %s", err))
+		}
+
+		i, err := getIdentity(stub, c.Username)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		res.Changes = append(res.Changes, c)
+		res.Identities = append(res.Identities, i.metadata())
+		res.Checkpoint = c.Cursor
+	}
+
+	resBytes, _ := marshal(res)
+
+	return shim.Success(resBytes)
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// claimObjectType prefixes the claims by username, type and attester
+const claimObjectType = "claim"
+
+// claimTypeObjectType indexes the claims by type, username and attester
+const claimTypeObjectType = "claimtype"
+
+// claimTypePattern matches the claim types, such as email-verified,
+// phone-verified or address-verified
+var claimTypePattern = regexp.MustCompile(`^[a-z][a-z0-9\-]{0,63}$`)
+
+// Claim is a fact about an identity asserted by an attester, an approved
+// verifier, Signature is the attester's signature over claimMessage
+// so anyone can check the claim against the attester's key, claims
+// attested by a proof system are unsigned, see VerifyPredicateProof
+type Claim struct {
+	Username          string `json:"username"`
+	Type              string `json:"type"`
+	Attester          string `json:"attester"`
+	EvidenceHash      string `json:"evidenceHash"`
+	IssuedAt          int64  `json:"issuedAt"`
+	ExpiresAt         int64  `json:"expiresAt,omitempty"`
+	Alg               string `json:"alg"`
+	KeyID             string `json:"keyId"`
+	Signature         string `json:"signature"`
+	SignatureEncoding string `json:"signatureEncoding"`
+	TxID              string `json:"txId"`
+}
+
+// claimMessage is the canonical payload an attester signs for a claim
+func claimMessage(c Claim) []byte {
+	m, _ := marshal(struct {
+		Username     string `json:"username"`
+		Type         string `json:"type"`
+		Attester     string `json:"attester"`
+		EvidenceHash string `json:"evidenceHash"`
+		IssuedAt     int64  `json:"issuedAt"`
+		ExpiresAt    int64  `json:"expiresAt"`
+	}{c.Username, c.Type, c.Attester, c.EvidenceHash, c.IssuedAt, c.ExpiresAt})
+
+	return m
+}
+
+// verifyClaim checks the signature of the claim against the registered key
+// of the attester and that the signature wasn't used before
+func verifyClaim(stub shim.ChaincodeStubInterface, c Claim, attester Identity) error {
+	publicKey, err := attester.signingKey(c.KeyID)
+	if err != nil {
+		return err
+	}
+
+	s, err := decodeSignature(c.Signature, c.SignatureEncoding)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error in decoding signature %s", err))
+	}
+
+	err = verifyWithAlgorithm(stub, c.Alg, publicKey, claimMessage(c), s)
+	if err != nil {
+		return err
+	}
+
+	nonceKey, err := stub.CreateCompositeKey(nonceObjectType, []string{attester.Username, sha256Hex(s)})
+	if err != nil {
+		return err
+	}
+
+	nBytes, err := stub.GetState(nonceKey)
+	if err != nil {
+		return errors.New("Failed to get state")
+	}
+	if nBytes != nil {
+		return errors.New("Claim signature already used")
+	}
+
+	return stub.PutState(nonceKey, []byte(stub.GetTxID()))
+}
+
+// valid tells whether the claim holds at now
+func (c Claim) valid(now int64) bool {
+	return c.ExpiresAt == 0 || now < c.ExpiresAt
+}
+
+func claimKeys(stub shim.ChaincodeStubInterface, username string, claimType string, attester string) (string, string, error) {
+	key, err := stub.CreateCompositeKey(claimObjectType, []string{username, claimType, attester})
+	if err != nil {
+		return "", "", err
+	}
+
+	typeKey, err := stub.CreateCompositeKey(claimTypeObjectType, []string{claimType, username, attester})
+	if err != nil {
+		return "", "", err
+	}
+
+	return key, typeKey, nil
+}
+
+// getClaimByKey reads the claim at key, nil when there is none
+func getClaimByKey(stub shim.ChaincodeStubInterface, key string) (*Claim, error) {
+	cBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get state")
+	}
+	if cBytes == nil {
+		return nil, nil
+	}
+
+	var c Claim
+	err = json.Unmarshal(cBytes, &c)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error in parsing claim %s", err))
+	}
+
+	return &c, nil
+}
+
+// putClaim writes the claim in the transaction, replacing the one
+// of the same type and attester, and indexes it by type
+func putClaim(stub shim.ChaincodeStubInterface, c Claim) ([]byte, error) {
+	c.TxID = stub.GetTxID()
+
+	key, typeKey, err := claimKeys(stub, c.Username, c.Type, c.Attester)
+	if err != nil {
+		return nil, err
+	}
+
+	cBytes, _ := marshal(c)
+	err = stub.PutState(key, cBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return cBytes, stub.PutState(typeKey, []byte(key))
+}
+
+// AddClaim will attach a claim of a type to a user, replacing the one
+// the attester made before, anyone can submit it, it is only stored once
+// the signature of the attester, an approved verifier, is verified
+func (t *DewalletChaincode) AddClaim(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Adding claim of user")
+
+	var c Claim
+	json.Unmarshal([]byte(args[0]), &c)
+
+	ai, err := getIdentity(stub, c.Attester)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't get attester %s %s", c.Attester, err))
+	}
+
+	err = requireVerifier(stub, ai)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = checkDenylist(stub, ai)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	i, err := getIdentity(stub, c.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if !claimTypePattern.MatchString(c.Type) {
+		return shim.Error(fmt.Sprintf("Invalid claim type %s", c.Type))
+	}
+	if !hashPattern.MatchString(c.EvidenceHash) {
+		return shim.Error("Evidence hash must be a hex SHA-256")
+	}
+
+	now, err := txSeconds(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if c.IssuedAt < now-envelopeMaxSkew || c.IssuedAt > now+envelopeMaxSkew {
+		return shim.Error("Claim issuance is outside the accepted window")
+	}
+	if c.ExpiresAt != 0 && c.ExpiresAt <= now {
+		return shim.Error("Claim expires in the past")
+	}
+
+	err = verifyClaim(stub, c, ai)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't verify claim %s", err))
+	}
+
+	cBytes, err := putClaim(stub, c)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = auditCreator(stub, i.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(cBytes)
+}
+
+type revokeClaimRequest struct {
+	Attester string `json:"attester"`
+	Username string `json:"username"`
+	Type     string `json:"type"`
+}
+
+// RevokeClaim will remove a claim the attester made about a user,
+// the request is signed by the attester
+func (t *DewalletChaincode) RevokeClaim(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Revoking claim of user")
+
+	var r revokeClaimRequest
+	env, err := t.ParseRequest(args, &r)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't parse request %s", err))
+	}
+
+	ai, err := getIdentity(stub, r.Attester)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't get attester %s %s", r.Attester, err))
+	}
+
+	err = t.VerifyRequest(stub, args, env, ai)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't verify signature %s", err))
+	}
+
+	err = resolveHandles(stub, &r.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	key, typeKey, err := claimKeys(stub, r.Username, r.Type, ai.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	c, err := getClaimByKey(stub, key)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if c == nil {
+		return shim.Error("Claim not found")
+	}
+
+	err = stub.DelState(key)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = stub.DelState(typeKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = audit(stub, c.Username, ai.Username, "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+type getClaimsRequest struct {
+	Username string `json:"username"`
+
+	// Type keeps the claims of one type, all when not given
+	Type string `json:"type"`
+}
+
+// GetClaims will query the blockchain and return the valid claims of a user
+func (t *DewalletChaincode) GetClaims(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Querying claims of user")
+
+	var req getClaimsRequest
+	json.Unmarshal([]byte(args[0]), &req)
+
+	err := resolveHandles(stub, &req.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	attributes := []string{req.Username}
+	if req.Type != "" {
+		attributes = append(attributes, req.Type)
+	}
+
+	now, err := txSeconds(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	it, err := stub.GetStateByPartialCompositeKey(claimObjectType, attributes)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't query claims %s", err))
+	}
+	defer it.Close()
+
+	res := []Claim{}
+	for it.HasNext() {
+		kv, err := it.Next()
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Can't query claims %s", err))
+		}
+
+		var c Claim
+		err = json.Unmarshal(kv.Value, &c)
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Error in parsing claim %s", err))
+		}
+
+		if c.valid(now) {
+			res = append(res, c)
+		}
+	}
+
+	resBytes, _ := marshal(res)
+
+	return shim.Success(resBytes)
+}
+
+type queryByClaimRequest struct {
+	Type string `json:"type"`
+
+	pageRequest
+}
+
+type queryByClaimResponse struct {
+	Claims []Claim `json:"claims"`
+
+	pageResponse
+}
+
+// QueryByClaim will page through the valid claims of a type of every user
+func (t *DewalletChaincode) QueryByClaim(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Querying claims by type")
+
+	var req queryByClaimRequest
+	json.Unmarshal([]byte(args[0]), &req)
+
+	if !claimTypePattern.MatchString(req.Type) {
+		return shim.Error(fmt.Sprintf("Invalid claim type %s", req.Type))
+	}
+
+	size, err := req.size()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	now, err := txSeconds(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	it, m, err := stub.GetStateByPartialCompositeKeyWithPagination(claimTypeObjectType, []string{req.Type}, size, req.Bookmark)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't query claims %s", err))
+	}
+	defer it.Close()
+
+	res := queryByClaimResponse{Claims: []Claim{}, pageResponse: newPageResponse(m)}
+	for it.HasNext() {
+		kv, err := it.Next()
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Can't query claims %s", err))
+		}
+
+		c, err := getClaimByKey(stub, string(kv.Value))
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if c != nil && c.valid(now) {
+			res.Claims = append(res.Claims, *c)
+		}
+	}
+
+	resBytes, _ := marshal(res)
+
+	return shim.Success(resBytes)
+}
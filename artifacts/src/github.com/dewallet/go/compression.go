@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Content encodings of stored values, a compressed value is marked with
+// its encoding so values of any encoding can coexist
+const (
+	contentEncodingGzip = "gzip"
+	contentEncodingZstd = "zstd"
+	// contentEncodingIdentity marks a value stored as is, only written
+	// for a value that starts like a marked one
+	contentEncodingIdentity = "identity"
+)
+
+// compressMinSize is the size below which values are stored as is
+const compressMinSize = 512
+
+// decompressMaxSize bounds the size of a decompressed value
+const decompressMaxSize = 64 << 20
+
+// contentEncodingMarker starts a marked value, the name of its encoding and
+// a NUL follow it. Values without it are stored as is, text and protobuf
+// never start with a NUL
+var contentEncodingMarker = []byte{0x00, 'c', 'e', 0x00}
+
+// markEncoding prefixes a value with the marker of its encoding
+func markEncoding(encoding string, value []byte) []byte {
+	m := append([]byte{}, contentEncodingMarker...)
+	m = append(m, encoding...)
+	m = append(m, 0x00)
+
+	return append(m, value...)
+}
+
+// storeAsIs is a value kept uncompressed, marked only when
+// it could be mistaken for a marked value
+func storeAsIs(value []byte) []byte {
+	if bytes.HasPrefix(value, contentEncodingMarker) {
+		return markEncoding(contentEncodingIdentity, value)
+	}
+
+	return value
+}
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+)
+
+// zstdCodecs creates the zstd coders once, a single encoder goroutine
+// keeps the output identical on every endorsing peer
+func zstdCodecs() (*zstd.Encoder, *zstd.Decoder) {
+	zstdOnce.Do(func() {
+		zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(decompressMaxSize))
+	})
+
+	return zstdEncoder, zstdDecoder
+}
+
+// compress encodes a value with the content encoding and marks it, small
+// values and values that don't shrink are kept as is
+func compress(encoding string, value []byte) ([]byte, error) {
+	if encoding == "" || len(value) < compressMinSize {
+		return storeAsIs(value), nil
+	}
+
+	var c []byte
+	switch encoding {
+	case contentEncodingGzip:
+		var b bytes.Buffer
+		w, _ := gzip.NewWriterLevel(&b, gzip.BestCompression)
+		w.Write(value)
+		err := w.Close()
+		if err != nil {
+			return nil, err
+		}
+		c = b.Bytes()
+	case contentEncodingZstd:
+		e, _ := zstdCodecs()
+		c = e.EncodeAll(value, nil)
+	default:
+		return nil, errors.New(fmt.Sprintf("Unsupported content encoding %s", encoding))
+	}
+
+	m := markEncoding(encoding, c)
+	if len(m) >= len(value) {
+		return storeAsIs(value), nil
+	}
+
+	return m, nil
+}
+
+// decompress decodes a stored value with the content encoding it is marked with
+func decompress(value []byte) ([]byte, error) {
+	if !bytes.HasPrefix(value, contentEncodingMarker) {
+		return value, nil
+	}
+
+	rest := value[len(contentEncodingMarker):]
+	n := bytes.IndexByte(rest, 0x00)
+	if n < 0 {
+		return nil, errors.New("Invalid content encoding marker")
+	}
+	encoding, value := string(rest[:n]), rest[n+1:]
+
+	switch encoding {
+	case contentEncodingIdentity:
+		return value, nil
+	case contentEncodingGzip:
+		r, err := gzip.NewReader(bytes.NewReader(value))
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Error in decompressing value %s", err))
+		}
+		d, err := ioutil.ReadAll(io.LimitReader(r, decompressMaxSize+1))
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Error in decompressing value %s", err))
+		}
+		if len(d) > decompressMaxSize {
+			return nil, errors.New("Decompressed value is too large")
+		}
+		return d, nil
+	case contentEncodingZstd:
+		_, dec := zstdCodecs()
+		d, err := dec.DecodeAll(value, nil)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Error in decompressing value %s", err))
+		}
+		return d, nil
+	default:
+		return nil, errors.New(fmt.Sprintf("Unsupported content encoding %s", encoding))
+	}
+}
+
+// contentEncoding is the configured encoding of written values
+func contentEncoding(stub shim.ChaincodeStubInterface) (string, error) {
+	c, err := getConfig(stub)
+	if err != nil {
+		return "", err
+	}
+
+	return c.ContentEncoding, nil
+}
+
+// putStoredState writes a value compressed with the configured encoding
+func putStoredState(stub shim.ChaincodeStubInterface, key string, value []byte) error {
+	encoding, err := contentEncoding(stub)
+	if err != nil {
+		return err
+	}
+
+	c, err := compress(encoding, value)
+	if err != nil {
+		return err
+	}
+
+	return stub.PutState(key, c)
+}
+
+// getStoredState reads a value written by putStoredState, nil when absent
+func getStoredState(stub shim.ChaincodeStubInterface, key string) ([]byte, error) {
+	value, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get state")
+	}
+	if value == nil {
+		return nil, nil
+	}
+
+	return decompress(value)
+}
+
+// putStoredPrivateData writes a private value compressed with the configured encoding
+func putStoredPrivateData(stub shim.ChaincodeStubInterface, collection string, key string, value []byte) error {
+	encoding, err := contentEncoding(stub)
+	if err != nil {
+		return err
+	}
+
+	c, err := compress(encoding, value)
+	if err != nil {
+		return err
+	}
+
+	return stub.PutPrivateData(collection, key, c)
+}
+
+// getStoredPrivateData reads a value written by putStoredPrivateData
+func getStoredPrivateData(stub shim.ChaincodeStubInterface, collection string, key string) ([]byte, error) {
+	value, err := stub.GetPrivateData(collection, key)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to get private data %s", err))
+	}
+	if value == nil {
+		return nil, nil
+	}
+
+	return decompress(value)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// configObjectType is the composite key of the chaincode configuration,
+// composite keys can't collide with usernames
+const configObjectType = "config"
+
+// Config is the deployment configuration of the chaincode, it is
+// passed as the JSON argument of Init when instantiating or upgrading
+type Config struct {
+	// PrivateData stores Data and wrapped keys in the private data
+	// collection of the registering org, only hashes stay on the ledger
+	PrivateData bool `json:"privateData"`
+	// CollectionPrefix is prepended to the MSP ID to name the org collection
+	CollectionPrefix string `json:"collectionPrefix"`
+	// AdminMSPs are the orgs whose members may call admin functions
+	AdminMSPs []string `json:"adminMSPs"`
+	// AdminAttribute restricts admin functions to the members of the admin orgs
+	// whose certificate has this attribute set to true, such as dewallet.admin
+	AdminAttribute string `json:"adminAttribute"`
+	// BindCreator makes the signed requests of an identity also require
+	// the transaction to be created by the client that registered it
+	BindCreator bool `json:"bindCreator"`
+	// BindEnrollment binds identities to the Fabric CA enrollment of the client
+	// registering them, with the role and email of its certificate, see enrollment.go
+	BindEnrollment bool `json:"bindEnrollment"`
+	// EnrollmentRoleAttribute and EnrollmentEmailAttribute name the certificate
+	// attributes read for the binding, hf.Type and email when not set
+	EnrollmentRoleAttribute  string `json:"enrollmentRoleAttribute"`
+	EnrollmentEmailAttribute string `json:"enrollmentEmailAttribute"`
+	// RequirePurpose makes keys, consents and data requests
+	// reference a registered purpose code, see RegisterPurpose
+	RequirePurpose bool `json:"requirePurpose"`
+	// FreezeQuorum is how many admin orgs lift a freeze,
+	// a majority of AdminMSPs when not set, see EmergencyFreeze
+	FreezeQuorum int `json:"freezeQuorum"`
+	// GovernanceQuorum is how many admin orgs change the verifiers and trust
+	// anchors, a majority of AdminMSPs when not set, see governance.go
+	GovernanceQuorum int `json:"governanceQuorum"`
+	// GovernanceVoteTTL is how many seconds a change collects votes, a week when not set
+	GovernanceVoteTTL int64 `json:"governanceVoteTTL"`
+	// VerificationValidity is how many seconds a verification lasts when its
+	// verifier gives no expiry, such as a year, forever when not set
+	VerificationValidity int64 `json:"verificationValidity"`
+	// TokenChaincode is the balance chaincode on the channel which gets a wallet
+	// for each registered identity and blocks those denylisted, see token.go
+	TokenChaincode string `json:"tokenChaincode"`
+	// SiblingDeployments are the deployments on other channels GetPublicKey
+	// and GetPublicKeys fall back to, see siblings.go
+	SiblingDeployments []SiblingDeployment `json:"siblingDeployments"`
+	// Limits bounds the size of stored values
+	Limits Limits `json:"limits"`
+	// RateLimits bounds how often an identity can call each function,
+	// such as 100 AddKey an hour, see checkRateLimit
+	RateLimits map[string]RateLimit `json:"rateLimits"`
+	// ContentEncoding compresses identities and data when written,
+	// gzip or zstd, values are decompressed on read whatever the setting
+	ContentEncoding string `json:"contentEncoding"`
+	// StateEncoding is json or protobuf, see state.proto,
+	// identities of either encoding are read whatever the setting
+	StateEncoding string `json:"stateEncoding"`
+	// Tenancy isolates the state of tenants sharing the deployment,
+	// msp or explicit, see tenant.go, tenants can then have their own config
+	Tenancy string `json:"tenancy"`
+	// Tenants are the MSPs allowed to act in each explicit tenant
+	Tenants map[string][]string `json:"tenants"`
+}
+
+// getConfig reads the configuration, the zero Config when none was set,
+// a tenant without a configuration of its own has the deployment one
+func getConfig(stub shim.ChaincodeStubInterface) (Config, error) {
+	var c Config
+
+	key, err := stub.CreateCompositeKey(configObjectType, []string{})
+	if err != nil {
+		return c, err
+	}
+
+	cBytes, err := stub.GetState(key)
+	if err != nil {
+		return c, errors.New("Failed to get state")
+	}
+	if cBytes == nil {
+		if ts, ok := tenantOf(stub); ok {
+			return getConfig(ts.ChaincodeStubInterface)
+		}
+		return c, nil
+	}
+
+	err = json.Unmarshal(cBytes, &c)
+	if err != nil {
+		return c, errors.New(fmt.Sprintf("Error in parsing config %s", err))
+	}
+
+	return c, nil
+}
+
+// putConfig writes the configuration
+func putConfig(stub shim.ChaincodeStubInterface, c Config) error {
+	key, err := stub.CreateCompositeKey(configObjectType, []string{})
+	if err != nil {
+		return err
+	}
+
+	cBytes, _ := marshal(c)
+	return stub.PutState(key, cBytes)
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// consentObjectType prefixes the consents by subject,
+// consentRecipientObjectType indexes them by recipient
+const (
+	consentObjectType          = "consent"
+	consentRecipientObjectType = "consentto"
+)
+
+// maxConsentPurposeLength bounds the purpose of a consent
+const maxConsentPurposeLength = 256
+
+// Statuses of a consent, expired is never stored
+// but reported once ExpiresAt is over
+const (
+	consentGiven     = "given"
+	consentWithdrawn = "withdrawn"
+	consentExpired   = "expired"
+)
+
+// Consent records that Subject agreed to Recipient using the data
+// in Scope for Purpose, independently of the keys it gave Recipient
+// Scope is slot/name or attribute/name, slot/ alone is the Data
+type Consent struct {
+	ID        string `json:"id"`
+	Subject   string `json:"subject"`
+	Recipient string `json:"recipient"`
+	Scope     string `json:"scope"`
+	Purpose   string `json:"purpose"`
+	Status    string `json:"status"`
+	GivenAt   int64  `json:"givenAt"`
+	ExpiresAt int64  `json:"expiresAt"`
+
+	WithdrawnAt int64 `json:"withdrawnAt,omitempty"`
+}
+
+// consentScope is the scope of a consent for a key of an attribute or of a slot
+func consentScope(attribute string, slot string) string {
+	if attribute != "" {
+		return "attribute/" + attribute
+	}
+
+	return "slot/" + slot
+}
+
+// status is the status of the consent at now
+func (c Consent) status(now int64) string {
+	if c.Status == consentGiven && c.ExpiresAt != 0 && now >= c.ExpiresAt {
+		return consentExpired
+	}
+
+	return c.Status
+}
+
+// consentTerms are what a subject consents to besides the recipient
+type consentTerms struct {
+	Purpose   string `json:"purpose"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+func (t consentTerms) validate(stub shim.ChaincodeStubInterface, now int64) error {
+	if t.Purpose == "" {
+		return errors.New("Consent has no purpose")
+	}
+	if t.ExpiresAt != 0 && t.ExpiresAt <= now {
+		return errors.New("Consent expires in the past")
+	}
+
+	err := checkSize("purpose", len(t.Purpose), maxConsentPurposeLength)
+	if err != nil {
+		return err
+	}
+
+	return checkPurpose(stub, t.Purpose)
+}
+
+// putConsent writes the consent and its recipient index entry
+func putConsent(stub shim.ChaincodeStubInterface, c Consent) error {
+	cKey, err := stub.CreateCompositeKey(consentObjectType, []string{c.Subject, c.ID})
+	if err != nil {
+		return err
+	}
+
+	cBytes, _ := marshal(c)
+	err = stub.PutState(cKey, cBytes)
+	if err != nil {
+		return err
+	}
+
+	rKey, err := stub.CreateCompositeKey(consentRecipientObjectType, []string{c.Recipient, c.Subject, c.ID})
+	if err != nil {
+		return err
+	}
+
+	return stub.PutState(rKey, []byte{0x00})
+}
+
+// getConsent reads a consent of subject, nil when there is none
+func getConsent(stub shim.ChaincodeStubInterface, subject string, id string) (*Consent, error) {
+	cKey, err := stub.CreateCompositeKey(consentObjectType, []string{subject, id})
+	if err != nil {
+		return nil, err
+	}
+
+	cBytes, err := stub.GetState(cKey)
+	if err != nil {
+		return nil, errors.New("Failed to get state")
+	}
+	if cBytes == nil {
+		return nil, nil
+	}
+
+	var c Consent
+	err = json.Unmarshal(cBytes, &c)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error in parsing consent %s", err))
+	}
+
+	return &c, nil
+}
+
+// giveConsent records a consent of the subject given in this transaction
+func giveConsent(stub shim.ChaincodeStubInterface, subject string, recipient string, scope string, terms consentTerms) (Consent, error) {
+	now, err := txSeconds(stub)
+	if err != nil {
+		return Consent{}, err
+	}
+
+	err = terms.validate(stub, now)
+	if err != nil {
+		return Consent{}, err
+	}
+
+	c := Consent{
+		ID:        stub.GetTxID(),
+		Subject:   subject,
+		Recipient: recipient,
+		Scope:     scope,
+		Purpose:   terms.Purpose,
+		Status:    consentGiven,
+		GivenAt:   now,
+		ExpiresAt: terms.ExpiresAt,
+	}
+
+	return c, putConsent(stub, c)
+}
+
+type giveConsentRequest struct {
+	Username  string `json:"username"`
+	Recipient string `json:"recipient"`
+	Attribute string `json:"attribute"`
+	Slot      string `json:"slot"`
+
+	consentTerms
+}
+
+// GiveConsent will record the consent of a user to another
+// using some of its data, see also the consent of AddKey
+func (t *DewalletChaincode) GiveConsent(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Giving consent of user")
+
+	var r giveConsentRequest
+	env, err := t.ParseRequest(args, &r)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't parse request %s", err))
+	}
+
+	i, err := getIdentity(stub, r.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = t.VerifyRequest(stub, args, env, i)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't verify signature %s", err))
+	}
+
+	if r.Attribute != "" && r.Slot != "" {
+		return shim.Error("A consent is scoped to an attribute or a slot, not both")
+	}
+
+	err = resolveHandles(stub, &r.Recipient)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	_, err = getIdentity(stub, r.Recipient)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't get recipient %s %s", r.Recipient, err))
+	}
+
+	c, err := giveConsent(stub, i.Username, r.Recipient, consentScope(r.Attribute, r.Slot), r.consentTerms)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	cBytes, _ := marshal(c)
+
+	return shim.Success(cBytes)
+}
+
+type withdrawConsentRequest struct {
+	Username string `json:"username"`
+	ID       string `json:"id"`
+}
+
+// revokedGrant is a key removed with the consent it was given under
+type revokedGrant struct {
+	Username  string `json:"username"`
+	Owner     string `json:"owner"`
+	Attribute string `json:"attribute,omitempty"`
+	Slot      string `json:"slot"`
+	Purpose   string `json:"purpose,omitempty"`
+	ConsentID string `json:"consentId"`
+}
+
+// revokeConsentKeys removes the keys of the identity given with the consent,
+// and those given to its recipient within its scope for its purpose
+func revokeConsentKeys(stub shim.ChaincodeStubInterface, i *Identity, c Consent) ([]revokedGrant, error) {
+	removed, err := removeKeys(stub, i, func(k Key) bool {
+		if k.ConsentID == c.ID {
+			return true
+		}
+
+		return k.Owner == c.Recipient && consentScope(k.Attribute, k.Slot) == c.Scope && k.Purpose != "" && k.Purpose == c.Purpose
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	revoked := []revokedGrant{}
+	for _, k := range removed {
+		revoked = append(revoked, revokedGrant{Username: i.Username, Owner: k.Owner, Attribute: k.Attribute, Slot: k.Slot, Purpose: k.Purpose, ConsentID: c.ID})
+	}
+
+	return revoked, nil
+}
+
+type withdrawConsentResponse struct {
+	Consent
+
+	Revoked []revokedGrant `json:"revoked"`
+}
+
+// WithdrawConsent will withdraw a consent the user gave and remove the keys
+// it covers, a GrantsRevoked event lists them so others stop honoring them
+func (t *DewalletChaincode) WithdrawConsent(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Withdrawing consent of user")
+
+	var r withdrawConsentRequest
+	env, err := t.ParseRequest(args, &r)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't parse request %s", err))
+	}
+
+	i, err := getIdentity(stub, r.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = t.VerifyRequest(stub, args, env, i)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't verify signature %s", err))
+	}
+
+	c, err := getConsent(stub, i.Username, r.ID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if c == nil {
+		return shim.Error("Consent not found")
+	}
+	if c.Status != consentGiven {
+		return shim.Error(fmt.Sprintf("Consent is %s", c.Status))
+	}
+
+	c.Status = consentWithdrawn
+	c.WithdrawnAt, err = txSeconds(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = putConsent(stub, *c)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	prev := i
+	revoked, err := revokeConsentKeys(stub, &i, *c)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if len(revoked) > 0 {
+		err = updateIndexes(stub, prev, i)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		_, err = putIdentity(stub, i)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		err = emitEvent(stub, &GrantsRevokedEvent{Grants: revoked})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	resBytes, _ := marshal(withdrawConsentResponse{Consent: *c, Revoked: revoked})
+
+	return shim.Success(resBytes)
+}
+
+type getConsentsRequest struct {
+	Username string `json:"username"`
+	Token    string `json:"token"`
+
+	// Received lists the consents given to the user instead of by the user
+	Received bool `json:"received"`
+
+	pageRequest
+}
+
+type getConsentsResponse struct {
+	Consents []Consent `json:"consents"`
+
+	pageResponse
+}
+
+// GetConsents will page through the consents a user gave, or was given,
+// with their current status, the user proves itself with a session token
+func (t *DewalletChaincode) GetConsents(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Querying consents of user")
+
+	var req getConsentsRequest
+	json.Unmarshal([]byte(args[0]), &req)
+
+	err := resolveHandles(stub, &req.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = t.VerifySession(stub, req.Token, req.Username)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't verify session %s", err))
+	}
+
+	size, err := req.size()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	now, err := txSeconds(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	objectType := consentObjectType
+	if req.Received {
+		objectType = consentRecipientObjectType
+	}
+
+	it, m, err := stub.GetStateByPartialCompositeKeyWithPagination(objectType, []string{req.Username}, size, req.Bookmark)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't query consents %s", err))
+	}
+	defer it.Close()
+
+	res := getConsentsResponse{Consents: []Consent{}, pageResponse: newPageResponse(m)}
+	for it.HasNext() {
+		kv, err := it.Next()
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Can't query consents %s", err))
+		}
+
+		var c Consent
+		if req.Received {
+			_, attrs, err := stub.SplitCompositeKey(kv.Key)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+
+			rc, err := getConsent(stub, attrs[1], attrs[2])
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			if rc == nil {
+				continue
+			}
+			c = *rc
+		} else {
+			err = json.Unmarshal(kv.Value, &c)
+			if err != nil {
+				return shim.Error(fmt.Sprintf("Error in parsing consent %s", err))
+			}
+		}
+
+		c.Status = c.status(now)
+		res.Consents = append(res.Consents, c)
+	}
+
+	resBytes, _ := marshal(res)
+
+	return shim.Success(resBytes)
+}
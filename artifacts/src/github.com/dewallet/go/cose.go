@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// coseSign1Tag is the CBOR tag of a COSE_Sign1 message (RFC 8152)
+const coseSign1Tag = 18
+
+// COSE header labels
+const (
+	coseHeaderAlg  = 1
+	coseHeaderCrit = 2
+)
+
+// coseAlgorithms maps COSE algorithm identifiers to the equivalent JWS algorithm
+var coseAlgorithms = map[int64]string{
+	-7:   "ES256",
+	-35:  "ES384",
+	-36:  "ES512",
+	-8:   "EdDSA",
+	-37:  "PS256",
+	-38:  "PS384",
+	-39:  "PS512",
+	-257: "RS256",
+	-258: "RS384",
+	-259: "RS512",
+}
+
+// coseSign1 is a decoded COSE_Sign1 message
+type coseSign1 struct {
+	Protected []byte
+	Alg       string
+	Payload   []byte
+	Signature []byte
+}
+
+// isCOSESign1 tells whether an argument looks like a COSE_Sign1 message,
+// either tagged or as the bare four item array, rather than a JSON request
+func isCOSESign1(arg string) bool {
+	return len(arg) > 0 && (arg[0] == 0xd2 || arg[0] == 0x84)
+}
+
+// parseCOSESign1 decodes a COSE_Sign1 message with an attached payload
+func parseCOSESign1(data []byte) (coseSign1, error) {
+	var m coseSign1
+
+	v, err := decodeCBOR(data)
+	if err != nil {
+		return m, errors.New(fmt.Sprintf("Error in decoding COSE message %s", err))
+	}
+
+	if tag, ok := v.(cborTag); ok {
+		if tag.Number != coseSign1Tag {
+			return m, errors.New(fmt.Sprintf("Unexpected COSE tag %d", tag.Number))
+		}
+		v = tag.Content
+	}
+
+	a, ok := v.([]interface{})
+	if !ok || len(a) != 4 {
+		return m, errors.New("COSE_Sign1 must be an array of four items")
+	}
+
+	protected, ok1 := a[0].([]byte)
+	_, ok2 := a[1].(map[interface{}]interface{})
+	payload, ok3 := a[2].([]byte)
+	signature, ok4 := a[3].([]byte)
+	if !ok1 || !ok2 || !ok3 || !ok4 {
+		return m, errors.New("Malformed COSE_Sign1 message, detached payloads are not supported")
+	}
+
+	h, err := decodeCBOR(protected)
+	if err != nil {
+		return m, errors.New(fmt.Sprintf("Error in decoding COSE protected header %s", err))
+	}
+	headers, ok := h.(map[interface{}]interface{})
+	if !ok {
+		return m, errors.New("COSE protected header must be a map")
+	}
+	if _, ok := headers[int64(coseHeaderCrit)]; ok {
+		return m, errors.New("Critical COSE headers are not supported")
+	}
+
+	alg, ok := headers[int64(coseHeaderAlg)].(int64)
+	if !ok {
+		return m, errors.New("COSE protected header must carry an integer alg")
+	}
+	m.Alg, ok = coseAlgorithms[alg]
+	if !ok {
+		return m, errors.New(fmt.Sprintf("Unsupported COSE algorithm %d", alg))
+	}
+
+	m.Protected = protected
+	m.Payload = payload
+	m.Signature = signature
+
+	return m, nil
+}
+
+// sigStructure builds the Sig_structure the COSE_Sign1 signature is computed over
+func (m coseSign1) sigStructure() []byte {
+	b := appendCBORHead(nil, cborArray, 4)
+	b = appendCBORText(b, "Signature1")
+	b = appendCBORBytes(b, m.Protected)
+	b = appendCBORBytes(b, nil)
+	return appendCBORBytes(b, m.Payload)
+}
+
+// payloadJSON converts the CBOR map payload of the message to a JSON request
+func (m coseSign1) payloadJSON() (string, error) {
+	v, err := decodeCBOR(m.Payload)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Error in decoding COSE payload %s", err))
+	}
+	if _, ok := v.(map[interface{}]interface{}); !ok {
+		return "", errors.New("COSE payload must be a map")
+	}
+
+	j, err := cborToJSON(v)
+	if err != nil {
+		return "", err
+	}
+
+	jBytes, err := marshal(j)
+	if err != nil {
+		return "", err
+	}
+
+	return string(jBytes), nil
+}
+
+// decodeCOSEArgs rewrites a request sent as a single COSE_Sign1 argument
+// into the JSON message and signature arguments the handlers expect,
+// the COSE message itself takes the place of the signature
+func decodeCOSEArgs(args []string) ([]string, error) {
+	if len(args) != 1 || !isCOSESign1(args[0]) {
+		return args, nil
+	}
+
+	m, err := parseCOSESign1([]byte(args[0]))
+	if err != nil {
+		return nil, err
+	}
+
+	j, err := m.payloadJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{j, args[0]}, nil
+}
+
+// verifyCOSESign1 verifies a COSE_Sign1 message against a base64 encoded
+// PKIX public key and checks that its payload is the JSON message
+func verifyCOSESign1(stub shim.ChaincodeStubInterface, message string, cose string, publicKey string) error {
+	m, err := parseCOSESign1([]byte(cose))
+	if err != nil {
+		return err
+	}
+
+	j, err := m.payloadJSON()
+	if err != nil {
+		return err
+	}
+	if j != message {
+		return errors.New("COSE payload does not match the message")
+	}
+
+	pk, err := parsePublicKey(stub, publicKey)
+	if err != nil {
+		return err
+	}
+
+	err = verifyJWSSignature(m.Alg, pk, m.sigStructure(), m.Signature)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error in verifying signature %s", err))
+	}
+
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// creatorID is the unique ID within its MSP of the client that created the transaction
+func creatorID(stub shim.ChaincodeStubInterface) (string, error) {
+	id, err := cid.GetID(stub)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Failed to get client ID %s", err))
+	}
+
+	return id, nil
+}
+
+// checkCreator checks, when the deployment binds creators, that the client
+// creating the transaction is the one that registered the identity,
+// identities registered before they were bound can be changed by anyone
+func checkCreator(stub shim.ChaincodeStubInterface, i Identity) error {
+	c, err := getConfig(stub)
+	if err != nil {
+		return err
+	}
+	if !c.BindCreator || i.Creator == "" {
+		return nil
+	}
+
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Failed to get MSP ID %s", err))
+	}
+
+	id, err := creatorID(stub)
+	if err != nil {
+		return err
+	}
+
+	if mspID != i.Org || id != i.Creator {
+		return errors.New(fmt.Sprintf("Transaction creator is not bound to %s", i.Username))
+	}
+
+	return nil
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// credentialObjectType prefixes the verifiable credentials by id
+const credentialObjectType = "credential"
+
+// credentialSubjectObjectType indexes the credentials by the username they are bound to
+const credentialSubjectObjectType = "credentialsubject"
+
+// maxCredentialTypes bounds the types of a credential
+const maxCredentialTypes = 8
+
+// Status of a credential, see RevokeCredential for revoking it
+const (
+	credentialActive    = "active"
+	credentialSuspended = "suspended"
+	credentialRevoked   = "revoked"
+)
+
+// credentialIDPattern matches the ids of credentials, such as urn:uuid:...
+var credentialIDPattern = regexp.MustCompile(`^[A-Za-z0-9:._\-/#]{1,128}$`)
+
+// hashPattern matches a hex SHA-256
+var hashPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// Credential is a W3C verifiable credential issued by a verifier to a user,
+// only the hash of the signed credential and its status are public,
+// the credential itself is kept off chain or as Ciphertext for the user
+type Credential struct {
+	ID         string   `json:"id"`
+	Subject    string   `json:"subject"`
+	Issuer     string   `json:"issuer"`
+	Types      []string `json:"types"`
+	Hash       string   `json:"hash"`
+	Ciphertext string   `json:"ciphertext,omitempty"`
+	IssuedAt   int64    `json:"issuedAt"`
+	ExpiresAt  int64    `json:"expiresAt,omitempty"`
+	Status     string   `json:"status"`
+	TxID       string   `json:"txId"`
+
+	// StatusIndex is the bit of the credential in the status lists of its issuer
+	StatusIndex int64 `json:"statusIndex"`
+}
+
+// credentialStatus is the public view of a credential
+type credentialStatus struct {
+	ID        string   `json:"id"`
+	Issuer    string   `json:"issuer"`
+	Types     []string `json:"types"`
+	Hash      string   `json:"hash"`
+	IssuedAt  int64    `json:"issuedAt"`
+	ExpiresAt int64    `json:"expiresAt,omitempty"`
+	Status    string   `json:"status"`
+
+	StatusIndex int64 `json:"statusIndex"`
+}
+
+// getCredential reads a credential, nil when there is none
+func getCredential(stub shim.ChaincodeStubInterface, id string) (*Credential, error) {
+	key, err := stub.CreateCompositeKey(credentialObjectType, []string{id})
+	if err != nil {
+		return nil, err
+	}
+
+	cBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get state")
+	}
+	if cBytes == nil {
+		return nil, nil
+	}
+
+	var c Credential
+	err = json.Unmarshal(cBytes, &c)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error in parsing credential %s", err))
+	}
+
+	return &c, nil
+}
+
+func putCredential(stub shim.ChaincodeStubInterface, c Credential) ([]byte, error) {
+	key, err := stub.CreateCompositeKey(credentialObjectType, []string{c.ID})
+	if err != nil {
+		return nil, err
+	}
+
+	cBytes, _ := marshal(c)
+	return cBytes, stub.PutState(key, cBytes)
+}
+
+// indexCredential points the subject of the credential to it,
+// or drops the pointer once the subject removed it
+func indexCredential(stub shim.ChaincodeStubInterface, c Credential, remove bool) error {
+	key, err := stub.CreateCompositeKey(credentialSubjectObjectType, []string{c.Subject, c.ID})
+	if err != nil {
+		return err
+	}
+
+	if remove {
+		return stub.DelState(key)
+	}
+
+	return stub.PutState(key, []byte(c.ID))
+}
+
+type issueCredentialRequest struct {
+	Issuer   string `json:"issuer"`
+	Username string `json:"username"`
+	ID       string `json:"id"`
+
+	Types []string `json:"types"`
+
+	// Hash is the hex SHA-256 of the signed credential
+	Hash string `json:"hash"`
+
+	// Ciphertext is the signed credential encrypted for the user, optional
+	Ciphertext string `json:"ciphertext"`
+
+	ExpiresAt int64 `json:"expiresAt"`
+}
+
+// IssueCredential will bind a verifiable credential to a user,
+// the request is signed by an approved verifier, the issuer
+func (t *DewalletChaincode) IssueCredential(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Issuing credential to user")
+
+	var r issueCredentialRequest
+	env, err := t.ParseRequest(args, &r)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't parse request %s", err))
+	}
+
+	vi, err := getIdentity(stub, r.Issuer)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't get issuer %s %s", r.Issuer, err))
+	}
+
+	err = t.VerifyRequest(stub, args, env, vi)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't verify signature %s", err))
+	}
+
+	err = requireVerifier(stub, vi)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	i, err := getIdentity(stub, r.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if !credentialIDPattern.MatchString(r.ID) {
+		return shim.Error(fmt.Sprintf("Invalid credential id %s", r.ID))
+	}
+	if !hashPattern.MatchString(r.Hash) {
+		return shim.Error("Credential hash must be a hex SHA-256")
+	}
+	if len(r.Types) == 0 || len(r.Types) > maxCredentialTypes {
+		return shim.Error(fmt.Sprintf("A credential has 1 to %d types", maxCredentialTypes))
+	}
+
+	l, err := getLimits(stub, i)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = l.checkData("ciphertext", r.Ciphertext)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	now, err := txSeconds(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if r.ExpiresAt != 0 && r.ExpiresAt <= now {
+		return shim.Error("Credential expires in the past")
+	}
+
+	prev, err := getCredential(stub, r.ID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if prev != nil {
+		return shim.Error(fmt.Sprintf("Credential %s already exists", r.ID))
+	}
+
+	index, err := nextStatusIndex(stub, vi.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	c := Credential{
+		ID:         r.ID,
+		Subject:    i.Username,
+		Issuer:     vi.Username,
+		Types:      r.Types,
+		Hash:       r.Hash,
+		Ciphertext: r.Ciphertext,
+		IssuedAt:   now,
+		ExpiresAt:  r.ExpiresAt,
+		Status:     credentialActive,
+		TxID:       stub.GetTxID(),
+
+		StatusIndex: index,
+	}
+
+	err = indexCredential(stub, c, false)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = audit(stub, i.Username, vi.Username, "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	cBytes, err := putCredential(stub, c)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(cBytes)
+}
+
+type setCredentialStatusRequest struct {
+	Issuer string `json:"issuer"`
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
+
+// SetCredentialStatus will suspend or reinstate a credential,
+// the request is signed by its issuer
+func (t *DewalletChaincode) SetCredentialStatus(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Setting status of credential")
+
+	var r setCredentialStatusRequest
+	env, err := t.ParseRequest(args, &r)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't parse request %s", err))
+	}
+
+	vi, err := getIdentity(stub, r.Issuer)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't get issuer %s %s", r.Issuer, err))
+	}
+
+	err = t.VerifyRequest(stub, args, env, vi)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't verify signature %s", err))
+	}
+
+	c, err := getCredential(stub, r.ID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if c == nil || c.Issuer != vi.Username {
+		return shim.Error("Credential not found")
+	}
+
+	if r.Status != credentialActive && r.Status != credentialSuspended {
+		return shim.Error(fmt.Sprintf("Unsupported credential status %s", r.Status))
+	}
+	if c.Status == credentialRevoked {
+		return shim.Error("Credential is revoked, see UnrevokeCredential")
+	}
+
+	if c.Status != r.Status {
+		err = setStatusBit(stub, c.Issuer, statusPurposeSuspension, c.StatusIndex, r.Status == credentialSuspended)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	c.Status = r.Status
+
+	err = audit(stub, c.Subject, vi.Username, "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	cBytes, err := putCredential(stub, *c)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(cBytes)
+}
+
+type removeCredentialRequest struct {
+	Username string `json:"username"`
+	ID       string `json:"id"`
+}
+
+// RemoveCredential will remove a credential from the user and drop its
+// ciphertext, its hash and status stay public, the request is signed by the user
+func (t *DewalletChaincode) RemoveCredential(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Removing credential of user")
+
+	var r removeCredentialRequest
+	env, err := t.ParseRequest(args, &r)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't parse request %s", err))
+	}
+
+	i, err := getIdentity(stub, r.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = t.VerifyRequest(stub, args, env, i)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't verify signature %s", err))
+	}
+
+	c, err := getCredential(stub, r.ID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if c == nil || c.Subject != i.Username {
+		return shim.Error("Credential not found")
+	}
+
+	err = indexCredential(stub, *c, true)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	c.Ciphertext = ""
+
+	_, err = putCredential(stub, *c)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+type getCredentialsRequest struct {
+	Username string `json:"username"`
+	Token    string `json:"token"`
+
+	pageRequest
+}
+
+type getCredentialsResponse struct {
+	Credentials []Credential `json:"credentials"`
+
+	pageResponse
+}
+
+// GetCredentials will page through the credentials of a user with
+// their ciphertext, the user proves itself with a session token
+func (t *DewalletChaincode) GetCredentials(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Querying credentials of user")
+
+	var req getCredentialsRequest
+	json.Unmarshal([]byte(args[0]), &req)
+
+	err := resolveHandles(stub, &req.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = t.VerifySession(stub, req.Token, req.Username)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't verify session %s", err))
+	}
+
+	size, err := req.size()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	it, m, err := stub.GetStateByPartialCompositeKeyWithPagination(credentialSubjectObjectType, []string{req.Username}, size, req.Bookmark)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't query credentials %s", err))
+	}
+	defer it.Close()
+
+	res := getCredentialsResponse{Credentials: []Credential{}, pageResponse: newPageResponse(m)}
+	for it.HasNext() {
+		kv, err := it.Next()
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Can't query credentials %s", err))
+		}
+
+		c, err := getCredential(stub, string(kv.Value))
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if c != nil {
+			res.Credentials = append(res.Credentials, *c)
+		}
+	}
+
+	resBytes, _ := marshal(res)
+
+	return shim.Success(resBytes)
+}
+
+type getCredentialStatusRequest struct {
+	ID string `json:"id"`
+}
+
+// GetCredentialStatus will query the blockchain and return the issuer, hash
+// and status of a credential, for relying parties presented with it
+func (t *DewalletChaincode) GetCredentialStatus(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Querying status of credential")
+
+	var req getCredentialStatusRequest
+	json.Unmarshal([]byte(args[0]), &req)
+
+	c, err := getCredential(stub, req.ID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if c == nil {
+		return shim.Error("Credential not found")
+	}
+
+	s := credentialStatus{
+		ID:        c.ID,
+		Issuer:    c.Issuer,
+		Types:     c.Types,
+		Hash:      c.Hash,
+		IssuedAt:  c.IssuedAt,
+		ExpiresAt: c.ExpiresAt,
+		Status:    c.Status,
+
+		StatusIndex: c.StatusIndex,
+	}
+
+	sBytes, _ := marshal(s)
+
+	return shim.Success(sBytes)
+}
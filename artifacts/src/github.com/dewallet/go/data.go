@@ -0,0 +1,110 @@
+package main
+
+import "github.com/hyperledger/fabric/core/chaincode/shim"
+
+// dataObjectType prefixes the state key of the encrypted data of an identity,
+// keeping the blob out of the identity so reading keys doesn't load it
+const dataObjectType = "data"
+
+// dataKey is the state key of the encrypted data in a slot of username
+func dataKey(stub shim.ChaincodeStubInterface, username string, slot string) (string, error) {
+	attributes := []string{username}
+	if slot != "" {
+		attributes = append(attributes, slot)
+	}
+
+	return stub.CreateCompositeKey(dataObjectType, attributes)
+}
+
+// privateDataKey is the private data key of the data in a slot,
+// the unnamed slot is kept under the username
+func privateDataKey(stub shim.ChaincodeStubInterface, username string, slot string) (string, error) {
+	if slot == "" {
+		return username, nil
+	}
+
+	return dataKey(stub, username, slot)
+}
+
+// putUserData writes the encrypted data in a slot of username,
+// in place of the chunks of an upload
+func putUserData(stub shim.ChaincodeStubInterface, username string, slot string, data string) error {
+	err := delDataManifest(stub, username, slot)
+	if err != nil {
+		return err
+	}
+
+	key, err := dataKey(stub, username, slot)
+	if err != nil {
+		return err
+	}
+
+	return putStoredState(stub, key, []byte(data))
+}
+
+// getUserData reads the encrypted data in a slot of the identity, assembled
+// from the chunks of an upload when it was committed from one. Identities
+// written before the data had its own key still carry it inline
+func getUserData(stub shim.ChaincodeStubInterface, i Identity, slot string) (string, error) {
+	m, err := getDataManifest(stub, i.Username, slot)
+	if err != nil {
+		return "", err
+	}
+	if m != nil {
+		return assembleData(stub, i.Username, *m)
+	}
+
+	key, err := dataKey(stub, i.Username, slot)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := getStoredState(stub, key)
+	if err != nil {
+		return "", err
+	}
+	if data == nil && slot == "" {
+		return i.Data, nil
+	}
+
+	return string(data), nil
+}
+
+// putSlotData writes the ciphertext of a slot on the ledger or,
+// in private data mode, in the collection of the identity
+func putSlotData(stub shim.ChaincodeStubInterface, i Identity, slot string, data []byte) error {
+	if i.Collection == "" {
+		return putUserData(stub, i.Username, slot, string(data))
+	}
+
+	dKey, err := privateDataKey(stub, i.Username, slot)
+	if err != nil {
+		return err
+	}
+
+	return putStoredPrivateData(stub, i.Collection, dKey, data)
+}
+
+// delSlotData deletes the ciphertext of a slot, with the chunks it was uploaded in
+func delSlotData(stub shim.ChaincodeStubInterface, i Identity, slot string) error {
+	if i.Collection == "" {
+		err := delDataManifest(stub, i.Username, slot)
+		if err != nil {
+			return err
+		}
+
+		key, err := dataKey(stub, i.Username, slot)
+		if err != nil {
+			return err
+		}
+
+		return stub.DelState(key)
+	}
+
+	dKey, err := privateDataKey(stub, i.Username, slot)
+	if err != nil {
+		return err
+	}
+
+	return stub.DelPrivateData(i.Collection, dKey)
+}
@@ -0,0 +1,487 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// dataRequestObjectType prefixes the data requests by subject,
+// dataRequestSentObjectType indexes them by requester
+const (
+	dataRequestObjectType     = "datarequest"
+	dataRequestSentObjectType = "datarequestby"
+)
+
+// defaultDataRequestTTL is how long in seconds a request stays pending
+// when the requester doesn't say
+const defaultDataRequestTTL = 7 * 24 * 60 * 60
+
+// Statuses of a data request, a pending request past ExpiresAt
+// is reported expired until ExpireDataRequest stores it
+const (
+	dataRequestPending  = "pending"
+	dataRequestApproved = "approved"
+	dataRequestDenied   = "denied"
+	dataRequestExpired  = "expired"
+)
+
+// DataRequest is Requester asking Subject for a key to the data
+// of an attribute or a slot, approving it gives the key and records
+// the consent, see ConsentID
+type DataRequest struct {
+	ID        string `json:"id"`
+	Subject   string `json:"subject"`
+	Requester string `json:"requester"`
+	Attribute string `json:"attribute,omitempty"`
+	Slot      string `json:"slot"`
+	Purpose   string `json:"purpose"`
+	Status    string `json:"status"`
+	CreatedAt int64  `json:"createdAt"`
+	ExpiresAt int64  `json:"expiresAt"`
+	DecidedAt int64  `json:"decidedAt,omitempty"`
+	ConsentID string `json:"consentId,omitempty"`
+}
+
+// status is the status of the request at now
+func (r DataRequest) status(now int64) string {
+	if r.Status == dataRequestPending && now >= r.ExpiresAt {
+		return dataRequestExpired
+	}
+
+	return r.Status
+}
+
+// putDataRequest writes the request and its requester index entry
+// and emits the event of its status
+func putDataRequest(stub shim.ChaincodeStubInterface, r DataRequest) error {
+	rKey, err := stub.CreateCompositeKey(dataRequestObjectType, []string{r.Subject, r.ID})
+	if err != nil {
+		return err
+	}
+
+	rBytes, _ := marshal(r)
+	err = stub.PutState(rKey, rBytes)
+	if err != nil {
+		return err
+	}
+
+	sKey, err := stub.CreateCompositeKey(dataRequestSentObjectType, []string{r.Requester, r.Subject, r.ID})
+	if err != nil {
+		return err
+	}
+
+	err = stub.PutState(sKey, []byte{0x00})
+	if err != nil {
+		return err
+	}
+
+	return emitEvent(stub, &DataRequestEvent{
+		ID:        r.ID,
+		Subject:   r.Subject,
+		Requester: r.Requester,
+		Attribute: r.Attribute,
+		Slot:      r.Slot,
+		Purpose:   r.Purpose,
+		Status:    r.Status,
+		ExpiresAt: r.ExpiresAt,
+	})
+}
+
+// getDataRequest reads a request made to subject, nil when there is none
+func getDataRequest(stub shim.ChaincodeStubInterface, subject string, id string) (*DataRequest, error) {
+	rKey, err := stub.CreateCompositeKey(dataRequestObjectType, []string{subject, id})
+	if err != nil {
+		return nil, err
+	}
+
+	rBytes, err := stub.GetState(rKey)
+	if err != nil {
+		return nil, errors.New("Failed to get state")
+	}
+	if rBytes == nil {
+		return nil, nil
+	}
+
+	var r DataRequest
+	err = json.Unmarshal(rBytes, &r)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error in parsing data request %s", err))
+	}
+
+	return &r, nil
+}
+
+// pendingDataRequest reads a request made to subject that can still be decided
+func pendingDataRequest(stub shim.ChaincodeStubInterface, subject string, id string, now int64) (*DataRequest, error) {
+	r, err := getDataRequest(stub, subject, id)
+	if err != nil {
+		return nil, err
+	}
+	if r == nil {
+		return nil, errors.New("Data request not found")
+	}
+	if status := r.status(now); status != dataRequestPending {
+		return nil, errors.New(fmt.Sprintf("Data request is %s", status))
+	}
+
+	return r, nil
+}
+
+type requestDataRequest struct {
+	Username  string `json:"username"`
+	Subject   string `json:"subject"`
+	Attribute string `json:"attribute"`
+	Slot      string `json:"slot"`
+	Purpose   string `json:"purpose"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+// RequestData will ask another user for a key to some of its data,
+// the request stays pending until the subject approves or denies it
+func (t *DewalletChaincode) RequestData(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Requesting data of user")
+
+	var r requestDataRequest
+	env, err := t.ParseRequest(args, &r)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't parse request %s", err))
+	}
+
+	i, err := getIdentity(stub, r.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = t.VerifyRequest(stub, args, env, i)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't verify signature %s", err))
+	}
+
+	if r.Attribute != "" && !attributeNamePattern.MatchString(r.Attribute) {
+		return shim.Error(fmt.Sprintf("Invalid attribute name %s", r.Attribute))
+	}
+	if r.Slot != "" && !slotNamePattern.MatchString(r.Slot) {
+		return shim.Error(fmt.Sprintf("Invalid slot name %s", r.Slot))
+	}
+	if r.Attribute != "" && r.Slot != "" {
+		return shim.Error("A data request is scoped to an attribute or a slot, not both")
+	}
+
+	err = resolveHandles(stub, &r.Subject)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	_, err = getIdentity(stub, r.Subject)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't get subject %s %s", r.Subject, err))
+	}
+
+	now, err := txSeconds(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	terms := consentTerms{Purpose: r.Purpose, ExpiresAt: r.ExpiresAt}
+	err = terms.validate(stub, now)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	dr := DataRequest{
+		ID:        stub.GetTxID(),
+		Subject:   r.Subject,
+		Requester: i.Username,
+		Attribute: r.Attribute,
+		Slot:      r.Slot,
+		Purpose:   r.Purpose,
+		Status:    dataRequestPending,
+		CreatedAt: now,
+		ExpiresAt: r.ExpiresAt,
+	}
+	if dr.ExpiresAt == 0 {
+		dr.ExpiresAt = now + defaultDataRequestTTL
+	}
+
+	err = putDataRequest(stub, dr)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	drBytes, _ := marshal(dr)
+
+	return shim.Success(drBytes)
+}
+
+type approveDataRequestRequest struct {
+	Username string `json:"username"`
+	ID       string `json:"id"`
+	Key      string `json:"key"`
+	KeyHash  string `json:"keyHash"`
+
+	// ConsentExpiresAt ends the consent recorded with the approval, when given
+	ConsentExpiresAt int64 `json:"consentExpiresAt"`
+}
+
+// ApproveDataRequest will give the requester the wrapped key of the request,
+// like AddKey, and record the consent of the user for its purpose
+func (t *DewalletChaincode) ApproveDataRequest(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Approving data request of user")
+
+	var r approveDataRequestRequest
+	env, err := t.ParseRequest(args, &r)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't parse request %s", err))
+	}
+
+	i, err := getIdentity(stub, r.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = t.VerifyRequest(stub, args, env, i)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't verify signature %s", err))
+	}
+
+	now, err := txSeconds(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	dr, err := pendingDataRequest(stub, i.Username, r.ID, now)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	terms := consentTerms{Purpose: dr.Purpose, ExpiresAt: r.ConsentExpiresAt}
+	_, err = t.addKey(stub, &i, addKeyRequest{
+		Username:  i.Username,
+		Owner:     dr.Requester,
+		Key:       r.Key,
+		KeyHash:   r.KeyHash,
+		Attribute: dr.Attribute,
+		Slot:      dr.Slot,
+		Purpose:   dr.Purpose,
+		Consent:   &terms,
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	_, err = putIdentity(stub, i)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	c, err := giveConsent(stub, i.Username, dr.Requester, consentScope(dr.Attribute, dr.Slot), terms)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	dr.Status = dataRequestApproved
+	dr.DecidedAt = now
+	dr.ConsentID = c.ID
+
+	err = putDataRequest(stub, *dr)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	drBytes, _ := marshal(dr)
+
+	return shim.Success(drBytes)
+}
+
+type denyDataRequestRequest struct {
+	Username string `json:"username"`
+	ID       string `json:"id"`
+}
+
+// DenyDataRequest will refuse a data request made to the user
+func (t *DewalletChaincode) DenyDataRequest(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Denying data request of user")
+
+	var r denyDataRequestRequest
+	env, err := t.ParseRequest(args, &r)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't parse request %s", err))
+	}
+
+	i, err := getIdentity(stub, r.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = t.VerifyRequest(stub, args, env, i)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't verify signature %s", err))
+	}
+
+	now, err := txSeconds(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	dr, err := pendingDataRequest(stub, i.Username, r.ID, now)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	dr.Status = dataRequestDenied
+	dr.DecidedAt = now
+
+	err = putDataRequest(stub, *dr)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	drBytes, _ := marshal(dr)
+
+	return shim.Success(drBytes)
+}
+
+type expireDataRequestRequest struct {
+	Subject string `json:"subject"`
+	ID      string `json:"id"`
+}
+
+// ExpireDataRequest will store the expiry of a pending data request
+// past its ExpiresAt, anyone can call it
+func (t *DewalletChaincode) ExpireDataRequest(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Expiring data request")
+
+	var req expireDataRequestRequest
+	json.Unmarshal([]byte(args[0]), &req)
+
+	err := resolveHandles(stub, &req.Subject)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	dr, err := getDataRequest(stub, req.Subject, req.ID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if dr == nil {
+		return shim.Error("Data request not found")
+	}
+
+	now, err := txSeconds(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if dr.Status != dataRequestPending || dr.status(now) != dataRequestExpired {
+		return shim.Error(fmt.Sprintf("Data request is %s", dr.status(now)))
+	}
+
+	dr.Status = dataRequestExpired
+	dr.DecidedAt = now
+
+	err = putDataRequest(stub, *dr)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	drBytes, _ := marshal(dr)
+
+	return shim.Success(drBytes)
+}
+
+type getDataRequestsRequest struct {
+	Username string `json:"username"`
+	Token    string `json:"token"`
+
+	// Sent lists the requests the user made instead of the ones made to the user
+	Sent bool `json:"sent"`
+
+	pageRequest
+}
+
+type getDataRequestsResponse struct {
+	Requests []DataRequest `json:"requests"`
+
+	pageResponse
+}
+
+// GetDataRequests will page through the data requests made to a user, or by it,
+// with their current status, the user proves itself with a session token
+func (t *DewalletChaincode) GetDataRequests(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Querying data requests of user")
+
+	var req getDataRequestsRequest
+	json.Unmarshal([]byte(args[0]), &req)
+
+	err := resolveHandles(stub, &req.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = t.VerifySession(stub, req.Token, req.Username)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't verify session %s", err))
+	}
+
+	size, err := req.size()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	now, err := txSeconds(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	objectType := dataRequestObjectType
+	if req.Sent {
+		objectType = dataRequestSentObjectType
+	}
+
+	it, m, err := stub.GetStateByPartialCompositeKeyWithPagination(objectType, []string{req.Username}, size, req.Bookmark)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't query data requests %s", err))
+	}
+	defer it.Close()
+
+	res := getDataRequestsResponse{Requests: []DataRequest{}, pageResponse: newPageResponse(m)}
+	for it.HasNext() {
+		kv, err := it.Next()
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Can't query data requests %s", err))
+		}
+
+		var dr DataRequest
+		if req.Sent {
+			_, attrs, err := stub.SplitCompositeKey(kv.Key)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+
+			sr, err := getDataRequest(stub, attrs[1], attrs[2])
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			if sr == nil {
+				continue
+			}
+			dr = *sr
+		} else {
+			err = json.Unmarshal(kv.Value, &dr)
+			if err != nil {
+				return shim.Error(fmt.Sprintf("Error in parsing data request %s", err))
+			}
+		}
+
+		dr.Status = dr.status(now)
+		res.Requests = append(res.Requests, dr)
+	}
+
+	resBytes, _ := marshal(res)
+
+	return shim.Success(resBytes)
+}
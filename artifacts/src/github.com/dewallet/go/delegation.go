@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// delegationObjectType prefixes the delegations by delegator and delegate
+const delegationObjectType = "delegation"
+
+// nonDelegable are the functions a delegate can never call, a delegate
+// can't register the delegator, rotate its keys, delegate further nor
+// change or act as its guardians. Batch is checked per operation, see batchStub
+var nonDelegable = []string{"Register", "RotateKeys", "Delegate", "RevokeDelegation", "SetGuardians", "ApproveGuardianAction", "Batch"}
+
+// Delegation lets Delegate sign the requests of Delegator to Functions,
+// within the NotBefore and NotAfter window in unix seconds when given
+type Delegation struct {
+	Delegator string   `json:"delegator"`
+	Delegate  string   `json:"delegate"`
+	Functions []string `json:"functions"`
+	NotBefore int64    `json:"notBefore"`
+	NotAfter  int64    `json:"notAfter"`
+	TxID      string   `json:"txId"`
+}
+
+// active tells whether the delegation is within its window at now
+func (d Delegation) active(now int64) bool {
+	return (d.NotBefore == 0 || now >= d.NotBefore) && (d.NotAfter == 0 || now < d.NotAfter)
+}
+
+// invokedFunction is the name of the function dispatched, without a tenant,
+// that of the operation within a Batch
+func invokedFunction(stub shim.ChaincodeStubInterface) string {
+	function, _ := stub.GetFunctionAndParameters()
+	if n := strings.LastIndex(function, "/"); n >= 0 {
+		function = function[n+1:]
+	}
+
+	return function
+}
+
+func delegationKey(stub shim.ChaincodeStubInterface, delegator string, delegate string) (string, error) {
+	return stub.CreateCompositeKey(delegationObjectType, []string{delegator, delegate})
+}
+
+// getDelegation reads the delegation of delegator to delegate, nil when there is none
+func getDelegation(stub shim.ChaincodeStubInterface, delegator string, delegate string) (*Delegation, error) {
+	key, err := delegationKey(stub, delegator, delegate)
+	if err != nil {
+		return nil, err
+	}
+
+	dBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get state")
+	}
+	if dBytes == nil {
+		return nil, nil
+	}
+
+	var d Delegation
+	err = json.Unmarshal(dBytes, &d)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error in parsing delegation %s", err))
+	}
+
+	return &d, nil
+}
+
+// delegateOf returns the identity of the delegate signing a request of the
+// identity, after checking it was delegated the function of the transaction
+func delegateOf(stub shim.ChaincodeStubInterface, i Identity, delegate string) (Identity, error) {
+	d, err := getDelegation(stub, i.Username, delegate)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	now, err := txSeconds(stub)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	function := invokedFunction(stub)
+	if d == nil || !d.active(now) || !containsString(d.Functions, function) {
+		return Identity{}, errors.New(fmt.Sprintf("%s can't call %s for %s", delegate, function, i.Username))
+	}
+
+	return getIdentity(stub, delegate)
+}
+
+type delegateRequest struct {
+	Username  string   `json:"username"`
+	Delegate  string   `json:"delegate"`
+	Functions []string `json:"functions"`
+	NotBefore int64    `json:"notBefore"`
+	NotAfter  int64    `json:"notAfter"`
+}
+
+// Delegate will let another user sign requests of the user to some functions,
+// replacing what it was delegated before
+func (t *DewalletChaincode) Delegate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Delegating functions of user")
+
+	var r delegateRequest
+	env, err := t.ParseRequest(args, &r)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't parse request %s", err))
+	}
+
+	i, err := getIdentity(stub, r.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = t.VerifyRequest(stub, args, env, i)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't verify signature %s", err))
+	}
+
+	err = resolveHandles(stub, &r.Delegate)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if r.Delegate == i.Username {
+		return shim.Error("A user can't delegate to itself")
+	}
+
+	_, err = getIdentity(stub, r.Delegate)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't get delegate %s %s", r.Delegate, err))
+	}
+
+	if len(r.Functions) == 0 {
+		return shim.Error("No function delegated")
+	}
+	for _, function := range r.Functions {
+		if containsString(nonDelegable, function) {
+			return shim.Error(fmt.Sprintf("%s can't be delegated", function))
+		}
+	}
+
+	now, err := txSeconds(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if r.NotAfter != 0 && (r.NotAfter <= now || r.NotAfter <= r.NotBefore) {
+		return shim.Error("Delegation expires before it is valid")
+	}
+
+	d := Delegation{
+		Delegator: i.Username,
+		Delegate:  r.Delegate,
+		Functions: r.Functions,
+		NotBefore: r.NotBefore,
+		NotAfter:  r.NotAfter,
+		TxID:      stub.GetTxID(),
+	}
+
+	key, err := delegationKey(stub, d.Delegator, d.Delegate)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	dBytes, _ := marshal(d)
+	err = stub.PutState(key, dBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(dBytes)
+}
+
+type revokeDelegationRequest struct {
+	Username string `json:"username"`
+	Delegate string `json:"delegate"`
+}
+
+// RevokeDelegation will withdraw what the user delegated to another user
+func (t *DewalletChaincode) RevokeDelegation(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Revoking delegation of user")
+
+	var r revokeDelegationRequest
+	env, err := t.ParseRequest(args, &r)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't parse request %s", err))
+	}
+
+	i, err := getIdentity(stub, r.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = t.VerifyRequest(stub, args, env, i)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't verify signature %s", err))
+	}
+
+	err = resolveHandles(stub, &r.Delegate)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	d, err := getDelegation(stub, i.Username, r.Delegate)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if d == nil {
+		return shim.Error("Delegation not found")
+	}
+
+	key, err := delegationKey(stub, i.Username, r.Delegate)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = stub.DelState(key)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+type getDelegationsRequest struct {
+	Username string `json:"username"`
+}
+
+// GetDelegations will query the blockchain
+// and return what a user delegated to other users
+func (t *DewalletChaincode) GetDelegations(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Querying delegations of user")
+
+	var req getDelegationsRequest
+	json.Unmarshal([]byte(args[0]), &req)
+
+	err := resolveHandles(stub, &req.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	it, err := stub.GetStateByPartialCompositeKey(delegationObjectType, []string{req.Username})
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't query delegations %s", err))
+	}
+	defer it.Close()
+
+	res := []Delegation{}
+	for it.HasNext() {
+		kv, err := it.Next()
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Can't query delegations %s", err))
+		}
+
+		var d Delegation
+		err = json.Unmarshal(kv.Value, &d)
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Error in parsing delegation %s", err))
+		}
+
+		res = append(res, d)
+	}
+
+	resBytes, _ := marshal(res)
+
+	return shim.Success(resBytes)
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// denylistObjectType prefixes the denylist entries by kind and value
+const denylistObjectType = "denylist"
+
+// Kinds of denylist entries
+const (
+	denylistKindUsername = "username"
+	// denylistKindKey is the fingerprint of a public key, see keyFingerprint
+	denylistKindKey = "key"
+	// denylistKindEmail is the hex SHA-256 of a lowercased email address
+	denylistKindEmail = "email"
+)
+
+// maxDenylistValueLength bounds the values and reasons of denylist entries
+const maxDenylistValueLength = 256
+
+// DenylistEntry blocks the identities matching it from registering
+// and from having their signed requests accepted
+type DenylistEntry struct {
+	Kind    string `json:"kind"`
+	Value   string `json:"value"`
+	Reason  string `json:"reason"`
+	AddedAt int64  `json:"addedAt"`
+	TxID    string `json:"txId"`
+}
+
+func denylistKey(stub shim.ChaincodeStubInterface, kind string, value string) (string, error) {
+	return stub.CreateCompositeKey(denylistObjectType, []string{kind, value})
+}
+
+// getDenylistEntry reads an entry of the denylist, nil when there is none
+func getDenylistEntry(stub shim.ChaincodeStubInterface, kind string, value string) (*DenylistEntry, error) {
+	key, err := denylistKey(stub, kind, value)
+	if err != nil {
+		return nil, err
+	}
+
+	eBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get state")
+	}
+	if eBytes == nil {
+		return nil, nil
+	}
+
+	var e DenylistEntry
+	err = json.Unmarshal(eBytes, &e)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error in parsing denylist entry %s", err))
+	}
+
+	return &e, nil
+}
+
+// checkDenylist checks that neither the username, the public keys
+// nor the email hash of the identity are denylisted
+func checkDenylist(stub shim.ChaincodeStubInterface, i Identity) error {
+	entries := [][2]string{
+		{denylistKindUsername, i.Username},
+		{denylistKindEmail, i.EmailHash},
+	}
+	for _, publicKey := range []string{i.PublicKey, i.EPublicKey, i.SPublicKey, i.BPublicKey, i.QPublicKey} {
+		entries = append(entries, [2]string{denylistKindKey, keyFingerprint(publicKey)})
+	}
+
+	for _, entry := range entries {
+		if entry[1] == "" {
+			continue
+		}
+
+		e, err := getDenylistEntry(stub, entry[0], entry[1])
+		if err != nil {
+			return err
+		}
+		if e != nil {
+			return errors.New(fmt.Sprintf("%s is denied, its %s is denylisted", i.Username, entry[0]))
+		}
+	}
+
+	return nil
+}
+
+// putDenylistEvent emits denylistChangedEvent for an entry
+func putDenylistEvent(stub shim.ChaincodeStubInterface, action string, e DenylistEntry) error {
+	admin, err := creatorID(stub)
+	if err != nil {
+		return err
+	}
+
+	return emitEvent(stub, &DenylistChangedEvent{Action: action, Entry: e, Admin: admin})
+}
+
+// putDenylistEntry writes an entry of the denylist
+func putDenylistEntry(stub shim.ChaincodeStubInterface, kind string, value string, reason string) (DenylistEntry, error) {
+	now, err := txSeconds(stub)
+	if err != nil {
+		return DenylistEntry{}, err
+	}
+
+	e := DenylistEntry{Kind: kind, Value: value, Reason: reason, AddedAt: now, TxID: stub.GetTxID()}
+
+	key, err := denylistKey(stub, kind, value)
+	if err != nil {
+		return e, err
+	}
+
+	eBytes, _ := marshal(e)
+	return e, stub.PutState(key, eBytes)
+}
+
+type denylistRequest struct {
+	Kind   string `json:"kind"`
+	Value  string `json:"value"`
+	Reason string `json:"reason"`
+}
+
+// AddToDenylist will block a username, a key fingerprint or an email hash,
+// admin only, identities already registered with it can't change anymore
+// and the wallet of a username can't transfer, see setWalletStatus
+func (t *DewalletChaincode) AddToDenylist(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Adding a denylist entry")
+
+	err := t.requireAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var r denylistRequest
+	json.Unmarshal([]byte(args[0]), &r)
+
+	if r.Kind != denylistKindUsername && r.Kind != denylistKindKey && r.Kind != denylistKindEmail {
+		return shim.Error(fmt.Sprintf("Unsupported denylist kind %s", r.Kind))
+	}
+	if r.Value == "" {
+		return shim.Error("Missing denylist value")
+	}
+
+	err = checkSize("value", len(r.Value), maxDenylistValueLength)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = checkSize("reason", len(r.Reason), maxDenylistValueLength)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	e, err := putDenylistEntry(stub, r.Kind, r.Value, r.Reason)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	eBytes, _ := marshal(e)
+
+	if e.Kind == denylistKindUsername {
+		err = setWalletStatus(stub, e.Value, walletBlocked, e.Reason)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	err = putDenylistEvent(stub, "add", e)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(eBytes)
+}
+
+// RemoveFromDenylist will unblock a username, a key fingerprint
+// or an email hash, admin only
+func (t *DewalletChaincode) RemoveFromDenylist(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Removing a denylist entry")
+
+	err := t.requireAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var r denylistRequest
+	json.Unmarshal([]byte(args[0]), &r)
+
+	e, err := getDenylistEntry(stub, r.Kind, r.Value)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if e == nil {
+		return shim.Error("Denylist entry not found")
+	}
+
+	key, err := denylistKey(stub, r.Kind, r.Value)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = stub.DelState(key)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if e.Kind == denylistKindUsername {
+		err = setWalletStatus(stub, e.Value, walletActive, "")
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	err = putDenylistEvent(stub, "remove", *e)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+type revokeIdentityRequest struct {
+	Username string `json:"username"`
+	Reason   string `json:"reason"`
+}
+
+// RevokeIdentity will denylist the username and the public keys of an identity,
+// admin only, an IdentityRevoked event tells relying parties to drop them
+func (t *DewalletChaincode) RevokeIdentity(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Revoking an identity")
+
+	err := t.requireAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var r revokeIdentityRequest
+	json.Unmarshal([]byte(args[0]), &r)
+
+	err = checkSize("reason", len(r.Reason), maxDenylistValueLength)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	i, err := getIdentity(stub, r.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	entries := []DenylistEntry{}
+	e, err := putDenylistEntry(stub, denylistKindUsername, i.Username, r.Reason)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	entries = append(entries, e)
+
+	fingerprints := []string{}
+	for _, publicKey := range []string{i.PublicKey, i.EPublicKey, i.SPublicKey, i.BPublicKey, i.QPublicKey} {
+		fp := keyFingerprint(publicKey)
+		if fp == "" || containsString(fingerprints, fp) {
+			continue
+		}
+		fingerprints = append(fingerprints, fp)
+
+		e, err = putDenylistEntry(stub, denylistKindKey, fp, r.Reason)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		entries = append(entries, e)
+	}
+
+	err = setWalletStatus(stub, i.Username, walletBlocked, r.Reason)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = emitEvent(stub, &IdentityRevokedEvent{Username: i.Username, Keys: fingerprints, Reason: r.Reason})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resBytes, _ := marshal(entries)
+
+	return shim.Success(resBytes)
+}
+
+type getDenylistRequest struct {
+	Kind string `json:"kind"`
+
+	pageRequest
+}
+
+type getDenylistResponse struct {
+	Entries []DenylistEntry `json:"entries"`
+
+	pageResponse
+}
+
+// GetDenylist will page through the denylist, of one kind when given, admin only
+func (t *DewalletChaincode) GetDenylist(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Querying denylist")
+
+	err := t.requireAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var req getDenylistRequest
+	json.Unmarshal([]byte(args[0]), &req)
+
+	size, err := req.size()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	attributes := []string{}
+	if req.Kind != "" {
+		attributes = append(attributes, req.Kind)
+	}
+
+	it, m, err := stub.GetStateByPartialCompositeKeyWithPagination(denylistObjectType, attributes, size, req.Bookmark)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't query denylist %s", err))
+	}
+	defer it.Close()
+
+	res := getDenylistResponse{Entries: []DenylistEntry{}, pageResponse: newPageResponse(m)}
+	for it.HasNext() {
+		kv, err := it.Next()
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Can't query denylist %s", err))
+		}
+
+		var e DenylistEntry
+		err = json.Unmarshal(kv.Value, &e)
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Error in parsing denylist entry %s", err))
+		}
+
+		res.Entries = append(res.Entries, e)
+	}
+
+	resBytes, _ := marshal(res)
+
+	return shim.Success(resBytes)
+}
@@ -1,16 +1,15 @@
 package main
 
 import (
-	"encoding/json"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/hex"
-	"fmt"
-	"crypto"
-	"crypto/x509"
-	"crypto/rsa"
-	"crypto/sha256"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"strings"
 
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
 )
@@ -24,300 +23,1745 @@ type DewalletChaincode struct {
 // Identity saves the identity of user
 // Data is an encrypted data of the user
 // Data can only be decrypted by user private key
+// Data is kept under its own state key, see dataKey, it is only
+// inline in identities registered before that
+// DataHash is the SHA-256 of the Data ciphertext
+// DataPointer replaces Data when the ciphertext is stored off-chain
+// When Collection is set Data lives in that private data
+// collection and only DataHash is on the ledger
+// Slots are further named data, kept the same way as Data
+// PublicAttributes are cleartext and searchable, see QueryByAttribute
+// DocType and Org are indexed for rich queries, see query.go
+// Registered is when the username was first registered, in unix seconds
+// Creator is the ID of the client that registered it within Org, see BindCreator
+// Policy restricts who may query it and be given its keys, see policy.go
 type Identity struct {
-	Username   string `json:"username"`
-	PublicKey  string `json:"publicKey"`
-	EPublicKey string `json:"ePublicKey"`
-	SPublicKey string `json:"sPublicKey"`
-	Data       string `json:"data"`
-	Verified   string `json:"verified"`
-	Keys       []Key  `json:"keys"`
+	Username   string    `json:"username"`
+	DocType    string    `json:"docType"`
+	Org        string    `json:"org"`
+	PublicKey  string    `json:"publicKey"`
+	EPublicKey string    `json:"ePublicKey"`
+	SPublicKey string    `json:"sPublicKey"`
+	BPublicKey string    `json:"bPublicKey"`
+	QPublicKey string    `json:"qPublicKey"`
+	QAlgorithm string    `json:"qAlgorithm"`
+	Data       string    `json:"data"`
+	DataHash   string    `json:"dataHash"`
+	DataSchema SchemaRef `json:"dataSchema"`
+	Collection string    `json:"collection"`
+	Verified   string    `json:"verified"`
+	Registered int64     `json:"registered"`
+	Creator    string    `json:"creator"`
+	Keys       []Key     `json:"keys"`
+
+	DataPointer   *DataPointer `json:"dataPointer,omitempty"`
+	DataRetention int64        `json:"dataRetention"`
+	DataExpiresAt int64        `json:"dataExpiresAt"`
+
+	Slots        map[string]DataSlot  `json:"slots"`
+	Attributes   map[string]Attribute `json:"attributes"`
+	Attestations []Attestation        `json:"attestations"`
+
+	PublicAttributes map[string]string `json:"publicAttributes"`
+	Policy           AccessPolicy      `json:"policy"`
+
+	// EndorsingOrgs must all endorse changes to the identity, see setEndorsementPolicy
+	EndorsingOrgs []string `json:"endorsingOrgs"`
+	// EmailHash is the hex SHA-256 of the lowercased email of the user, see checkDenylist
+	EmailHash string `json:"emailHash,omitempty"`
+	// Guardians approve the key rotations of the user, see SetGuardians
+	Guardians []string `json:"guardians,omitempty"`
+	// Verifications are the verifications of the user by each verifier, see SetVerification
+	Verifications []Verification `json:"verifications,omitempty"`
+	// CertificateChain certifies SPublicKey up to a trust anchor, leaf first, see checkCertificateChain
+	CertificateChain []string `json:"certificateChain,omitempty"`
+	// Services are the service endpoints of the user, see SetServices and GetDIDDocument
+	Services []Service `json:"services,omitempty"`
+	// Commitments are the salted hashes of the attributes in the data by name,
+	// to check values revealed off-chain, see VerifyDisclosures
+	Commitments map[string]string `json:"commitments,omitempty"`
+
+	// keyRecords are the values of the records Keys were read from, see loadKeyRecords
+	keyRecords map[string][]byte
 }
 
 // Key save the association between allowed user's username
 // and encrypted key that can be used to decrypt the user data
+// In private data mode only KeyHash is kept, the key is in the collection
+// A key with an Attribute only decrypts that attribute
+// and a key with a Slot only decrypts the data in that slot
+// DataHash is the hash of what the key was given for, when it was given
+// A key with NotBefore or NotAfter is only returned within that window,
+// in unix seconds, see ExpireGrants
+// Purpose is what the key was given for, see RevokePurpose
+// ConsentID is the consent given with the key, withdrawing it removes the key
 type Key struct {
-	Owner string `json:"for"`
-	Key   string `json:"key"`
+	Owner     string `json:"for"`
+	Key       string `json:"key"`
+	KeyHash   string `json:"keyHash"`
+	Attribute string `json:"attribute"`
+	Slot      string `json:"slot"`
+	DataHash  string `json:"dataHash"`
+	NotBefore int64  `json:"notBefore,omitempty"`
+	NotAfter  int64  `json:"notAfter,omitempty"`
+	Purpose   string `json:"purpose,omitempty"`
+	ConsentID string `json:"consentId,omitempty"`
+
+	// record is the state key of the key, see loadKeyRecords
+	record string
 }
 
-func (t *DewalletChaincode) VerifySignature(args []string, publicKey string) error {
-	m := []byte(args[0])
-	s, err := hex.DecodeString(args[1])
+// Supported encodings of the signature passed as the second argument
+const (
+	signatureEncodingHex       = "hex"
+	signatureEncodingBase64    = "base64"
+	signatureEncodingBase64URL = "base64url"
+	// signatureEncodingJWS means the signature is a detached JWS over the message
+	signatureEncodingJWS = "jws"
+)
+
+// decodeSignature decodes the signature with the declared encoding,
+// hex is assumed when no encoding is declared
+func decodeSignature(signature string, encoding string) ([]byte, error) {
+	var enc *base64.Encoding
+
+	switch encoding {
+	case "", signatureEncodingHex:
+		return hex.DecodeString(signature)
+	case signatureEncodingBase64:
+		enc = base64.StdEncoding.Strict()
+	case signatureEncodingBase64URL:
+		enc = base64.RawURLEncoding.Strict()
+		if strings.HasSuffix(signature, "=") {
+			enc = base64.URLEncoding.Strict()
+		}
+	default:
+		return nil, errors.New(fmt.Sprintf("Unsupported signature encoding %s", encoding))
+	}
+
+	s, err := enc.DecodeString(signature)
 	if err != nil {
-		return errors.New(fmt.Sprintf("Error in decoding signature %s", err))
+		return nil, err
+	}
+
+	// the decoder silently skips line breaks, only accept the canonical form
+	if enc.EncodeToString(s) != signature {
+		return nil, errors.New(fmt.Sprintf("Signature is not canonical %s", encoding))
+	}
+
+	return s, nil
+}
+
+// parsePublicKey parses a base64 encoded PKIX public key,
+// once per key in an invocation, see parsedKeys
+func parsePublicKey(stub shim.ChaincodeStubInterface, publicKey string) (interface{}, error) {
+	keys := parsedKeys(stub)
+	if pk, ok := keys[publicKey]; ok {
+		return pk, nil
 	}
 
 	pkBytes, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error in decoding key %s %s", publicKey, err))
+	}
+
 	pk, err := x509.ParsePKIXPublicKey(pkBytes)
 	if err != nil {
-		return errors.New(fmt.Sprintf("Error in parsing key %s %s", publicKey, err))
+		return nil, errors.New(fmt.Sprintf("Error in parsing key %s %s", publicKey, err))
+	}
+	if keys != nil {
+		keys[publicKey] = pk
 	}
 
-	switch pk := pk.(type) {
-		case *rsa.PublicKey:
-			h := sha256.Sum256(m)
-			err = rsa.VerifyPKCS1v15(pk, crypto.SHA256, h[:], s)
-			if err != nil {
-				return errors.New(fmt.Sprintf("Error in verifying signature %s", err))
-			}
+	return pk, nil
+}
+
+func (t *DewalletChaincode) VerifySignature(stub shim.ChaincodeStubInterface, args []string, publicKey string) error {
+	if len(args) < 2 {
+		return errors.New("Missing signature argument")
+	}
+
+	if isCOSESign1(args[1]) {
+		return verifyCOSESign1(stub, args[0], args[1], publicKey)
+	}
+
+	var env signedEnvelope
+	err := json.Unmarshal([]byte(args[0]), &env)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error in parsing signed message %s", err))
+	}
+
+	m := []byte(args[0])
+	if env.SignatureEncoding == signatureEncodingJWS {
+		return verifyDetachedJWS(stub, args[1], m, publicKey)
+	}
 
-			return nil
-		default:
-			return errors.New(fmt.Sprintf("Key is not RSA"))
+	s, err := decodeSignature(args[1], env.SignatureEncoding)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error in decoding signature %s", err))
 	}
-	
+
+	return verifyWithAlgorithm(stub, env.Alg, publicKey, m, s)
 }
 
 // Init will initialize the chaincode
 func (t *DewalletChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 	logger.Info("Initialize Dewallet Chaincode")
+
+	// without arguments an upgrade keeps the current configuration
+	_, args := stub.GetFunctionAndParameters()
+	if len(args) == 0 {
+		return shim.Success(nil)
+	}
+
+	var c Config
+	err := json.Unmarshal([]byte(args[0]), &c)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't parse config %s", err))
+	}
+	if c.ContentEncoding != "" && c.ContentEncoding != contentEncodingGzip && c.ContentEncoding != contentEncodingZstd {
+		return shim.Error(fmt.Sprintf("Unsupported content encoding %s", c.ContentEncoding))
+	}
+	if c.StateEncoding != "" && c.StateEncoding != stateEncodingJSON && c.StateEncoding != stateEncodingProtobuf {
+		return shim.Error(fmt.Sprintf("Unsupported state encoding %s", c.StateEncoding))
+	}
+	if c.Tenancy != "" && c.Tenancy != tenancyMSP && c.Tenancy != tenancyExplicit {
+		return shim.Error(fmt.Sprintf("Unsupported tenancy %s", c.Tenancy))
+	}
+	if c.VerificationValidity < 0 {
+		return shim.Error("Verification validity can't be negative")
+	}
+	if c.GovernanceQuorum < 0 || c.GovernanceVoteTTL < 0 {
+		return shim.Error("Governance quorum and vote TTL can't be negative")
+	}
+	for _, s := range c.SiblingDeployments {
+		if s.Channel == "" || s.Chaincode == "" || s.Channel == stub.GetChannelID() {
+			return shim.Error("A sibling deployment is a chaincode on another channel")
+		}
+	}
+	for function, r := range c.RateLimits {
+		err = r.validate(function)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	err = c.Limits.validate()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = putConfig(stub, c)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
 	return shim.Success(nil)
 }
 
 // Invoke will run the approriate function based on argument
-func (t *DewalletChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+func (t *DewalletChaincode) Invoke(stub shim.ChaincodeStubInterface) (res pb.Response) {
 	logger.Info("Invoking Dewallet Chaincode")
 
 	function, args := stub.GetFunctionAndParameters()
 
+	args, err := decodeCOSEArgs(args)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't decode COSE request %s", err))
+	}
+
+	// the correlation id of the request is echoed in the message of the response
+	correlationID := requestCorrelationID(args)
+	if correlationID != "" {
+		logger.Infof("Correlation id %s", correlationID)
+
+		defer func() {
+			if res.Message == "" {
+				res.Message = correlationID
+			} else {
+				res.Message = fmt.Sprintf("%s (correlation id %s)", res.Message, correlationID)
+			}
+		}()
+	}
+
+	// the handlers read their own writes, written to the stub once they succeed
+	cache := newStateCache(stub)
+	defer func() {
+		if res.Status != shim.OK {
+			return
+		}
+
+		err := cache.flush()
+		if err != nil {
+			res = shim.Error(fmt.Sprintf("Can't write state %s", err))
+		}
+	}()
+
+	stub, function, err = t.tenantScope(cache, function)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't resolve tenant %s", err))
+	}
+
+	err = t.checkACL(stub, function)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Access denied %s", err))
+	}
+
+	err = checkGlobalFreeze(stub, function)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	res = t.dispatch(stub, function, args)
+	if res.Status != shim.OK {
+		return res
+	}
+
+	err = t.checkRateLimit(stub, function)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return res
+}
+
+// functions are the functions of the chaincode, see dispatch
+var functions = []string{
+	"Batch", "Register", "RotateKeys", "UpdateUserData", "BeginDataUpload",
+	"AppendDataChunk", "CommitDataUpload", "UpdateUserDataPatch",
+	"UpdateUserAttributes", "UpdatePublicAttributes", "SetAccessPolicy",
+	"SetOrgPolicy", "IssueCapability", "RevokeCapability", "RedeemCapability",
+	"RequestData", "ApproveDataRequest", "DenyDataRequest", "ExpireDataRequest",
+	"GetDataRequests", "SetDisclosurePolicy", "GetDisclosurePolicy",
+	"SetGuardians", "ApproveGuardianAction", "EmergencyFreeze", "LiftFreeze",
+	"GetFreezes", "AddToDenylist", "RemoveFromDenylist", "GetDenylist",
+	"GetAccessLog", "RegisterPurpose", "GetPurposes", "RevokePurpose", "Delegate",
+	"RevokeDelegation", "GetDelegations", "GiveConsent", "WithdrawConsent",
+	"GetConsents", "AddKey", "SetBLSKey", "AddVerifier", "RemoveVerifier",
+	"GetVerifiers", "SetVerification", "RenewVerification", "RevokeVerification",
+	"GetVerifications", "IssueCredential", "SetCredentialStatus",
+	"RemoveCredential", "GetCredentials", "GetCredentialStatus",
+	"RevokeCredential", "UnrevokeCredential", "GetStatusList", "AddClaim",
+	"RevokeClaim", "GetClaims", "QueryByClaim", "RegisterKYCProvider",
+	"SetKYCProviderStatus", "IngestOracleResult", "GetKYCProviders",
+	"ChangeTrustAnchor", "GetTrustAnchors", "SetServices", "GetDIDDocument",
+	"IndexDIDs", "RebuildIndexes", "VerifyDisclosures", "VerifyPredicateProof",
+	"GetVerifierChanges", "GetVerificationSummary", "GetVerificationReceipts",
+	"GetEnrollmentBinding", "GetChangesSince", "RevokeIdentity", "AddAttestation",
+	"GetAttestations", "RegisterSchema", "GetSchema", "SetLimits", "SetACL",
+	"GetACL", "PurgeExpiredData", "QueryIdentities", "QueryByAttribute",
+	"QueryByVerification", "GetIdentityStats", "SearchUsernames",
+	"ListIdentities", "ExportIdentities", "GetPublicKey", "GetPublicKeys",
+	"Exists", "GetIdentityByPublicKey", "GetMyGrants", "ExpireGrants",
+	"GetAccessibleIdentities", "GetSharedUserData", "GetUserData",
+}
+
+// dispatch runs the handler of the function, for Invoke and each operation of Batch
+func (t *DewalletChaincode) dispatch(stub shim.ChaincodeStubInterface, function string, args []string) pb.Response {
+	if function == "Batch" {
+		return t.Batch(stub, args)
+	}
+
 	if function == "Register" {
 		// Deletes an entity from its state
 		return t.Register(stub, args)
 	}
 
+	if function == "RotateKeys" {
+		return t.RotateKeys(stub, args)
+	}
+
 	if function == "UpdateUserData" {
 		return t.UpdateUserData(stub, args)
 	}
 
-	if function == "AddKey" {
-		return t.AddKey(stub, args)
+	if function == "BeginDataUpload" {
+		return t.BeginDataUpload(stub, args)
 	}
 
-	if function == "GetPublicKey" {
-		// queries an entity state
-		return t.GetPublicKey(stub, args)
+	if function == "AppendDataChunk" {
+		return t.AppendDataChunk(stub, args)
 	}
 
-	if function == "GetUserData" {
-		return t.GetUserData(stub, args)
+	if function == "CommitDataUpload" {
+		return t.CommitDataUpload(stub, args)
 	}
 
-	logger.Errorf("Unknown action, check the first argument, must be one of 'Register', 'GetPublicKey'. But got: %v", args[0])
-	return shim.Error(fmt.Sprintf("Unknown action, check the first argument, must be one of 'Register', 'GetPublicKey'. But got: %v", args[0]))
-}
+	if function == "UpdateUserDataPatch" {
+		return t.UpdateUserDataPatch(stub, args)
+	}
 
-// Register will add the user identity into blockchain
-func (t *DewalletChaincode) Register(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	logger.Info("Registering a member")
+	if function == "UpdateUserAttributes" {
+		return t.UpdateUserAttributes(stub, args)
+	}
 
-	var i Identity
-	json.Unmarshal([]byte(args[0]), &i)
+	if function == "UpdatePublicAttributes" {
+		return t.UpdatePublicAttributes(stub, args)
+	}
 
-	i.Keys = []Key{}
+	if function == "SetAccessPolicy" {
+		return t.SetAccessPolicy(stub, args)
+	}
 
-	iBytes, _ := json.Marshal(i)
-	err := stub.PutState(i.Username, iBytes)
-	if err != nil {
-		return shim.Error(err.Error())
+	if function == "SetOrgPolicy" {
+		return t.SetOrgPolicy(stub, args)
 	}
 
-	return shim.Success(iBytes)
-}
+	if function == "IssueCapability" {
+		return t.IssueCapability(stub, args)
+	}
 
-type updateUserDataRequest struct {
-	Username string `json:"username"`
-	Data     string `json:"data"`
-}
+	if function == "RevokeCapability" {
+		return t.RevokeCapability(stub, args)
+	}
 
-type updateUserDataResponse struct {
-	Data string `json:"data"`
-}
+	if function == "RedeemCapability" {
+		return t.RedeemCapability(stub, args)
+	}
 
-// UpdateUserData will query the blockchain
-// and update the encrypted data
-func (t *DewalletChaincode) UpdateUserData(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	logger.Info("Updating data of user")
+	if function == "RequestData" {
+		return t.RequestData(stub, args)
+	}
 
-	var r updateUserDataRequest
-	json.Unmarshal([]byte(args[0]), &r)
+	if function == "ApproveDataRequest" {
+		return t.ApproveDataRequest(stub, args)
+	}
 
-	iBytes, err := stub.GetState(r.Username)
-	if err != nil {
-		return shim.Error("Failed to get state")
+	if function == "DenyDataRequest" {
+		return t.DenyDataRequest(stub, args)
 	}
-	if iBytes == nil {
-		return shim.Error("Username not found")
+
+	if function == "ExpireDataRequest" {
+		return t.ExpireDataRequest(stub, args)
 	}
 
-	var i Identity
-	json.Unmarshal([]byte(iBytes), &i)
+	if function == "GetDataRequests" {
+		return t.GetDataRequests(stub, args)
+	}
 
-	err = t.VerifySignature(args, i.SPublicKey)
-	if err != nil {
-		return shim.Error(fmt.Sprintf("Can't verify signature %s", err))
+	if function == "SetDisclosurePolicy" {
+		return t.SetDisclosurePolicy(stub, args)
 	}
 
-	i.Data = r.Data
+	if function == "GetDisclosurePolicy" {
+		return t.GetDisclosurePolicy(stub, args)
+	}
 
-	iBytes, _ = json.Marshal(i)
-	err = stub.PutState(i.Username, iBytes)
-	if err != nil {
-		return shim.Error(err.Error())
+	if function == "SetGuardians" {
+		return t.SetGuardians(stub, args)
 	}
 
-	return shim.Success(iBytes)
-}
+	if function == "ApproveGuardianAction" {
+		return t.ApproveGuardianAction(stub, args)
+	}
 
+	if function == "EmergencyFreeze" {
+		return t.EmergencyFreeze(stub, args)
+	}
 
-type addKeyRequest struct {
-	Username string `json:"username"`
-	Owner    string `json:"owner"`
-	Key      string `json:"key"`
-}
+	if function == "LiftFreeze" {
+		return t.LiftFreeze(stub, args)
+	}
 
-type addKeyResponse struct {
-	Owner string `json:"owner"`
-	Key   string `json:"key"`
-}
+	if function == "GetFreezes" {
+		return t.GetFreezes(stub, args)
+	}
 
-// AddKey will add symetric key to blockchain
-func (t *DewalletChaincode) AddKey(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	logger.Info("Adding decryption key of user data")
+	if function == "AddToDenylist" {
+		return t.AddToDenylist(stub, args)
+	}
 
-	var r addKeyRequest
-	json.Unmarshal([]byte(args[0]), &r)
+	if function == "RemoveFromDenylist" {
+		return t.RemoveFromDenylist(stub, args)
+	}
 
-	iBytes, err := stub.GetState(r.Username)
-	if err != nil {
-		return shim.Error("Failed to get state")
+	if function == "GetDenylist" {
+		return t.GetDenylist(stub, args)
 	}
-	if iBytes == nil {
-		return shim.Error("Username not found")
+
+	if function == "GetAccessLog" {
+		return t.GetAccessLog(stub, args)
 	}
 
-	key := Key{
-		Owner: r.Owner,
-		Key:   r.Key,
+	if function == "RegisterPurpose" {
+		return t.RegisterPurpose(stub, args)
 	}
 
-	var i Identity
-	json.Unmarshal([]byte(iBytes), &i)
+	if function == "GetPurposes" {
+		return t.GetPurposes(stub, args)
+	}
 
-	err = t.VerifySignature(args, i.SPublicKey)
-	if err != nil {
-		return shim.Error(fmt.Sprintf("Can't verify signature %s", err))
+	if function == "RevokePurpose" {
+		return t.RevokePurpose(stub, args)
 	}
 
-	i.Keys = append(i.Keys, key)
-	iBytes, _ = json.Marshal(i)
+	if function == "Delegate" {
+		return t.Delegate(stub, args)
+	}
 
-	err = stub.PutState(i.Username, iBytes)
-	if err != nil {
-		return shim.Error(err.Error())
+	if function == "RevokeDelegation" {
+		return t.RevokeDelegation(stub, args)
 	}
 
-	res := addKeyResponse{
-		Owner: r.Owner,
-		Key:   r.Key,
+	if function == "GetDelegations" {
+		return t.GetDelegations(stub, args)
 	}
 
-	resBytes, _ := json.Marshal(res)
+	if function == "GiveConsent" {
+		return t.GiveConsent(stub, args)
+	}
 
-	return shim.Success(resBytes)
-}
+	if function == "WithdrawConsent" {
+		return t.WithdrawConsent(stub, args)
+	}
 
-type getPublicKeyRequest struct {
-	Username string `json:"username"`
-}
+	if function == "GetConsents" {
+		return t.GetConsents(stub, args)
+	}
 
-type getPublicKeyResponse struct {
-	PublicKey  string `json:"publicKey"`
-	EPublicKey string `json:"ePublicKey"`
-}
+	if function == "AddKey" {
+		return t.AddKey(stub, args)
+	}
 
-// GetPublicKey will query the blockchain
-// to get the public key of a username
-func (t *DewalletChaincode) GetPublicKey(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	logger.Info("Querying a member public key")
+	if function == "SetBLSKey" {
+		return t.SetBLSKey(stub, args)
+	}
 
-	var req getPublicKeyRequest
-	json.Unmarshal([]byte(args[0]), &req)
+	if function == "AddVerifier" {
+		return t.AddVerifier(stub, args)
+	}
 
-	iBytes, err := stub.GetState(req.Username)
-	if err != nil {
-		return shim.Error("Failed to get state")
+	if function == "RemoveVerifier" {
+		return t.RemoveVerifier(stub, args)
 	}
-	if iBytes == nil {
-		return shim.Error("Username not found")
+
+	if function == "GetVerifiers" {
+		return t.GetVerifiers(stub, args)
 	}
 
-	var i Identity
-	json.Unmarshal([]byte(iBytes), &i)
+	if function == "SetVerification" {
+		return t.SetVerification(stub, args)
+	}
 
-	res := getPublicKeyResponse{
-		PublicKey:  i.PublicKey,
-		EPublicKey: i.EPublicKey,
+	if function == "RenewVerification" {
+		return t.RenewVerification(stub, args)
 	}
 
-	resBytes, _ := json.Marshal(res)
+	if function == "RevokeVerification" {
+		return t.RevokeVerification(stub, args)
+	}
 
-	return shim.Success(resBytes)
-}
+	if function == "GetVerifications" {
+		return t.GetVerifications(stub, args)
+	}
 
-type getUserDataRequest struct {
-	Username string `json:"username"`
-	Owner    string `json:"owner"`
-}
+	if function == "IssueCredential" {
+		return t.IssueCredential(stub, args)
+	}
 
-type getUserDataResponse struct {
-	PublicKey  string `json:"publicKey"`
-	EPublicKey string `json:"ePublicKey"`
-	SPublicKey string `json:"sPublicKey"`
-	Data string `json:"data"`
-	Key  string `json:"key"`
-}
+	if function == "SetCredentialStatus" {
+		return t.SetCredentialStatus(stub, args)
+	}
 
-// GetUserData will query the blockchain
-// and return encrypted data of a user
-func (t *DewalletChaincode) GetUserData(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	logger.Info("Querying a user data")
+	if function == "RemoveCredential" {
+		return t.RemoveCredential(stub, args)
+	}
 
-	var req getUserDataRequest
-	json.Unmarshal([]byte(args[0]), &req)
+	if function == "GetCredentials" {
+		return t.GetCredentials(stub, args)
+	}
 
-	iBytes, err := stub.GetState(req.Username)
-	if err != nil {
-		return shim.Error("Failed to get state")
+	if function == "GetCredentialStatus" {
+		return t.GetCredentialStatus(stub, args)
 	}
-	if iBytes == nil {
-		return shim.Error("Username not found")
+
+	if function == "RevokeCredential" {
+		return t.RevokeCredential(stub, args)
 	}
 
-	var i Identity
-	json.Unmarshal([]byte(iBytes), &i)
+	if function == "UnrevokeCredential" {
+		return t.UnrevokeCredential(stub, args)
+	}
 
-	var keyResult string
+	if function == "GetStatusList" {
+		return t.GetStatusList(stub, args)
+	}
 
-	for _, key := range i.Keys {
-		if key.Owner == req.Owner {
-			keyResult = key.Key
-		}
+	if function == "AddClaim" {
+		return t.AddClaim(stub, args)
 	}
 
-	res := getUserDataResponse{
-		PublicKey: i.PublicKey,
-		EPublicKey: i.EPublicKey,
-		SPublicKey: i.SPublicKey,
-		Data: i.Data,
-		Key:  keyResult,
+	if function == "RevokeClaim" {
+		return t.RevokeClaim(stub, args)
 	}
 
-	resBytes, _ := json.Marshal(res)
+	if function == "GetClaims" {
+		return t.GetClaims(stub, args)
+	}
 
-	return shim.Success(resBytes)
-}
+	if function == "QueryByClaim" {
+		return t.QueryByClaim(stub, args)
+	}
+
+	if function == "RegisterKYCProvider" {
+		return t.RegisterKYCProvider(stub, args)
+	}
+
+	if function == "SetKYCProviderStatus" {
+		return t.SetKYCProviderStatus(stub, args)
+	}
+
+	if function == "IngestOracleResult" {
+		return t.IngestOracleResult(stub, args)
+	}
+
+	if function == "GetKYCProviders" {
+		return t.GetKYCProviders(stub, args)
+	}
+
+	if function == "ChangeTrustAnchor" {
+		return t.ChangeTrustAnchor(stub, args)
+	}
 
+	if function == "GetTrustAnchors" {
+		return t.GetTrustAnchors(stub, args)
+	}
+
+	if function == "SetServices" {
+		return t.SetServices(stub, args)
+	}
+
+	if function == "GetDIDDocument" {
+		return t.GetDIDDocument(stub, args)
+	}
+
+	if function == "IndexDIDs" {
+		return t.IndexDIDs(stub, args)
+	}
+
+	if function == "RebuildIndexes" {
+		return t.RebuildIndexes(stub, args)
+	}
+
+	if function == "VerifyDisclosures" {
+		return t.VerifyDisclosures(stub, args)
+	}
+
+	if function == "VerifyPredicateProof" {
+		return t.VerifyPredicateProof(stub, args)
+	}
+
+	if function == "GetVerifierChanges" {
+		return t.GetVerifierChanges(stub, args)
+	}
+
+	if function == "GetVerificationSummary" {
+		return t.GetVerificationSummary(stub, args)
+	}
+
+	if function == "GetVerificationReceipts" {
+		return t.GetVerificationReceipts(stub, args)
+	}
+
+	if function == "GetEnrollmentBinding" {
+		return t.GetEnrollmentBinding(stub, args)
+	}
+
+	if function == "GetChangesSince" {
+		return t.GetChangesSince(stub, args)
+	}
+
+	if function == "RevokeIdentity" {
+		return t.RevokeIdentity(stub, args)
+	}
+
+	if function == "AddAttestation" {
+		return t.AddAttestation(stub, args)
+	}
+
+	if function == "GetAttestations" {
+		return t.GetAttestations(stub, args)
+	}
+
+	if function == "RegisterSchema" {
+		return t.RegisterSchema(stub, args)
+	}
+
+	if function == "GetSchema" {
+		return t.GetSchema(stub, args)
+	}
+
+	if function == "SetLimits" {
+		return t.SetLimits(stub, args)
+	}
+
+	if function == "SetACL" {
+		return t.SetACL(stub, args)
+	}
+
+	if function == "GetACL" {
+		return t.GetACL(stub, args)
+	}
+
+	if function == "PurgeExpiredData" {
+		return t.PurgeExpiredData(stub, args)
+	}
+
+	if function == "QueryIdentities" {
+		return t.QueryIdentities(stub, args)
+	}
+
+	if function == "QueryByAttribute" {
+		return t.QueryByAttribute(stub, args)
+	}
+
+	if function == "QueryByVerification" {
+		return t.QueryByVerification(stub, args)
+	}
+
+	if function == "GetIdentityStats" {
+		return t.GetIdentityStats(stub, args)
+	}
+
+	if function == "SearchUsernames" {
+		return t.SearchUsernames(stub, args)
+	}
+
+	if function == "ListIdentities" {
+		return t.ListIdentities(stub, args)
+	}
+
+	if function == "ExportIdentities" {
+		return t.ExportIdentities(stub, args)
+	}
+
+	if function == "GetPublicKey" {
+		// queries an entity state
+		return t.GetPublicKey(stub, args)
+	}
+
+	if function == "GetPublicKeys" {
+		return t.GetPublicKeys(stub, args)
+	}
+
+	if function == "Exists" {
+		return t.Exists(stub, args)
+	}
+
+	if function == "GetIdentityByPublicKey" {
+		return t.GetIdentityByPublicKey(stub, args)
+	}
+
+	if function == "GetMyGrants" {
+		return t.GetMyGrants(stub, args)
+	}
+
+	if function == "ExpireGrants" {
+		return t.ExpireGrants(stub, args)
+	}
+
+	if function == "GetAccessibleIdentities" {
+		return t.GetAccessibleIdentities(stub, args)
+	}
+
+	if function == "GetSharedUserData" {
+		return t.GetSharedUserData(stub, args)
+	}
+
+	if function == "GetUserData" {
+		return t.GetUserData(stub, args)
+	}
+
+	logger.Errorf("Unknown function %s", function)
+	return shim.Error(fmt.Sprintf("Unknown function %s, must be one of %s", function, strings.Join(functions, ", ")))
+}
+
+// Register will add the user identity into blockchain
+func (t *DewalletChaincode) Register(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Registering a member")
+
+	var i Identity
+	env, err := t.ParseRequest(args, &i)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't parse request %s", err))
+	}
+
+	// DIDs are resolved in place of usernames, see resolveUsername
+	if strings.HasPrefix(i.Username, "did:") {
+		return shim.Error("A username can't be a DID")
+	}
+	// claims from verified proofs are attested by their proof system
+	if strings.HasPrefix(i.Username, proofAttesterPrefix) {
+		return shim.Error(fmt.Sprintf("A username can't start with %s", proofAttesterPrefix))
+	}
+
+	i.Org, err = cid.GetMSPID(stub)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Failed to get MSP ID %s", err))
+	}
+
+	i.Creator, err = creatorID(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// changes to the identity are endorsed by its org unless others are chosen
+	if len(i.EndorsingOrgs) == 0 {
+		i.EndorsingOrgs = []string{i.Org}
+	}
+
+	err = checkEndorsingOrgs(i.EndorsingOrgs)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	i.Keys = []Key{}
+	i.DataHash = ""
+	i.DataSchema = SchemaRef{}
+	i.DataPointer = nil
+	i.Collection = ""
+	i.Slots = map[string]DataSlot{}
+	i.Attributes = map[string]Attribute{}
+	i.PublicAttributes = map[string]string{}
+
+	c, err := getConfig(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = c.Limits.checkIdentity(i)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if c.PrivateData {
+		i.Collection, err = orgCollection(stub, c)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		// data is only written through UpdateUserData in private data mode
+		i.Data = ""
+	}
+
+	// BLS keys need a proof of possession, see SetBLSKey
+	i.BPublicKey = ""
+	i.Attestations = []Attestation{}
+
+	err = validateIdentityKeys(i)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Invalid key %s", err))
+	}
+
+	err = checkCertificateChain(stub, i)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = checkCommitments(i.Commitments)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// the email hash may come from the enrollment, before the denylist is checked
+	binding, err := enrollmentBinding(stub, c, &i)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// the envelope is signed by the key being registered to prove its possession
+	err = t.VerifyRequest(stub, args, env, i)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't verify signature %s", err))
+	}
+
+	data := i.Data
+	i.Data = ""
+	if data != "" {
+		i.DataHash = sha256Hex([]byte(data))
+	}
+
+	// a username is registered once, its keys are changed with RotateKeys
+	_, err = getIdentity(stub, i.Username)
+	if err == nil {
+		return shim.Error(fmt.Sprintf("Username %s is already registered", i.Username))
+	}
+	if err != errUsernameNotFound {
+		return shim.Error(err.Error())
+	}
+
+	// a new account starts unverified, only approved verifiers change
+	// the verification status, see SetVerification
+	i.Verified = verificationNone
+	i.Verifications = nil
+	// guardians are only changed with SetGuardians
+	i.Guardians = nil
+	// services are only changed with SetServices
+	i.Services = nil
+
+	i.Registered, err = txSeconds(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = updateIndexes(stub, Identity{}, i)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	iBytes, err := putIdentity(stub, i)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = putEnrollmentBinding(stub, binding)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = setEndorsementPolicy(stub, i)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if data != "" {
+		err = putUserData(stub, i.Username, "", data)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	_, err = invokeToken(stub, tokenCreateWallet, createWalletRequest{
+		Wallet:   walletID(stub, i.Username),
+		Username: i.Username,
+		Tenant:   walletTenant(stub),
+		DID:      didOf(stub, i.Username),
+		Org:      i.Org,
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(iBytes)
+}
+
+type updateUserDataRequest struct {
+	Username string    `json:"username"`
+	Slot     string    `json:"slot"`
+	Data     string    `json:"data"`
+	DataHash string    `json:"dataHash"`
+	Schema   SchemaRef `json:"schema"`
+
+	Pointer *DataPointer `json:"pointer"`
+
+	// Retention is how long in seconds the slot is kept after this write
+	Retention int64 `json:"retention"`
+
+	// Commitments replace those of the identity when given, see VerifyDisclosures
+	Commitments map[string]string `json:"commitments"`
+}
+
+type updateUserDataResponse struct {
+	Data string `json:"data"`
+}
+
+// UpdateUserData will query the blockchain
+// and update the encrypted data, of a named slot when one is given,
+// a pointer declares data stored off-chain instead
+func (t *DewalletChaincode) UpdateUserData(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Updating data of user")
+
+	var r updateUserDataRequest
+	env, err := t.ParseRequest(args, &r)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't parse request %s", err))
+	}
+
+	i, err := getIdentity(stub, r.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = t.VerifyRequest(stub, args, env, i)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't verify signature %s", err))
+	}
+
+	if r.Slot != "" && !slotNamePattern.MatchString(r.Slot) {
+		return shim.Error(fmt.Sprintf("Invalid slot name %s", r.Slot))
+	}
+
+	err = validateSchemaRef(stub, r.Schema)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if r.Retention < 0 {
+		return shim.Error("Retention can't be negative")
+	}
+
+	err = checkCommitments(r.Commitments)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	now, err := txSeconds(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	prev, ok := i.slot(r.Slot)
+	if prev.AppendOnly {
+		return shim.Error(fmt.Sprintf("Slot %s is append only", r.Slot))
+	}
+	s := DataSlot{Hash: prev.Hash, Schema: r.Schema, Retention: r.Retention}
+	s.retain(now)
+
+	l, err := getLimits(stub, i)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if r.Pointer != nil {
+		if r.Data != "" || r.DataHash != "" {
+			return shim.Error("Data is either inline or a pointer, not both")
+		}
+
+		err = r.Pointer.validate()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		// the inline ciphertext the pointer replaces is dropped
+		err = delSlotData(stub, i, r.Slot)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		s.Hash = r.Pointer.Hash
+		s.Pointer = r.Pointer
+	} else if i.Collection != "" {
+		data, err := transientField(stub, transientData, r.DataHash)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		err = l.checkData(transientData, string(data))
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		err = putSlotData(stub, i, r.Slot, data)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		s.Hash = r.DataHash
+	} else {
+		err = l.checkData("data", r.Data)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		err = putSlotData(stub, i, r.Slot, []byte(r.Data))
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		s.Hash = sha256Hex([]byte(r.Data))
+	}
+
+	// the identity is only rewritten when its metadata changes
+	changed := !ok || s != prev || (r.Slot == "" && i.Data != "") || r.Commitments != nil
+	i.setSlot(r.Slot, s)
+	if r.Commitments != nil {
+		i.Commitments = r.Commitments
+	}
+	if r.Slot == "" {
+		i.Data = ""
+	}
+
+	iBytes, _ := marshal(i)
+	if changed {
+		_, err = putIdentity(stub, i)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	return shim.Success(iBytes)
+}
+
+type addKeyRequest struct {
+	Username  string `json:"username"`
+	Owner     string `json:"owner"`
+	Key       string `json:"key"`
+	KeyHash   string `json:"keyHash"`
+	Attribute string `json:"attribute"`
+	Slot      string `json:"slot"`
+	NotBefore int64  `json:"notBefore"`
+	NotAfter  int64  `json:"notAfter"`
+	Purpose   string `json:"purpose"`
+
+	// Consent is recorded for the owner along with the key, when given,
+	// for the purpose of the key unless it has its own
+	Consent *consentTerms `json:"consent"`
+}
+
+type addKeyResponse struct {
+	Owner string `json:"owner"`
+	Key   string `json:"key"`
+
+	Consent *Consent `json:"consent,omitempty"`
+	// Evicted are the keys removed to make room for the key, see capGrants
+	Evicted []expiredGrant `json:"evicted,omitempty"`
+}
+
+// AddKey will add symetric key to blockchain
+func (t *DewalletChaincode) AddKey(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Adding decryption key of user data")
+
+	var r addKeyRequest
+	env, err := t.ParseRequest(args, &r)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't parse request %s", err))
+	}
+
+	// the key is written to its own record, see putKeyRecord
+	i, err := getStoredIdentity(stub, r.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = t.VerifyRequest(stub, args, env, i)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't verify signature %s", err))
+	}
+
+	err = resolveHandles(stub, &r.Owner)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	evicted, err := t.addKey(stub, &i, r)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// evicted keys still inline are dropped from the identity,
+	// the others only have their record deleted
+	if evictedInline(evicted) {
+		_, err = putIdentity(stub, i)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	} else {
+		for _, e := range evicted {
+			err = stub.DelState(e.record)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+		}
+
+		_, err = putKeyRecord(stub, i, i.Keys[len(i.Keys)-1], 0)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		_, err = appendChange(stub, i.Username)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	res := addKeyResponse{
+		Owner:   r.Owner,
+		Key:     r.Key,
+		Evicted: evicted,
+	}
+
+	if r.Consent != nil {
+		if r.Consent.Purpose == "" {
+			r.Consent.Purpose = r.Purpose
+		}
+
+		c, err := giveConsent(stub, i.Username, r.Owner, consentScope(r.Attribute, r.Slot), *r.Consent)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		res.Consent = &c
+	}
+
+	resBytes, _ := marshal(res)
+
+	return shim.Success(resBytes)
+}
+
+// addKey gives the key of the request to the identity, in private data mode
+// the key is taken from the transient map, the identity is left to be written.
+// It returns the keys evicted at the grant cap
+func (t *DewalletChaincode) addKey(stub shim.ChaincodeStubInterface, i *Identity, r addKeyRequest) ([]expiredGrant, error) {
+	if r.Attribute != "" && !attributeNamePattern.MatchString(r.Attribute) {
+		return nil, errors.New(fmt.Sprintf("Invalid attribute name %s", r.Attribute))
+	}
+	if r.Slot != "" && !slotNamePattern.MatchString(r.Slot) {
+		return nil, errors.New(fmt.Sprintf("Invalid slot name %s", r.Slot))
+	}
+	if r.Attribute != "" && r.Slot != "" {
+		return nil, errors.New("A key is scoped to an attribute or a slot, not both")
+	}
+
+	now, err := txSeconds(stub)
+	if err != nil {
+		return nil, err
+	}
+	if r.NotAfter != 0 && r.NotAfter <= now {
+		return nil, errors.New("Key expires in the past")
+	}
+	if r.NotAfter != 0 && r.NotAfter <= r.NotBefore {
+		return nil, errors.New("Key expires before it is valid")
+	}
+
+	l, err := getLimits(stub, *i)
+	if err != nil {
+		return nil, err
+	}
+
+	err = l.checkUsername("owner", r.Owner)
+	if err != nil {
+		return nil, err
+	}
+
+	err = l.checkKey("key", r.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkGrantPolicy(stub, *i, r.Owner)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkPurpose(stub, r.Purpose)
+	if err != nil {
+		return nil, err
+	}
+
+	// the cap counts every key of the identity, a capped AddKey reads them
+	if l.MaxGrants > 0 && i.keyRecords == nil {
+		err = loadKeyRecords(stub, i)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	prev := *i
+	evicted, err := capGrants(stub, i, l, now)
+	if err != nil {
+		return nil, err
+	}
+
+	key := Key{
+		Owner:     r.Owner,
+		Key:       r.Key,
+		Attribute: r.Attribute,
+		Slot:      r.Slot,
+		NotBefore: r.NotBefore,
+		NotAfter:  r.NotAfter,
+		Purpose:   r.Purpose,
+	}
+	if r.Consent != nil {
+		// the consent is recorded with the ID of the transaction, see giveConsent
+		key.ConsentID = stub.GetTxID()
+	}
+	if r.Attribute != "" {
+		key.DataHash = i.Attributes[r.Attribute].Hash
+	} else {
+		s, _ := i.slot(r.Slot)
+		key.DataHash = s.Hash
+	}
+
+	if i.Collection != "" {
+		k, err := transientField(stub, transientKey, r.KeyHash)
+		if err != nil {
+			return nil, err
+		}
+
+		err = l.checkKey(transientKey, string(k))
+		if err != nil {
+			return nil, err
+		}
+
+		pKey, err := privateKeyKey(stub, i.Username, r.Owner, r.Attribute, r.Slot)
+		if err != nil {
+			return nil, err
+		}
+
+		err = stub.PutPrivateData(i.Collection, pKey, k)
+		if err != nil {
+			return nil, err
+		}
+
+		key.Key = ""
+		key.KeyHash = r.KeyHash
+	}
+
+	i.Keys = append(i.Keys, key)
+
+	return evicted, updateIndexes(stub, prev, *i)
+}
+
+type getPublicKeyRequest struct {
+	Username string `json:"username"`
+
+	// Local doesn't look up the sibling deployments, Siblings looks a
+	// username not found here up in them, a DID always is on the channel it names
+	Local    bool `json:"local"`
+	Siblings bool `json:"siblings"`
+	// Encoding of the response, json or protobuf for a PublicKeys message of state.proto
+	Encoding string `json:"encoding"`
+
+	fieldMask
+}
+
+type getPublicKeyResponse struct {
+	PublicKey  string `json:"publicKey"`
+	EPublicKey string `json:"ePublicKey"`
+
+	// Channel is the sibling channel the identity was found on, if not here
+	Channel string `json:"channel,omitempty"`
+}
+
+// GetPublicKey will query the blockchain
+// to get the public key of a username, of the sibling
+// deployment of the channel of a DID not registered here
+func (t *DewalletChaincode) GetPublicKey(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Querying a member public key")
+
+	var req getPublicKeyRequest
+	json.Unmarshal([]byte(args[0]), &req)
+
+	err := req.check(getPublicKeyResponse{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	r, err := getKeyMaterial(stub, req.Username)
+	if notFound(err) && !req.Local {
+		res, serr := siblingPublicKey(stub, req.Username, req.Siblings)
+		if serr == nil {
+			return res.encode(req.Encoding, req.fieldMask)
+		}
+	}
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = checkQueryPolicy(stub, r.identity())
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	res := getPublicKeyResponse{
+		PublicKey:  r.PublicKey,
+		EPublicKey: r.EPublicKey,
+	}
+
+	return res.encode(req.Encoding, req.fieldMask)
+}
+
+// encode returns the response in the encoding the client asked,
+// trimmed to the field mask, which only applies to JSON
+func (res getPublicKeyResponse) encode(encoding string, mask fieldMask) pb.Response {
+	if encoding == "" || encoding == stateEncodingJSON {
+		resBytes, err := mask.apply(res)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		return shim.Success(resBytes)
+	}
+	if len(mask.Fields) > 0 {
+		return shim.Error(fmt.Sprintf("Fields can't be selected in the %s encoding", encoding))
+	}
+
+	resBytes, err := encodeState(encoding, res, &pbPublicKeys{PublicKey: res.PublicKey, EPublicKey: res.EPublicKey, Channel: res.Channel})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(resBytes)
+}
+
+// maxBatchSize is the most usernames a batch query resolves
+const maxBatchSize = 100
+
+type getPublicKeysRequest struct {
+	Usernames []string `json:"usernames"`
+
+	// Siblings looks the usernames not found here up in the sibling deployments
+	Siblings bool `json:"siblings"`
+
+	fieldMask
+}
+
+type getPublicKeysResult struct {
+	PublicKey  string `json:"publicKey,omitempty"`
+	EPublicKey string `json:"ePublicKey,omitempty"`
+	Channel    string `json:"channel,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// GetPublicKeys will query the blockchain
+// to get the public keys of several usernames at once,
+// a username that can't be resolved gets an error instead,
+// which the field mask doesn't trim
+func (t *DewalletChaincode) GetPublicKeys(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Querying member public keys")
+
+	var req getPublicKeysRequest
+	err := parseQuery(args, &req)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = req.check(getPublicKeysResult{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if len(req.Usernames) > maxBatchSize {
+		return shim.Error(fmt.Sprintf("At most %d usernames can be queried at once", maxBatchSize))
+	}
+
+	res := map[string]getPublicKeysResult{}
+	for _, username := range req.Usernames {
+		if _, ok := res[username]; ok {
+			continue
+		}
+
+		r, err := getKeyMaterial(stub, username)
+		if notFound(err) {
+			s, serr := siblingPublicKey(stub, username, req.Siblings)
+			if serr == nil {
+				res[username] = getPublicKeysResult{PublicKey: s.PublicKey, EPublicKey: s.EPublicKey, Channel: s.Channel}
+				continue
+			}
+		}
+		if err == nil {
+			err = checkQueryPolicy(stub, r.identity())
+		}
+		if err != nil {
+			res[username] = getPublicKeysResult{Error: err.Error()}
+			continue
+		}
+
+		res[username] = getPublicKeysResult{
+			PublicKey:  r.PublicKey,
+			EPublicKey: r.EPublicKey,
+		}
+	}
+
+	masked := map[string]json.RawMessage{}
+	for username, r := range res {
+		mask := req.fieldMask
+		if r.Error != "" {
+			mask = fieldMask{}
+		}
+
+		masked[username], err = mask.apply(r)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	resBytes, _ := marshal(masked)
+
+	return shim.Success(resBytes)
+}
+
+type existsResponse struct {
+	Username string `json:"username"`
+	Exists   bool   `json:"exists"`
+}
+
+// Exists will query the blockchain
+// to tell whether a username is registered, without reading the identity
+func (t *DewalletChaincode) Exists(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Querying whether a member exists")
+
+	var req getPublicKeyRequest
+	json.Unmarshal([]byte(args[0]), &req)
+
+	res := existsResponse{Username: req.Username}
+
+	// an unknown DID doesn't exist either
+	username, err := resolveUsername(stub, req.Username)
+	if err == nil {
+		iBytes, err := stub.GetState(username)
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Failed to get state %s", err))
+		}
+		res.Exists = iBytes != nil
+	}
+
+	resBytes, _ := marshal(res)
+
+	return shim.Success(resBytes)
+}
+
+type getUserDataRequest struct {
+	Username string `json:"username"`
+	Slot     string `json:"slot"`
+	Owner    string `json:"owner"`
+	// Token is a session JWT of the owner, required with an owner, see VerifySession
+	Token string `json:"token"`
+
+	// Purpose is checked against the disclosure policy of the user
+	// and recorded in its access log
+	Purpose string `json:"purpose"`
+
+	fieldMask
+}
+
+type getUserDataResponse struct {
+	PublicKey  string `json:"publicKey"`
+	EPublicKey string `json:"ePublicKey"`
+	SPublicKey string `json:"sPublicKey"`
+	Data       string `json:"data"`
+	DataHash   string `json:"dataHash"`
+	Key        string `json:"key"`
+	// NoGrant is set when the reader has no active key for the slot,
+	// Key and Data are then empty
+	NoGrant bool `json:"noGrant,omitempty"`
+
+	Pointer *DataPointer `json:"pointer,omitempty"`
+
+	Entries       []string          `json:"entries,omitempty"`
+	Attributes    map[string]string `json:"attributes"`
+	AttributeKeys map[string]string `json:"attributeKeys"`
+}
+
+// VerifySession checks that token is a live session JWT
+// signed by the registered signing key of username
+func (t *DewalletChaincode) VerifySession(stub shim.ChaincodeStubInterface, token string, username string) error {
+	if token == "" {
+		return errors.New("Missing session token")
+	}
+
+	i, err := getIdentity(stub, username)
+	if err != nil {
+		return err
+	}
+
+	c, err := verifyJWT(stub, token, i.SPublicKey)
+	if err != nil {
+		return err
+	}
+
+	ts, err := stub.GetTxTimestamp()
+	if err != nil {
+		return errors.New("Failed to get transaction timestamp")
+	}
+
+	return c.validate(username, ts.Seconds)
+}
+
+// GetUserData will query the blockchain
+// and return encrypted data of a user
+func (t *DewalletChaincode) GetUserData(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Querying a user data")
+
+	var req getUserDataRequest
+	err := parseQuery(args, &req)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = req.check(getUserDataResponse{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// the keys given to the reader are looked up by owner, see userData
+	i, err := getStoredIdentity(stub, req.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = resolveHandles(stub, &req.Owner)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// the data and keys of an owner are only returned to a session of the
+	// owner, without one only what isn't scoped to an owner is returned
+	if req.Owner != "" {
+		err = t.VerifySession(stub, req.Token, req.Owner)
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Can't verify session %s", err))
+		}
+	}
+
+	now, err := txSeconds(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	res, err := t.userData(stub, i, req.Owner, req.Slot, req.Purpose, now)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = audit(stub, i.Username, req.Owner, req.Purpose)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resBytes, err := req.apply(res)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(resBytes)
+}
+
+// userData is what owner can read of a slot of the identity, the keys
+// owner was given and only the data and attributes they are for,
+// the user itself reads everything
+func (t *DewalletChaincode) userData(stub shim.ChaincodeStubInterface, i Identity, owner string, slot string, purpose string, now int64) (getUserDataResponse, error) {
+	s, ok := i.slot(slot)
+	if !ok {
+		return getUserDataResponse{}, errors.New(fmt.Sprintf("Slot %s not found", slot))
+	}
+	if s.expired(now) {
+		return getUserDataResponse{}, errors.New(fmt.Sprintf("Slot %s has expired", slot))
+	}
+
+	self := owner == i.Username
+
+	keys, err := ownerKeys(stub, i, owner)
+	if err != nil {
+		return getUserDataResponse{}, err
+	}
+
+	var keyResult string
+	hasKey := false
+	attributeKeys := map[string]string{}
+
+	// the newest active key is used, a grant given again supersedes the previous
+	for n := len(keys) - 1; n >= 0; n-- {
+		key := keys[n]
+		if !key.active(now) {
+			continue
+		}
+
+		if key.Attribute != "" {
+			if _, ok := attributeKeys[key.Attribute]; !ok {
+				attributeKeys[key.Attribute] = key.Key
+			}
+		} else if key.Slot == slot && !hasKey {
+			keyResult = key.Key
+			hasKey = true
+		}
+	}
+
+	if !self && (hasKey || len(attributeKeys) > 0) {
+		requester, err := getIdentity(stub, owner)
+		if err != nil {
+			return getUserDataResponse{}, err
+		}
+
+		d := disclosureRequest{Requester: requester, Purpose: purpose, Now: now}
+		if hasKey {
+			err = authorizeDisclosure(stub, i, consentScope("", slot), d)
+			if err != nil {
+				return getUserDataResponse{}, err
+			}
+		}
+		if len(attributeKeys) > 0 {
+			err = authorizeDisclosure(stub, i, disclosureScopeIdentity, d)
+			if err != nil {
+				return getUserDataResponse{}, err
+			}
+		}
+	}
+
+	attributes := map[string]string{}
+	for name, a := range i.Attributes {
+		if _, ok := attributeKeys[name]; ok || self {
+			attributes[name] = a.Value
+		}
+	}
+
+	if !self && !hasKey {
+		i.Data = ""
+		s.Pointer = nil
+	} else if i.Collection == "" {
+		i.Data, err = getUserData(stub, i, slot)
+		if err != nil {
+			return getUserDataResponse{}, err
+		}
+	} else {
+		i.Data, keyResult, err = t.getPrivateUserData(stub, i, owner, slot)
+		if err != nil {
+			return getUserDataResponse{}, err
+		}
+
+		// keys outside their window are still in the collection
+		if !hasKey {
+			keyResult = ""
+		}
+	}
+
+	if i.Collection != "" {
+		if len(attributes) > 0 {
+			private, err := t.getPrivateAttributes(stub, i)
+			if err != nil {
+				return getUserDataResponse{}, err
+			}
+
+			for name := range attributes {
+				attributes[name] = private[name]
+			}
+		}
+
+		for name := range attributeKeys {
+			attributeKeys[name], err = t.getPrivateKey(stub, i, owner, name, "")
+			if err != nil {
+				return getUserDataResponse{}, err
+			}
+		}
+	}
+
+	// identities written before hashes were kept get one computed
+	if s.Hash == "" && i.Data != "" {
+		s.Hash = sha256Hex([]byte(i.Data))
+	}
+
+	var entries []string
+	if s.AppendOnly && (self || hasKey) {
+		entries, err = getSlotEntries(stub, i, slot, s)
+		if err != nil {
+			return getUserDataResponse{}, err
+		}
+	}
+
+	return getUserDataResponse{
+		PublicKey:  i.PublicKey,
+		EPublicKey: i.EPublicKey,
+		SPublicKey: i.SPublicKey,
+		Data:       i.Data,
+		DataHash:   s.Hash,
+		Key:        keyResult,
+		NoGrant:    !self && !hasKey,
+
+		Pointer: s.Pointer,
+
+		Entries:       entries,
+		Attributes:    attributes,
+		AttributeKeys: attributeKeys,
+	}, nil
+}
 
 func main() {
 	err := shim.Start(new(DewalletChaincode))
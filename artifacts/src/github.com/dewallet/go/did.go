@@ -0,0 +1,371 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// didMethod prefixes the DIDs of identities, did:fabric:<channel>:<username>
+const didMethod = "did:fabric"
+
+// didObjectType indexes the usernames by DID
+const didObjectType = "did"
+
+// maxServices bounds the service endpoints of an identity
+const maxServices = 8
+
+var didContext = []string{"https://www.w3.org/ns/did/v1", "https://w3id.org/security/suites/jws-2020/v1"}
+
+// serviceIDPattern matches the fragments identifying services
+var serviceIDPattern = regexp.MustCompile(`^[A-Za-z0-9._\-]{1,64}$`)
+
+// Service is a service endpoint of an identity listed in its DID document
+type Service struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Endpoint string `json:"serviceEndpoint"`
+}
+
+func (s Service) validate() error {
+	if !serviceIDPattern.MatchString(s.ID) {
+		return errors.New(fmt.Sprintf("Invalid service id %s", s.ID))
+	}
+	if s.Type == "" || len(s.Type) > 64 {
+		return errors.New(fmt.Sprintf("Invalid type of service %s", s.ID))
+	}
+
+	u, err := url.Parse(s.Endpoint)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return errors.New(fmt.Sprintf("Invalid endpoint of service %s", s.ID))
+	}
+
+	return nil
+}
+
+// JWK is a public key as a JSON Web Key
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+type didVerificationMethod struct {
+	ID           string `json:"id"`
+	Type         string `json:"type"`
+	Controller   string `json:"controller"`
+	PublicKeyJwk JWK    `json:"publicKeyJwk"`
+}
+
+// DIDDocument is the W3C DID document of an identity
+type DIDDocument struct {
+	Context            []string                `json:"@context"`
+	ID                 string                  `json:"id"`
+	VerificationMethod []didVerificationMethod `json:"verificationMethod"`
+	Authentication     []string                `json:"authentication"`
+	AssertionMethod    []string                `json:"assertionMethod"`
+	KeyAgreement       []string                `json:"keyAgreement"`
+	Service            []Service               `json:"service"`
+}
+
+// padBytes is the big-endian n of size bytes
+func padBytes(n *big.Int, size int) []byte {
+	b := n.Bytes()
+	return append(make([]byte, size-len(b)), b...)
+}
+
+// toJWK converts a base64 PKIX public key
+func toJWK(publicKey string) (JWK, error) {
+	pkBytes, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil {
+		return JWK{}, err
+	}
+
+	pk, err := x509.ParsePKIXPublicKey(pkBytes)
+	if err != nil {
+		return JWK{}, err
+	}
+
+	b64 := base64.RawURLEncoding.EncodeToString
+	switch pk := pk.(type) {
+	case *rsa.PublicKey:
+		return JWK{Kty: "RSA", N: b64(pk.N.Bytes()), E: b64(big.NewInt(int64(pk.E)).Bytes())}, nil
+	case *ecdsa.PublicKey:
+		size := (pk.Curve.Params().BitSize + 7) / 8
+		return JWK{Kty: "EC", Crv: pk.Curve.Params().Name, X: b64(padBytes(pk.X, size)), Y: b64(padBytes(pk.Y, size))}, nil
+	case ed25519.PublicKey:
+		return JWK{Kty: "OKP", Crv: "Ed25519", X: b64(pk)}, nil
+	}
+
+	return JWK{}, errors.New("Unsupported key type")
+}
+
+// didOf is the DID of a username on the channel
+func didOf(stub shim.ChaincodeStubInterface, username string) string {
+	return fmt.Sprintf("%s:%s:%s", didMethod, stub.GetChannelID(), username)
+}
+
+// indexDID points the DID of the identity to its username
+func indexDID(stub shim.ChaincodeStubInterface, i Identity) error {
+	key, err := stub.CreateCompositeKey(didObjectType, []string{didOf(stub, i.Username)})
+	if err != nil {
+		return err
+	}
+
+	username, err := stub.GetState(key)
+	if err != nil {
+		return errors.New("Failed to get state")
+	}
+	if username != nil {
+		return nil
+	}
+
+	return stub.PutState(key, []byte(i.Username))
+}
+
+// resolveUsername is the username of a handle, which is
+// either the username itself or the DID of the identity
+// didNotFoundError is the error of resolving a DID no identity has
+type didNotFoundError struct {
+	did string
+}
+
+func (e didNotFoundError) Error() string {
+	return fmt.Sprintf("DID %s not found", e.did)
+}
+
+func resolveUsername(stub shim.ChaincodeStubInterface, handle string) (string, error) {
+	if !strings.HasPrefix(handle, "did:") {
+		return handle, nil
+	}
+
+	key, err := stub.CreateCompositeKey(didObjectType, []string{handle})
+	if err != nil {
+		return "", err
+	}
+
+	username, err := stub.GetState(key)
+	if err != nil {
+		return "", errors.New("Failed to get state")
+	}
+	if username == nil {
+		return "", didNotFoundError{handle}
+	}
+
+	return string(username), nil
+}
+
+// resolveHandles replaces the DIDs among handles by their usernames
+func resolveHandles(stub shim.ChaincodeStubInterface, handles ...*string) error {
+	for _, h := range handles {
+		username, err := resolveUsername(stub, *h)
+		if err != nil {
+			return err
+		}
+		*h = username
+	}
+
+	return nil
+}
+
+// resolveHandleList replaces the DIDs in a list of handles by their usernames
+func resolveHandleList(stub shim.ChaincodeStubInterface, handles []string) error {
+	for k := range handles {
+		err := resolveHandles(stub, &handles[k])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// didDocument renders the keys and services of the identity
+func didDocument(stub shim.ChaincodeStubInterface, i Identity) (DIDDocument, error) {
+	did := didOf(stub, i.Username)
+	doc := DIDDocument{
+		Context:            didContext,
+		ID:                 did,
+		VerificationMethod: []didVerificationMethod{},
+		Authentication:     []string{},
+		AssertionMethod:    []string{},
+		KeyAgreement:       []string{},
+		Service:            []Service{},
+	}
+
+	for _, k := range [][2]string{
+		{keySlotIdentity, i.PublicKey},
+		{keySlotEncryption, i.EPublicKey},
+		{keySlotSigning, i.SPublicKey},
+	} {
+		if k[1] == "" {
+			continue
+		}
+
+		jwk, err := toJWK(k[1])
+		if err != nil {
+			return doc, errors.New(fmt.Sprintf("Can't convert %s %s", k[0], err))
+		}
+
+		id := did + "#" + k[0]
+		doc.VerificationMethod = append(doc.VerificationMethod, didVerificationMethod{ID: id, Type: "JsonWebKey2020", Controller: did, PublicKeyJwk: jwk})
+
+		switch k[0] {
+		case keySlotSigning:
+			doc.Authentication = append(doc.Authentication, id)
+			doc.AssertionMethod = append(doc.AssertionMethod, id)
+		case keySlotEncryption:
+			doc.KeyAgreement = append(doc.KeyAgreement, id)
+		}
+	}
+
+	for _, s := range i.Services {
+		doc.Service = append(doc.Service, Service{ID: did + "#" + s.ID, Type: s.Type, Endpoint: s.Endpoint})
+	}
+
+	return doc, nil
+}
+
+type setServicesRequest struct {
+	Username string    `json:"username"`
+	Services []Service `json:"services"`
+}
+
+// SetServices will replace the service endpoints of the user
+// listed in its DID document
+func (t *DewalletChaincode) SetServices(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Setting services of user")
+
+	var r setServicesRequest
+	env, err := t.ParseRequest(args, &r)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't parse request %s", err))
+	}
+
+	i, err := getIdentity(stub, r.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = t.VerifyRequest(stub, args, env, i)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't verify signature %s", err))
+	}
+
+	if len(r.Services) > maxServices {
+		return shim.Error(fmt.Sprintf("At most %d services", maxServices))
+	}
+	for k, s := range r.Services {
+		err = s.validate()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		for _, o := range r.Services[:k] {
+			if o.ID == s.ID {
+				return shim.Error(fmt.Sprintf("Service %s listed twice", s.ID))
+			}
+		}
+	}
+
+	i.Services = r.Services
+
+	iBytes, err := putIdentity(stub, i)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(iBytes)
+}
+
+type indexDIDsRequest struct {
+	pageRequest
+}
+
+// IndexDIDs will index the DIDs of a page of identities, admin only,
+// for the identities not written since DIDs are indexed on write
+func (t *DewalletChaincode) IndexDIDs(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Indexing DIDs")
+
+	err := t.requireAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var req indexDIDsRequest
+	json.Unmarshal([]byte(args[0]), &req)
+
+	size, err := req.size()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	it, m, err := stub.GetStateByRangeWithPagination("", "", size, req.Bookmark)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't list identities %s", err))
+	}
+	defer it.Close()
+
+	identities, err := collectIdentities(stub, it, func(Identity) bool { return true })
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't list identities %s", err))
+	}
+
+	for _, i := range identities {
+		err = indexDID(stub, i)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	resBytes, _ := marshal(newPageResponse(m))
+
+	return shim.Success(resBytes)
+}
+
+type getDIDDocumentRequest struct {
+	Username string `json:"username"`
+}
+
+// GetDIDDocument will query the blockchain and return
+// the identity of a user as a DID document
+func (t *DewalletChaincode) GetDIDDocument(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Querying DID document of user")
+
+	var req getDIDDocumentRequest
+	json.Unmarshal([]byte(args[0]), &req)
+
+	i, err := getIdentity(stub, req.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = checkQueryPolicy(stub, i)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	doc, err := didDocument(stub, i)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	docBytes, _ := marshal(doc)
+
+	return shim.Success(docBytes)
+}
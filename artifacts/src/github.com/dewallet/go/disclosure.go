@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// maxCommitments bounds the attribute commitments of an identity
+const maxCommitments = 64
+
+// minSaltLength is the shortest salt of a disclosure, 128 bits in base64url
+const minSaltLength = 22
+
+// checkCommitments checks the attribute commitments stored with the data,
+// each the hex SHA-256 of the disclosure of an attribute, see parseDisclosure
+func checkCommitments(commitments map[string]string) error {
+	if len(commitments) > maxCommitments {
+		return errors.New(fmt.Sprintf("At most %d commitments", maxCommitments))
+	}
+
+	for name, c := range commitments {
+		if !attributeNamePattern.MatchString(name) {
+			return errors.New(fmt.Sprintf("Invalid attribute name %s", name))
+		}
+		if !hashPattern.MatchString(c) {
+			return errors.New(fmt.Sprintf("Commitment of %s must be a hex SHA-256", name))
+		}
+	}
+
+	return nil
+}
+
+// parseDisclosure decodes a disclosure revealed off-chain, as in SD-JWT
+// the base64url of the JSON array [salt, name, value]
+func parseDisclosure(d string) (string, json.RawMessage, error) {
+	dBytes, err := base64.RawURLEncoding.DecodeString(d)
+	if err != nil {
+		return "", nil, errors.New(fmt.Sprintf("Error in decoding disclosure %s", err))
+	}
+
+	var parts []json.RawMessage
+	err = json.Unmarshal(dBytes, &parts)
+	if err != nil || len(parts) != 3 {
+		return "", nil, errors.New("A disclosure is an array of salt, name and value")
+	}
+
+	var salt, name string
+	err = json.Unmarshal(parts[0], &salt)
+	if err != nil || len(salt) < minSaltLength {
+		return "", nil, errors.New(fmt.Sprintf("Salt of a disclosure has at least %d characters", minSaltLength))
+	}
+
+	err = json.Unmarshal(parts[1], &name)
+	if err != nil || !attributeNamePattern.MatchString(name) {
+		return "", nil, errors.New("Invalid attribute name of disclosure")
+	}
+
+	return name, parts[2], nil
+}
+
+type verifyDisclosuresRequest struct {
+	Username    string   `json:"username"`
+	Disclosures []string `json:"disclosures"`
+}
+
+type verifyDisclosuresResponse struct {
+	Username   string                     `json:"username"`
+	Attributes map[string]json.RawMessage `json:"attributes"`
+}
+
+// VerifyDisclosures will check attribute values a user revealed off-chain
+// against the commitments stored with its data and return them
+func (t *DewalletChaincode) VerifyDisclosures(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Verifying disclosures of user")
+
+	var req verifyDisclosuresRequest
+	json.Unmarshal([]byte(args[0]), &req)
+
+	i, err := getIdentity(stub, req.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if len(req.Disclosures) == 0 || len(req.Disclosures) > maxCommitments {
+		return shim.Error(fmt.Sprintf("Verify 1 to %d disclosures", maxCommitments))
+	}
+
+	res := verifyDisclosuresResponse{Username: i.Username, Attributes: map[string]json.RawMessage{}}
+	for _, d := range req.Disclosures {
+		name, value, err := parseDisclosure(d)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		c, ok := i.Commitments[name]
+		if !ok || c != sha256Hex([]byte(d)) {
+			return shim.Error(fmt.Sprintf("Disclosure of %s doesn't match its commitment", name))
+		}
+
+		res.Attributes[name] = value
+	}
+
+	resBytes, _ := marshal(res)
+
+	return shim.Success(resBytes)
+}
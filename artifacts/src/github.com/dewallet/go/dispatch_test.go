@@ -0,0 +1,74 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// TestDispatchUnknown checks an unknown function, an empty Invoke
+// included, is refused with the functions of the chaincode
+func TestDispatchUnknown(t *testing.T) {
+	tests := []struct {
+		name     string
+		function string
+		args     []string
+	}{
+		{name: "empty"},
+		{name: "unknown", function: "Unregister", args: []string{"{}"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHarness(t, time.Now().Unix())
+
+			res := h.invoke(tt.function, tt.args...)
+			if res.Status == shim.OK {
+				t.Fatal("Unknown function wasn't refused")
+			}
+			if !strings.Contains(res.Message, strings.Join(functions, ", ")) {
+				t.Fatalf("Unexpected error %s", res.Message)
+			}
+		})
+	}
+}
+
+// TestDispatchFunctions checks functions are the functions dispatch handles
+func TestDispatchFunctions(t *testing.T) {
+	f, err := parser.ParseFile(token.NewFileSet(), "dewallet_cc.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dispatched := []string{}
+	for _, d := range f.Decls {
+		fn, ok := d.(*ast.FuncDecl)
+		if !ok || fn.Name.Name != "dispatch" {
+			continue
+		}
+
+		ast.Inspect(fn.Body, func(n ast.Node) bool {
+			b, ok := n.(*ast.BinaryExpr)
+			if !ok || b.Op != token.EQL {
+				return true
+			}
+			x, ok := b.X.(*ast.Ident)
+			l, lok := b.Y.(*ast.BasicLit)
+			if ok && lok && x.Name == "function" {
+				name, _ := strconv.Unquote(l.Value)
+				dispatched = append(dispatched, name)
+			}
+			return true
+		})
+	}
+
+	if strings.Join(dispatched, ",") != strings.Join(functions, ",") {
+		t.Fatalf("Functions %v don't match dispatch %v", functions, dispatched)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/core/chaincode/shim/ext/statebased"
+)
+
+// maxEndorsingOrgs bounds the orgs an identity can require endorsements of
+const maxEndorsingOrgs = 8
+
+// checkEndorsingOrgs validates the orgs chosen at Register
+func checkEndorsingOrgs(orgs []string) error {
+	if len(orgs) > maxEndorsingOrgs {
+		return errors.New(fmt.Sprintf("At most %d endorsing orgs", maxEndorsingOrgs))
+	}
+
+	for k, org := range orgs {
+		if !tenantNamePattern.MatchString(org) {
+			return errors.New(fmt.Sprintf("Invalid endorsing org %s", org))
+		}
+		if containsString(orgs[:k], org) {
+			return errors.New(fmt.Sprintf("Endorsing org %s listed twice", org))
+		}
+	}
+
+	return nil
+}
+
+// setEndorsementPolicy sets the key level endorsement policy of the identity,
+// a peer of each of its endorsing orgs must endorse the transactions changing it,
+// so the peers of another org alone can't tamper with it
+func setEndorsementPolicy(stub shim.ChaincodeStubInterface, i Identity) error {
+	err := setKeyEndorsementPolicy(stub, i.Username, i.EndorsingOrgs)
+	if err != nil {
+		return err
+	}
+
+	key, err := keyMaterialKey(stub, i.Username)
+	if err != nil {
+		return err
+	}
+
+	return setKeyEndorsementPolicy(stub, key, i.EndorsingOrgs)
+}
+
+// setKeyEndorsementPolicy requires a peer of each of orgs to endorse changes to key
+func setKeyEndorsementPolicy(stub shim.ChaincodeStubInterface, key string, orgs []string) error {
+	ep, err := statebased.NewStateEP(nil)
+	if err != nil {
+		return err
+	}
+
+	err = ep.AddOrgs(statebased.RoleTypePeer, orgs...)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Invalid endorsing orgs %s", err))
+	}
+
+	policy, err := ep.Policy()
+	if err != nil {
+		return err
+	}
+
+	return stub.SetStateValidationParameter(key, policy)
+}
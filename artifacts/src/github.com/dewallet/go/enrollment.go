@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// enrollmentObjectType prefixes the enrollment bindings by username
+const enrollmentObjectType = "enrollment"
+
+// Fabric CA attributes read from the enrollment certificate, hf.EnrollmentID
+// and hf.Type are in every certificate it issues, email when asked for
+const (
+	enrollmentIDAttribute           = "hf.EnrollmentID"
+	enrollmentAffiliationAttribute  = "hf.Affiliation"
+	defaultEnrollmentRoleAttribute  = "hf.Type"
+	defaultEnrollmentEmailAttribute = "email"
+)
+
+// EnrollmentBinding ties an identity to the Fabric CA enrollment of the
+// client that registered it, an identity stays bound to one enrollment
+// of one org while its certificate is renewed
+type EnrollmentBinding struct {
+	Username        string `json:"username"`
+	MSPID           string `json:"mspId"`
+	EnrollmentID    string `json:"enrollmentId"`
+	Affiliation     string `json:"affiliation,omitempty"`
+	Role            string `json:"role,omitempty"`
+	EmailHash       string `json:"emailHash,omitempty"`
+	CertFingerprint string `json:"certFingerprint"`
+	NotAfter        int64  `json:"notAfter"`
+	BoundAt         int64  `json:"boundAt"`
+	TxID            string `json:"txId"`
+}
+
+func enrollmentKey(stub shim.ChaincodeStubInterface, username string) (string, error) {
+	return stub.CreateCompositeKey(enrollmentObjectType, []string{username})
+}
+
+// attributeOr reads a certificate attribute, the attribute default when not configured
+func attributeOr(stub shim.ChaincodeStubInterface, name string, defaultName string) (string, error) {
+	if name == "" {
+		name = defaultName
+	}
+
+	value, _, err := cid.GetAttributeValue(stub, name)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Failed to get attribute %s %s", name, err))
+	}
+
+	return value, nil
+}
+
+// enrollmentBinding reads the enrollment certificate of the client
+// registering i when the deployment binds enrollments, nil otherwise.
+// The email of the certificate must be the one i declares, it is
+// taken as the email hash of i when it declares none
+func enrollmentBinding(stub shim.ChaincodeStubInterface, c Config, i *Identity) (*EnrollmentBinding, error) {
+	if !c.BindEnrollment {
+		return nil, nil
+	}
+
+	cert, err := cid.GetX509Certificate(stub)
+	if err != nil || cert == nil {
+		return nil, errors.New("Registration needs an X.509 enrollment certificate")
+	}
+
+	b := EnrollmentBinding{
+		Username:        i.Username,
+		MSPID:           i.Org,
+		CertFingerprint: sha256Hex(cert.Raw),
+		NotAfter:        cert.NotAfter.Unix(),
+		TxID:            stub.GetTxID(),
+	}
+
+	b.EnrollmentID, err = attributeOr(stub, "", enrollmentIDAttribute)
+	if err != nil {
+		return nil, err
+	}
+	// certificates not issued by Fabric CA name the enrollment in the subject
+	if b.EnrollmentID == "" {
+		b.EnrollmentID = cert.Subject.CommonName
+	}
+
+	b.Affiliation, err = attributeOr(stub, "", enrollmentAffiliationAttribute)
+	if err != nil {
+		return nil, err
+	}
+
+	b.Role, err = attributeOr(stub, c.EnrollmentRoleAttribute, defaultEnrollmentRoleAttribute)
+	if err != nil {
+		return nil, err
+	}
+
+	email, err := attributeOr(stub, c.EnrollmentEmailAttribute, defaultEnrollmentEmailAttribute)
+	if err != nil {
+		return nil, err
+	}
+	if email == "" && len(cert.EmailAddresses) > 0 {
+		email = cert.EmailAddresses[0]
+	}
+	if email != "" {
+		b.EmailHash = sha256Hex([]byte(strings.ToLower(email)))
+	}
+
+	if b.EmailHash != "" && i.EmailHash != "" && b.EmailHash != i.EmailHash {
+		return nil, errors.New("Email hash does not match the enrollment certificate")
+	}
+	if i.EmailHash == "" {
+		i.EmailHash = b.EmailHash
+	}
+
+	b.BoundAt, err = txSeconds(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	return &b, nil
+}
+
+// getEnrollmentBinding reads the binding of username, nil when it has none
+func getEnrollmentBinding(stub shim.ChaincodeStubInterface, username string) (*EnrollmentBinding, error) {
+	key, err := enrollmentKey(stub, username)
+	if err != nil {
+		return nil, err
+	}
+
+	bBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get state")
+	}
+	if bBytes == nil {
+		return nil, nil
+	}
+
+	var b EnrollmentBinding
+	err = json.Unmarshal(bBytes, &b)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error in parsing enrollment binding %s", err))
+	}
+
+	return &b, nil
+}
+
+// putEnrollmentBinding stores the binding, an identity already bound
+// keeps its enrollment and only its certificate details are refreshed
+func putEnrollmentBinding(stub shim.ChaincodeStubInterface, b *EnrollmentBinding) error {
+	if b == nil {
+		return nil
+	}
+
+	prev, err := getEnrollmentBinding(stub, b.Username)
+	if err != nil {
+		return err
+	}
+	if prev != nil && (prev.MSPID != b.MSPID || prev.EnrollmentID != b.EnrollmentID) {
+		return errors.New(fmt.Sprintf("%s is bound to enrollment %s of %s", b.Username, prev.EnrollmentID, prev.MSPID))
+	}
+
+	key, err := enrollmentKey(stub, b.Username)
+	if err != nil {
+		return err
+	}
+
+	bBytes, _ := marshal(b)
+	return stub.PutState(key, bBytes)
+}
+
+type getEnrollmentBindingRequest struct {
+	Username string `json:"username"`
+}
+
+// GetEnrollmentBinding will query the blockchain and return the enrollment
+// a user is bound to, for the orgs its access policy allows
+func (t *DewalletChaincode) GetEnrollmentBinding(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Querying enrollment binding of user")
+
+	var req getEnrollmentBindingRequest
+	json.Unmarshal([]byte(args[0]), &req)
+
+	i, err := getIdentity(stub, req.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = checkQueryPolicy(stub, i)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	b, err := getEnrollmentBinding(stub, i.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if b == nil {
+		return shim.Error(fmt.Sprintf("%s is not bound to an enrollment", i.Username))
+	}
+
+	bBytes, _ := marshal(b)
+
+	return shim.Success(bBytes)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// envelopeMaxSkew is how far in seconds the timestamp of an envelope
+// may be from the transaction timestamp
+const envelopeMaxSkew = 300
+
+// envelopeMaxNonce bounds the length of a nonce
+const envelopeMaxNonce = 64
+
+// nonceObjectType prefixes the state keys recording used nonces
+const nonceObjectType = "nonce"
+
+// signedEnvelope is the first argument of every mutating function,
+// the second argument is the signature over the whole envelope.
+// Alg names an algorithm of the registry, KeyID the fingerprint of the
+// registered key it was signed with, and the request itself is the Payload.
+// Delegate names the user signing on behalf of the one of the request, see Delegate.
+// CorrelationID is echoed in the response and the event, see tracing.go.
+type signedEnvelope struct {
+	Alg               string          `json:"alg"`
+	KeyID             string          `json:"keyId"`
+	Nonce             string          `json:"nonce"`
+	Timestamp         int64           `json:"timestamp"`
+	SignatureEncoding string          `json:"signatureEncoding"`
+	Delegate          string          `json:"delegate,omitempty"`
+	CorrelationID     string          `json:"correlationId,omitempty"`
+	Payload           json.RawMessage `json:"payload"`
+}
+
+// keyFingerprint is the hex SHA-256 of a base64 encoded key, used as key id
+func keyFingerprint(publicKey string) string {
+	pkBytes, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil {
+		return ""
+	}
+
+	h := sha256.Sum256(pkBytes)
+	return hex.EncodeToString(h[:])
+}
+
+// signingKey returns the registered key an envelope with keyID is verified against
+func (i Identity) signingKey(keyID string) (string, error) {
+	if keyID == "" || keyID == keyFingerprint(i.SPublicKey) {
+		return i.SPublicKey, nil
+	}
+
+	return "", errors.New(fmt.Sprintf("Unknown key %s", keyID))
+}
+
+// ParseRequest decodes the signed envelope in args and its payload into v
+func (t *DewalletChaincode) ParseRequest(args []string, v interface{}) (signedEnvelope, error) {
+	var env signedEnvelope
+
+	if len(args) < 2 {
+		return env, errors.New("Missing signature argument")
+	}
+
+	err := json.Unmarshal([]byte(args[0]), &env)
+	if err != nil {
+		return env, errors.New(fmt.Sprintf("Error in parsing envelope %s", err))
+	}
+
+	if len(env.Payload) == 0 {
+		return env, errors.New("Envelope has no payload")
+	}
+	if env.Nonce == "" || len(env.Nonce) > envelopeMaxNonce {
+		return env, errors.New(fmt.Sprintf("Envelope nonce must have 1 to %d characters", envelopeMaxNonce))
+	}
+	if env.Timestamp == 0 {
+		return env, errors.New("Envelope has no timestamp")
+	}
+	if env.CorrelationID != "" && !correlationIDPattern.MatchString(env.CorrelationID) {
+		return env, errors.New(fmt.Sprintf("Invalid correlation id %s", env.CorrelationID))
+	}
+
+	err = json.Unmarshal(env.Payload, v)
+	if err != nil {
+		return env, errors.New(fmt.Sprintf("Error in parsing payload %s", err))
+	}
+
+	return env, nil
+}
+
+// parseQuery decodes the JSON request of a query, its first argument, into v
+func parseQuery(args []string, v interface{}) error {
+	if len(args) == 0 {
+		return errors.New("Missing request argument")
+	}
+
+	err := json.Unmarshal([]byte(args[0]), v)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error in parsing request %s", err))
+	}
+
+	return nil
+}
+
+// VerifyRequest checks that the envelope is fresh and signed by the identity,
+// then records its nonce so the same request can't be replayed.
+// Hybrid identities also need the post-quantum signature of the envelope
+// as the third argument, they can't sign with JWS or COSE.
+// An envelope with a delegate is verified against the keys of the delegate instead. The request is added to the access log
+// of the identity, see GetAccessLog. Denylisted identities and those of frozen orgs are refused.
+// The identity whose keys signed it is rate limited, see checkRateLimit
+func (t *DewalletChaincode) VerifyRequest(stub shim.ChaincodeStubInterface, args []string, env signedEnvelope, i Identity) error {
+	err := checkDenylist(stub, i)
+	if err != nil {
+		return err
+	}
+
+	err = checkOrgFreeze(stub, i.Org)
+	if err != nil {
+		return err
+	}
+
+	subject := i.Username
+	if env.Delegate != "" {
+		d, err := delegateOf(stub, i, env.Delegate)
+		if err != nil {
+			return err
+		}
+
+		err = checkDenylist(stub, d)
+		if err != nil {
+			return err
+		}
+		i = d
+	}
+
+	publicKey, err := i.signingKey(env.KeyID)
+	if err != nil {
+		return err
+	}
+
+	// the post-quantum signature is a third argument next to the envelope,
+	// a JWS or a COSE_Sign1 carries a single signature
+	if i.QPublicKey != "" && (env.SignatureEncoding == signatureEncodingJWS || len(args) > 1 && isCOSESign1(args[1])) {
+		return errors.New("Hybrid identities can't sign with JWS or COSE, the post-quantum signature is a separate argument")
+	}
+
+	err = checkCreator(stub, i)
+	if err != nil {
+		return err
+	}
+
+	ts, err := stub.GetTxTimestamp()
+	if err != nil {
+		return errors.New("Failed to get transaction timestamp")
+	}
+	if env.Timestamp < ts.Seconds-envelopeMaxSkew || env.Timestamp > ts.Seconds+envelopeMaxSkew {
+		return errors.New("Envelope timestamp is outside the accepted window")
+	}
+
+	nonceKey, err := stub.CreateCompositeKey(nonceObjectType, []string{i.Username, env.Nonce})
+	if err != nil {
+		return err
+	}
+
+	nBytes, err := stub.GetState(nonceKey)
+	if err != nil {
+		return errors.New("Failed to get state")
+	}
+	if nBytes != nil {
+		return errors.New("Envelope nonce already used")
+	}
+
+	err = t.VerifySignature(stub, args, publicKey)
+	if err != nil {
+		return err
+	}
+
+	if i.QPublicKey != "" {
+		if len(args) < 3 {
+			return errors.New("Missing post-quantum signature argument")
+		}
+
+		s, err := decodeSignature(args[2], env.SignatureEncoding)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Error in decoding post-quantum signature %s", err))
+		}
+
+		err = verifyWithAlgorithm(stub, i.QAlgorithm, i.QPublicKey, []byte(args[0]), s)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = stub.PutState(nonceKey, []byte(stub.GetTxID()))
+	if err != nil {
+		return err
+	}
+
+	if cache, ok := invocationCache(stub); ok {
+		cache.authenticated = append(cache.authenticated, i.Username)
+	}
+
+	return audit(stub, subject, i.Username, requestPurpose(env))
+}
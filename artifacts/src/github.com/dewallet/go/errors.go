@@ -0,0 +1,26 @@
+package main
+
+// Codes of structured errors
+const (
+	errorCodeTooLarge      = "PAYLOAD_TOO_LARGE"
+	errorCodeThrottled     = "RATE_LIMITED"
+	errorCodeTooManyGrants = "TOO_MANY_GRANTS"
+)
+
+// structuredError is returned as the JSON message of an error response
+// so clients can react to a failure without parsing text
+type structuredError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Field   string `json:"field,omitempty"`
+	Limit   int    `json:"limit,omitempty"`
+	Size    int    `json:"size,omitempty"`
+
+	// RetryAfter is in how many seconds a throttled call is allowed again
+	RetryAfter int64 `json:"retryAfter,omitempty"`
+}
+
+func (e structuredError) Error() string {
+	eBytes, _ := marshal(e)
+	return string(eBytes)
+}
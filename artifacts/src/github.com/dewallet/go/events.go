@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// eventSchemaVersion is the version of the event payloads, it is raised
+// when a payload changes in a way consumers could break on, adding
+// a field doesn't raise it
+const eventSchemaVersion = 1
+
+// Names of the events, they don't change across versions
+const (
+	// grantsRevokedEvent lists the keys removed when the consent they
+	// were given under was withdrawn or their purpose revoked
+	grantsRevokedEvent = "GrantsRevoked"
+	// grantsExpiredEvent lists the keys ExpireGrants removed
+	grantsExpiredEvent = "GrantsExpired"
+	// denylistChangedEvent is emitted when an entry is added or removed
+	denylistChangedEvent = "DenylistChanged"
+	// dataRequest events are emitted as a data request is created and decided,
+	// for the apps of its subject and requester to notify them
+	dataRequestCreatedEvent  = "DataRequestCreated"
+	dataRequestApprovedEvent = "DataRequestApproved"
+	dataRequestDeniedEvent   = "DataRequestDenied"
+	dataRequestExpiredEvent  = "DataRequestExpired"
+	// identityRevokedEvent is emitted when an identity and its keys are denylisted
+	identityRevokedEvent = "IdentityRevoked"
+	// keyRotatedEvent is emitted when an identity rotates its keys
+	keyRotatedEvent = "KeyRotated"
+	// batchEvent carries the events of the operations of a batch when more
+	// than one emitted some, a transaction having only one event
+	batchEvent = "Batch"
+)
+
+// EventHeader starts every event payload, set by emitEvent,
+// CorrelationID is the one of the request when it has one
+type EventHeader struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	TxID          string `json:"txId"`
+	CorrelationID string `json:"correlationId,omitempty"`
+}
+
+func (h *EventHeader) header() *EventHeader {
+	return h
+}
+
+// event is a payload emitted under its name
+type event interface {
+	header() *EventHeader
+	eventName() string
+}
+
+// emitEvent sets the event of the transaction, a transaction has at most
+// one event so every emission goes through here
+func emitEvent(stub shim.ChaincodeStubInterface, e event) error {
+	h := e.header()
+	h.SchemaVersion = eventSchemaVersion
+	h.TxID = stub.GetTxID()
+	h.CorrelationID = stubCorrelationID(stub)
+
+	eBytes, err := marshal(e)
+	if err != nil {
+		return err
+	}
+
+	return stub.SetEvent(e.eventName(), eBytes)
+}
+
+// GrantsRevokedEvent is the payload of grantsRevokedEvent
+type GrantsRevokedEvent struct {
+	EventHeader
+
+	Grants []revokedGrant `json:"grants"`
+}
+
+func (GrantsRevokedEvent) eventName() string { return grantsRevokedEvent }
+
+// IdentityRevokedEvent is the payload of identityRevokedEvent,
+// Keys are the fingerprints of its public keys
+type IdentityRevokedEvent struct {
+	EventHeader
+
+	Username string   `json:"username"`
+	Keys     []string `json:"keys"`
+	Reason   string   `json:"reason"`
+}
+
+func (IdentityRevokedEvent) eventName() string { return identityRevokedEvent }
+
+// KeyRotatedEvent is the payload of keyRotatedEvent, the fingerprints
+// of the keys before and after by slot, see RotateKeys
+type KeyRotatedEvent struct {
+	EventHeader
+
+	Username string            `json:"username"`
+	Previous map[string]string `json:"previous"`
+	Current  map[string]string `json:"current"`
+}
+
+func (KeyRotatedEvent) eventName() string { return keyRotatedEvent }
+
+// keyFingerprints are the fingerprints of the keys of the identity by slot,
+// the public keys of rotationHash
+func keyFingerprints(i Identity) map[string]string {
+	fps := map[string]string{}
+	for slot, publicKey := range map[string]string{
+		keySlotIdentity:   i.PublicKey,
+		keySlotEncryption: i.EPublicKey,
+		keySlotSigning:    i.SPublicKey,
+		"qPublicKey":      i.QPublicKey,
+	} {
+		if publicKey != "" {
+			fps[slot] = keyFingerprint(publicKey)
+		}
+	}
+
+	return fps
+}
+
+// GrantsExpiredEvent is the payload of grantsExpiredEvent
+type GrantsExpiredEvent struct {
+	EventHeader
+
+	Grants []expiredGrant `json:"grants"`
+}
+
+func (GrantsExpiredEvent) eventName() string { return grantsExpiredEvent }
+
+// DenylistChangedEvent is the payload of denylistChangedEvent,
+// Action is add or remove and Admin the client who made it
+type DenylistChangedEvent struct {
+	EventHeader
+
+	Action string        `json:"action"`
+	Entry  DenylistEntry `json:"entry"`
+	Admin  string        `json:"admin"`
+}
+
+func (DenylistChangedEvent) eventName() string { return denylistChangedEvent }
+
+// DataRequestEvent is the payload of the data request events, without
+// the wrapped key an approval gives
+type DataRequestEvent struct {
+	EventHeader
+
+	ID        string `json:"id"`
+	Subject   string `json:"subject"`
+	Requester string `json:"requester"`
+	Attribute string `json:"attribute,omitempty"`
+	Slot      string `json:"slot"`
+	Purpose   string `json:"purpose"`
+	Status    string `json:"status"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+func (e DataRequestEvent) eventName() string {
+	switch e.Status {
+	case dataRequestApproved:
+		return dataRequestApprovedEvent
+	case dataRequestDenied:
+		return dataRequestDeniedEvent
+	case dataRequestExpired:
+		return dataRequestExpiredEvent
+	}
+
+	return dataRequestCreatedEvent
+}
+
+// batchedEvent is an event of an operation of a batch as it was emitted
+type batchedEvent struct {
+	Name    string          `json:"name"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// BatchEvent is the payload of batchEvent, the events in the order of the operations
+type BatchEvent struct {
+	EventHeader
+
+	Events []batchedEvent `json:"events"`
+}
+
+func (BatchEvent) eventName() string { return batchEvent }
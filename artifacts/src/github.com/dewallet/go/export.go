@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// metadata is the identity without any ciphertext: the data,
+// the wrapped keys and the attribute values are dropped, their hashes are kept
+func (i Identity) metadata() Identity {
+	i.Data = ""
+
+	keys := []Key{}
+	for _, k := range i.Keys {
+		k.Key = ""
+		keys = append(keys, k)
+	}
+	i.Keys = keys
+
+	attributes := map[string]Attribute{}
+	for name, a := range i.Attributes {
+		a.Value = ""
+		attributes[name] = a
+	}
+	i.Attributes = attributes
+
+	return i
+}
+
+type exportIdentitiesRequest struct {
+	pageRequest
+}
+
+type exportIdentitiesResponse struct {
+	Identities []Identity `json:"identities"`
+
+	pageResponse
+}
+
+// ExportIdentities will page through the metadata of the registered identities
+// for backups and reconciliation, no ciphertext is exported, admin only
+func (t *DewalletChaincode) ExportIdentities(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Exporting identities")
+
+	err := t.requireAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var req exportIdentitiesRequest
+	json.Unmarshal([]byte(args[0]), &req)
+
+	size, err := req.size()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	it, m, err := stub.GetStateByRangeWithPagination("", "", size, req.Bookmark)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't export identities %s", err))
+	}
+	defer it.Close()
+
+	identities, err := collectIdentities(stub, it, func(Identity) bool { return true })
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't export identities %s", err))
+	}
+
+	res := exportIdentitiesResponse{Identities: []Identity{}, pageResponse: newPageResponse(m)}
+	for _, i := range identities {
+		res.Identities = append(res.Identities, i.metadata())
+	}
+
+	resBytes, _ := marshal(res)
+
+	return shim.Success(resBytes)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// fieldMask is embedded in the request of the queries that can trim their
+// records, Fields are the JSON names of the fields of a record to return,
+// every field when empty
+type fieldMask struct {
+	Fields []string `json:"fields"`
+}
+
+// check fails on a field the records like record don't have,
+// before the query is run
+func (f fieldMask) check(record interface{}) error {
+	if len(f.Fields) == 0 {
+		return nil
+	}
+
+	names := map[string]bool{}
+	jsonFieldNames(reflect.TypeOf(record), names)
+
+	for _, name := range f.Fields {
+		if !names[name] {
+			return errors.New(fmt.Sprintf("Unknown field %s", name))
+		}
+	}
+
+	return nil
+}
+
+// apply encodes the record with only the fields of the mask, those
+// left empty by omitempty stay out
+func (f fieldMask) apply(record interface{}) (json.RawMessage, error) {
+	rBytes, err := marshal(record)
+	if err != nil || len(f.Fields) == 0 {
+		return rBytes, err
+	}
+
+	var fields map[string]json.RawMessage
+	err = json.Unmarshal(rBytes, &fields)
+	if err != nil {
+		return nil, err
+	}
+
+	masked := map[string]json.RawMessage{}
+	for _, name := range f.Fields {
+		if value, ok := fields[name]; ok {
+			masked[name] = value
+		}
+	}
+
+	return marshal(masked)
+}
+
+// jsonFieldNames adds the JSON names of the fields of a struct type,
+// with those of its embedded structs
+func jsonFieldNames(t reflect.Type, names map[string]bool) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return
+	}
+
+	for k := 0; k < t.NumField(); k++ {
+		f := t.Field(k)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+
+		if f.Anonymous && name == "" {
+			jsonFieldNames(f.Type, names)
+			continue
+		}
+		if f.PkgPath != "" || name == "-" {
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+		names[name] = true
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// TestPublicKeysFieldMask checks GetPublicKey and GetPublicKeys trim their
+// responses to the fields asked, keeping the errors of GetPublicKeys
+func TestPublicKeysFieldMask(t *testing.T) {
+	h := newHarness(t, time.Now().Unix())
+	alice := newFixture(t, "alice")
+	h.register(alice)
+
+	var res map[string]json.RawMessage
+	err := json.Unmarshal(h.mustInvoke("GetPublicKey", `{"username":"alice","fields":["publicKey"]}`), &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 || string(res["publicKey"]) != `"`+alice.publicKey+`"` {
+		t.Fatalf("Unexpected response %v", res)
+	}
+
+	var results map[string]map[string]string
+	err = json.Unmarshal(h.mustInvoke("GetPublicKeys", `{"usernames":["alice","bob"],"fields":["ePublicKey"]}`), &results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results["alice"]) != 1 || results["alice"]["ePublicKey"] != alice.publicKey {
+		t.Fatalf("Unexpected result %v", results["alice"])
+	}
+	if results["bob"]["error"] == "" {
+		t.Fatal("The error of an unknown username was trimmed")
+	}
+
+	if res := h.invoke("GetPublicKey", `{"username":"alice","fields":["data"]}`); res.Status == shim.OK {
+		t.Fatal("An unknown field was accepted")
+	}
+}
+
+// TestGetPublicKeysMalformed checks GetPublicKeys refuses a missing
+// or malformed request instead of querying no usernames
+func TestGetPublicKeysMalformed(t *testing.T) {
+	h := newHarness(t, time.Now().Unix())
+
+	for _, args := range [][]string{{}, {"{"}} {
+		res := h.invoke("GetPublicKeys", args...)
+		if res.Status == shim.OK {
+			t.Fatalf("Request %q wasn't refused", args)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// freezeObjectType prefixes the freezes, the global one has no org
+const freezeObjectType = "freeze"
+
+// readOnlyFunctions can still be called while the chaincode is frozen
+var readOnlyFunctions = []string{
+	"GetPublicKey", "GetPublicKeys", "GetIdentityByPublicKey", "Exists",
+	"GetUserData", "GetSharedUserData", "GetMyGrants", "GetAccessibleIdentities",
+	"QueryIdentities", "QueryByAttribute", "QueryByVerification", "SearchUsernames",
+	"ListIdentities", "ExportIdentities", "GetIdentityStats", "GetAttestations",
+	"GetSchema", "GetACL", "GetVerifiers", "GetConsents", "GetDataRequests",
+	"GetDelegations", "GetPurposes", "GetAccessLog", "GetDenylist", "GetFreezes",
+	"GetDisclosurePolicy", "GetVerifications", "GetCredentials", "GetCredentialStatus",
+	"GetStatusList", "GetClaims", "QueryByClaim",
+	"GetKYCProviders", "GetTrustAnchors", "GetDIDDocument",
+	"VerifyDisclosures", "GetVerifierChanges", "GetVerificationSummary",
+	"GetVerificationReceipts", "GetEnrollmentBinding", "GetChangesSince",
+	// the freeze itself is managed while frozen
+	"EmergencyFreeze", "LiftFreeze",
+}
+
+// Freeze makes the chaincode read-only, or the identities of Org when set,
+// until admins of a quorum of admin orgs approved lifting it
+type Freeze struct {
+	Org       string   `json:"org,omitempty"`
+	Reason    string   `json:"reason"`
+	FrozenBy  string   `json:"frozenBy"`
+	FrozenAt  int64    `json:"frozenAt"`
+	Approvals []string `json:"approvals"`
+}
+
+func freezeKey(stub shim.ChaincodeStubInterface, org string) (string, error) {
+	if org == "" {
+		return stub.CreateCompositeKey(freezeObjectType, []string{})
+	}
+
+	return stub.CreateCompositeKey(freezeObjectType, []string{org})
+}
+
+// getFreeze reads the freeze of org, the global one when org is empty,
+// nil when there is none
+func getFreeze(stub shim.ChaincodeStubInterface, org string) (*Freeze, error) {
+	key, err := freezeKey(stub, org)
+	if err != nil {
+		return nil, err
+	}
+
+	fBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get state")
+	}
+	if fBytes == nil {
+		return nil, nil
+	}
+
+	var f Freeze
+	err = json.Unmarshal(fBytes, &f)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error in parsing freeze %s", err))
+	}
+
+	return &f, nil
+}
+
+func putFreeze(stub shim.ChaincodeStubInterface, f Freeze) error {
+	key, err := freezeKey(stub, f.Org)
+	if err != nil {
+		return err
+	}
+
+	fBytes, _ := marshal(f)
+	return stub.PutState(key, fBytes)
+}
+
+// checkGlobalFreeze refuses the functions changing state while the chaincode is frozen
+func checkGlobalFreeze(stub shim.ChaincodeStubInterface, function string) error {
+	if containsString(readOnlyFunctions, function) {
+		return nil
+	}
+
+	f, err := getFreeze(stub, "")
+	if err != nil {
+		return err
+	}
+	if f != nil {
+		return errors.New(fmt.Sprintf("Chaincode is frozen %s", f.Reason))
+	}
+
+	return nil
+}
+
+// checkOrgFreeze refuses changes to the identities of a frozen org
+func checkOrgFreeze(stub shim.ChaincodeStubInterface, org string) error {
+	if org == "" {
+		return nil
+	}
+
+	f, err := getFreeze(stub, org)
+	if err != nil {
+		return err
+	}
+	if f != nil {
+		return errors.New(fmt.Sprintf("Identities of %s are frozen %s", org, f.Reason))
+	}
+
+	return nil
+}
+
+// freezeQuorum is how many admin orgs must approve lifting a freeze,
+// a majority of them unless configured
+func freezeQuorum(c Config) int {
+	if c.FreezeQuorum > 0 {
+		return c.FreezeQuorum
+	}
+
+	return len(c.AdminMSPs)/2 + 1
+}
+
+type freezeRequest struct {
+	Org    string `json:"org"`
+	Reason string `json:"reason"`
+}
+
+// EmergencyFreeze will make the chaincode read-only, or only the identities
+// of an org when given, admin only, see LiftFreeze
+func (t *DewalletChaincode) EmergencyFreeze(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Freezing")
+
+	err := t.requireAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var r freezeRequest
+	json.Unmarshal([]byte(args[0]), &r)
+
+	if r.Org != "" && !tenantNamePattern.MatchString(r.Org) {
+		return shim.Error(fmt.Sprintf("Invalid MSP ID %s", r.Org))
+	}
+
+	err = checkSize("reason", len(r.Reason), maxDenylistValueLength)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Failed to get MSP ID %s", err))
+	}
+
+	now, err := txSeconds(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	f := Freeze{Org: r.Org, Reason: r.Reason, FrozenBy: mspID, FrozenAt: now, Approvals: []string{}}
+
+	err = putFreeze(stub, f)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fBytes, _ := marshal(f)
+
+	return shim.Success(fBytes)
+}
+
+type liftFreezeRequest struct {
+	Org string `json:"org"`
+}
+
+// LiftFreeze will approve lifting a freeze for the org of the admin,
+// the freeze is lifted once a quorum of admin orgs approved it
+func (t *DewalletChaincode) LiftFreeze(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Lifting freeze")
+
+	err := t.requireAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var r liftFreezeRequest
+	json.Unmarshal([]byte(args[0]), &r)
+
+	f, err := getFreeze(stub, r.Org)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if f == nil {
+		return shim.Error("Freeze not found")
+	}
+
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Failed to get MSP ID %s", err))
+	}
+
+	if !containsString(f.Approvals, mspID) {
+		f.Approvals = append(f.Approvals, mspID)
+	}
+
+	c, err := getConfig(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fBytes, _ := marshal(f)
+
+	if len(f.Approvals) < freezeQuorum(c) {
+		err = putFreeze(stub, *f)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		return shim.Success(fBytes)
+	}
+
+	key, err := freezeKey(stub, r.Org)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = stub.DelState(key)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(fBytes)
+}
+
+// GetFreezes will query the blockchain
+// and return the global freeze and the freezes of orgs
+func (t *DewalletChaincode) GetFreezes(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Querying freezes")
+
+	it, err := stub.GetStateByPartialCompositeKey(freezeObjectType, []string{})
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't query freezes %s", err))
+	}
+	defer it.Close()
+
+	res := []Freeze{}
+	for it.HasNext() {
+		kv, err := it.Next()
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Can't query freezes %s", err))
+		}
+
+		var f Freeze
+		err = json.Unmarshal(kv.Value, &f)
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Error in parsing freeze %s", err))
+		}
+
+		res = append(res, f)
+	}
+
+	resBytes, _ := marshal(res)
+
+	return shim.Success(resBytes)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// defaultGovernanceVoteTTL is how many seconds a change collects votes
+// when not configured, a week
+const defaultGovernanceVoteTTL = 7 * 24 * 3600
+
+// Votes are the approvals of admin orgs collected for a change to the
+// trusted issuers, votes lapse at ExpiresAt and collecting starts over
+type Votes struct {
+	Approvals  []string `json:"approvals"`
+	ProposedAt int64    `json:"proposedAt"`
+	ExpiresAt  int64    `json:"expiresAt"`
+}
+
+// governanceQuorum is how many admin orgs must approve a change,
+// a majority of them unless configured
+func governanceQuorum(c Config) int {
+	if c.GovernanceQuorum > 0 {
+		return c.GovernanceQuorum
+	}
+
+	return len(c.AdminMSPs)/2 + 1
+}
+
+// pending tells whether the votes still count at now
+func (v Votes) pending(now int64) bool {
+	return v.ExpiresAt == 0 || now < v.ExpiresAt
+}
+
+// vote records the approval of the org of the admin, the change is to be
+// made when it returns true, callers check requireAdmin first
+func (v *Votes) vote(stub shim.ChaincodeStubInterface) (bool, error) {
+	c, err := getConfig(stub)
+	if err != nil {
+		return false, err
+	}
+
+	now, err := txSeconds(stub)
+	if err != nil {
+		return false, err
+	}
+
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return false, errors.New(fmt.Sprintf("Failed to get MSP ID %s", err))
+	}
+
+	if !v.pending(now) {
+		*v = Votes{}
+	}
+	if v.ProposedAt == 0 {
+		ttl := c.GovernanceVoteTTL
+		if ttl == 0 {
+			ttl = defaultGovernanceVoteTTL
+		}
+
+		v.ProposedAt = now
+		v.ExpiresAt = now + ttl
+	}
+
+	if !containsString(v.Approvals, mspID) {
+		v.Approvals = append(v.Approvals, mspID)
+	}
+
+	return len(v.Approvals) >= governanceQuorum(c), nil
+}
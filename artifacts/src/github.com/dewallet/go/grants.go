@@ -0,0 +1,479 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// grantObjectType indexes the usernames that gave a key by the owner of the key
+const grantObjectType = "grant"
+
+// active tells whether the key is within its validity window at now
+func (k Key) active(now int64) bool {
+	return (k.NotBefore == 0 || now >= k.NotBefore) && (k.NotAfter == 0 || now < k.NotAfter)
+}
+
+// owners are the owners of the keys of the identity
+func (i Identity) owners() map[string]bool {
+	owners := map[string]bool{}
+	for _, k := range i.Keys {
+		owners[k.Owner] = true
+	}
+
+	return owners
+}
+
+type getAccessibleIdentitiesRequest struct {
+	Owner string `json:"owner"`
+	Token string `json:"token"`
+
+	pageRequest
+}
+
+type getAccessibleIdentitiesResponse struct {
+	Usernames []string `json:"usernames"`
+
+	pageResponse
+}
+
+// GetAccessibleIdentities will page through the usernames
+// that gave owner a key, owner proves itself with a session token
+func (t *DewalletChaincode) GetAccessibleIdentities(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Querying identities accessible to a user")
+
+	var req getAccessibleIdentitiesRequest
+	json.Unmarshal([]byte(args[0]), &req)
+
+	err := resolveHandles(stub, &req.Owner)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = t.VerifySession(stub, req.Token, req.Owner)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't verify session %s", err))
+	}
+
+	size, err := req.size()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	it, m, err := stub.GetStateByPartialCompositeKeyWithPagination(grantObjectType, []string{req.Owner}, size, req.Bookmark)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't query grants %s", err))
+	}
+	defer it.Close()
+
+	res := getAccessibleIdentitiesResponse{Usernames: []string{}, pageResponse: newPageResponse(m)}
+	for it.HasNext() {
+		kv, err := it.Next()
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Can't query grants %s", err))
+		}
+
+		_, attrs, err := stub.SplitCompositeKey(kv.Key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		res.Usernames = append(res.Usernames, attrs[1])
+	}
+
+	resBytes, _ := marshal(res)
+
+	return shim.Success(resBytes)
+}
+
+type getMyGrantsRequest struct {
+	Username string `json:"username"`
+	Token    string `json:"token"`
+
+	pageRequest
+}
+
+type getMyGrantsResponse struct {
+	Grants []grant `json:"grants"`
+
+	pageResponse
+}
+
+// grant is a key an identity gave, Stale is set when what it was given for
+// was written again or removed since, so the key no longer decrypts it,
+// or when the key is past its NotAfter
+type grant struct {
+	Owner     string `json:"owner"`
+	Attribute string `json:"attribute,omitempty"`
+	Slot      string `json:"slot"`
+	ExpiresAt int64  `json:"expiresAt"`
+	NotBefore int64  `json:"notBefore,omitempty"`
+	NotAfter  int64  `json:"notAfter,omitempty"`
+	Purpose   string `json:"purpose,omitempty"`
+	Stale     bool   `json:"stale"`
+}
+
+// GetMyGrants will page through the keys a user gave to other users,
+// the user proves itself with a session token. The keys are read from
+// their records so an identity may give any number of them, the first
+// page starts with those still inline in identities written before
+func (t *DewalletChaincode) GetMyGrants(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Querying grants of a user")
+
+	var req getMyGrantsRequest
+	json.Unmarshal([]byte(args[0]), &req)
+
+	size, err := req.size()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = resolveHandles(stub, &req.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = t.VerifySession(stub, req.Token, req.Username)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't verify session %s", err))
+	}
+
+	i, err := getStoredIdentity(stub, req.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	now, err := txSeconds(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	keys := []Key{}
+	if req.Bookmark == "" {
+		keys = append(keys, i.Keys...)
+	}
+
+	it, m, err := stub.GetStateByPartialCompositeKeyWithPagination(keyRecordObjectType, []string{i.Username}, size, req.Bookmark)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't query keys of %s %s", i.Username, err))
+	}
+	defer it.Close()
+
+	for it.HasNext() {
+		kv, err := it.Next()
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Can't query keys of %s %s", i.Username, err))
+		}
+
+		k, err := decodeKey(kv.Value)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		keys = append(keys, k)
+	}
+
+	res := getMyGrantsResponse{Grants: []grant{}, pageResponse: newPageResponse(m)}
+	for _, k := range keys {
+		g := grant{Owner: k.Owner, Attribute: k.Attribute, Slot: k.Slot, NotBefore: k.NotBefore, NotAfter: k.NotAfter, Purpose: k.Purpose}
+
+		if k.Attribute != "" {
+			a, ok := i.Attributes[k.Attribute]
+			g.Stale = !ok || (k.DataHash != "" && a.Hash != k.DataHash)
+		} else {
+			s, ok := i.slot(k.Slot)
+			g.ExpiresAt = s.ExpiresAt
+			g.Stale = !ok || s.expired(now) || (k.DataHash != "" && s.Hash != k.DataHash)
+		}
+
+		if k.NotAfter != 0 && now >= k.NotAfter {
+			g.Stale = true
+		}
+
+		res.Grants = append(res.Grants, g)
+	}
+
+	resBytes, _ := marshal(res)
+
+	return shim.Success(resBytes)
+}
+
+// expiredGrant is a key removed past its NotAfter, or evicted at the grant cap
+type expiredGrant struct {
+	Username  string `json:"username"`
+	Owner     string `json:"owner"`
+	Attribute string `json:"attribute,omitempty"`
+	Slot      string `json:"slot"`
+	NotAfter  int64  `json:"notAfter"`
+
+	// record is the key record of an evicted key, empty when it was inline
+	record string
+}
+
+// removeKeys removes the keys of the identity matching remove,
+// and their wrapped key in private data mode, and returns them.
+// The grants of an owner with the same scope share their wrapped key,
+// it is only removed with the last of them
+func removeKeys(stub shim.ChaincodeStubInterface, i *Identity, remove func(Key) bool) ([]Key, error) {
+	removed := []Key{}
+
+	keys := []Key{}
+	for _, k := range i.Keys {
+		if !remove(k) {
+			keys = append(keys, k)
+			continue
+		}
+
+		removed = append(removed, k)
+	}
+	i.Keys = keys
+
+	if i.Collection == "" {
+		return removed, nil
+	}
+
+	kept := map[string]bool{}
+	for _, k := range keys {
+		pKey, err := privateKeyKey(stub, i.Username, k.Owner, k.Attribute, k.Slot)
+		if err != nil {
+			return nil, err
+		}
+		kept[pKey] = true
+	}
+
+	for _, k := range removed {
+		pKey, err := privateKeyKey(stub, i.Username, k.Owner, k.Attribute, k.Slot)
+		if err != nil {
+			return nil, err
+		}
+		if kept[pKey] {
+			continue
+		}
+
+		err = stub.DelPrivateData(i.Collection, pKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return removed, nil
+}
+
+// expireKeys removes the keys of the identity past their NotAfter
+func expireKeys(stub shim.ChaincodeStubInterface, i *Identity, now int64) ([]expiredGrant, error) {
+	removed, err := removeKeys(stub, i, func(k Key) bool {
+		return k.NotAfter != 0 && now >= k.NotAfter
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	expired := []expiredGrant{}
+	for _, k := range removed {
+		expired = append(expired, expiredGrant{Username: i.Username, Owner: k.Owner, Attribute: k.Attribute, Slot: k.Slot, NotAfter: k.NotAfter})
+	}
+
+	return expired, nil
+}
+
+// capGrants makes room for a new key of the identity within MaxGrants, the
+// keys of the identity must be loaded. At the cap it fails, or evicts the
+// keys first in the order of the eviction policy of the limits
+func capGrants(stub shim.ChaincodeStubInterface, i *Identity, l Limits, now int64) ([]expiredGrant, error) {
+	evicted := []expiredGrant{}
+	if l.MaxGrants == 0 {
+		return evicted, nil
+	}
+
+	// the keys not expired, by their position in the keys of the identity
+	active := []int{}
+	for n, k := range i.Keys {
+		if k.NotAfter == 0 || now < k.NotAfter {
+			active = append(active, n)
+		}
+	}
+
+	over := len(active) + 1 - l.MaxGrants
+	if over <= 0 {
+		return evicted, nil
+	}
+
+	if l.GrantEviction == "" {
+		return nil, structuredError{
+			Code:    errorCodeTooManyGrants,
+			Message: fmt.Sprintf("%s gives %d keys, the limit is %d", i.Username, len(active), l.MaxGrants),
+			Field:   "grants",
+			Limit:   l.MaxGrants,
+			Size:    len(active),
+		}
+	}
+
+	// keys still inline are older than any record, records are ordered
+	// by their id, see keyRecordID
+	ids := map[int]string{}
+	for _, n := range active {
+		if i.Keys[n].record == "" {
+			continue
+		}
+
+		_, attributes, err := stub.SplitCompositeKey(i.Keys[n].record)
+		if err != nil {
+			return nil, err
+		}
+		ids[n] = attributes[2]
+	}
+
+	sort.SliceStable(active, func(a, b int) bool {
+		ka, kb := i.Keys[active[a]], i.Keys[active[b]]
+		if l.GrantEviction == grantEvictionExpiry && ka.NotAfter != kb.NotAfter {
+			if ka.NotAfter == 0 || kb.NotAfter == 0 {
+				return kb.NotAfter == 0
+			}
+			return ka.NotAfter < kb.NotAfter
+		}
+		return ids[active[a]] < ids[active[b]]
+	})
+
+	evict := map[int]bool{}
+	for _, n := range active[:over] {
+		evict[n] = true
+	}
+
+	n := -1
+	removed, err := removeKeys(stub, i, func(Key) bool {
+		n++
+		return evict[n]
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, k := range removed {
+		evicted = append(evicted, expiredGrant{Username: i.Username, Owner: k.Owner, Attribute: k.Attribute, Slot: k.Slot, NotAfter: k.NotAfter, record: k.record})
+	}
+
+	return evicted, nil
+}
+
+// evictedInline tells whether a key evicted was still inline in the identity
+func evictedInline(evicted []expiredGrant) bool {
+	for _, e := range evicted {
+		if e.record == "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+type expireGrantsRequest struct {
+	Usernames []string `json:"usernames"`
+
+	// the registered identities are paged through without usernames
+	pageRequest
+}
+
+type expireGrantsResponse struct {
+	Grants []expiredGrant `json:"grants"`
+
+	pageResponse
+}
+
+// ExpireGrants will remove the keys past their NotAfter, of the given users
+// or of a page of the registered identities, and emit a GrantsExpired event
+// listing them, admin only, GetUserData already stops returning them when
+// they expire
+func (t *DewalletChaincode) ExpireGrants(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Expiring grants")
+
+	err := t.requireAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var req expireGrantsRequest
+	err = parseQuery(args, &req)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if len(req.Usernames) > maxBatchSize {
+		return shim.Error(fmt.Sprintf("At most %d usernames can be expired at once", maxBatchSize))
+	}
+
+	now, err := txSeconds(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	res := expireGrantsResponse{Grants: []expiredGrant{}}
+
+	var identities []Identity
+	if len(req.Usernames) > 0 {
+		for _, username := range req.Usernames {
+			i, err := getIdentity(stub, username)
+			if err != nil {
+				return shim.Error(fmt.Sprintf("Can't get %s %s", username, err))
+			}
+			identities = append(identities, i)
+		}
+	} else {
+		size, err := req.size()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		it, m, err := stub.GetStateByRangeWithPagination("", "", size, req.Bookmark)
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Can't query identities %s", err))
+		}
+		defer it.Close()
+
+		identities, err = collectIdentities(stub, it, func(Identity) bool { return true })
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Can't query identities %s", err))
+		}
+		res.pageResponse = newPageResponse(m)
+	}
+
+	for _, i := range identities {
+		prev := i
+		expired, err := expireKeys(stub, &i, now)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if len(expired) == 0 {
+			continue
+		}
+
+		err = updateIndexes(stub, prev, i)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		err = auditCreator(stub, i.Username)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		_, err = putIdentity(stub, i)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		res.Grants = append(res.Grants, expired...)
+	}
+
+	resBytes, _ := marshal(res)
+
+	if len(res.Grants) > 0 {
+		err = emitEvent(stub, &GrantsExpiredEvent{Grants: res.Grants})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	return shim.Success(resBytes)
+}
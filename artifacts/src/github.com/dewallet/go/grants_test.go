@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// TestAddKeyEvictsRecords checks a key evicted at the grant cap only
+// has its record deleted, the identity isn't written again
+func TestAddKeyEvictsRecords(t *testing.T) {
+	h := newHarness(t, time.Now().Unix())
+	h.init(Config{Limits: Limits{MaxGrants: 1, GrantEviction: grantEvictionFirstGiven}})
+
+	alice := newFixture(t, "alice")
+	h.register(alice)
+	for _, owner := range []string{"bob", "carol"} {
+		h.register(newFixture(t, owner))
+	}
+
+	h.mustInvoke("AddKey", alice.request(t, h.now, addKeyRequest{Username: "alice", Owner: "bob", Key: "k1"})...)
+	iBytes := h.stub.State["alice"]
+
+	var res addKeyResponse
+	err := json.Unmarshal(h.mustInvoke("AddKey", alice.request(t, h.now, addKeyRequest{Username: "alice", Owner: "carol", Key: "k2"})...), &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Evicted) != 1 || res.Evicted[0].Owner != "bob" {
+		t.Fatalf("Unexpected evicted keys %+v", res.Evicted)
+	}
+
+	if !bytes.Equal(h.stub.State["alice"], iBytes) {
+		t.Fatal("Eviction wrote the identity")
+	}
+
+	i, err := getStoredIdentity(h.stub, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = loadKeyRecords(h.stub, &i)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(i.Keys) != 1 || i.Keys[0].Owner != "carol" {
+		t.Fatalf("Unexpected keys %+v", i.Keys)
+	}
+}
+
+// TestRemoveKeysSharedPrivateKey checks the wrapped key the grants of an
+// owner with the same scope share is only removed with the last of them
+func TestRemoveKeysSharedPrivateKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		remove func(Key) bool
+		// kept are the owners whose wrapped key is left
+		kept []string
+	}{
+		{
+			name:   "one of the grants of an owner",
+			remove: func(k Key) bool { return k.Owner == "bob" && k.NotAfter != 0 },
+			kept:   []string{"bob", "carol"},
+		},
+		{
+			name:   "every grant of an owner",
+			remove: func(k Key) bool { return k.Owner == "bob" },
+			kept:   []string{"carol"},
+		},
+		{
+			name:   "none",
+			remove: func(k Key) bool { return false },
+			kept:   []string{"bob", "carol"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHarness(t, time.Now().Unix())
+			i := Identity{Username: "alice", Collection: "alice-collection", Keys: []Key{
+				{Owner: "bob", NotAfter: h.now},
+				{Owner: "bob"},
+				{Owner: "carol"},
+			}}
+
+			h.stub.MockTransactionStart("tx")
+			for _, owner := range []string{"bob", "carol"} {
+				pKey, err := privateKeyKey(h.stub, i.Username, owner, "", "")
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = h.stub.PutPrivateData(i.Collection, pKey, []byte("wrapped"))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			_, err := removeKeys(h.stub, &i, tt.remove)
+			if err != nil {
+				t.Fatal(err)
+			}
+			h.stub.MockTransactionEnd("tx")
+
+			kept := []string{}
+			for _, owner := range []string{"bob", "carol"} {
+				pKey, _ := privateKeyKey(h.stub, i.Username, owner, "", "")
+				if h.stub.PvtState[i.Collection][pKey] != nil {
+					kept = append(kept, owner)
+				}
+			}
+			if strings.Join(kept, ",") != strings.Join(tt.kept, ",") {
+				t.Fatalf("Unexpected wrapped keys %v", kept)
+			}
+		})
+	}
+}
+
+// TestExpireGrantsPages checks ExpireGrants without usernames pages
+// through the identities, expiring the grants of each page
+func TestExpireGrantsPages(t *testing.T) {
+	h := newHarness(t, time.Now().Unix())
+	h.init(Config{AdminMSPs: []string{harnessOrg}})
+
+	alice := newFixture(t, "alice")
+	bob := newFixture(t, "bob")
+	h.register(alice)
+	h.register(bob)
+	h.register(newFixture(t, "carol"))
+
+	h.mustInvoke("AddKey", alice.request(t, h.now, addKeyRequest{Username: "alice", Owner: "bob", Key: "k1", NotAfter: h.now + 10})...)
+	h.mustInvoke("AddKey", alice.request(t, h.now, addKeyRequest{Username: "alice", Owner: "carol", Key: "k2", NotAfter: h.now + 10})...)
+	h.mustInvoke("AddKey", bob.request(t, h.now, addKeyRequest{Username: "bob", Owner: "alice", Key: "k3", NotAfter: h.now + 10})...)
+	h.now += 10
+
+	res := h.invoke("ExpireGrants", `{"pageSize":`+strconv.Itoa(maxPageSize+1)+`}`)
+	if res.Status == shim.OK {
+		t.Fatal("Page size over the maximum wasn't refused")
+	}
+
+	expired := []string{}
+	req := expireGrantsRequest{pageRequest: pageRequest{PageSize: 1}}
+	for pages := 0; ; pages++ {
+		if pages > len(h.stub.State) {
+			t.Fatal("Paging didn't end")
+		}
+
+		reqBytes, _ := json.Marshal(req)
+
+		var page expireGrantsResponse
+		err := json.Unmarshal(h.mustInvoke("ExpireGrants", string(reqBytes)), &page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, g := range page.Grants {
+			expired = append(expired, g.Username+">"+g.Owner)
+		}
+
+		if page.Bookmark == "" {
+			break
+		}
+		req.Bookmark = page.Bookmark
+	}
+
+	sort.Strings(expired)
+	if strings.Join(expired, ",") != "alice>bob,alice>carol,bob>alice" {
+		t.Fatalf("Unexpected expired grants %v", expired)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// guardianApprovalObjectType prefixes the pending guardian approvals
+// by username and action
+const guardianApprovalObjectType = "guardianapproval"
+
+// maxGuardians bounds the guardians of an identity
+const maxGuardians = 8
+
+// guardianApprovalTTL is how long in seconds an approval can be used
+const guardianApprovalTTL = 7 * 24 * 60 * 60
+
+// Actions of an identity with guardians that one of them must approve
+const (
+	// guardianActionRotate is rotating the keys, see RotateKeys
+	guardianActionRotate = "rotate"
+	// guardianActionGuardians is changing the guardians
+	guardianActionGuardians = "guardians"
+)
+
+// GuardianApproval lets the user take Action once, for the keys
+// or the guardians whose hash is Hash, see rotationHash and guardiansHash
+type GuardianApproval struct {
+	Username   string `json:"username"`
+	Action     string `json:"action"`
+	Hash       string `json:"hash"`
+	Guardian   string `json:"guardian"`
+	ApprovedAt int64  `json:"approvedAt"`
+}
+
+// rotationHash is the hash of every field RotateKeys replaces, the keys,
+// the post-quantum algorithm and the certificate chain, as a JSON array
+func rotationHash(i Identity) string {
+	fields := append([]string{i.PublicKey, i.EPublicKey, i.SPublicKey, i.QPublicKey, i.QAlgorithm}, i.CertificateChain...)
+	fBytes, _ := marshal(fields)
+
+	return sha256Hex(fBytes)
+}
+
+// guardiansHash is the hash of a set of guardians, in any order
+func guardiansHash(guardians []string) string {
+	g := append([]string{}, guardians...)
+	sort.Strings(g)
+
+	return sha256Hex([]byte(strings.Join(g, "\n")))
+}
+
+func guardianApprovalKey(stub shim.ChaincodeStubInterface, username string, action string) (string, error) {
+	return stub.CreateCompositeKey(guardianApprovalObjectType, []string{username, action})
+}
+
+// useGuardianApproval checks, when the identity has guardians, that one of them
+// approved the action for hash and removes the approval so it is used once
+func useGuardianApproval(stub shim.ChaincodeStubInterface, i Identity, action string, hash string) error {
+	if len(i.Guardians) == 0 {
+		return nil
+	}
+
+	key, err := guardianApprovalKey(stub, i.Username, action)
+	if err != nil {
+		return err
+	}
+
+	aBytes, err := stub.GetState(key)
+	if err != nil {
+		return errors.New("Failed to get state")
+	}
+	if aBytes == nil {
+		return errors.New(fmt.Sprintf("A guardian of %s must approve it", i.Username))
+	}
+
+	var a GuardianApproval
+	err = json.Unmarshal(aBytes, &a)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error in parsing guardian approval %s", err))
+	}
+
+	now, err := txSeconds(stub)
+	if err != nil {
+		return err
+	}
+
+	if a.Hash != hash || now >= a.ApprovedAt+guardianApprovalTTL || !containsString(i.Guardians, a.Guardian) {
+		return errors.New(fmt.Sprintf("A guardian of %s must approve it", i.Username))
+	}
+
+	return stub.DelState(key)
+}
+
+// checkKeyRotation checks that the rotation of the keys of prev to those of i
+// is signed by the registered signing key of prev, not a delegate, that the
+// new keys aren't denylisted, and when the keys change that one of its
+// guardians approved it too
+func (t *DewalletChaincode) checkKeyRotation(stub shim.ChaincodeStubInterface, args []string, env signedEnvelope, prev Identity, i Identity) error {
+	if env.Delegate != "" {
+		return errors.New("Keys can't be rotated by a delegate")
+	}
+
+	err := t.VerifyRequest(stub, args, env, prev)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Can't verify signature %s", err))
+	}
+
+	// the new keys mustn't be denylisted either
+	err = checkDenylist(stub, i)
+	if err != nil {
+		return err
+	}
+
+	if rotationHash(prev) == rotationHash(i) {
+		return nil
+	}
+
+	return useGuardianApproval(stub, prev, guardianActionRotate, rotationHash(i))
+}
+
+type setGuardiansRequest struct {
+	Username  string   `json:"username"`
+	Guardians []string `json:"guardians"`
+}
+
+// SetGuardians will replace the guardians of the user, once it has some
+// one of them must approve the key rotations and the guardian changes
+func (t *DewalletChaincode) SetGuardians(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Setting guardians of user")
+
+	var r setGuardiansRequest
+	env, err := t.ParseRequest(args, &r)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't parse request %s", err))
+	}
+
+	i, err := getIdentity(stub, r.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = t.VerifyRequest(stub, args, env, i)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't verify signature %s", err))
+	}
+
+	err = resolveHandleList(stub, r.Guardians)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if len(r.Guardians) > maxGuardians {
+		return shim.Error(fmt.Sprintf("At most %d guardians", maxGuardians))
+	}
+	for k, g := range r.Guardians {
+		if g == i.Username {
+			return shim.Error("A user can't be its own guardian")
+		}
+		if containsString(r.Guardians[:k], g) {
+			return shim.Error(fmt.Sprintf("Guardian %s listed twice", g))
+		}
+
+		_, err = getIdentity(stub, g)
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Can't get guardian %s %s", g, err))
+		}
+	}
+
+	err = useGuardianApproval(stub, i, guardianActionGuardians, guardiansHash(r.Guardians))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	i.Guardians = r.Guardians
+
+	iBytes, err := putIdentity(stub, i)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(iBytes)
+}
+
+type approveGuardianActionRequest struct {
+	Guardian string `json:"guardian"`
+	Username string `json:"username"`
+	Action   string `json:"action"`
+
+	// Hash is the rotationHash of the new keys
+	// or the guardiansHash of the new guardians
+	Hash string `json:"hash"`
+}
+
+// ApproveGuardianAction will let a user rotate its keys, or change its
+// guardians, to those of the hash, the request is signed by one of its guardians
+func (t *DewalletChaincode) ApproveGuardianAction(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Approving action of user as guardian")
+
+	var r approveGuardianActionRequest
+	env, err := t.ParseRequest(args, &r)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't parse request %s", err))
+	}
+
+	gi, err := getIdentity(stub, r.Guardian)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't get guardian %s %s", r.Guardian, err))
+	}
+
+	err = t.VerifyRequest(stub, args, env, gi)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't verify signature %s", err))
+	}
+
+	i, err := getIdentity(stub, r.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if !containsString(i.Guardians, gi.Username) {
+		return shim.Error(fmt.Sprintf("%s is not a guardian of %s", gi.Username, i.Username))
+	}
+	if r.Action != guardianActionRotate && r.Action != guardianActionGuardians {
+		return shim.Error(fmt.Sprintf("Unsupported guardian action %s", r.Action))
+	}
+	if r.Hash == "" {
+		return shim.Error("Missing hash")
+	}
+
+	now, err := txSeconds(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	a := GuardianApproval{Username: i.Username, Action: r.Action, Hash: r.Hash, Guardian: gi.Username, ApprovedAt: now}
+
+	key, err := guardianApprovalKey(stub, i.Username, r.Action)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	aBytes, _ := marshal(a)
+	err = stub.PutState(key, aBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(aBytes)
+}
@@ -0,0 +1,468 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cloudflare/circl/sign"
+	"github.com/cloudflare/circl/sign/mldsa/mldsa65"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/msp"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// harnessOrg is the MSP of the client of the harness
+const harnessOrg = "Org1MSP"
+
+// harnessStub is a MockStub with what the MockStub of Fabric 1.4 leaves
+// out, the creator of the transactions, the function and arguments
+// given as strings and the deletion of private data
+type harnessStub struct {
+	*shim.MockStub
+
+	creator  []byte
+	function string
+	args     []string
+}
+
+func (s *harnessStub) GetCreator() ([]byte, error) {
+	return s.creator, nil
+}
+
+func (s *harnessStub) DelPrivateData(collection string, key string) error {
+	delete(s.PvtState[collection], key)
+	return nil
+}
+
+func (s *harnessStub) GetFunctionAndParameters() (string, []string) {
+	return s.function, s.args
+}
+
+// GetStateByRangeWithPagination pages through the range as the peer does,
+// the bookmark is the key of the next page
+func (s *harnessStub) GetStateByRangeWithPagination(startKey, endKey string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	if startKey == "" {
+		startKey = emptyStartKey
+	}
+	if endKey == "" {
+		endKey = string(utf8.MaxRune)
+	}
+	if bookmark != "" {
+		startKey = bookmark
+	}
+
+	it := shim.NewMockStateRangeQueryIterator(s.MockStub, startKey, endKey)
+	defer it.Close()
+
+	page := &cacheIterator{}
+	m := &pb.QueryResponseMetadata{}
+	for it.HasNext() {
+		kv, err := it.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		if int32(len(page.kvs)) == pageSize {
+			m.Bookmark = kv.Key
+			break
+		}
+		page.kvs = append(page.kvs, kv)
+	}
+	m.FetchedRecordsCount = int32(len(page.kvs))
+
+	return page, m, nil
+}
+
+func (s *harnessStub) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	prefix, err := s.CreateCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return s.GetStateByRangeWithPagination(prefix, prefix+string(utf8.MaxRune), pageSize, bookmark)
+}
+
+// GetQueryResultWithPagination fails like GetQueryResult, the MockStub
+// has no rich queries, as on LevelDB
+func (s *harnessStub) GetQueryResultWithPagination(query string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	_, err := s.GetQueryResult(query)
+	return nil, nil, err
+}
+
+// harness runs the chaincode on a MockStub, every transaction
+// at the unix time now and created by the same client of harnessOrg
+type harness struct {
+	t    *testing.T
+	cc   *DewalletChaincode
+	stub *harnessStub
+	now  int64
+	txs  int
+}
+
+func newHarness(t *testing.T, now int64) *harness {
+	cc := new(DewalletChaincode)
+
+	return &harness{
+		t:    t,
+		cc:   cc,
+		stub: &harnessStub{MockStub: shim.NewMockStub("dewallet", cc), creator: harnessCreator(t)},
+		now:  now,
+	}
+}
+
+// harnessCreator is the serialized identity of a client with a self-signed certificate
+func harnessCreator(t *testing.T) []byte {
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "harness", Organization: []string{harnessOrg}},
+		NotBefore:    time.Unix(0, 0),
+		NotAfter:     time.Unix(1<<33, 0),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, k.Public(), k)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	creator, err := proto.Marshal(&msp.SerializedIdentity{
+		Mspid:   harnessOrg,
+		IdBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return creator
+}
+
+// init instantiates the chaincode with the configuration
+func (h *harness) init(c Config) {
+	h.t.Helper()
+
+	cBytes, err := json.Marshal(c)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+
+	res := h.stub.MockInit("init", [][]byte{[]byte("init"), cBytes})
+	if res.Status != shim.OK {
+		h.t.Fatalf("Init failed %s", res.Message)
+	}
+}
+
+// invoke runs function through Invoke in a transaction of its own
+func (h *harness) invoke(function string, args ...string) pb.Response {
+	h.txs++
+	txID := fmt.Sprintf("tx%d", h.txs)
+
+	h.stub.function, h.stub.args = function, args
+	h.stub.MockTransactionStart(txID)
+	h.stub.TxTimestamp = &timestamp.Timestamp{Seconds: h.now}
+	defer h.stub.MockTransactionEnd(txID)
+
+	return h.cc.Invoke(h.stub)
+}
+
+// mustInvoke runs function and fails the test unless it succeeds
+func (h *harness) mustInvoke(function string, args ...string) []byte {
+	h.t.Helper()
+
+	res := h.invoke(function, args...)
+	if res.Status != shim.OK {
+		h.t.Fatalf("%s failed %s", function, res.Message)
+	}
+
+	return res.Payload
+}
+
+// fixture is an identity of the tests, its keys are all one P-256 key,
+// a hybrid fixture also has a post-quantum key, see newHybridFixture
+type fixture struct {
+	username  string
+	key       *ecdsa.PrivateKey
+	publicKey string
+	nonces    int
+
+	qScheme    sign.Scheme
+	qKey       sign.PrivateKey
+	qPublicKey string
+}
+
+func newFixture(t *testing.T, username string) *fixture {
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkBytes, err := x509.MarshalPKIXPublicKey(k.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &fixture{username: username, key: k, publicKey: base64.StdEncoding.EncodeToString(pkBytes)}
+}
+
+// newHybridFixture is a fixture with an ML-DSA-65 post-quantum key
+func newHybridFixture(t *testing.T, username string) *fixture {
+	f := newFixture(t, username)
+	f.qScheme = mldsa65.Scheme()
+
+	pk, sk, err := f.qScheme.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkBytes, err := pk.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.qKey, f.qPublicKey = sk, base64.StdEncoding.EncodeToString(pkBytes)
+
+	return f
+}
+
+// identity is the identity the fixture registers
+func (f *fixture) identity() Identity {
+	i := Identity{Username: f.username, PublicKey: f.publicKey, EPublicKey: f.publicKey, SPublicKey: f.publicKey}
+	if f.qKey != nil {
+		i.QPublicKey, i.QAlgorithm = f.qPublicKey, f.qScheme.Name()
+	}
+
+	return i
+}
+
+// qSign is the hex post-quantum signature of m, hybrid fixtures only
+func (f *fixture) qSign(m []byte) string {
+	return hex.EncodeToString(f.qScheme.Sign(f.qKey, m, nil))
+}
+
+// request is the arguments of a request of the fixture at now, its
+// envelope signed with ES256 and a nonce of its own
+func (f *fixture) request(t *testing.T, now int64, payload interface{}) []string {
+	pBytes, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return f.signEnvelope(t, signedEnvelope{Timestamp: now, Payload: pBytes})
+}
+
+// signEnvelope is the arguments of the envelope signed by the fixture,
+// with its algorithm, key id, encoding and a nonce of its own
+func (f *fixture) signEnvelope(t *testing.T, env signedEnvelope) []string {
+	f.nonces++
+	env.Alg = "ES256"
+	env.KeyID = keyFingerprint(f.publicKey)
+	env.Nonce = fmt.Sprintf("%s-%d", f.username, f.nonces)
+	env.SignatureEncoding = signatureEncodingHex
+
+	envBytes, err := json.Marshal(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args := []string{string(envBytes), f.sign(t, envBytes)}
+	if f.qKey != nil {
+		args = append(args, f.qSign(envBytes))
+	}
+
+	return args
+}
+
+// sign is the hex ES256 signature of m with the key of the fixture
+func (f *fixture) sign(t *testing.T, m []byte) string {
+	h := sha256.Sum256(m)
+	r, s, err := ecdsa.Sign(rand.Reader, f.key, h[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+
+	return hex.EncodeToString(sig)
+}
+
+// session is a session JWT of the fixture at now
+func (f *fixture) session(t *testing.T, now int64) string {
+	enc := base64.RawURLEncoding
+
+	h, _ := json.Marshal(jwsHeader{Alg: "ES256"})
+	c, _ := json.Marshal(map[string]interface{}{"sub": f.username, "aud": jwtAudience, "iat": now, "exp": now + 60})
+	signingInput := enc.EncodeToString(h) + "." + enc.EncodeToString(c)
+
+	sig, err := hex.DecodeString(f.sign(t, []byte(signingInput)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signingInput + "." + enc.EncodeToString(sig)
+}
+
+// register registers the fixture
+func (h *harness) register(f *fixture) {
+	h.t.Helper()
+
+	h.mustInvoke("Register", f.request(h.t, h.now, f.identity())...)
+}
+
+func TestHarnessRegister(t *testing.T) {
+	h := newHarness(t, time.Now().Unix())
+	alice := newFixture(t, "alice")
+
+	h.register(alice)
+
+	i, err := getIdentity(h.stub, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i.Org != harnessOrg || i.SPublicKey != alice.publicKey || i.Verified != verificationNone {
+		t.Fatalf("Unexpected identity %+v", i)
+	}
+}
+
+func TestHarnessRegisterTwice(t *testing.T) {
+	h := newHarness(t, time.Now().Unix())
+	alice := newFixture(t, "alice")
+	h.register(alice)
+
+	// another key can't take the username over
+	mallory := newFixture(t, "alice")
+	res := h.invoke("Register", mallory.request(t, h.now, mallory.identity())...)
+	if res.Status == shim.OK {
+		t.Fatal("Registering a username twice succeeded")
+	}
+
+	i, err := getIdentity(h.stub, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i.SPublicKey != alice.publicKey {
+		t.Fatal("Registering a username twice changed its key")
+	}
+}
+
+// TestHarnessRefused checks the requests signed or authorized the wrong
+// way are refused, and that they don't change the data of the user
+func TestHarnessRefused(t *testing.T) {
+	update := func(data string) updateUserDataRequest {
+		return updateUserDataRequest{Username: "alice", Data: data, DataHash: sha256Hex([]byte(data))}
+	}
+
+	tests := []struct {
+		name string
+		// invoke is the function and arguments of a request about alice,
+		// bob is another registered user
+		invoke func(t *testing.T, h *harness, alice *fixture, bob *fixture) (string, []string)
+		err    string
+	}{
+		{
+			name: "signed by another key",
+			invoke: func(t *testing.T, h *harness, alice *fixture, bob *fixture) (string, []string) {
+				mallory := newFixture(t, "alice")
+				return "UpdateUserData", mallory.request(t, h.now, update("mallory"))
+			},
+			err: "Can't verify signature",
+		},
+		{
+			name: "signed by a user not delegated",
+			invoke: func(t *testing.T, h *harness, alice *fixture, bob *fixture) (string, []string) {
+				pBytes, _ := json.Marshal(update("bob"))
+				return "UpdateUserData", bob.signEnvelope(t, signedEnvelope{Timestamp: h.now, Delegate: "bob", Payload: pBytes})
+			},
+			err: "bob can't call UpdateUserData for alice",
+		},
+		{
+			name: "signed by a delegate of another function",
+			invoke: func(t *testing.T, h *harness, alice *fixture, bob *fixture) (string, []string) {
+				h.mustInvoke("Delegate", alice.request(t, h.now, delegateRequest{Username: "alice", Delegate: "bob", Functions: []string{"AddKey"}})...)
+
+				pBytes, _ := json.Marshal(update("bob"))
+				return "UpdateUserData", bob.signEnvelope(t, signedEnvelope{Timestamp: h.now, Delegate: "bob", Payload: pBytes})
+			},
+			err: "bob can't call UpdateUserData for alice",
+		},
+		{
+			name: "expired capability",
+			invoke: func(t *testing.T, h *harness, alice *fixture, bob *fixture) (string, []string) {
+				var c Capability
+				err := json.Unmarshal(h.mustInvoke("IssueCapability", alice.request(t, h.now, issueCapabilityRequest{Username: "alice", Redeemer: "bob", Key: "k", Uses: 1, ExpiresAt: h.now + 10})...), &c)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				h.now += 10
+				return "RedeemCapability", bob.request(t, h.now, redeemCapabilityRequest{Redeemer: "bob", Subject: "alice", ID: c.ID})
+			},
+			err: "Capability has expired",
+		},
+		{
+			name: "denylisted key",
+			invoke: func(t *testing.T, h *harness, alice *fixture, bob *fixture) (string, []string) {
+				h.mustInvoke("AddToDenylist", `{"kind":"key","value":"`+keyFingerprint(alice.publicKey)+`"}`)
+				return "UpdateUserData", alice.request(t, h.now, update("denylisted"))
+			},
+			err: "denylisted",
+		},
+		{
+			name: "frozen org",
+			invoke: func(t *testing.T, h *harness, alice *fixture, bob *fixture) (string, []string) {
+				h.mustInvoke("EmergencyFreeze", `{"org":"`+harnessOrg+`","reason":"compromised"}`)
+				return "UpdateUserData", alice.request(t, h.now, update("frozen"))
+			},
+			err: "are frozen",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHarness(t, time.Now().Unix())
+			h.init(Config{AdminMSPs: []string{harnessOrg}})
+			alice := newFixture(t, "alice")
+			bob := newFixture(t, "bob")
+			h.register(alice)
+			h.register(bob)
+			h.mustInvoke("UpdateUserData", alice.request(t, h.now, update("alice"))...)
+
+			function, args := tt.invoke(t, h, alice, bob)
+			res := h.invoke(function, args...)
+			if res.Status == shim.OK {
+				t.Fatal("Request wasn't refused")
+			}
+			if !strings.Contains(res.Message, tt.err) {
+				t.Fatalf("Unexpected error %s", res.Message)
+			}
+
+			i, err := getIdentity(h.stub, "alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := getUserData(h.stub, i, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data != "alice" {
+				t.Fatalf("Refused request changed the data to %s", data)
+			}
+		})
+	}
+}
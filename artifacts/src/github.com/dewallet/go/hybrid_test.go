@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// TestHybridSignatureEncodings checks hybrid identities sign with the
+// post-quantum signature as the third argument and are refused the
+// encodings that carry a single signature
+func TestHybridSignatureEncodings(t *testing.T) {
+	tests := []struct {
+		name string
+		// args are the arguments of a SetGuardians of alice, a hybrid identity
+		args func(t *testing.T, h *harness, alice *fixture) []string
+		err  string
+	}{
+		{
+			name: "hex with the post-quantum signature",
+			args: func(t *testing.T, h *harness, alice *fixture) []string {
+				return alice.request(t, h.now, setGuardiansRequest{Username: "alice", Guardians: []string{"bob"}})
+			},
+		},
+		{
+			name: "without the post-quantum signature",
+			args: func(t *testing.T, h *harness, alice *fixture) []string {
+				return alice.request(t, h.now, setGuardiansRequest{Username: "alice", Guardians: []string{"bob"}})[:2]
+			},
+			err: "Missing post-quantum signature",
+		},
+		{
+			name: "post-quantum signature of another message",
+			args: func(t *testing.T, h *harness, alice *fixture) []string {
+				args := alice.request(t, h.now, setGuardiansRequest{Username: "alice", Guardians: []string{"bob"}})
+				args[2] = alice.qSign([]byte(args[0] + " "))
+				return args
+			},
+			err: "Can't verify",
+		},
+		{
+			name: "JWS",
+			args: func(t *testing.T, h *harness, alice *fixture) []string {
+				args := alice.request(t, h.now, setGuardiansRequest{Username: "alice", Guardians: []string{"bob"}})
+
+				var env signedEnvelope
+				err := json.Unmarshal([]byte(args[0]), &env)
+				if err != nil {
+					t.Fatal(err)
+				}
+				env.SignatureEncoding = signatureEncodingJWS
+				envBytes, _ := json.Marshal(env)
+
+				return []string{string(envBytes), "eyJhbGciOiJFUzI1NiJ9..c2ln", alice.qSign(envBytes)}
+			},
+			err: "can't sign with JWS or COSE",
+		},
+		{
+			name: "COSE",
+			args: func(t *testing.T, h *harness, alice *fixture) []string {
+				args := alice.request(t, h.now, setGuardiansRequest{Username: "alice", Guardians: []string{"bob"}})
+				return []string{args[0], "\x84\x43\xa1\x01\x26", args[2]}
+			},
+			err: "can't sign with JWS or COSE",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHarness(t, time.Now().Unix())
+			alice := newHybridFixture(t, "alice")
+			h.register(alice)
+			h.register(newFixture(t, "bob"))
+
+			res := h.invoke("SetGuardians", tt.args(t, h, alice)...)
+
+			if tt.err == "" {
+				if res.Status != shim.OK {
+					t.Fatalf("Request failed %s", res.Message)
+				}
+				return
+			}
+			if res.Status == shim.OK {
+				t.Fatal("Request wasn't refused")
+			}
+			if !strings.Contains(res.Message, tt.err) {
+				t.Fatalf("Unexpected error %s", res.Message)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// getIdentity reads the identity of username, or of a DID, see resolveUsername,
+// with the keys it gave, see loadKeyRecords
+// errUsernameNotFound is the error of reading an identity not registered
+var errUsernameNotFound = errors.New("Username not found")
+
+// notFound tells whether err is that of a username or a DID not registered
+func notFound(err error) bool {
+	_, ok := err.(didNotFoundError)
+	return ok || err == errUsernameNotFound
+}
+
+func getIdentity(stub shim.ChaincodeStubInterface, username string) (Identity, error) {
+	i, err := getStoredIdentity(stub, username)
+	if err != nil {
+		return i, err
+	}
+
+	err = loadKeyRecords(stub, &i)
+	return i, err
+}
+
+// getStoredIdentity reads the identity without the records of its keys,
+// for AddKey not to read the keys given concurrently
+func getStoredIdentity(stub shim.ChaincodeStubInterface, username string) (Identity, error) {
+	username, err := resolveUsername(stub, username)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	iBytes, err := getStoredState(stub, username)
+	if err != nil {
+		return Identity{}, err
+	}
+	if iBytes == nil {
+		return Identity{}, errUsernameNotFound
+	}
+
+	return decodeIdentity(iBytes)
+}
+
+// putIdentity writes the identity with the configured state encoding
+// and returns its JSON, unless its org is frozen
+func putIdentity(stub shim.ChaincodeStubInterface, i Identity) ([]byte, error) {
+	i.DocType = identityDocType
+
+	err := checkOrgFreeze(stub, i.Org)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	// the keys are stored in their records, see putKeyRecords
+	stored := i
+	stored.Keys = nil
+
+	value, err := encodeIdentity(c.StateEncoding, stored)
+	if err != nil {
+		return nil, err
+	}
+
+	err = putStoredState(stub, i.Username, value)
+	if err != nil {
+		return nil, err
+	}
+
+	err = putKeyRecords(stub, i)
+	if err != nil {
+		return nil, err
+	}
+
+	err = putKeyMaterial(stub, i)
+	if err != nil {
+		return nil, err
+	}
+
+	err = indexDID(stub, i)
+	if err != nil {
+		return nil, err
+	}
+
+	err = recordChange(stub, i.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	iBytes, _ := marshal(i)
+
+	return iBytes, nil
+}
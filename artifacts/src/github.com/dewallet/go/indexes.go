@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// indexEntry is an entry of an identity in a secondary index,
+// the attributes of its composite key and its value
+type indexEntry struct {
+	attributes []string
+	value      []byte
+}
+
+// identityIndex is a secondary index of the identities, entries are those
+// an identity has in it. The entries of a unique index point to one
+// username, they fail with conflict when taken by another, the entries
+// of the other indexes end with the username
+type identityIndex struct {
+	name       string
+	objectType string
+	entries    func(i Identity) []indexEntry
+	conflict   string
+}
+
+// identityIndexes are the secondary indexes updateIndexes keeps, a new indexed
+// field only needs an index here. The DID of an identity never changes,
+// it is indexed by putIdentity
+var identityIndexes = []identityIndex{
+	{name: "verified", objectType: verifiedObjectType, entries: verifiedEntries},
+	{name: "pubkey", objectType: publicKeyObjectType, entries: publicKeyEntries, conflict: "Public key is already registered by another username"},
+	{name: "grant", objectType: grantObjectType, entries: grantEntries},
+	{name: "publicattribute", objectType: publicAttributeObjectType, entries: publicAttributeEntries},
+	{name: "kycverification", objectType: kycVerificationObjectType, entries: kycVerificationEntries},
+}
+
+// verifiedEntries index the identity under its verification status
+func verifiedEntries(i Identity) []indexEntry {
+	return []indexEntry{{[]string{i.Verified, i.Username}, []byte{0x00}}}
+}
+
+// publicKeyEntries point the fingerprints of the keys of the identity to its username
+func publicKeyEntries(i Identity) []indexEntry {
+	entries := []indexEntry{}
+	for _, fingerprint := range i.fingerprints() {
+		entries = append(entries, indexEntry{[]string{fingerprint}, []byte(i.Username)})
+	}
+
+	return entries
+}
+
+// grantEntries index the identity under the owners of its keys
+func grantEntries(i Identity) []indexEntry {
+	owners := []string{}
+	for owner := range i.owners() {
+		owners = append(owners, owner)
+	}
+	sort.Strings(owners)
+
+	entries := []indexEntry{}
+	for _, owner := range owners {
+		entries = append(entries, indexEntry{[]string{owner, i.Username}, []byte{0x00}})
+	}
+
+	return entries
+}
+
+// publicAttributeEntries index the identity under the values of its public attributes
+func publicAttributeEntries(i Identity) []indexEntry {
+	names := []string{}
+	for name := range i.PublicAttributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	entries := []indexEntry{}
+	for _, name := range names {
+		entries = append(entries, indexEntry{[]string{name, i.PublicAttributes[name], i.Username}, []byte{0x00}})
+	}
+
+	return entries
+}
+
+// kycVerificationEntries index the identity under the KYC providers
+// of its verifications not revoked
+func kycVerificationEntries(i Identity) []indexEntry {
+	entries := []indexEntry{}
+	seen := map[string]bool{}
+	for _, v := range i.Verifications {
+		if v.Provider == "" || v.RevokedAt != 0 || seen[v.Provider] {
+			continue
+		}
+		seen[v.Provider] = true
+
+		entries = append(entries, indexEntry{[]string{v.Provider, i.Username}, []byte{0x00}})
+	}
+
+	return entries
+}
+
+// keys are the composite keys of the entries of the identity in the index
+// in the order of its entries, with their values
+func (x identityIndex) keys(stub shim.ChaincodeStubInterface, i Identity) ([]string, map[string][]byte, error) {
+	order := []string{}
+	values := map[string][]byte{}
+	if i.Username == "" {
+		return order, values, nil
+	}
+
+	for _, e := range x.entries(i) {
+		key, err := stub.CreateCompositeKey(x.objectType, e.attributes)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if _, ok := values[key]; !ok {
+			order = append(order, key)
+		}
+		values[key] = e.value
+	}
+
+	return order, values, nil
+}
+
+// put writes an entry of the identity, a unique index fails
+// when the entry belongs to another username
+func (x identityIndex) put(stub shim.ChaincodeStubInterface, key string, value []byte, username string) error {
+	if x.conflict != "" {
+		owner, err := stub.GetState(key)
+		if err != nil {
+			return err
+		}
+		if owner != nil && string(owner) != username {
+			return errors.New(x.conflict)
+		}
+	}
+
+	return stub.PutState(key, value)
+}
+
+// update moves the entries of the identity in the index from those of prev
+// to those of i, the entries they both have aren't written again
+func (x identityIndex) update(stub shim.ChaincodeStubInterface, prev Identity, i Identity) error {
+	prevOrder, prevValues, err := x.keys(stub, prev)
+	if err != nil {
+		return err
+	}
+
+	order, values, err := x.keys(stub, i)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range prevOrder {
+		if _, ok := values[key]; ok {
+			continue
+		}
+
+		err = stub.DelState(key)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, key := range order {
+		if value, ok := prevValues[key]; ok && bytes.Equal(value, values[key]) {
+			continue
+		}
+
+		err = x.put(stub, key, values[key], i.Username)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// updateIndexes moves the entries of the identity in the secondary indexes
+// from those of prev, the identity as stored, to those of i, the identity
+// about to be written. prev is empty for a new identity. prev and i must
+// both have the records of their keys loaded, or both not
+func updateIndexes(stub shim.ChaincodeStubInterface, prev Identity, i Identity) error {
+	for _, x := range identityIndexes {
+		err := x.update(stub, prev, i)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Can't update %s index %s", x.name, err))
+		}
+	}
+
+	return nil
+}
+
+// findIdentityIndex is the secondary index with the name
+func findIdentityIndex(name string) (identityIndex, error) {
+	for _, x := range identityIndexes {
+		if x.name == name {
+			return x, nil
+		}
+	}
+
+	return identityIndex{}, errors.New(fmt.Sprintf("Unknown index %s", name))
+}
+
+// indexedUsername is the username an entry of the index belongs to
+func (x identityIndex) indexedUsername(attributes []string, value []byte) string {
+	if x.conflict != "" {
+		return string(value)
+	}
+
+	return attributes[len(attributes)-1]
+}
+
+type rebuildIndexesRequest struct {
+	Index string `json:"index"`
+
+	// Prune pages through the entries of the index instead of the
+	// identities, and drops those their identities don't have
+	Prune bool `json:"prune"`
+
+	pageRequest
+}
+
+// RebuildIndexes will write the entries of a page of identities in a
+// secondary index, admin only, for the entries missing from identities
+// written before the index or its field existed. With prune it pages
+// through the entries of the index and drops the stale ones instead
+func (t *DewalletChaincode) RebuildIndexes(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Rebuilding index")
+
+	err := t.requireAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var req rebuildIndexesRequest
+	json.Unmarshal([]byte(args[0]), &req)
+
+	x, err := findIdentityIndex(req.Index)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	size, err := req.size()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if req.Prune {
+		return pruneIndex(stub, x, size, req.Bookmark)
+	}
+
+	it, m, err := stub.GetStateByRangeWithPagination("", "", size, req.Bookmark)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't list identities %s", err))
+	}
+	defer it.Close()
+
+	identities, err := collectIdentities(stub, it, func(Identity) bool { return true })
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't list identities %s", err))
+	}
+
+	for _, i := range identities {
+		order, values, err := x.keys(stub, i)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		for _, key := range order {
+			err = x.put(stub, key, values[key], i.Username)
+			if err != nil {
+				return shim.Error(fmt.Sprintf("Can't index %s %s", i.Username, err))
+			}
+		}
+	}
+
+	resBytes, _ := marshal(newPageResponse(m))
+
+	return shim.Success(resBytes)
+}
+
+// pruneIndex drops the entries of a page of the index that the
+// identities they belong to don't have, or that belong to no identity
+func pruneIndex(stub shim.ChaincodeStubInterface, x identityIndex, size int32, bookmark string) pb.Response {
+	it, m, err := stub.GetStateByPartialCompositeKeyWithPagination(x.objectType, []string{}, size, bookmark)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't list %s index %s", x.name, err))
+	}
+	defer it.Close()
+
+	// the entries of the identities of the page, read once
+	indexed := map[string]map[string][]byte{}
+
+	for it.HasNext() {
+		kv, err := it.Next()
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Can't list %s index %s", x.name, err))
+		}
+
+		_, attributes, err := stub.SplitCompositeKey(kv.Key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		username := x.indexedUsername(attributes, kv.Value)
+		values, ok := indexed[username]
+		if !ok {
+			values, err = storedIndexEntries(stub, x, username)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			indexed[username] = values
+		}
+
+		if value, ok := values[kv.Key]; ok && bytes.Equal(value, kv.Value) {
+			continue
+		}
+
+		err = stub.DelState(kv.Key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	resBytes, _ := marshal(newPageResponse(m))
+
+	return shim.Success(resBytes)
+}
+
+// storedIndexEntries are the entries in the index of the identity stored
+// under username, none when there is no such identity
+func storedIndexEntries(stub shim.ChaincodeStubInterface, x identityIndex, username string) (map[string][]byte, error) {
+	iBytes, err := getStoredState(stub, username)
+	if err != nil {
+		return nil, err
+	}
+	if iBytes == nil {
+		return map[string][]byte{}, nil
+	}
+
+	i, err := decodeIdentity(iBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	err = loadKeyRecords(stub, &i)
+	if err != nil {
+		return nil, err
+	}
+
+	_, values, err := x.keys(stub, i)
+	return values, err
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// jwsHeader is the protected header of a JWS
+type jwsHeader struct {
+	Alg  string   `json:"alg"`
+	Kid  string   `json:"kid,omitempty"`
+	Typ  string   `json:"typ,omitempty"`
+	B64  *bool    `json:"b64,omitempty"`
+	Crit []string `json:"crit,omitempty"`
+}
+
+// jwsHashes maps the supported JWS algorithms to their digest
+var jwsHashes = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+	"PS256": crypto.SHA256,
+	"PS384": crypto.SHA384,
+	"PS512": crypto.SHA512,
+	"ES256": crypto.SHA256,
+	"ES384": crypto.SHA384,
+	"ES512": crypto.SHA512,
+	"EdDSA": 0,
+}
+
+// jwsCurveBits maps the ECDSA algorithms to the size of their curve
+var jwsCurveBits = map[string]int{
+	"ES256": 256,
+	"ES384": 384,
+	"ES512": 521,
+}
+
+// parseJWSHeader decodes the protected header of a JWS
+// and rejects critical extensions that are not understood
+func parseJWSHeader(encoded string) (jwsHeader, error) {
+	var h jwsHeader
+
+	hBytes, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return h, errors.New(fmt.Sprintf("Error in decoding JWS header %s", err))
+	}
+
+	err = json.Unmarshal(hBytes, &h)
+	if err != nil {
+		return h, errors.New(fmt.Sprintf("Error in parsing JWS header %s", err))
+	}
+
+	for _, c := range h.Crit {
+		if c != "b64" {
+			return h, errors.New(fmt.Sprintf("Unsupported critical JWS header %s", c))
+		}
+	}
+
+	// RFC 7797 requires b64 to be marked critical when it is used
+	if h.B64 != nil && !containsString(h.Crit, "b64") {
+		return h, errors.New("JWS header b64 must be listed in crit")
+	}
+
+	return h, nil
+}
+
+// verifyDetachedJWS verifies a JWS in compact serialization whose payload
+// has been detached (RFC 7515 appendix F). When the header sets b64 to false
+// the payload is signed unencoded as described by RFC 7797.
+func verifyDetachedJWS(stub shim.ChaincodeStubInterface, jws string, payload []byte, publicKey string) error {
+	parts := strings.Split(jws, ".")
+	if len(parts) != 3 {
+		return errors.New("JWS must have three parts")
+	}
+	if parts[1] != "" {
+		return errors.New("JWS payload must be detached")
+	}
+
+	h, err := parseJWSHeader(parts[0])
+	if err != nil {
+		return err
+	}
+
+	encodedPayload := base64.RawURLEncoding.EncodeToString(payload)
+	if h.B64 != nil && !*h.B64 {
+		encodedPayload = string(payload)
+	}
+
+	return verifyJWSParts(stub, h, parts[0]+"."+encodedPayload, parts[2], publicKey)
+}
+
+// verifyJWSParts checks the signature of a JWS signing input
+// against a base64 encoded PKIX public key
+func verifyJWSParts(stub shim.ChaincodeStubInterface, h jwsHeader, signingInput string, encodedSignature string, publicKey string) error {
+	s, err := base64.RawURLEncoding.Strict().DecodeString(encodedSignature)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error in decoding JWS signature %s", err))
+	}
+
+	pk, err := parsePublicKey(stub, publicKey)
+	if err != nil {
+		return err
+	}
+
+	err = verifyJWSSignature(h.Alg, pk, []byte(signingInput), s)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error in verifying signature %s", err))
+	}
+
+	return nil
+}
+
+// verifyJWSSignature verifies a JWS signature as defined by RFC 7518 and RFC 8037
+func verifyJWSSignature(alg string, pk interface{}, m []byte, s []byte) error {
+	hash, ok := jwsHashes[alg]
+	if !ok {
+		return errors.New(fmt.Sprintf("Unsupported JWS algorithm %s", alg))
+	}
+
+	var digest []byte
+	if hash != 0 {
+		h := hash.New()
+		h.Write(m)
+		digest = h.Sum(nil)
+	}
+
+	switch alg[:2] {
+	case "RS":
+		pk, ok := pk.(*rsa.PublicKey)
+		if !ok {
+			return errors.New(fmt.Sprintf("Key is not RSA for %s", alg))
+		}
+		return rsa.VerifyPKCS1v15(pk, hash, digest, s)
+	case "PS":
+		pk, ok := pk.(*rsa.PublicKey)
+		if !ok {
+			return errors.New(fmt.Sprintf("Key is not RSA for %s", alg))
+		}
+		return rsa.VerifyPSS(pk, hash, digest, s, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	case "ES":
+		pk, ok := pk.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.New(fmt.Sprintf("Key is not ECDSA for %s", alg))
+		}
+		if pk.Curve.Params().BitSize != jwsCurveBits[alg] {
+			return errors.New(fmt.Sprintf("Key curve does not match %s", alg))
+		}
+		size := (pk.Curve.Params().BitSize + 7) / 8
+		if len(s) != 2*size {
+			return errors.New("Invalid ECDSA signature length")
+		}
+		r := new(big.Int).SetBytes(s[:size])
+		ss := new(big.Int).SetBytes(s[size:])
+		if !ecdsa.Verify(pk, digest, r, ss) {
+			return errors.New("ECDSA signature mismatch")
+		}
+		return nil
+	default:
+		pk, ok := pk.(ed25519.PublicKey)
+		if !ok {
+			return errors.New(fmt.Sprintf("Key is not Ed25519 for %s", alg))
+		}
+		if !ed25519.Verify(pk, m, s) {
+			return errors.New("Ed25519 signature mismatch")
+		}
+		return nil
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// jwtAudience is the audience a session token must be issued for
+const jwtAudience = "dewallet"
+
+// jwtMaxLifetime is the longest validity in seconds accepted for a session token
+const jwtMaxLifetime = 300
+
+// jwtLeeway is the clock skew in seconds tolerated when checking a session token
+const jwtLeeway = 30
+
+// jwtAudienceClaim accepts the aud claim as a string or an array of strings
+type jwtAudienceClaim []string
+
+func (a *jwtAudienceClaim) UnmarshalJSON(b []byte) error {
+	var s string
+	if json.Unmarshal(b, &s) == nil {
+		*a = jwtAudienceClaim{s}
+		return nil
+	}
+
+	var l []string
+	err := json.Unmarshal(b, &l)
+	if err != nil {
+		return err
+	}
+
+	*a = l
+	return nil
+}
+
+// jwtClaims are the registered claims checked on a session token
+type jwtClaims struct {
+	Subject   string           `json:"sub"`
+	Audience  jwtAudienceClaim `json:"aud"`
+	ExpiresAt int64            `json:"exp"`
+	NotBefore int64            `json:"nbf"`
+	IssuedAt  int64            `json:"iat"`
+}
+
+// verifyJWT verifies the signature of a JWT in compact serialization
+// against a base64 encoded PKIX public key and returns its claims
+func verifyJWT(stub shim.ChaincodeStubInterface, token string, publicKey string) (jwtClaims, error) {
+	var c jwtClaims
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[1] == "" {
+		return c, errors.New("JWT must have three parts")
+	}
+
+	h, err := parseJWSHeader(parts[0])
+	if err != nil {
+		return c, err
+	}
+	if h.B64 != nil {
+		return c, errors.New("JWT must not use the b64 header")
+	}
+
+	err = verifyJWSParts(stub, h, parts[0]+"."+parts[1], parts[2], publicKey)
+	if err != nil {
+		return c, err
+	}
+
+	cBytes, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return c, errors.New(fmt.Sprintf("Error in decoding JWT claims %s", err))
+	}
+
+	err = json.Unmarshal(cBytes, &c)
+	if err != nil {
+		return c, errors.New(fmt.Sprintf("Error in parsing JWT claims %s", err))
+	}
+
+	return c, nil
+}
+
+// validate checks that the claims describe a live session
+// of subject for this chaincode at the unix time now
+func (c jwtClaims) validate(subject string, now int64) error {
+	if c.Subject != subject {
+		return errors.New(fmt.Sprintf("Token subject %s does not match %s", c.Subject, subject))
+	}
+	if !containsString(c.Audience, jwtAudience) {
+		return errors.New(fmt.Sprintf("Token audience must contain %s", jwtAudience))
+	}
+	if c.ExpiresAt == 0 || c.IssuedAt == 0 {
+		return errors.New("Token must carry exp and iat")
+	}
+	if c.ExpiresAt-c.IssuedAt > jwtMaxLifetime {
+		return errors.New(fmt.Sprintf("Token lifetime exceeds %d seconds", jwtMaxLifetime))
+	}
+	if now > c.ExpiresAt+jwtLeeway {
+		return errors.New("Token has expired")
+	}
+	if now+jwtLeeway < c.IssuedAt || now+jwtLeeway < c.NotBefore {
+		return errors.New("Token is not valid yet")
+	}
+
+	return nil
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// parsedKeys are the keys parsePublicKey parsed in the invocation of the
+// stub, so the operations of a batch or the several signatures of a request
+// checked against the same key parse it once. They are kept by the
+// stateCache of the invocation, nil outside of Invoke
+func parsedKeys(stub shim.ChaincodeStubInterface) map[string]interface{} {
+	s, ok := invocationCache(stub)
+	if !ok {
+		return nil
+	}
+
+	return s.keys
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// publicKeyObjectType indexes the usernames by the fingerprints of their public keys
+const publicKeyObjectType = "pubkey"
+
+// fingerprints are the fingerprints of the keys of every key slot of the identity
+func (i Identity) fingerprints() []string {
+	fingerprints := []string{}
+	for _, k := range []string{i.PublicKey, i.EPublicKey, i.SPublicKey, i.BPublicKey, i.QPublicKey} {
+		fingerprint := keyFingerprint(k)
+		if k != "" && fingerprint != "" {
+			fingerprints = append(fingerprints, fingerprint)
+		}
+	}
+
+	return fingerprints
+}
+
+// hasPublicKey tells whether one of the key slots of the identity has the fingerprint
+func (i Identity) hasPublicKey(fingerprint string) bool {
+	for _, f := range i.fingerprints() {
+		if f == fingerprint {
+			return true
+		}
+	}
+
+	return false
+}
+
+type getIdentityByPublicKeyRequest struct {
+	PublicKey   string `json:"publicKey"`
+	Fingerprint string `json:"fingerprint"`
+
+	fieldMask
+}
+
+type getIdentityByPublicKeyResponse struct {
+	identitySummary
+
+	PublicKey  string `json:"publicKey"`
+	EPublicKey string `json:"ePublicKey"`
+	SPublicKey string `json:"sPublicKey"`
+}
+
+// GetIdentityByPublicKey will query the blockchain
+// for the identity having a public key, or the fingerprint of one
+func (t *DewalletChaincode) GetIdentityByPublicKey(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Querying a member by public key")
+
+	var req getIdentityByPublicKeyRequest
+	json.Unmarshal([]byte(args[0]), &req)
+
+	err := req.check(getIdentityByPublicKeyResponse{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fingerprint := req.Fingerprint
+	if req.PublicKey != "" {
+		fingerprint = keyFingerprint(req.PublicKey)
+	}
+	if fingerprint == "" {
+		return shim.Error("Missing public key")
+	}
+
+	pKey, err := stub.CreateCompositeKey(publicKeyObjectType, []string{fingerprint})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	username, err := stub.GetState(pKey)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't get public key index %s", err))
+	}
+	if username == nil {
+		return shim.Error("Public key not found")
+	}
+
+	i, err := getIdentity(stub, string(username))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !i.hasPublicKey(fingerprint) {
+		return shim.Error("Public key not found")
+	}
+
+	err = checkQueryPolicy(stub, i)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	res := getIdentityByPublicKeyResponse{
+		identitySummary: newIdentitySummary(i),
+		PublicKey:       i.PublicKey,
+		EPublicKey:      i.EPublicKey,
+		SPublicKey:      i.SPublicKey,
+	}
+
+	resBytes, err := req.apply(res)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(resBytes)
+}
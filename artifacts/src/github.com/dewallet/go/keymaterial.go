@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// keyMaterialObjectType prefixes the key material of each identity by username,
+// GetPublicKey reads it without decoding the data, slots and keys of the identity
+const keyMaterialObjectType = "keymaterial"
+
+// KeyMaterial is the public keys of an identity,
+// with what its query policy is checked against
+type KeyMaterial struct {
+	Username   string       `json:"username"`
+	Org        string       `json:"org"`
+	PublicKey  string       `json:"publicKey"`
+	EPublicKey string       `json:"ePublicKey"`
+	SPublicKey string       `json:"sPublicKey"`
+	Policy     AccessPolicy `json:"policy"`
+}
+
+func newKeyMaterial(i Identity) KeyMaterial {
+	return KeyMaterial{
+		Username:   i.Username,
+		Org:        i.Org,
+		PublicKey:  i.PublicKey,
+		EPublicKey: i.EPublicKey,
+		SPublicKey: i.SPublicKey,
+		Policy:     i.Policy,
+	}
+}
+
+// identity is the part of the identity checkQueryPolicy reads
+func (r KeyMaterial) identity() Identity {
+	return Identity{Username: r.Username, Org: r.Org, Policy: r.Policy}
+}
+
+func keyMaterialKey(stub shim.ChaincodeStubInterface, username string) (string, error) {
+	return stub.CreateCompositeKey(keyMaterialObjectType, []string{username})
+}
+
+// putKeyMaterial writes the key material of the identity, with putIdentity
+func putKeyMaterial(stub shim.ChaincodeStubInterface, i Identity) error {
+	key, err := keyMaterialKey(stub, i.Username)
+	if err != nil {
+		return err
+	}
+
+	encoding, err := stateEncoding(stub)
+	if err != nil {
+		return err
+	}
+
+	rBytes, err := encodeKeyMaterial(encoding, newKeyMaterial(i))
+	if err != nil {
+		return err
+	}
+
+	return stub.PutState(key, rBytes)
+}
+
+// getKeyMaterial reads the key material of username, identities written
+// before the records are read whole until they are written again
+func getKeyMaterial(stub shim.ChaincodeStubInterface, username string) (KeyMaterial, error) {
+	username, err := resolveUsername(stub, username)
+	if err != nil {
+		return KeyMaterial{}, err
+	}
+
+	key, err := keyMaterialKey(stub, username)
+	if err != nil {
+		return KeyMaterial{}, err
+	}
+
+	rBytes, err := stub.GetState(key)
+	if err != nil {
+		return KeyMaterial{}, errors.New("Failed to get state")
+	}
+	if rBytes == nil {
+		i, err := getStoredIdentity(stub, username)
+		if err != nil {
+			return KeyMaterial{}, err
+		}
+
+		return newKeyMaterial(i), nil
+	}
+
+	return decodeKeyMaterial(rBytes)
+}
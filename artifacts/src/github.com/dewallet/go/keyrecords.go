@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// keyRecordObjectType prefixes the keys an identity gave by username, owner
+// and record id, the identity itself is stored without them so its value
+// stays bounded, grants are added without rewriting it and the keys
+// of an owner are read with a partial composite key
+const keyRecordObjectType = "identitykey"
+
+// keyRecordID orders the records of an owner by when they were created,
+// n tells apart the keys created by the same transaction
+func keyRecordID(stub shim.ChaincodeStubInterface, n int) (string, error) {
+	ts, err := stub.GetTxTimestamp()
+	if err != nil {
+		return "", errors.New("Failed to get transaction timestamp")
+	}
+
+	return fmt.Sprintf("%s.%s.%04d", changeSequence(ts.Seconds, ts.Nanos), stub.GetTxID(), n), nil
+}
+
+// putKeyRecord writes a new record for a key given by the identity. A new
+// record is endorsed under the chaincode policy, the endorsing orgs of the
+// identity guard its changes, a forged record gives nothing without the data key
+func putKeyRecord(stub shim.ChaincodeStubInterface, i Identity, k Key, n int) (string, error) {
+	id, err := keyRecordID(stub, n)
+	if err != nil {
+		return "", err
+	}
+
+	key, err := stub.CreateCompositeKey(keyRecordObjectType, []string{i.Username, k.Owner, id})
+	if err != nil {
+		return "", err
+	}
+
+	encoding, err := stateEncoding(stub)
+	if err != nil {
+		return "", err
+	}
+
+	kBytes, err := encodeKey(encoding, k)
+	if err != nil {
+		return "", err
+	}
+
+	err = stub.PutState(key, kBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return key, setKeyEndorsementPolicy(stub, key, i.EndorsingOrgs)
+}
+
+// loadKeyRecords appends the keys of the records of the identity to those
+// still stored in it, identities written before the records have their keys
+// inline until they are written again
+func loadKeyRecords(stub shim.ChaincodeStubInterface, i *Identity) error {
+	it, err := stub.GetStateByPartialCompositeKey(keyRecordObjectType, []string{i.Username})
+	if err != nil {
+		return errors.New(fmt.Sprintf("Can't query keys of %s %s", i.Username, err))
+	}
+	defer it.Close()
+
+	i.keyRecords = map[string][]byte{}
+	for it.HasNext() {
+		kv, err := it.Next()
+		if err != nil {
+			return errors.New(fmt.Sprintf("Can't query keys of %s %s", i.Username, err))
+		}
+
+		k, err := decodeKey(kv.Value)
+		if err != nil {
+			return err
+		}
+		k.record = kv.Key
+
+		i.Keys = append(i.Keys, k)
+		i.keyRecords[kv.Key] = kv.Value
+	}
+
+	return nil
+}
+
+// putKeyRecords writes the keys of the identity being written to their
+// records: new keys get a record, changed ones are rewritten and the records
+// of the keys it no longer has are deleted
+func putKeyRecords(stub shim.ChaincodeStubInterface, i Identity) error {
+	encoding, err := stateEncoding(stub)
+	if err != nil {
+		return err
+	}
+
+	kept := map[string]bool{}
+
+	for n, k := range i.Keys {
+		if k.record == "" {
+			_, err := putKeyRecord(stub, i, k, n)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		kept[k.record] = true
+
+		// records of the other encoding are rewritten in the configured one
+		kBytes, err := encodeKey(encoding, k)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(kBytes, i.keyRecords[k.record]) {
+			continue
+		}
+
+		err = stub.PutState(k.record, kBytes)
+		if err != nil {
+			return err
+		}
+	}
+
+	for key := range i.keyRecords {
+		if kept[key] {
+			continue
+		}
+
+		err := stub.DelState(key)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ownerKeys are the keys the identity gave owner oldest first, those still
+// inline then the records under owner, without reading the keys of others
+func ownerKeys(stub shim.ChaincodeStubInterface, i Identity, owner string) ([]Key, error) {
+	keys := []Key{}
+	for _, k := range i.Keys {
+		if k.record == "" && k.Owner == owner {
+			keys = append(keys, k)
+		}
+	}
+
+	it, err := stub.GetStateByPartialCompositeKey(keyRecordObjectType, []string{i.Username, owner})
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Can't query keys of %s %s", i.Username, err))
+	}
+	defer it.Close()
+
+	for it.HasNext() {
+		kv, err := it.Next()
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Can't query keys of %s %s", i.Username, err))
+		}
+
+		k, err := decodeKey(kv.Value)
+		if err != nil {
+			return nil, err
+		}
+		k.record = kv.Key
+
+		keys = append(keys, k)
+	}
+
+	return keys, nil
+}
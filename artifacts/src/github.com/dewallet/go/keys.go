@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// minRSABits is the smallest RSA modulus accepted for any key slot
+const minRSABits = 2048
+
+// Key slots of an identity, each slot accepts different key types
+const (
+	keySlotIdentity   = "publicKey"
+	keySlotEncryption = "ePublicKey"
+	keySlotSigning    = "sPublicKey"
+)
+
+// approvedCurves are the elliptic curves accepted for EC keys
+var approvedCurves = map[elliptic.Curve]bool{
+	elliptic.P256(): true,
+	elliptic.P384(): true,
+	elliptic.P521(): true,
+}
+
+// validatePublicKey checks that publicKey is a canonical base64 PKIX
+// encoding of a key strong enough for, and usable in, the slot
+func validatePublicKey(slot string, publicKey string) error {
+	if publicKey == "" {
+		return errors.New(fmt.Sprintf("Missing %s", slot))
+	}
+
+	pkBytes, err := base64.StdEncoding.Strict().DecodeString(publicKey)
+	if err != nil || base64.StdEncoding.EncodeToString(pkBytes) != publicKey {
+		return errors.New(fmt.Sprintf("%s is not canonical base64", slot))
+	}
+
+	pk, err := x509.ParsePKIXPublicKey(pkBytes)
+	if err != nil {
+		return errors.New(fmt.Sprintf("%s is not a PKIX public key %s", slot, err))
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(pk)
+	if err != nil || !bytes.Equal(der, pkBytes) {
+		return errors.New(fmt.Sprintf("%s is not DER encoded", slot))
+	}
+
+	switch pk := pk.(type) {
+	case *rsa.PublicKey:
+		if pk.N.BitLen() < minRSABits {
+			return errors.New(fmt.Sprintf("%s RSA modulus must be at least %d bits", slot, minRSABits))
+		}
+		if pk.E < 3 || pk.E%2 == 0 {
+			return errors.New(fmt.Sprintf("%s RSA exponent is invalid", slot))
+		}
+	case *ecdsa.PublicKey:
+		if !approvedCurves[pk.Curve] {
+			return errors.New(fmt.Sprintf("%s curve is not approved", slot))
+		}
+	case ed25519.PublicKey:
+		// Ed25519 can only sign, it can't wrap data keys
+		if slot == keySlotEncryption {
+			return errors.New(fmt.Sprintf("%s can't be an Ed25519 key", slot))
+		}
+	default:
+		return errors.New(fmt.Sprintf("%s key type is not supported", slot))
+	}
+
+	return nil
+}
+
+// validateIdentityKeys checks every key slot of an identity
+func validateIdentityKeys(i Identity) error {
+	slots := []struct {
+		name string
+		key  string
+	}{
+		{keySlotIdentity, i.PublicKey},
+		{keySlotEncryption, i.EPublicKey},
+		{keySlotSigning, i.SPublicKey},
+	}
+
+	for _, s := range slots {
+		err := validatePublicKey(s.name, s.key)
+		if err != nil {
+			return err
+		}
+	}
+
+	if i.QPublicKey != "" {
+		return validatePostQuantumKey(i.QAlgorithm, i.QPublicKey)
+	}
+
+	return nil
+}
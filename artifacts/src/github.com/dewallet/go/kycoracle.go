@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// oracleResultObjectType prefixes the ingested oracle results by provider
+// and result id, they are kept as the audit trail of the verifications
+const oracleResultObjectType = "kycoracleresult"
+
+// oracleResultMaxAge is how many seconds after it was issued
+// an oracle result can still be ingested
+const oracleResultMaxAge = 3600
+
+// OracleResult is what the off-chain service of a KYC provider signs once
+// it checked a user, ID is unique among the results of the provider
+type OracleResult struct {
+	ID           string `json:"id"`
+	Provider     string `json:"provider"`
+	Subject      string `json:"subject"`
+	Level        string `json:"level"`
+	EvidenceHash string `json:"evidenceHash"`
+	IssuedAt     int64  `json:"issuedAt"`
+	ExpiresAt    int64  `json:"expiresAt"`
+}
+
+// OracleRecord is an ingested result as it was signed
+type OracleRecord struct {
+	Result            json.RawMessage `json:"result"`
+	Signature         string          `json:"signature"`
+	SignatureEncoding string          `json:"signatureEncoding"`
+	IngestedAt        int64           `json:"ingestedAt"`
+	TxID              string          `json:"txId"`
+}
+
+// validateOracleKey checks the key and algorithm registered for the oracle of a provider
+func validateOracleKey(alg string, publicKey string) error {
+	if alg == "" {
+		alg = defaultSignatureAlgorithm
+	}
+	if _, ok := signatureAlgorithms[alg]; !ok {
+		return errors.New(fmt.Sprintf("Unsupported signature algorithm %s", alg))
+	}
+
+	if _, ok := postQuantumAlgorithms[alg]; ok {
+		return validatePostQuantumKey(alg, publicKey)
+	}
+
+	return validatePublicKey("oracleKey", publicKey)
+}
+
+type ingestOracleResultRequest struct {
+	// Result is the JSON of an OracleResult, the signature is over its exact bytes
+	Result            string `json:"result"`
+	Signature         string `json:"signature"`
+	SignatureEncoding string `json:"signatureEncoding"`
+}
+
+// IngestOracleResult will record the verification of a user from a result
+// signed by the oracle of its KYC provider, as if the provider's verifier
+// had set it. Anyone may relay a result, its signature, freshness and id
+// are checked and the result is kept along the verification
+func (t *DewalletChaincode) IngestOracleResult(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Ingesting KYC oracle result")
+
+	var r ingestOracleResultRequest
+	json.Unmarshal([]byte(args[0]), &r)
+
+	var res OracleResult
+	err := json.Unmarshal([]byte(r.Result), &res)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Error in parsing oracle result %s", err))
+	}
+	if res.ID == "" {
+		return shim.Error("Oracle result has no id")
+	}
+
+	p, err := getKYCProvider(stub, res.Provider)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if p == nil {
+		return shim.Error(fmt.Sprintf("KYC provider %s not found", res.Provider))
+	}
+	if p.OracleKey == "" {
+		return shim.Error(fmt.Sprintf("KYC provider %s has no oracle", p.ID))
+	}
+
+	sig, err := decodeSignature(r.Signature, r.SignatureEncoding)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Error in decoding oracle signature %s", err))
+	}
+
+	err = verifyWithAlgorithm(stub, p.OracleAlg, p.OracleKey, []byte(r.Result), sig)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't verify oracle result %s", err))
+	}
+
+	now, err := txSeconds(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if res.IssuedAt < now-oracleResultMaxAge || res.IssuedAt > now+envelopeMaxSkew {
+		return shim.Error("Oracle result is too old or issued in the future")
+	}
+
+	key, err := stub.CreateCompositeKey(oracleResultObjectType, []string{p.ID, res.ID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	seen, err := stub.GetState(key)
+	if err != nil {
+		return shim.Error("Failed to get state")
+	}
+	if seen != nil {
+		return shim.Error(fmt.Sprintf("Oracle result %s was already ingested", res.ID))
+	}
+
+	vi, err := getIdentity(stub, p.Username)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't get verifier %s %s", p.Username, err))
+	}
+
+	err = requireVerifier(stub, vi)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if verificationRank(res.Level) < 0 {
+		return shim.Error(fmt.Sprintf("Unsupported verification level %s", res.Level))
+	}
+
+	err = checkKYCProvider(stub, p.ID, vi.Username, res.Level)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	i, err := getIdentity(stub, res.Subject)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	expiresAt, err := verificationExpiry(stub, now, res.ExpiresAt)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	v := Verification{
+		Level:        res.Level,
+		Verifier:     vi.Username,
+		VerifiedAt:   now,
+		ExpiresAt:    expiresAt,
+		EvidenceHash: res.EvidenceHash,
+		TxID:         stub.GetTxID(),
+		Provider:     p.ID,
+	}
+
+	// the receipt of a previous verification by the verifier doesn't hold anymore
+	err = putReceipt(stub, i.Username, vi, v, nil)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	prev := i
+	i.putVerification(v, now)
+
+	err = updateIndexes(stub, prev, i)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = audit(stub, i.Username, vi.Username, "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	rBytes, _ := marshal(OracleRecord{
+		Result:            json.RawMessage(r.Result),
+		Signature:         r.Signature,
+		SignatureEncoding: r.SignatureEncoding,
+		IngestedAt:        now,
+		TxID:              stub.GetTxID(),
+	})
+	err = stub.PutState(key, rBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	iBytes, err := putIdentity(stub, i)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(iBytes)
+}
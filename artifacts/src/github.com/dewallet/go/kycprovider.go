@@ -0,0 +1,380 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// kycProviderObjectType prefixes the KYC providers by id
+const kycProviderObjectType = "kycprovider"
+
+// kycVerificationObjectType indexes the identities by the KYC provider
+// of one of their verifications
+const kycVerificationObjectType = "kycverification"
+
+// Status of a KYC provider, a retired provider can't come back
+const (
+	kycProviderActive    = "active"
+	kycProviderSuspended = "suspended"
+	kycProviderRetired   = "retired"
+)
+
+// kycProviderIDPattern matches the ids of KYC providers
+var kycProviderIDPattern = regexp.MustCompile(`^[A-Za-z0-9._\-]{1,64}$`)
+
+// jurisdictionPattern matches an ISO 3166 country or subdivision code
+var jurisdictionPattern = regexp.MustCompile(`^[A-Z]{2}(-[A-Z0-9]{1,3})?$`)
+
+// KYCProvider is an entry of the registry of KYC providers managed by the
+// admin orgs, Username is the verifier identity signing its verifications
+// and Levels the verification levels it may give.
+// OracleKey is the key its off-chain service signs results with, see IngestOracleResult
+type KYCProvider struct {
+	ID           string   `json:"id"`
+	Username     string   `json:"username"`
+	Name         string   `json:"name"`
+	Levels       []string `json:"levels"`
+	Jurisdiction string   `json:"jurisdiction"`
+	Status       string   `json:"status"`
+	UpdatedAt    int64    `json:"updatedAt"`
+	UpdatedBy    string   `json:"updatedBy"`
+	OracleKey    string   `json:"oracleKey,omitempty"`
+	OracleAlg    string   `json:"oracleAlg,omitempty"`
+}
+
+// getKYCProvider reads a KYC provider, nil when there is none
+func getKYCProvider(stub shim.ChaincodeStubInterface, id string) (*KYCProvider, error) {
+	key, err := stub.CreateCompositeKey(kycProviderObjectType, []string{id})
+	if err != nil {
+		return nil, err
+	}
+
+	pBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get state")
+	}
+	if pBytes == nil {
+		return nil, nil
+	}
+
+	var p KYCProvider
+	err = json.Unmarshal(pBytes, &p)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error in parsing KYC provider %s", err))
+	}
+
+	return &p, nil
+}
+
+func putKYCProvider(stub shim.ChaincodeStubInterface, p KYCProvider) ([]byte, error) {
+	key, err := stub.CreateCompositeKey(kycProviderObjectType, []string{p.ID})
+	if err != nil {
+		return nil, err
+	}
+
+	pBytes, _ := marshal(p)
+	return pBytes, stub.PutState(key, pBytes)
+}
+
+// checkKYCProvider checks that a verification of level by verifier
+// can reference the provider
+func checkKYCProvider(stub shim.ChaincodeStubInterface, id string, verifier string, level string) error {
+	p, err := getKYCProvider(stub, id)
+	if err != nil {
+		return err
+	}
+	if p == nil {
+		return errors.New(fmt.Sprintf("KYC provider %s not found", id))
+	}
+	if p.Status != kycProviderActive {
+		return errors.New(fmt.Sprintf("KYC provider %s is %s", id, p.Status))
+	}
+	if p.Username != verifier {
+		return errors.New(fmt.Sprintf("%s doesn't verify for KYC provider %s", verifier, id))
+	}
+	if !containsString(p.Levels, level) {
+		return errors.New(fmt.Sprintf("KYC provider %s can't give level %s", id, level))
+	}
+
+	return nil
+}
+
+// applyKYCProviderStatus downgrades the verifications of the provider to its
+// status, they are suspended with it, restored when it is active again
+// and revoked once it retires
+func applyKYCProviderStatus(stub shim.ChaincodeStubInterface, p KYCProvider, now int64) error {
+	it, err := stub.GetStateByPartialCompositeKey(kycVerificationObjectType, []string{p.ID})
+	if err != nil {
+		return errors.New(fmt.Sprintf("Can't query verifications of KYC provider %s", err))
+	}
+	defer it.Close()
+
+	for it.HasNext() {
+		kv, err := it.Next()
+		if err != nil {
+			return errors.New(fmt.Sprintf("Can't query verifications of KYC provider %s", err))
+		}
+
+		_, attributes, err := stub.SplitCompositeKey(kv.Key)
+		if err != nil {
+			return err
+		}
+
+		i, err := getIdentity(stub, attributes[1])
+		if err != nil {
+			return err
+		}
+
+		prev := i
+		for k := range i.Verifications {
+			v := &i.Verifications[k]
+			if v.Provider != p.ID || v.RevokedAt != 0 {
+				continue
+			}
+
+			switch p.Status {
+			case kycProviderActive:
+				v.SuspendedAt = 0
+			case kycProviderSuspended:
+				v.SuspendedAt = now
+			case kycProviderRetired:
+				v.RevokedAt = now
+
+				rKey, err := receiptKey(stub, i.Username, v.Verifier)
+				if err != nil {
+					return err
+				}
+
+				err = stub.DelState(rKey)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		i.Verified = i.verificationLevel(now)
+
+		err = updateIndexes(stub, prev, i)
+		if err != nil {
+			return err
+		}
+
+		_, err = putIdentity(stub, i)
+		if err != nil {
+			return err
+		}
+
+		if p.Status == kycProviderRetired {
+			err = stub.DelState(kv.Key)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+type registerKYCProviderRequest struct {
+	ID           string   `json:"id"`
+	Username     string   `json:"username"`
+	Name         string   `json:"name"`
+	Levels       []string `json:"levels"`
+	Jurisdiction string   `json:"jurisdiction"`
+	OracleKey    string   `json:"oracleKey"`
+	OracleAlg    string   `json:"oracleAlg"`
+}
+
+// RegisterKYCProvider will add a KYC provider or update the one with the id,
+// admin only, the provider's identity must be an approved verifier
+func (t *DewalletChaincode) RegisterKYCProvider(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Registering a KYC provider")
+
+	err := t.requireAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var r registerKYCProviderRequest
+	json.Unmarshal([]byte(args[0]), &r)
+
+	if !kycProviderIDPattern.MatchString(r.ID) {
+		return shim.Error(fmt.Sprintf("Invalid KYC provider id %s", r.ID))
+	}
+	if !jurisdictionPattern.MatchString(r.Jurisdiction) {
+		return shim.Error(fmt.Sprintf("Invalid jurisdiction %s", r.Jurisdiction))
+	}
+	if len(r.Levels) == 0 {
+		return shim.Error("A KYC provider gives at least one level")
+	}
+	for _, level := range r.Levels {
+		if verificationRank(level) <= 0 {
+			return shim.Error(fmt.Sprintf("Unsupported verification level %s", level))
+		}
+	}
+
+	err = checkSize("name", len(r.Name), maxDenylistValueLength)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if r.OracleKey != "" {
+		err = validateOracleKey(r.OracleAlg, r.OracleKey)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	vi, err := getIdentity(stub, r.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = requireVerifier(stub, vi)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	prev, err := getKYCProvider(stub, r.ID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if prev != nil && prev.Status == kycProviderRetired {
+		return shim.Error(fmt.Sprintf("KYC provider %s is retired", r.ID))
+	}
+	if prev != nil && prev.Username != vi.Username {
+		return shim.Error("The identity of a KYC provider can't change")
+	}
+
+	admin, err := creatorID(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	now, err := txSeconds(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	p := KYCProvider{
+		ID:           r.ID,
+		Username:     vi.Username,
+		Name:         r.Name,
+		Levels:       r.Levels,
+		Jurisdiction: r.Jurisdiction,
+		Status:       kycProviderActive,
+		UpdatedAt:    now,
+		UpdatedBy:    admin,
+		OracleKey:    r.OracleKey,
+		OracleAlg:    r.OracleAlg,
+	}
+	if prev != nil {
+		p.Status = prev.Status
+	}
+
+	pBytes, err := putKYCProvider(stub, p)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(pBytes)
+}
+
+type setKYCProviderStatusRequest struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
+
+// SetKYCProviderStatus will suspend, reactivate or retire a KYC provider,
+// admin only, the verifications referencing it follow in the same transaction
+func (t *DewalletChaincode) SetKYCProviderStatus(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Setting status of KYC provider")
+
+	err := t.requireAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var r setKYCProviderStatusRequest
+	json.Unmarshal([]byte(args[0]), &r)
+
+	p, err := getKYCProvider(stub, r.ID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if p == nil {
+		return shim.Error("KYC provider not found")
+	}
+
+	if r.Status != kycProviderActive && r.Status != kycProviderSuspended && r.Status != kycProviderRetired {
+		return shim.Error(fmt.Sprintf("Unsupported KYC provider status %s", r.Status))
+	}
+	if p.Status == kycProviderRetired {
+		return shim.Error(fmt.Sprintf("KYC provider %s is retired", p.ID))
+	}
+	if p.Status == r.Status {
+		return shim.Error(fmt.Sprintf("KYC provider %s is already %s", p.ID, p.Status))
+	}
+
+	admin, err := creatorID(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	now, err := txSeconds(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	p.Status = r.Status
+	p.UpdatedAt = now
+	p.UpdatedBy = admin
+
+	err = applyKYCProviderStatus(stub, *p, now)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	pBytes, err := putKYCProvider(stub, *p)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(pBytes)
+}
+
+// GetKYCProviders will query the blockchain
+// and return every entry of the KYC provider registry
+func (t *DewalletChaincode) GetKYCProviders(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Querying KYC providers")
+
+	it, err := stub.GetStateByPartialCompositeKey(kycProviderObjectType, []string{})
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't query KYC providers %s", err))
+	}
+	defer it.Close()
+
+	res := []KYCProvider{}
+	for it.HasNext() {
+		kv, err := it.Next()
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Can't query KYC providers %s", err))
+		}
+
+		var p KYCProvider
+		err = json.Unmarshal(kv.Value, &p)
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Error in parsing KYC provider %s", err))
+		}
+
+		res = append(res, p)
+	}
+
+	resBytes, _ := marshal(res)
+
+	return shim.Success(resBytes)
+}
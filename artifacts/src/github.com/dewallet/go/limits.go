@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Limits applied when a size is not configured
+const (
+	defaultMaxDataSize       = 256 * 1024
+	defaultMaxKeySize        = 8 * 1024
+	defaultMaxUsernameLength = 64
+)
+
+// Grant eviction policies, what AddKey does when an identity is at its cap
+const (
+	// grantEvictionFirstGiven removes the keys in the order they were given,
+	// first given first. Reads aren't recorded, how recently a key was
+	// used doesn't count
+	grantEvictionFirstGiven = "firstGiven"
+	// grantEvictionExpiry removes the keys expiring the soonest,
+	// those that don't expire last
+	grantEvictionExpiry = "expiry"
+)
+
+// Limits are the maximum sizes in bytes accepted for stored values,
+// zero means the default
+// MaxGrants caps the keys an identity gives that haven't expired, zero
+// means no cap, AddKey fails at the cap unless GrantEviction is set
+// ByLevel are the limits of the identities at a verification level,
+// their zero sizes are those of the limits they are part of
+type Limits struct {
+	MaxDataSize       int    `json:"maxDataSize"`
+	MaxKeySize        int    `json:"maxKeySize"`
+	MaxUsernameLength int    `json:"maxUsernameLength"`
+	MaxGrants         int    `json:"maxGrants,omitempty"`
+	GrantEviction     string `json:"grantEviction,omitempty"`
+
+	ByLevel map[string]Limits `json:"byLevel,omitempty"`
+}
+
+// forLevel returns the limits of the identities at level
+func (l Limits) forLevel(level string) Limits {
+	o, ok := l.ByLevel[level]
+	if !ok {
+		return l
+	}
+
+	if o.MaxDataSize > 0 {
+		l.MaxDataSize = o.MaxDataSize
+	}
+	if o.MaxKeySize > 0 {
+		l.MaxKeySize = o.MaxKeySize
+	}
+	if o.MaxUsernameLength > 0 {
+		l.MaxUsernameLength = o.MaxUsernameLength
+	}
+	if o.MaxGrants > 0 {
+		l.MaxGrants = o.MaxGrants
+	}
+	if o.GrantEviction != "" {
+		l.GrantEviction = o.GrantEviction
+	}
+
+	return l
+}
+
+func (l Limits) maxDataSize() int {
+	if l.MaxDataSize > 0 {
+		return l.MaxDataSize
+	}
+	return defaultMaxDataSize
+}
+
+func (l Limits) maxKeySize() int {
+	if l.MaxKeySize > 0 {
+		return l.MaxKeySize
+	}
+	return defaultMaxKeySize
+}
+
+func (l Limits) maxUsernameLength() int {
+	if l.MaxUsernameLength > 0 {
+		return l.MaxUsernameLength
+	}
+	return defaultMaxUsernameLength
+}
+
+func validGrantEviction(eviction string) bool {
+	return eviction == "" || eviction == grantEvictionFirstGiven || eviction == grantEvictionExpiry
+}
+
+// validate checks the limits and their overrides by verification level,
+// Init and SetLimits store the same limits
+func (l Limits) validate() error {
+	if l.MaxDataSize < 0 || l.MaxKeySize < 0 || l.MaxUsernameLength < 0 || l.MaxGrants < 0 {
+		return errors.New("Limits can't be negative")
+	}
+	if !validGrantEviction(l.GrantEviction) {
+		return errors.New(fmt.Sprintf("Unsupported grant eviction %s", l.GrantEviction))
+	}
+	for level, o := range l.ByLevel {
+		if verificationRank(level) < 0 {
+			return errors.New(fmt.Sprintf("Unsupported verification level %s", level))
+		}
+		if o.MaxDataSize < 0 || o.MaxKeySize < 0 || o.MaxUsernameLength < 0 || o.MaxGrants < 0 {
+			return errors.New("Limits can't be negative")
+		}
+		if !validGrantEviction(o.GrantEviction) {
+			return errors.New(fmt.Sprintf("Unsupported grant eviction %s", o.GrantEviction))
+		}
+	}
+
+	return nil
+}
+
+// checkSize returns a structured error when a field is over its limit
+func checkSize(field string, size int, limit int) error {
+	if size <= limit {
+		return nil
+	}
+
+	return structuredError{
+		Code:    errorCodeTooLarge,
+		Message: fmt.Sprintf("%s is %d bytes, the limit is %d", field, size, limit),
+		Field:   field,
+		Limit:   limit,
+		Size:    size,
+	}
+}
+
+// checkData checks the size of an encrypted data value
+func (l Limits) checkData(field string, data string) error {
+	return checkSize(field, len(data), l.maxDataSize())
+}
+
+// checkKey checks the size of a public or wrapped key
+func (l Limits) checkKey(field string, key string) error {
+	return checkSize(field, len(key), l.maxKeySize())
+}
+
+// checkUsername checks the length of a username
+func (l Limits) checkUsername(field string, username string) error {
+	return checkSize(field, len(username), l.maxUsernameLength())
+}
+
+// checkIdentity checks every size limited field of a registration
+func (l Limits) checkIdentity(i Identity) error {
+	checks := []error{
+		l.checkUsername("username", i.Username),
+		l.checkKey("publicKey", i.PublicKey),
+		l.checkKey("ePublicKey", i.EPublicKey),
+		l.checkKey("sPublicKey", i.SPublicKey),
+		l.checkData("data", i.Data),
+	}
+
+	for _, err := range checks {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getLimits reads the configured limits of the identity,
+// those of its verification level
+func getLimits(stub shim.ChaincodeStubInterface, i Identity) (Limits, error) {
+	c, err := getConfig(stub)
+	if err != nil {
+		return Limits{}, err
+	}
+
+	now, err := txSeconds(stub)
+	if err != nil {
+		return Limits{}, err
+	}
+
+	return c.Limits.forLevel(i.verificationLevel(now)), nil
+}
+
+// SetLimits will change the configured size limits, admin only
+func (t *DewalletChaincode) SetLimits(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Setting size limits")
+
+	err := t.requireAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var l Limits
+	err = json.Unmarshal([]byte(args[0]), &l)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't parse limits %s", err))
+	}
+	err = l.validate()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	c, err := getConfig(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	c.Limits = l
+
+	err = putConfig(stub, c)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	lBytes, _ := marshal(l)
+
+	return shim.Success(lBytes)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// TestInitValidatesLimits checks Init refuses the limits SetLimits refuses
+func TestInitValidatesLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits Limits
+		err    string
+	}{
+		{
+			name:   "valid",
+			limits: Limits{MaxGrants: 2, GrantEviction: grantEvictionExpiry},
+		},
+		{
+			name:   "negative",
+			limits: Limits{MaxGrants: -1},
+			err:    "can't be negative",
+		},
+		{
+			name:   "unsupported grant eviction",
+			limits: Limits{GrantEviction: "random"},
+			err:    "Unsupported grant eviction",
+		},
+		{
+			name:   "unsupported verification level",
+			limits: Limits{ByLevel: map[string]Limits{"unknown": {}}},
+			err:    "Unsupported verification level",
+		},
+		{
+			name:   "negative override",
+			limits: Limits{ByLevel: map[string]Limits{verificationBasic: {MaxDataSize: -1}}},
+			err:    "can't be negative",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHarness(t, time.Now().Unix())
+
+			cBytes, err := json.Marshal(Config{Limits: tt.limits})
+			if err != nil {
+				t.Fatal(err)
+			}
+			res := h.stub.MockInit("init", [][]byte{[]byte("init"), cBytes})
+
+			if tt.err == "" {
+				if res.Status != shim.OK {
+					t.Fatalf("Init failed %s", res.Message)
+				}
+				return
+			}
+			if res.Status == shim.OK || !strings.Contains(res.Message, tt.err) {
+				t.Fatalf("Unexpected response %d %s", res.Status, res.Message)
+			}
+		})
+	}
+}
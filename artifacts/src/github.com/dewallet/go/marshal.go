@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+var (
+	jsonMarshaler = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	rawMessage    = reflect.TypeOf(json.RawMessage{})
+)
+
+// marshalChecked caches the result of checking a type
+var marshalChecked sync.Map
+
+// marshal encodes anything written to state or returned in a response,
+// every encoding goes through it so all peers endorse the same bytes:
+// struct fields keep their declaration order, map keys are sorted and
+// types with their own MarshalJSON, which could iterate a map, are refused
+func marshal(v interface{}) ([]byte, error) {
+	err := checkDeterministic(reflect.TypeOf(v))
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(v)
+}
+
+func checkDeterministic(t reflect.Type) error {
+	if t == nil {
+		return nil
+	}
+	if err, ok := marshalChecked.Load(t); ok {
+		if err == nil {
+			return nil
+		}
+		return err.(error)
+	}
+
+	err := visitType(t, map[reflect.Type]bool{})
+	if err != nil {
+		marshalChecked.Store(t, err)
+		return err
+	}
+
+	marshalChecked.Store(t, nil)
+	return nil
+}
+
+// visitType walks the types encoding/json visits, values behind an
+// interface are left to encoding/json which sorts their map keys too
+func visitType(t reflect.Type, seen map[reflect.Type]bool) error {
+	if seen[t] || t == rawMessage {
+		return nil
+	}
+	seen[t] = true
+
+	if t.Implements(jsonMarshaler) || reflect.PtrTo(t).Implements(jsonMarshaler) {
+		return errors.New(fmt.Sprintf("Type %s has its own JSON encoding", t))
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		return visitType(t.Elem(), seen)
+	case reflect.Map:
+		err := visitType(t.Key(), seen)
+		if err != nil {
+			return err
+		}
+		return visitType(t.Elem(), seen)
+	case reflect.Struct:
+		for k := 0; k < t.NumField(); k++ {
+			f := t.Field(k)
+			if f.PkgPath != "" && !f.Anonymous {
+				continue
+			}
+			err := visitType(f.Type, seen)
+			if err != nil {
+				return err
+			}
+		}
+	case reflect.Chan, reflect.Func, reflect.Complex64, reflect.Complex128, reflect.UnsafePointer:
+		return errors.New(fmt.Sprintf("Type %s can't be encoded", t))
+	}
+
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// defaultPageSize and maxPageSize bound the pages of paginated queries
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// pageRequest is embedded in the request of every paginated query,
+// an empty bookmark fetches the first page
+type pageRequest struct {
+	PageSize int32  `json:"pageSize"`
+	Bookmark string `json:"bookmark"`
+}
+
+// size checks the page size of the request
+func (p pageRequest) size() (int32, error) {
+	if p.PageSize == 0 {
+		return defaultPageSize, nil
+	}
+	if p.PageSize < 0 || p.PageSize > maxPageSize {
+		return 0, errors.New(fmt.Sprintf("Page size must be between 1 and %d", maxPageSize))
+	}
+
+	return p.PageSize, nil
+}
+
+// pageResponse is embedded in the response of every paginated query,
+// the bookmark fetches the next page and is empty on the last one
+// TotalFetched counts the records read for the page,
+// results filtered out after reading are counted too
+type pageResponse struct {
+	Bookmark     string `json:"bookmark"`
+	TotalFetched int32  `json:"totalFetched"`
+}
+
+func newPageResponse(m *pb.QueryResponseMetadata) pageResponse {
+	if m == nil {
+		return pageResponse{}
+	}
+
+	return pageResponse{Bookmark: m.Bookmark, TotalFetched: m.FetchedRecordsCount}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Operations of a data patch
+const (
+	patchSet    = "set"
+	patchRemove = "remove"
+	patchAppend = "append"
+)
+
+// transientPatch carries the JSON array of patch values in private data mode,
+// one per operation in the order of the patch
+const transientPatch = "patch"
+
+// entryObjectType prefixes the keys of the entries of append only slots
+const entryObjectType = "entry"
+
+// patchOp changes one slot or one attribute, Hash replaces Value in private data mode
+type patchOp struct {
+	Op        string `json:"op"`
+	Slot      string `json:"slot"`
+	Attribute string `json:"attribute"`
+	Value     string `json:"value"`
+	Hash      string `json:"hash"`
+}
+
+type updateUserDataPatchRequest struct {
+	Username string    `json:"username"`
+	Patch    []patchOp `json:"patch"`
+}
+
+// entryKey is the key of an entry of an append only slot,
+// the sequence is padded so the keys sort in order
+func entryKey(stub shim.ChaincodeStubInterface, username string, slot string, seq int) (string, error) {
+	return stub.CreateCompositeKey(entryObjectType, []string{username, slot, fmt.Sprintf("%010d", seq)})
+}
+
+// patchValues returns the value of every operation, read from the
+// transient map and checked against the signed hashes in private data mode
+func patchValues(stub shim.ChaincodeStubInterface, i Identity, patch []patchOp) ([]string, error) {
+	values := make([]string, len(patch))
+
+	if i.Collection == "" {
+		for k, op := range patch {
+			values[k] = op.Value
+		}
+		return values, nil
+	}
+
+	tm, err := stub.GetTransient()
+	if err != nil {
+		return nil, errors.New("Failed to get transient map")
+	}
+
+	var tValues []string
+	if tBytes, ok := tm[transientPatch]; ok {
+		err = json.Unmarshal(tBytes, &tValues)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Error in parsing transient patch %s", err))
+		}
+	}
+	if len(tValues) != len(patch) {
+		return nil, errors.New("Transient patch must have a value for every operation")
+	}
+
+	for k, op := range patch {
+		if op.Op != patchRemove && sha256Hex([]byte(tValues[k])) != op.Hash {
+			return nil, errors.New(fmt.Sprintf("Transient patch value %d does not match the signed hash", k))
+		}
+		values[k] = tValues[k]
+	}
+
+	return values, nil
+}
+
+// UpdateUserDataPatch will apply a list of operations to the slots and
+// attributes of the user, so only the changed ciphertexts are sent
+func (t *DewalletChaincode) UpdateUserDataPatch(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Patching data of user")
+
+	var r updateUserDataPatchRequest
+	env, err := t.ParseRequest(args, &r)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't parse request %s", err))
+	}
+
+	i, err := getIdentity(stub, r.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = t.VerifyRequest(stub, args, env, i)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't verify signature %s", err))
+	}
+
+	if len(r.Patch) == 0 {
+		return shim.Error("Empty patch")
+	}
+
+	l, err := getLimits(stub, i)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	values, err := patchValues(stub, i, r.Patch)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if i.Attributes == nil {
+		i.Attributes = map[string]Attribute{}
+	}
+
+	for k, op := range r.Patch {
+		if op.Attribute != "" {
+			err = t.patchAttribute(stub, &i, l, op, values[k])
+		} else {
+			err = t.patchSlot(stub, &i, l, op, values[k])
+		}
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Can't apply operation %d %s", k, err))
+		}
+	}
+
+	iBytes, err := putIdentity(stub, i)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(iBytes)
+}
+
+// patchAttribute sets or removes an attribute
+func (t *DewalletChaincode) patchAttribute(stub shim.ChaincodeStubInterface, i *Identity, l Limits, op patchOp, value string) error {
+	if op.Slot != "" {
+		return errors.New("An operation targets a slot or an attribute, not both")
+	}
+	if !attributeNamePattern.MatchString(op.Attribute) {
+		return errors.New(fmt.Sprintf("Invalid attribute name %s", op.Attribute))
+	}
+
+	aKey, err := privateAttributeKey(stub, i.Username, op.Attribute)
+	if err != nil {
+		return err
+	}
+
+	switch op.Op {
+	case patchSet:
+		err = l.checkData(op.Attribute, value)
+		if err != nil {
+			return err
+		}
+
+		a := Attribute{Schema: i.Attributes[op.Attribute].Schema}
+		if i.Collection != "" {
+			err = stub.PutPrivateData(i.Collection, aKey, []byte(value))
+			if err != nil {
+				return err
+			}
+			a.Hash = op.Hash
+		} else {
+			a.Value = value
+			a.Hash = sha256Hex([]byte(value))
+		}
+		i.Attributes[op.Attribute] = a
+	case patchRemove:
+		delete(i.Attributes, op.Attribute)
+
+		if i.Collection != "" {
+			return stub.DelPrivateData(i.Collection, aKey)
+		}
+	default:
+		return errors.New(fmt.Sprintf("Unsupported attribute operation %s", op.Op))
+	}
+
+	return nil
+}
+
+// patchSlot sets or removes a slot, or appends an entry to an append only slot
+func (t *DewalletChaincode) patchSlot(stub shim.ChaincodeStubInterface, i *Identity, l Limits, op patchOp, value string) error {
+	if op.Slot != "" && !slotNamePattern.MatchString(op.Slot) {
+		return errors.New(fmt.Sprintf("Invalid slot name %s", op.Slot))
+	}
+
+	now, err := txSeconds(stub)
+	if err != nil {
+		return err
+	}
+
+	s, ok := i.slot(op.Slot)
+	s.retain(now)
+
+	switch op.Op {
+	case patchSet:
+		if s.AppendOnly {
+			return errors.New(fmt.Sprintf("Slot %s is append only", op.Slot))
+		}
+
+		err := l.checkData("data", value)
+		if err != nil {
+			return err
+		}
+
+		err = putSlotData(stub, *i, op.Slot, []byte(value))
+		if err != nil {
+			return err
+		}
+
+		s.Hash = sha256Hex([]byte(value))
+		s.Pointer = nil
+		if op.Slot == "" {
+			i.Data = ""
+		}
+		i.setSlot(op.Slot, s)
+	case patchRemove:
+		if op.Slot == "" {
+			return errors.New("The unnamed slot can't be removed")
+		}
+		if !ok {
+			return errors.New(fmt.Sprintf("Slot %s not found", op.Slot))
+		}
+		if s.AppendOnly {
+			return errors.New(fmt.Sprintf("Slot %s is append only", op.Slot))
+		}
+
+		err := delSlotData(stub, *i, op.Slot)
+		if err != nil {
+			return err
+		}
+
+		delete(i.Slots, op.Slot)
+	case patchAppend:
+		if op.Slot == "" {
+			return errors.New("The unnamed slot can't be append only")
+		}
+		if ok && !s.AppendOnly {
+			return errors.New(fmt.Sprintf("Slot %s is not append only", op.Slot))
+		}
+
+		err := l.checkData("entry", value)
+		if err != nil {
+			return err
+		}
+
+		eKey, err := entryKey(stub, i.Username, op.Slot, s.Entries)
+		if err != nil {
+			return err
+		}
+
+		if i.Collection != "" {
+			err = putStoredPrivateData(stub, i.Collection, eKey, []byte(value))
+		} else {
+			err = putStoredState(stub, eKey, []byte(value))
+		}
+		if err != nil {
+			return err
+		}
+
+		s.AppendOnly = true
+		s.Entries++
+		i.setSlot(op.Slot, s)
+	default:
+		return errors.New(fmt.Sprintf("Unsupported slot operation %s", op.Op))
+	}
+
+	return nil
+}
+
+// getSlotEntries reads the entries of an append only slot in order
+func getSlotEntries(stub shim.ChaincodeStubInterface, i Identity, slot string, s DataSlot) ([]string, error) {
+	entries := make([]string, 0, s.Entries)
+
+	for seq := 0; seq < s.Entries; seq++ {
+		eKey, err := entryKey(stub, i.Username, slot, seq)
+		if err != nil {
+			return nil, err
+		}
+
+		var e []byte
+		if i.Collection != "" {
+			e, err = getStoredPrivateData(stub, i.Collection, eKey)
+		} else {
+			e, err = getStoredState(stub, eKey)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, string(e))
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// pointerSchemes are the accepted locations of off-chain ciphertexts
+var pointerSchemes = []string{"ipfs", "s3", "https"}
+
+// DataPointer references a ciphertext stored off-chain, such as a bundle
+// of KYC documents, by location, SHA-256 and size in bytes
+type DataPointer struct {
+	URI  string `json:"uri"`
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+}
+
+// validate checks that the pointer is complete and uses a known scheme
+func (p DataPointer) validate() error {
+	u, err := url.Parse(p.URI)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Invalid pointer URI %s", err))
+	}
+	if !containsString(pointerSchemes, u.Scheme) {
+		return errors.New(fmt.Sprintf("Unsupported pointer scheme %s", u.Scheme))
+	}
+	if u.Host == "" && u.Opaque == "" {
+		return errors.New("Pointer URI must name the stored object")
+	}
+
+	h, err := hex.DecodeString(p.Hash)
+	if err != nil || len(h) != 32 {
+		return errors.New("Pointer hash must be a hex SHA-256")
+	}
+
+	if p.Size <= 0 {
+		return errors.New("Pointer size must be positive")
+	}
+
+	return nil
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// orgPolicyObjectType prefixes the access policies orgs set for their identities
+const orgPolicyObjectType = "orgpolicy"
+
+// AccessPolicy restricts who may use an identity, an empty list doesn't restrict
+// QueryMSPs are the orgs allowed to query its public keys
+// GrantOrgs are the orgs the owners of the keys it gives must be registered by
+// GrantMinLevel is the verification level the owners of the keys it gives must have
+type AccessPolicy struct {
+	QueryMSPs     []string `json:"queryMSPs"`
+	GrantOrgs     []string `json:"grantOrgs"`
+	GrantMinLevel string   `json:"grantMinLevel,omitempty"`
+}
+
+// accessPolicy is the policy of the identity, each list it
+// leaves empty is taken from the policy of its org
+func accessPolicy(stub shim.ChaincodeStubInterface, i Identity) (AccessPolicy, error) {
+	p := i.Policy
+	if len(p.QueryMSPs) > 0 && len(p.GrantOrgs) > 0 && p.GrantMinLevel != "" {
+		return p, nil
+	}
+
+	op, err := getOrgPolicy(stub, i.Org)
+	if err != nil {
+		return p, err
+	}
+
+	if len(p.QueryMSPs) == 0 {
+		p.QueryMSPs = op.QueryMSPs
+	}
+	if len(p.GrantOrgs) == 0 {
+		p.GrantOrgs = op.GrantOrgs
+	}
+	if p.GrantMinLevel == "" {
+		p.GrantMinLevel = op.GrantMinLevel
+	}
+
+	return p, nil
+}
+
+func (p AccessPolicy) validate() error {
+	if p.GrantMinLevel != "" && verificationRank(p.GrantMinLevel) < 0 {
+		return errors.New(fmt.Sprintf("Unsupported verification level %s", p.GrantMinLevel))
+	}
+
+	return nil
+}
+
+// checkQueryPolicy checks that the creator of the transaction
+// may query the public keys of the identity
+func checkQueryPolicy(stub shim.ChaincodeStubInterface, i Identity) error {
+	p, err := accessPolicy(stub, i)
+	if err != nil {
+		return err
+	}
+	if len(p.QueryMSPs) == 0 {
+		return nil
+	}
+
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Failed to get MSP ID %s", err))
+	}
+	if !containsString(p.QueryMSPs, mspID) {
+		return errors.New(fmt.Sprintf("MSP %s can't query %s", mspID, i.Username))
+	}
+
+	return nil
+}
+
+// checkGrantPolicy checks that the identity may give a key to owner
+func checkGrantPolicy(stub shim.ChaincodeStubInterface, i Identity, owner string) error {
+	p, err := accessPolicy(stub, i)
+	if err != nil {
+		return err
+	}
+	if len(p.GrantOrgs) == 0 && p.GrantMinLevel == "" {
+		return nil
+	}
+
+	o, err := getIdentity(stub, owner)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Can't get owner %s %s", owner, err))
+	}
+	if len(p.GrantOrgs) > 0 && !containsString(p.GrantOrgs, o.Org) {
+		return errors.New(fmt.Sprintf("Keys can't be given to members of %s", o.Org))
+	}
+
+	now, err := txSeconds(stub)
+	if err != nil {
+		return err
+	}
+	if !atLeast(o.verificationLevel(now), p.GrantMinLevel) {
+		return errors.New(fmt.Sprintf("Keys can only be given to users verified %s", p.GrantMinLevel))
+	}
+
+	return nil
+}
+
+func orgPolicyKey(stub shim.ChaincodeStubInterface, org string) (string, error) {
+	return stub.CreateCompositeKey(orgPolicyObjectType, []string{org})
+}
+
+// getOrgPolicy reads the policy of an org, the empty policy when it has none
+func getOrgPolicy(stub shim.ChaincodeStubInterface, org string) (AccessPolicy, error) {
+	var p AccessPolicy
+	if org == "" {
+		return p, nil
+	}
+
+	key, err := orgPolicyKey(stub, org)
+	if err != nil {
+		return p, err
+	}
+
+	pBytes, err := stub.GetState(key)
+	if err != nil {
+		return p, errors.New("Failed to get state")
+	}
+	if pBytes == nil {
+		return p, nil
+	}
+
+	err = json.Unmarshal(pBytes, &p)
+	if err != nil {
+		return p, errors.New(fmt.Sprintf("Error in parsing policy %s", err))
+	}
+
+	return p, nil
+}
+
+type setAccessPolicyRequest struct {
+	Username string       `json:"username"`
+	Policy   AccessPolicy `json:"policy"`
+}
+
+// SetAccessPolicy will replace the access policy of a user
+func (t *DewalletChaincode) SetAccessPolicy(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Setting access policy of user")
+
+	var r setAccessPolicyRequest
+	env, err := t.ParseRequest(args, &r)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't parse request %s", err))
+	}
+
+	i, err := getIdentity(stub, r.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = t.VerifyRequest(stub, args, env, i)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't verify signature %s", err))
+	}
+
+	err = r.Policy.validate()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	i.Policy = r.Policy
+
+	iBytes, err := putIdentity(stub, i)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(iBytes)
+}
+
+// SetOrgPolicy will replace the access policy of the org of the creator
+// of the transaction, for its identities without a policy of their own,
+// when an admin attribute is configured the creator must have it
+func (t *DewalletChaincode) SetOrgPolicy(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Setting access policy of org")
+
+	c, err := getConfig(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Failed to get MSP ID %s", err))
+	}
+
+	if c.AdminAttribute != "" {
+		err = cid.AssertAttributeValue(stub, c.AdminAttribute, "true")
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Client is not an admin %s", err))
+		}
+	}
+
+	var p AccessPolicy
+	err = json.Unmarshal([]byte(args[0]), &p)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't parse policy %s", err))
+	}
+
+	err = p.validate()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	key, err := orgPolicyKey(stub, mspID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	pBytes, _ := marshal(p)
+	err = stub.PutState(key, pBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(pBytes)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Transient fields carrying the confidential input in private data mode
+const (
+	transientData = "data"
+	transientKey  = "key"
+)
+
+// privateKeyObjectType prefixes the private data keys of wrapped keys
+const privateKeyObjectType = "key"
+
+// sha256Hex is the hex SHA-256 of b
+func sha256Hex(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+// orgCollection names the private data collection of the creator's org
+func orgCollection(stub shim.ChaincodeStubInterface, c Config) (string, error) {
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Failed to get MSP ID %s", err))
+	}
+
+	return c.CollectionPrefix + mspID, nil
+}
+
+// transientField reads a transient field and checks it against the signed hash,
+// the signature of the request covers the hash instead of the value
+func transientField(stub shim.ChaincodeStubInterface, name string, hash string) ([]byte, error) {
+	tm, err := stub.GetTransient()
+	if err != nil {
+		return nil, errors.New("Failed to get transient map")
+	}
+
+	v, ok := tm[name]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Missing transient field %s", name))
+	}
+	if sha256Hex(v) != hash {
+		return nil, errors.New(fmt.Sprintf("Transient field %s does not match the signed hash", name))
+	}
+
+	return v, nil
+}
+
+// privateKeyKey is the private data key of the key wrapped for owner,
+// keys scoped to an attribute or a slot are kept apart from the key of the whole data
+func privateKeyKey(stub shim.ChaincodeStubInterface, username string, owner string, attribute string, slot string) (string, error) {
+	attributes := []string{username, owner}
+	if attribute != "" || slot != "" {
+		attributes = append(attributes, attribute)
+	}
+	if slot != "" {
+		attributes = append(attributes, slot)
+	}
+
+	return stub.CreateCompositeKey(privateKeyObjectType, attributes)
+}
+
+// getPrivateKey reads the key wrapped for owner from the collection of the identity
+func (t *DewalletChaincode) getPrivateKey(stub shim.ChaincodeStubInterface, i Identity, owner string, attribute string, slot string) (string, error) {
+	pKey, err := privateKeyKey(stub, i.Username, owner, attribute, slot)
+	if err != nil {
+		return "", err
+	}
+
+	key, err := stub.GetPrivateData(i.Collection, pKey)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Failed to get private data %s", err))
+	}
+
+	return string(key), nil
+}
+
+// getPrivateUserData reads the data in a slot and the key wrapped for owner
+// from the collection of the identity, the peer must be a member
+func (t *DewalletChaincode) getPrivateUserData(stub shim.ChaincodeStubInterface, i Identity, owner string, slot string) (string, string, error) {
+	dKey, err := privateDataKey(stub, i.Username, slot)
+	if err != nil {
+		return "", "", err
+	}
+
+	data, err := getStoredPrivateData(stub, i.Collection, dKey)
+	if err != nil {
+		return "", "", err
+	}
+
+	key, err := t.getPrivateKey(stub, i, owner, "", slot)
+	if err != nil {
+		return "", "", err
+	}
+
+	return string(data), key, nil
+}
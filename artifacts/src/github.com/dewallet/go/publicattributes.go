@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// publicAttributeObjectType indexes the usernames by the name and value of their public attributes
+const publicAttributeObjectType = "pubattr"
+
+// Public attributes are kept small, they are for discovery, not data
+const (
+	maxPublicAttributes      = 16
+	maxPublicAttributeLength = 256
+)
+
+// checkPublicAttribute checks the name and the value of a public attribute
+func checkPublicAttribute(name string, value string) error {
+	if !attributeNamePattern.MatchString(name) {
+		return errors.New(fmt.Sprintf("Invalid attribute name %s", name))
+	}
+	if value == "" {
+		return errors.New(fmt.Sprintf("Public attribute %s is empty", name))
+	}
+
+	return checkSize(name, len(value), maxPublicAttributeLength)
+}
+
+type updatePublicAttributesRequest struct {
+	Username         string            `json:"username"`
+	Attributes       map[string]string `json:"attributes"`
+	RemoveAttributes []string          `json:"removeAttributes"`
+}
+
+// UpdatePublicAttributes will set or remove some cleartext attributes
+// of the user that anyone can search identities by, such as orgName,
+// country or role, and leave the others untouched
+func (t *DewalletChaincode) UpdatePublicAttributes(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Updating public attributes of user")
+
+	var r updatePublicAttributesRequest
+	env, err := t.ParseRequest(args, &r)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't parse request %s", err))
+	}
+
+	i, err := getIdentity(stub, r.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = t.VerifyRequest(stub, args, env, i)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't verify signature %s", err))
+	}
+
+	prev := i
+	i.PublicAttributes = map[string]string{}
+	for name, value := range prev.PublicAttributes {
+		i.PublicAttributes[name] = value
+	}
+
+	for name, value := range r.Attributes {
+		err = checkPublicAttribute(name, value)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		i.PublicAttributes[name] = value
+	}
+
+	for _, name := range r.RemoveAttributes {
+		delete(i.PublicAttributes, name)
+	}
+
+	if len(i.PublicAttributes) > maxPublicAttributes {
+		return shim.Error(fmt.Sprintf("At most %d public attributes can be set", maxPublicAttributes))
+	}
+
+	err = updateIndexes(stub, prev, i)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	iBytes, err := putIdentity(stub, i)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(iBytes)
+}
+
+type queryByAttributeRequest struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+
+	pageRequest
+	fieldMask
+}
+
+// QueryByAttribute will page through the identities
+// having a public attribute with the value
+func (t *DewalletChaincode) QueryByAttribute(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Querying identities by public attribute")
+
+	var req queryByAttributeRequest
+	json.Unmarshal([]byte(args[0]), &req)
+
+	err := checkPublicAttribute(req.Name, req.Value)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = req.check(identitySummary{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	size, err := req.size()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	it, m, err := stub.GetStateByPartialCompositeKeyWithPagination(publicAttributeObjectType, []string{req.Name, req.Value}, size, req.Bookmark)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't query identities %s", err))
+	}
+	defer it.Close()
+
+	identities := []Identity{}
+	for it.HasNext() {
+		kv, err := it.Next()
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Can't query identities %s", err))
+		}
+
+		_, attrs, err := stub.SplitCompositeKey(kv.Key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		i, err := getIdentity(stub, attrs[2])
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Can't get %s %s", attrs[2], err))
+		}
+
+		identities = append(identities, i)
+	}
+
+	return identityPage(identities, m, req.fieldMask)
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// purposeObjectType prefixes the registered purposes
+const purposeObjectType = "purpose"
+
+// purposeCodePattern restricts purpose codes such as marketing, credit-check or AML
+var purposeCodePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Purpose is a registered reason for which data can be disclosed
+type Purpose struct {
+	Code        string `json:"code"`
+	Description string `json:"description"`
+}
+
+func purposeKey(stub shim.ChaincodeStubInterface, code string) (string, error) {
+	return stub.CreateCompositeKey(purposeObjectType, []string{code})
+}
+
+// getPurpose reads a registered purpose, nil when it isn't registered
+func getPurpose(stub shim.ChaincodeStubInterface, code string) (*Purpose, error) {
+	key, err := purposeKey(stub, code)
+	if err != nil {
+		return nil, err
+	}
+
+	pBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get state")
+	}
+	if pBytes == nil {
+		return nil, nil
+	}
+
+	var p Purpose
+	err = json.Unmarshal(pBytes, &p)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error in parsing purpose %s", err))
+	}
+
+	return &p, nil
+}
+
+// checkPurpose checks the purpose of a grant or a request, when the
+// configuration requires purposes it must be a registered code
+func checkPurpose(stub shim.ChaincodeStubInterface, code string) error {
+	c, err := getConfig(stub)
+	if err != nil {
+		return err
+	}
+	if !c.RequirePurpose {
+		return nil
+	}
+
+	if code == "" {
+		return errors.New("Missing purpose")
+	}
+
+	p, err := getPurpose(stub, code)
+	if err != nil {
+		return err
+	}
+	if p == nil {
+		return errors.New(fmt.Sprintf("Purpose %s is not registered", code))
+	}
+
+	return nil
+}
+
+// RegisterPurpose will add or describe again a purpose code, admin only
+func (t *DewalletChaincode) RegisterPurpose(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Registering a purpose")
+
+	err := t.requireAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var p Purpose
+	json.Unmarshal([]byte(args[0]), &p)
+
+	if !purposeCodePattern.MatchString(p.Code) {
+		return shim.Error(fmt.Sprintf("Invalid purpose code %s", p.Code))
+	}
+
+	key, err := purposeKey(stub, p.Code)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	pBytes, _ := marshal(p)
+	err = stub.PutState(key, pBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(pBytes)
+}
+
+// GetPurposes will query the blockchain
+// and return every registered purpose
+func (t *DewalletChaincode) GetPurposes(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Querying purposes")
+
+	it, err := stub.GetStateByPartialCompositeKey(purposeObjectType, []string{})
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't query purposes %s", err))
+	}
+	defer it.Close()
+
+	res := []Purpose{}
+	for it.HasNext() {
+		kv, err := it.Next()
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Can't query purposes %s", err))
+		}
+
+		var p Purpose
+		err = json.Unmarshal(kv.Value, &p)
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Error in parsing purpose %s", err))
+		}
+
+		res = append(res, p)
+	}
+
+	resBytes, _ := marshal(res)
+
+	return shim.Success(resBytes)
+}
+
+type revokePurposeRequest struct {
+	Username string `json:"username"`
+	Purpose  string `json:"purpose"`
+}
+
+type revokePurposeResponse struct {
+	Keys     []Key    `json:"keys"`
+	Consents []string `json:"consents"`
+}
+
+// RevokePurpose will remove every key the user gave for a purpose
+// and withdraw every consent it gave for it
+func (t *DewalletChaincode) RevokePurpose(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Revoking grants of user for a purpose")
+
+	var r revokePurposeRequest
+	env, err := t.ParseRequest(args, &r)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't parse request %s", err))
+	}
+
+	i, err := getIdentity(stub, r.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = t.VerifyRequest(stub, args, env, i)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't verify signature %s", err))
+	}
+
+	if r.Purpose == "" {
+		return shim.Error("Missing purpose")
+	}
+
+	now, err := txSeconds(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	prev := i
+	removed, err := removeKeys(stub, &i, func(k Key) bool { return k.Purpose == r.Purpose })
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if len(removed) > 0 {
+		err = updateIndexes(stub, prev, i)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		_, err = putIdentity(stub, i)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		revoked := []revokedGrant{}
+		for _, k := range removed {
+			revoked = append(revoked, revokedGrant{Username: i.Username, Owner: k.Owner, Attribute: k.Attribute, Slot: k.Slot, Purpose: k.Purpose, ConsentID: k.ConsentID})
+		}
+
+		err = emitEvent(stub, &GrantsRevokedEvent{Grants: revoked})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	it, err := stub.GetStateByPartialCompositeKey(consentObjectType, []string{i.Username})
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't query consents %s", err))
+	}
+	defer it.Close()
+
+	withdrawn := []Consent{}
+	for it.HasNext() {
+		kv, err := it.Next()
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Can't query consents %s", err))
+		}
+
+		var c Consent
+		err = json.Unmarshal(kv.Value, &c)
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Error in parsing consent %s", err))
+		}
+
+		if c.Purpose == r.Purpose && c.Status == consentGiven {
+			withdrawn = append(withdrawn, c)
+		}
+	}
+
+	res := revokePurposeResponse{Keys: removed, Consents: []string{}}
+	for _, c := range withdrawn {
+		c.Status = consentWithdrawn
+		c.WithdrawnAt = now
+
+		err = putConsent(stub, c)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		res.Consents = append(res.Consents, c.ID)
+	}
+
+	resBytes, _ := marshal(res)
+
+	return shim.Success(resBytes)
+}
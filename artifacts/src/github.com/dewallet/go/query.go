@@ -0,0 +1,321 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// identityDocType tells identities apart from other documents in CouchDB
+const identityDocType = "identity"
+
+// compositeKeyNamespace starts every composite key, identities are the simple keys
+const compositeKeyNamespace = "\x00"
+
+// identitySummary is what queries return about an identity
+type identitySummary struct {
+	Username string `json:"username"`
+	Org      string `json:"org"`
+	Verified string `json:"verified"`
+
+	Registered int64 `json:"registered"`
+
+	PublicAttributes map[string]string `json:"publicAttributes"`
+}
+
+func newIdentitySummary(i Identity) identitySummary {
+	return identitySummary{
+		Username:   i.Username,
+		Org:        i.Org,
+		Verified:   i.Verified,
+		Registered: i.Registered,
+
+		PublicAttributes: i.PublicAttributes,
+	}
+}
+
+// richQueries tells whether identities are stored as JSON CouchDB can select on
+func richQueries(c Config) bool {
+	return c.ContentEncoding == "" && (c.StateEncoding == "" || c.StateEncoding == stateEncodingJSON)
+}
+
+// queryIdentities returns the identities matching the CouchDB selector,
+// on LevelDB or with encoded state every identity is scanned with match instead
+func queryIdentities(stub shim.ChaincodeStubInterface, selector map[string]interface{}, match func(Identity) bool) ([]Identity, error) {
+	c, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	if richQueries(c) {
+		selector["docType"] = identityDocType
+		q, _ := marshal(map[string]interface{}{"selector": selector})
+
+		it, err := stub.GetQueryResult(string(q))
+		if err == nil {
+			defer it.Close()
+			return collectIdentities(stub, it, match)
+		}
+
+		// LevelDB doesn't support rich queries
+		logger.Debugf("Rich query failed, scanning state %s", err)
+	}
+
+	it, err := stub.GetStateByRange("", "")
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	return collectIdentities(stub, it, match)
+}
+
+// collectIdentities decodes the identities of an iterator that match
+func collectIdentities(stub shim.ChaincodeStubInterface, it shim.StateQueryIteratorInterface, match func(Identity) bool) ([]Identity, error) {
+	identities := []Identity{}
+
+	for it.HasNext() {
+		kv, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(kv.Key, compositeKeyNamespace) {
+			continue
+		}
+
+		value, err := decompress(kv.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		i, err := decodeIdentity(value)
+		if err != nil {
+			return nil, err
+		}
+
+		err = loadKeyRecords(stub, &i)
+		if err != nil {
+			return nil, err
+		}
+
+		if match(i) {
+			identities = append(identities, i)
+		}
+	}
+
+	return identities, nil
+}
+
+type queryIdentitiesRequest struct {
+	Verified string `json:"verified"`
+	Org      string `json:"org"`
+
+	pageRequest
+	fieldMask
+}
+
+// QueryIdentities will page through the identities with a verification
+// status or registered by an org, admin only. On LevelDB the identities
+// with a status are read from the verification index, the others from the
+// range of identities, a page may then have fewer identities than its size
+func (t *DewalletChaincode) QueryIdentities(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Querying identities")
+
+	err := t.requireAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var req queryIdentitiesRequest
+	json.Unmarshal([]byte(args[0]), &req)
+
+	err = req.check(identitySummary{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	size, err := req.size()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	match := func(i Identity) bool {
+		return (req.Verified == "" || i.Verified == req.Verified) && (req.Org == "" || i.Org == req.Org)
+	}
+
+	c, err := getConfig(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if richQueries(c) {
+		selector := map[string]interface{}{"docType": identityDocType}
+		if req.Verified != "" {
+			selector["verified"] = req.Verified
+		}
+		if req.Org != "" {
+			selector["org"] = req.Org
+		}
+		q, _ := marshal(map[string]interface{}{"selector": selector})
+
+		it, m, err := stub.GetQueryResultWithPagination(string(q), size, req.Bookmark)
+		if err == nil {
+			defer it.Close()
+
+			identities, err := collectIdentities(stub, it, match)
+			if err != nil {
+				return shim.Error(fmt.Sprintf("Can't query identities %s", err))
+			}
+
+			return identityPage(identities, m, req.fieldMask)
+		}
+
+		// LevelDB doesn't support rich queries
+		logger.Debugf("Rich query failed, paging through state %s", err)
+	}
+
+	if req.Verified != "" {
+		identities, m, err := verifiedIdentities(stub, req.Verified, size, req.Bookmark, match)
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Can't query identities %s", err))
+		}
+
+		return identityPage(identities, m, req.fieldMask)
+	}
+
+	it, m, err := stub.GetStateByRangeWithPagination("", "", size, req.Bookmark)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't query identities %s", err))
+	}
+	defer it.Close()
+
+	identities, err := collectIdentities(stub, it, match)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't query identities %s", err))
+	}
+
+	return identityPage(identities, m, req.fieldMask)
+}
+
+type listIdentitiesRequest struct {
+	pageRequest
+	fieldMask
+}
+
+// listIdentitiesResponse are identity summaries trimmed to the field mask
+type listIdentitiesResponse struct {
+	Identities []json.RawMessage `json:"identities"`
+
+	pageResponse
+}
+
+// ListIdentities will page through the registered identities,
+// the bookmark of the response fetches the next page, admin only
+func (t *DewalletChaincode) ListIdentities(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Listing identities")
+
+	err := t.requireAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var req listIdentitiesRequest
+	json.Unmarshal([]byte(args[0]), &req)
+
+	err = req.check(identitySummary{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	size, err := req.size()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	it, m, err := stub.GetStateByRangeWithPagination("", "", size, req.Bookmark)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't list identities %s", err))
+	}
+	defer it.Close()
+
+	identities, err := collectIdentities(stub, it, func(Identity) bool { return true })
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't list identities %s", err))
+	}
+
+	return identityPage(identities, m, req.fieldMask)
+}
+
+// identityPage is the response of a paginated identity query
+func identityPage(identities []Identity, m *pb.QueryResponseMetadata, mask fieldMask) pb.Response {
+	res := listIdentitiesResponse{Identities: []json.RawMessage{}, pageResponse: newPageResponse(m)}
+	for _, i := range identities {
+		s, err := mask.apply(newIdentitySummary(i))
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		res.Identities = append(res.Identities, s)
+	}
+
+	resBytes, _ := marshal(res)
+
+	return shim.Success(resBytes)
+}
+
+type searchUsernamesRequest struct {
+	Prefix string `json:"prefix"`
+
+	pageRequest
+}
+
+type searchUsernamesResponse struct {
+	Usernames []string `json:"usernames"`
+
+	pageResponse
+}
+
+// SearchUsernames will page through the usernames starting with a prefix,
+// only the keys are read so nothing of the identities is returned
+func (t *DewalletChaincode) SearchUsernames(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Searching usernames")
+
+	var req searchUsernamesRequest
+	json.Unmarshal([]byte(args[0]), &req)
+
+	size, err := req.size()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	endKey := ""
+	if req.Prefix != "" {
+		endKey = req.Prefix + string(utf8.MaxRune)
+	}
+
+	it, m, err := stub.GetStateByRangeWithPagination(req.Prefix, endKey, size, req.Bookmark)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't search usernames %s", err))
+	}
+	defer it.Close()
+
+	res := searchUsernamesResponse{Usernames: []string{}, pageResponse: newPageResponse(m)}
+	for it.HasNext() {
+		kv, err := it.Next()
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Can't search usernames %s", err))
+		}
+		if strings.HasPrefix(kv.Key, compositeKeyNamespace) {
+			continue
+		}
+
+		res.Usernames = append(res.Usernames, kv.Key)
+	}
+
+	resBytes, _ := marshal(res)
+
+	return shim.Success(resBytes)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// TestQueryIdentitiesPages checks QueryIdentities pages through the
+// identities when rich queries aren't supported
+func TestQueryIdentitiesPages(t *testing.T) {
+	h := newHarness(t, time.Now().Unix())
+	h.init(Config{AdminMSPs: []string{harnessOrg}})
+
+	for _, username := range []string{"alice", "bob", "carol"} {
+		h.register(newFixture(t, username))
+	}
+
+	for _, query := range []queryIdentitiesRequest{{Org: harnessOrg}, {Verified: verificationNone}} {
+		usernames := []string{}
+		query.PageSize = 2
+		for pages := 0; pages < 2; pages++ {
+			var res struct {
+				Identities []identitySummary `json:"identities"`
+				pageResponse
+			}
+			qBytes, err := json.Marshal(query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = json.Unmarshal(h.mustInvoke("QueryIdentities", string(qBytes)), &res)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, i := range res.Identities {
+				usernames = append(usernames, i.Username)
+			}
+			query.Bookmark = res.Bookmark
+		}
+
+		if len(usernames) != 3 || query.Bookmark != "" {
+			t.Fatalf("Query %+v listed %v", query, usernames)
+		}
+	}
+}
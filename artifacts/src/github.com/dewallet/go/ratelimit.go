@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// rateLimitObjectType prefixes the recent calls of a function by an identity
+const rateLimitObjectType = "ratelimit"
+
+// maxRateLimit bounds Max, every call of the window is kept
+const maxRateLimit = 1000
+
+// RateLimit allows Max calls of a function within the last Window seconds
+type RateLimit struct {
+	Max    int   `json:"max"`
+	Window int64 `json:"window"`
+}
+
+func (r RateLimit) validate(function string) error {
+	if r.Max < 1 || r.Max > maxRateLimit {
+		return errors.New(fmt.Sprintf("Rate limit of %s must allow 1 to %d calls", function, maxRateLimit))
+	}
+	if r.Window < 1 {
+		return errors.New(fmt.Sprintf("Rate limit of %s has no window", function))
+	}
+
+	return nil
+}
+
+// checkRateLimit records the call of a function by the identities
+// VerifyRequest authenticated since the last check, once each, and returns
+// a structured error once one made more calls than the configured rate limit
+// allows in its rolling window. It runs after the handler, the transaction
+// fails as a whole, only committed transactions are counted
+func (t *DewalletChaincode) checkRateLimit(stub shim.ChaincodeStubInterface, function string) error {
+	cache, ok := invocationCache(stub)
+	if !ok {
+		return nil
+	}
+
+	usernames := cache.authenticated
+	cache.authenticated = nil
+
+	c, err := getConfig(stub)
+	if err != nil {
+		return err
+	}
+
+	limit, ok := c.RateLimits[function]
+	if !ok {
+		return nil
+	}
+
+	checked := map[string]bool{}
+	for _, username := range usernames {
+		if checked[username] {
+			continue
+		}
+		checked[username] = true
+
+		err = limit.record(stub, function, username)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// record adds a call of the function by username to its rolling window
+func (r RateLimit) record(stub shim.ChaincodeStubInterface, function string, username string) error {
+	now, err := txSeconds(stub)
+	if err != nil {
+		return err
+	}
+
+	key, err := stub.CreateCompositeKey(rateLimitObjectType, []string{username, function})
+	if err != nil {
+		return err
+	}
+
+	cBytes, err := stub.GetState(key)
+	if err != nil {
+		return errors.New("Failed to get state")
+	}
+
+	var calls []int64
+	if cBytes != nil {
+		err = json.Unmarshal(cBytes, &calls)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Error in parsing rate limit %s", err))
+		}
+	}
+
+	recent := []int64{}
+	for _, call := range calls {
+		if call > now-r.Window {
+			recent = append(recent, call)
+		}
+	}
+
+	if len(recent) >= r.Max {
+		return structuredError{
+			Code:       errorCodeThrottled,
+			Message:    fmt.Sprintf("%s called %s %d times in %d seconds", username, function, len(recent), r.Window),
+			Field:      function,
+			Limit:      r.Max,
+			Size:       len(recent),
+			RetryAfter: recent[len(recent)-r.Max] + r.Window - now,
+		}
+	}
+
+	cBytes, _ = marshal(append(recent, now))
+	return stub.PutState(key, cBytes)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRateLimitAuthenticated checks the calls are counted for the identity
+// whose signature was verified, whatever the payload names
+func TestRateLimitAuthenticated(t *testing.T) {
+	h := newHarness(t, time.Now().Unix())
+	h.init(Config{RateLimits: map[string]RateLimit{"AddKey": {Max: 1, Window: 3600}}})
+
+	alice := newFixture(t, "alice")
+	h.register(alice)
+	h.register(newFixture(t, "bob"))
+
+	h.mustInvoke("AddKey", alice.request(t, h.now, addKeyRequest{Username: "alice", Owner: "bob", Key: "k1"})...)
+
+	res := h.invoke("AddKey", alice.request(t, h.now, addKeyRequest{Username: "alice", Owner: "bob", Key: "k2"})...)
+	if !strings.Contains(res.Message, errorCodeThrottled) {
+		t.Fatalf("Second call wasn't throttled %s", res.Message)
+	}
+
+	// a request failing its signature isn't counted for the user it names
+	h.now += 3600
+	mallory := newFixture(t, "alice")
+	h.invoke("AddKey", mallory.request(t, h.now, addKeyRequest{Username: "alice", Owner: "bob", Key: "k3"})...)
+
+	h.mustInvoke("AddKey", alice.request(t, h.now, addKeyRequest{Username: "alice", Owner: "bob", Key: "k4"})...)
+}
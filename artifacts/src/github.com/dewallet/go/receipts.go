@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// receiptObjectType prefixes the verification receipts by username and verifier
+const receiptObjectType = "verificationreceipt"
+
+// receiptSignature is what a verifier sends along a verification to leave
+// a receipt, the signature is over receiptMessage of the verification
+// with IssuedAt, which is within the accepted window of the transaction
+type receiptSignature struct {
+	IssuedAt          int64  `json:"issuedAt"`
+	Alg               string `json:"alg"`
+	KeyID             string `json:"keyId"`
+	Signature         string `json:"signature"`
+	SignatureEncoding string `json:"signatureEncoding"`
+}
+
+// VerificationReceipt is the portable proof of a verification, signed by the
+// verifier over receiptMessage so systems outside the channel can check it
+// against the verifier's key in its DID document
+type VerificationReceipt struct {
+	Subject      string `json:"subject"`
+	Verifier     string `json:"verifier"`
+	Level        string `json:"level"`
+	EvidenceHash string `json:"evidenceHash"`
+	Provider     string `json:"provider,omitempty"`
+	IssuedAt     int64  `json:"issuedAt"`
+	ExpiresAt    int64  `json:"expiresAt"`
+
+	Alg               string `json:"alg"`
+	KeyID             string `json:"keyId"`
+	Signature         string `json:"signature"`
+	SignatureEncoding string `json:"signatureEncoding"`
+	TxID              string `json:"txId"`
+}
+
+// receiptMessage is the canonical payload a verifier signs for a receipt,
+// subject and verifier are DIDs
+func receiptMessage(rc VerificationReceipt) []byte {
+	m, _ := marshal(struct {
+		Subject      string `json:"subject"`
+		Verifier     string `json:"verifier"`
+		Level        string `json:"level"`
+		EvidenceHash string `json:"evidenceHash"`
+		Provider     string `json:"provider"`
+		IssuedAt     int64  `json:"issuedAt"`
+		ExpiresAt    int64  `json:"expiresAt"`
+	}{rc.Subject, rc.Verifier, rc.Level, rc.EvidenceHash, rc.Provider, rc.IssuedAt, rc.ExpiresAt})
+
+	return m
+}
+
+func receiptKey(stub shim.ChaincodeStubInterface, username string, verifier string) (string, error) {
+	return stub.CreateCompositeKey(receiptObjectType, []string{username, verifier})
+}
+
+// putReceipt stores the receipt of the verification v of username signed by
+// the verifier vi, or drops the previous one when the verifier left none
+// so a receipt never outlives the verification it is for
+func putReceipt(stub shim.ChaincodeStubInterface, username string, vi Identity, v Verification, s *receiptSignature) error {
+	key, err := receiptKey(stub, username, vi.Username)
+	if err != nil {
+		return err
+	}
+
+	if s == nil {
+		return stub.DelState(key)
+	}
+
+	now, err := txSeconds(stub)
+	if err != nil {
+		return err
+	}
+	if s.IssuedAt < now-envelopeMaxSkew || s.IssuedAt > now+envelopeMaxSkew {
+		return errors.New("Receipt issuance is outside the accepted window")
+	}
+
+	rc := VerificationReceipt{
+		Subject:           didOf(stub, username),
+		Verifier:          didOf(stub, vi.Username),
+		Level:             v.Level,
+		EvidenceHash:      v.EvidenceHash,
+		Provider:          v.Provider,
+		IssuedAt:          s.IssuedAt,
+		ExpiresAt:         v.ExpiresAt,
+		Alg:               s.Alg,
+		KeyID:             s.KeyID,
+		Signature:         s.Signature,
+		SignatureEncoding: s.SignatureEncoding,
+		TxID:              stub.GetTxID(),
+	}
+
+	publicKey, err := vi.signingKey(rc.KeyID)
+	if err != nil {
+		return err
+	}
+
+	sig, err := decodeSignature(rc.Signature, rc.SignatureEncoding)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error in decoding receipt signature %s", err))
+	}
+
+	// the verifier signs the expiry the verification ends up with
+	err = verifyWithAlgorithm(stub, rc.Alg, publicKey, receiptMessage(rc), sig)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Can't verify receipt %s", err))
+	}
+
+	rcBytes, _ := marshal(rc)
+	return stub.PutState(key, rcBytes)
+}
+
+type getVerificationReceiptsRequest struct {
+	Username string `json:"username"`
+	Token    string `json:"token"`
+}
+
+// GetVerificationReceipts will query the blockchain and return the receipts
+// of the verifications of a user, the user proves itself with a session token
+func (t *DewalletChaincode) GetVerificationReceipts(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Querying verification receipts of user")
+
+	var req getVerificationReceiptsRequest
+	json.Unmarshal([]byte(args[0]), &req)
+
+	err := resolveHandles(stub, &req.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = t.VerifySession(stub, req.Token, req.Username)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't verify session %s", err))
+	}
+
+	it, err := stub.GetStateByPartialCompositeKey(receiptObjectType, []string{req.Username})
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't query receipts %s", err))
+	}
+	defer it.Close()
+
+	res := []VerificationReceipt{}
+	for it.HasNext() {
+		kv, err := it.Next()
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Can't query receipts %s", err))
+		}
+
+		var rc VerificationReceipt
+		err = json.Unmarshal(kv.Value, &rc)
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Error in parsing receipt %s", err))
+		}
+
+		res = append(res, rc)
+	}
+
+	resBytes, _ := marshal(res)
+
+	return shim.Success(resBytes)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// txSeconds is the timestamp of the transaction in unix seconds
+func txSeconds(stub shim.ChaincodeStubInterface) (int64, error) {
+	ts, err := stub.GetTxTimestamp()
+	if err != nil {
+		return 0, errors.New("Failed to get transaction timestamp")
+	}
+
+	return ts.Seconds, nil
+}
+
+// expired tells whether the retention period of the slot is over
+func (s DataSlot) expired(now int64) bool {
+	return s.ExpiresAt != 0 && now >= s.ExpiresAt
+}
+
+// retain restarts the retention period of a slot being written
+func (s *DataSlot) retain(now int64) {
+	if s.Retention > 0 {
+		s.ExpiresAt = now + s.Retention
+	} else {
+		s.ExpiresAt = 0
+	}
+}
+
+// purgeSlot deletes the ciphertext, the entries and the keys of a slot
+func purgeSlot(stub shim.ChaincodeStubInterface, i *Identity, name string) error {
+	s, _ := i.slot(name)
+
+	err := delSlotData(stub, *i, name)
+	if err != nil {
+		return err
+	}
+
+	for seq := 0; seq < s.Entries; seq++ {
+		eKey, err := entryKey(stub, i.Username, name, seq)
+		if err != nil {
+			return err
+		}
+
+		if i.Collection != "" {
+			err = stub.DelPrivateData(i.Collection, eKey)
+		} else {
+			err = stub.DelState(eKey)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	keys := []Key{}
+	for _, k := range i.Keys {
+		if k.Attribute != "" || k.Slot != name {
+			keys = append(keys, k)
+			continue
+		}
+
+		if i.Collection != "" {
+			pKey, err := privateKeyKey(stub, i.Username, k.Owner, "", name)
+			if err != nil {
+				return err
+			}
+
+			err = stub.DelPrivateData(i.Collection, pKey)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	i.Keys = keys
+
+	if name == "" {
+		i.Data = ""
+		i.setSlot(name, DataSlot{})
+	} else {
+		delete(i.Slots, name)
+	}
+
+	return nil
+}
+
+// purgeExpired purges the expired slots of the identity and returns their names
+func purgeExpired(stub shim.ChaincodeStubInterface, i *Identity, now int64) ([]string, error) {
+	names := []string{""}
+	for name := range i.Slots {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	purged := []string{}
+	for _, name := range names {
+		s, _ := i.slot(name)
+		if !s.expired(now) {
+			continue
+		}
+
+		err := purgeSlot(stub, i, name)
+		if err != nil {
+			return nil, err
+		}
+		purged = append(purged, name)
+	}
+
+	return purged, nil
+}
+
+type purgeExpiredDataRequest struct {
+	Usernames []string `json:"usernames"`
+}
+
+// PurgeExpiredData will delete the data slots whose retention period
+// is over, of the given users or of every user, admin only
+func (t *DewalletChaincode) PurgeExpiredData(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Purging expired data")
+
+	err := t.requireAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var req purgeExpiredDataRequest
+	json.Unmarshal([]byte(args[0]), &req)
+
+	now, err := txSeconds(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var identities []Identity
+	if len(req.Usernames) > 0 {
+		for _, username := range req.Usernames {
+			i, err := getIdentity(stub, username)
+			if err != nil {
+				return shim.Error(fmt.Sprintf("Can't get %s %s", username, err))
+			}
+			identities = append(identities, i)
+		}
+	} else {
+		identities, err = queryIdentities(stub, map[string]interface{}{}, func(Identity) bool { return true })
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Can't query identities %s", err))
+		}
+	}
+
+	res := map[string][]string{}
+	for _, i := range identities {
+		prev := i
+		purged, err := purgeExpired(stub, &i, now)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if len(purged) == 0 {
+			continue
+		}
+
+		err = updateIndexes(stub, prev, i)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		err = auditCreator(stub, i.Username)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		_, err = putIdentity(stub, i)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		res[i.Username] = purged
+	}
+
+	resBytes, _ := marshal(res)
+
+	return shim.Success(resBytes)
+}
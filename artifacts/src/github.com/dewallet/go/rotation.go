@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+type rotateKeysRequest struct {
+	Username   string `json:"username"`
+	PublicKey  string `json:"publicKey"`
+	EPublicKey string `json:"ePublicKey"`
+	SPublicKey string `json:"sPublicKey"`
+	QPublicKey string `json:"qPublicKey"`
+	QAlgorithm string `json:"qAlgorithm"`
+
+	CertificateChain []string `json:"certificateChain"`
+
+	// Proof is the signature of rotationProofMessage with the new signing key,
+	// under ProofAlg and encoded as the envelope declares
+	Proof    string `json:"proof"`
+	ProofAlg string `json:"proofAlg"`
+
+	// QProof is the signature of rotationProofMessage with the new
+	// post-quantum key, required with a QPublicKey
+	QProof string `json:"qProof"`
+}
+
+// rotationProofMessage is what the new signing key signs to prove
+// its possession, bound to the username and the new keys
+func rotationProofMessage(i Identity) []byte {
+	return []byte(strings.Join([]string{"rotate", i.Username, rotationHash(i)}, "\n"))
+}
+
+// RotateKeys will replace the keys of the user, the request is signed by
+// its registered signing key, one of its guardians approves it when it has
+// some, and the new signing key proves its possession.
+// Everything else of the identity, its grants included, is kept
+func (t *DewalletChaincode) RotateKeys(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Rotating keys of user")
+
+	var r rotateKeysRequest
+	env, err := t.ParseRequest(args, &r)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't parse request %s", err))
+	}
+
+	prev, err := getIdentity(stub, r.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// the verification status is kept, the registered key
+	// authenticates the rotation, see checkKeyRotation
+	i := prev
+	i.PublicKey = r.PublicKey
+	i.EPublicKey = r.EPublicKey
+	i.SPublicKey = r.SPublicKey
+	i.QPublicKey = r.QPublicKey
+	i.QAlgorithm = r.QAlgorithm
+	i.CertificateChain = r.CertificateChain
+
+	err = validateIdentityKeys(i)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Invalid key %s", err))
+	}
+
+	err = checkCertificateChain(stub, i)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = verifyRotationProof(stub, env, r, i)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't verify proof of possession %s", err))
+	}
+
+	err = t.checkKeyRotation(stub, args, env, prev, i)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't rotate keys %s", err))
+	}
+
+	err = updateIndexes(stub, prev, i)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	iBytes, err := putIdentity(stub, i)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if rotationHash(prev) != rotationHash(i) {
+		err = emitEvent(stub, &KeyRotatedEvent{Username: i.Username, Previous: keyFingerprints(prev), Current: keyFingerprints(i)})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	return shim.Success(iBytes)
+}
+
+// verifyRotationProof checks the proof of possession of the new signing key
+// and of the new post-quantum key
+func verifyRotationProof(stub shim.ChaincodeStubInterface, env signedEnvelope, r rotateKeysRequest, i Identity) error {
+	if r.Proof == "" {
+		return errors.New("Missing proof")
+	}
+
+	// the proofs of a JWS envelope are base64url like the JWS itself
+	encoding := env.SignatureEncoding
+	if encoding == signatureEncodingJWS {
+		encoding = signatureEncodingBase64URL
+	}
+
+	s, err := decodeSignature(r.Proof, encoding)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error in decoding proof %s", err))
+	}
+
+	err = verifyWithAlgorithm(stub, r.ProofAlg, i.SPublicKey, rotationProofMessage(i), s)
+	if err != nil {
+		return err
+	}
+
+	if i.QPublicKey == "" {
+		return nil
+	}
+	if r.QProof == "" {
+		return errors.New("Missing post-quantum proof")
+	}
+
+	qs, err := decodeSignature(r.QProof, encoding)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error in decoding post-quantum proof %s", err))
+	}
+
+	return verifyWithAlgorithm(stub, i.QAlgorithm, i.QPublicKey, rotationProofMessage(i), qs)
+}
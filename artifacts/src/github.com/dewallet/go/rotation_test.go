@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// rotation is the request of f rotating its keys to those of next,
+// with the proof of possession of next
+func rotation(t *testing.T, f *fixture, next *fixture) rotateKeysRequest {
+	i := next.identity()
+	i.Username = f.username
+
+	r := rotateKeysRequest{
+		Username:   f.username,
+		PublicKey:  i.PublicKey,
+		EPublicKey: i.EPublicKey,
+		SPublicKey: i.SPublicKey,
+		QPublicKey: i.QPublicKey,
+		QAlgorithm: i.QAlgorithm,
+		Proof:      next.sign(t, rotationProofMessage(i)),
+		ProofAlg:   "ES256",
+	}
+	if next.qKey != nil {
+		r.QProof = next.qSign(rotationProofMessage(i))
+	}
+
+	return r
+}
+
+// rotatedIdentity is the identity of f with the keys of next
+func rotatedIdentity(t *testing.T, h *harness, f *fixture, next *fixture) Identity {
+	i, err := getIdentity(h.stub, f.username)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i.PublicKey, i.EPublicKey, i.SPublicKey = next.publicKey, next.publicKey, next.publicKey
+	return i
+}
+
+// TestRotateKeys checks RotateKeys replaces the keys of the identity and
+// refuses the rotations not authorized by its registered key and guardians
+func TestRotateKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		// args are the arguments of RotateKeys of alice to next,
+		// bob is another registered user
+		args func(t *testing.T, h *harness, alice *fixture, bob *fixture, next *fixture) []string
+		err  string
+	}{
+		{
+			name: "signed by the registered key",
+			args: func(t *testing.T, h *harness, alice *fixture, bob *fixture, next *fixture) []string {
+				return alice.request(t, h.now, rotation(t, alice, next))
+			},
+		},
+		{
+			name: "stale envelope",
+			args: func(t *testing.T, h *harness, alice *fixture, bob *fixture, next *fixture) []string {
+				return alice.request(t, h.now-3600, rotation(t, alice, next))
+			},
+			err: "outside the accepted window",
+		},
+		{
+			name: "signed by another key",
+			args: func(t *testing.T, h *harness, alice *fixture, bob *fixture, next *fixture) []string {
+				mallory := newFixture(t, "alice")
+				return mallory.request(t, h.now, rotation(t, alice, next))
+			},
+			err: "Can't verify signature",
+		},
+		{
+			name: "signed by a delegate",
+			args: func(t *testing.T, h *harness, alice *fixture, bob *fixture, next *fixture) []string {
+				pBytes, _ := json.Marshal(rotation(t, alice, next))
+				return bob.signEnvelope(t, signedEnvelope{Timestamp: h.now, Delegate: "bob", Payload: pBytes})
+			},
+			err: "delegate",
+		},
+		{
+			name: "without proof of possession",
+			args: func(t *testing.T, h *harness, alice *fixture, bob *fixture, next *fixture) []string {
+				r := rotation(t, alice, next)
+				r.Proof = alice.sign(t, rotationProofMessage(rotatedIdentity(t, h, alice, next)))
+				return alice.request(t, h.now, r)
+			},
+			err: "proof of possession",
+		},
+		{
+			name: "without guardian approval",
+			args: func(t *testing.T, h *harness, alice *fixture, bob *fixture, next *fixture) []string {
+				h.mustInvoke("SetGuardians", alice.request(t, h.now, setGuardiansRequest{Username: "alice", Guardians: []string{"bob"}})...)
+				return alice.request(t, h.now, rotation(t, alice, next))
+			},
+			err: "guardian",
+		},
+		{
+			name: "with guardian approval",
+			args: func(t *testing.T, h *harness, alice *fixture, bob *fixture, next *fixture) []string {
+				h.mustInvoke("SetGuardians", alice.request(t, h.now, setGuardiansRequest{Username: "alice", Guardians: []string{"bob"}})...)
+				h.mustInvoke("ApproveGuardianAction", bob.request(t, h.now, approveGuardianActionRequest{
+					Guardian: "bob",
+					Username: "alice",
+					Action:   guardianActionRotate,
+					Hash:     rotationHash(rotatedIdentity(t, h, alice, next)),
+				})...)
+				return alice.request(t, h.now, rotation(t, alice, next))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHarness(t, time.Now().Unix())
+			alice := newFixture(t, "alice")
+			bob := newFixture(t, "bob")
+			h.register(alice)
+			h.register(bob)
+			next := newFixture(t, "alice")
+
+			res := h.invoke("RotateKeys", tt.args(t, h, alice, bob, next)...)
+
+			i, err := getIdentity(h.stub, "alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.err == "" {
+				if res.Status != shim.OK {
+					t.Fatalf("Rotation failed %s", res.Message)
+				}
+				if i.SPublicKey != next.publicKey {
+					t.Fatal("Keys weren't rotated")
+				}
+
+				// the previous key can't sign for the user anymore
+				res = h.invoke("RotateKeys", alice.request(t, h.now, rotation(t, alice, newFixture(t, "alice")))...)
+				if res.Status == shim.OK {
+					t.Fatal("The previous key still signs for the user")
+				}
+				return
+			}
+
+			if res.Status == shim.OK || !strings.Contains(res.Message, tt.err) {
+				t.Fatalf("Rotation wasn't refused with %q %s", tt.err, res.Message)
+			}
+			if i.SPublicKey != alice.publicKey {
+				t.Fatal("Keys were rotated")
+			}
+		})
+	}
+}
+
+// TestRotateKeysDenylisted checks the keys can't be rotated to a denylisted key
+func TestRotateKeysDenylisted(t *testing.T) {
+	h := newHarness(t, time.Now().Unix())
+	h.init(Config{AdminMSPs: []string{harnessOrg}})
+
+	alice := newFixture(t, "alice")
+	h.register(alice)
+
+	next := newFixture(t, "alice")
+	h.mustInvoke("AddToDenylist", `{"kind":"key","value":"`+keyFingerprint(next.publicKey)+`"}`)
+
+	res := h.invoke("RotateKeys", alice.request(t, h.now, rotation(t, alice, next))...)
+	if res.Status == shim.OK || !strings.Contains(res.Message, "denylisted") {
+		t.Fatalf("Rotation to a denylisted key wasn't refused %s", res.Message)
+	}
+
+	i, err := getIdentity(h.stub, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i.SPublicKey != alice.publicKey {
+		t.Fatal("Keys were rotated")
+	}
+}
+
+// TestRotationHashFields checks a guardian approval is bound to
+// every field RotateKeys replaces
+func TestRotationHashFields(t *testing.T) {
+	i := newFixture(t, "alice").identity()
+
+	for name, change := range map[string]func(i *Identity){
+		"qAlgorithm":       func(i *Identity) { i.QAlgorithm = "ML-DSA-65" },
+		"qPublicKey":       func(i *Identity) { i.QPublicKey = "AAAA" },
+		"certificateChain": func(i *Identity) { i.CertificateChain = []string{"AAAA"} },
+	} {
+		changed := i
+		change(&changed)
+
+		if rotationHash(changed) == rotationHash(i) {
+			t.Errorf("Changing %s keeps the rotation hash", name)
+		}
+	}
+}
+
+// TestGuardiansNonDelegable checks a delegate can't be given the guardian functions
+func TestGuardiansNonDelegable(t *testing.T) {
+	h := newHarness(t, time.Now().Unix())
+	alice := newFixture(t, "alice")
+	h.register(alice)
+	h.register(newFixture(t, "bob"))
+
+	for _, function := range []string{"SetGuardians", "ApproveGuardianAction"} {
+		res := h.invoke("Delegate", alice.request(t, h.now, delegateRequest{Username: "alice", Delegate: "bob", Functions: []string{function}})...)
+		if res.Status == shim.OK {
+			t.Errorf("%s was delegated", function)
+		}
+	}
+}
+
+// TestRotateKeysPostQuantumProof checks a new post-quantum key
+// proves its possession like the new signing key
+func TestRotateKeysPostQuantumProof(t *testing.T) {
+	tests := []struct {
+		name string
+		// proof is the QProof of the rotation of alice to next
+		proof func(r rotateKeysRequest, alice *fixture, next *fixture) string
+		err   string
+	}{
+		{
+			name:  "signed by the new key",
+			proof: func(r rotateKeysRequest, alice *fixture, next *fixture) string { return r.QProof },
+		},
+		{
+			name:  "missing",
+			proof: func(r rotateKeysRequest, alice *fixture, next *fixture) string { return "" },
+			err:   "Missing post-quantum proof",
+		},
+		{
+			name: "signed by another key",
+			proof: func(r rotateKeysRequest, alice *fixture, next *fixture) string {
+				other := newHybridFixture(t, "alice")
+				return other.qSign(rotationProofMessage(Identity{Username: "alice", PublicKey: r.PublicKey}))
+			},
+			err: "proof of possession",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHarness(t, time.Now().Unix())
+			alice := newFixture(t, "alice")
+			h.register(alice)
+			next := newHybridFixture(t, "alice")
+
+			r := rotation(t, alice, next)
+			r.QProof = tt.proof(r, alice, next)
+			res := h.invoke("RotateKeys", alice.request(t, h.now, r)...)
+
+			i, err := getIdentity(h.stub, "alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.err == "" {
+				if res.Status != shim.OK {
+					t.Fatalf("Rotation failed %s", res.Message)
+				}
+				if i.QPublicKey != next.qPublicKey {
+					t.Fatal("Post-quantum key wasn't rotated")
+				}
+				return
+			}
+			if res.Status == shim.OK || !strings.Contains(res.Message, tt.err) {
+				t.Fatalf("Unexpected response %d %s", res.Status, res.Message)
+			}
+			if i.QPublicKey != "" {
+				t.Fatal("Post-quantum key was rotated")
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// schemaObjectType prefixes the state keys of registered schemas
+const schemaObjectType = "schema"
+
+// Schema is a JSON schema of the cleartext user data, registered once
+// per version so clients of the ecosystem agree on the structure
+type Schema struct {
+	ID      string          `json:"id"`
+	Version string          `json:"version"`
+	Schema  json.RawMessage `json:"schema"`
+	Hash    string          `json:"hash"`
+}
+
+// SchemaRef is the schema version an encrypted payload declares to conform to
+type SchemaRef struct {
+	ID      string `json:"id"`
+	Version string `json:"version"`
+}
+
+func schemaKey(stub shim.ChaincodeStubInterface, id string, version string) (string, error) {
+	return stub.CreateCompositeKey(schemaObjectType, []string{id, version})
+}
+
+// getSchema reads a registered schema, nil when it doesn't exist
+func getSchema(stub shim.ChaincodeStubInterface, id string, version string) (*Schema, error) {
+	key, err := schemaKey(stub, id, version)
+	if err != nil {
+		return nil, err
+	}
+
+	sBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get state")
+	}
+	if sBytes == nil {
+		return nil, nil
+	}
+
+	var s Schema
+	json.Unmarshal(sBytes, &s)
+
+	return &s, nil
+}
+
+// validateSchemaRef checks that a declared schema is registered,
+// declaring no schema is allowed
+func validateSchemaRef(stub shim.ChaincodeStubInterface, ref SchemaRef) error {
+	if ref.ID == "" && ref.Version == "" {
+		return nil
+	}
+
+	s, err := getSchema(stub, ref.ID, ref.Version)
+	if err != nil {
+		return err
+	}
+	if s == nil {
+		return errors.New(fmt.Sprintf("Schema %s version %s is not registered", ref.ID, ref.Version))
+	}
+
+	return nil
+}
+
+type registerSchemaRequest struct {
+	ID      string          `json:"id"`
+	Version string          `json:"version"`
+	Schema  json.RawMessage `json:"schema"`
+}
+
+// RegisterSchema will add a version of a data schema, admin only
+func (t *DewalletChaincode) RegisterSchema(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Registering a data schema")
+
+	err := t.requireAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var r registerSchemaRequest
+	json.Unmarshal([]byte(args[0]), &r)
+
+	if r.ID == "" || r.Version == "" {
+		return shim.Error("Schema id and version are required")
+	}
+
+	var doc map[string]interface{}
+	err = json.Unmarshal(r.Schema, &doc)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Schema must be a JSON object %s", err))
+	}
+
+	existing, err := getSchema(stub, r.ID, r.Version)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if existing != nil {
+		return shim.Error(fmt.Sprintf("Schema %s version %s is already registered", r.ID, r.Version))
+	}
+
+	// store the schema compacted so its hash doesn't depend on formatting
+	compact, _ := marshal(doc)
+
+	s := Schema{
+		ID:      r.ID,
+		Version: r.Version,
+		Schema:  compact,
+		Hash:    sha256Hex(compact),
+	}
+
+	key, err := schemaKey(stub, r.ID, r.Version)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	sBytes, _ := marshal(s)
+	err = stub.PutState(key, sBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(sBytes)
+}
+
+type getSchemaRequest struct {
+	ID      string `json:"id"`
+	Version string `json:"version"`
+}
+
+// GetSchema will query the blockchain
+// and return a registered data schema
+func (t *DewalletChaincode) GetSchema(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Querying a data schema")
+
+	var req getSchemaRequest
+	json.Unmarshal([]byte(args[0]), &req)
+
+	s, err := getSchema(stub, req.ID, req.Version)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if s == nil {
+		return shim.Error("Schema not found")
+	}
+
+	sBytes, _ := marshal(s)
+
+	return shim.Success(sBytes)
+}
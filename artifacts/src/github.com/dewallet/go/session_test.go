@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// TestGetUserDataSession checks the data of an owner is only returned
+// to a session of the owner
+func TestGetUserDataSession(t *testing.T) {
+	h := newHarness(t, time.Now().Unix())
+	alice := newFixture(t, "alice")
+	h.register(alice)
+	h.mustInvoke("UpdateUserData", alice.request(t, h.now, updateUserDataRequest{Username: "alice", Slot: "kyc", Data: "secret", DataHash: sha256Hex([]byte("secret"))})...)
+
+	tests := []struct {
+		name string
+		req  getUserDataRequest
+		ok   bool
+		data string
+	}{
+		{name: "session", req: getUserDataRequest{Username: "alice", Slot: "kyc", Owner: "alice", Token: alice.session(t, h.now)}, ok: true, data: "secret"},
+		{name: "no token", req: getUserDataRequest{Username: "alice", Slot: "kyc", Owner: "alice"}},
+		{name: "session of another", req: getUserDataRequest{Username: "alice", Slot: "kyc", Owner: "alice", Token: newFixture(t, "alice").session(t, h.now)}},
+		{name: "expired session", req: getUserDataRequest{Username: "alice", Slot: "kyc", Owner: "alice", Token: alice.session(t, h.now-3600)}},
+		{name: "no owner", req: getUserDataRequest{Username: "alice", Slot: "kyc"}, ok: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rBytes, err := json.Marshal(tt.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res := h.invoke("GetUserData", string(rBytes))
+			if (res.Status == shim.OK) != tt.ok {
+				t.Fatalf("Unexpected status %d %s", res.Status, res.Message)
+			}
+			if !tt.ok {
+				return
+			}
+
+			var r getUserDataResponse
+			err = json.Unmarshal(res.Payload, &r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.Data != tt.data {
+				t.Fatalf("Unexpected data %q", r.Data)
+			}
+		})
+	}
+
+	if res := h.invoke("GetUserData", "{"); res.Status == shim.OK {
+		t.Fatal("A malformed request was accepted")
+	}
+}
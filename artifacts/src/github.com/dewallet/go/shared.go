@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+type getSharedUserDataRequest struct {
+	Owner     string   `json:"owner"`
+	Token     string   `json:"token"`
+	Slot      string   `json:"slot"`
+	Usernames []string `json:"usernames"`
+	Purpose   string   `json:"purpose"`
+
+	pageRequest
+}
+
+type sharedUserData struct {
+	Username string `json:"username"`
+
+	*getUserDataResponse
+
+	Error string `json:"error,omitempty"`
+}
+
+type getSharedUserDataResponse struct {
+	Results []sharedUserData `json:"results"`
+
+	pageResponse
+}
+
+// GetSharedUserData will query the blockchain
+// for the data and keys several users have shared with owner,
+// a page of the usernames at a time, the bookmark is where the next page starts
+func (t *DewalletChaincode) GetSharedUserData(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Querying data shared with a user")
+
+	var req getSharedUserDataRequest
+	json.Unmarshal([]byte(args[0]), &req)
+
+	err := resolveHandles(stub, &req.Owner)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = t.VerifySession(stub, req.Token, req.Owner)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't verify session %s", err))
+	}
+
+	size, err := req.size()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	start := 0
+	if req.Bookmark != "" {
+		start, err = strconv.Atoi(req.Bookmark)
+		if err != nil || start < 0 || start > len(req.Usernames) {
+			return shim.Error(fmt.Sprintf("Invalid bookmark %s", req.Bookmark))
+		}
+	}
+
+	end := start + int(size)
+	if end > len(req.Usernames) {
+		end = len(req.Usernames)
+	}
+
+	now, err := txSeconds(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	res := getSharedUserDataResponse{Results: []sharedUserData{}}
+	for _, username := range req.Usernames[start:end] {
+		r := sharedUserData{Username: username}
+
+		i, err := getStoredIdentity(stub, username)
+		if err == nil {
+			var data getUserDataResponse
+			data, err = t.userData(stub, i, req.Owner, req.Slot, req.Purpose, now)
+			if err == nil && data.NoGrant && len(data.AttributeKeys) == 0 {
+				err = errors.New(fmt.Sprintf("Nothing shared with %s", req.Owner))
+			}
+			if err == nil {
+				err = audit(stub, i.Username, req.Owner, req.Purpose)
+			}
+			r.getUserDataResponse = &data
+		}
+		if err != nil {
+			r.getUserDataResponse = nil
+			r.Error = err.Error()
+		}
+
+		res.Results = append(res.Results, r)
+	}
+	res.TotalFetched = int32(end - start)
+	if end < len(req.Usernames) {
+		res.Bookmark = strconv.Itoa(end)
+	}
+
+	resBytes, _ := marshal(res)
+
+	return shim.Success(resBytes)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// SiblingDeployment is this chaincode deployed on another channel
+// of the consortium, its identities are looked up when not found here
+type SiblingDeployment struct {
+	Channel   string `json:"channel"`
+	Chaincode string `json:"chaincode"`
+}
+
+// siblingsFor are the sibling deployments that may hold a handle, the one
+// of the channel of a DID or, for a username, each of them in order when
+// usernames are looked up, they aren't unique across channels
+func siblingsFor(handle string, siblings []SiblingDeployment, usernames bool) []SiblingDeployment {
+	if !strings.HasPrefix(handle, didMethod+":") {
+		if !usernames {
+			return nil
+		}
+		return siblings
+	}
+
+	channel := strings.SplitN(strings.TrimPrefix(handle, didMethod+":"), ":", 2)[0]
+	for _, s := range siblings {
+		if s.Channel == channel {
+			return []SiblingDeployment{s}
+		}
+	}
+
+	return nil
+}
+
+// siblingPublicKey looks up the public keys of a handle in the sibling
+// deployments, see siblingsFor, calls to other channels are read-only and
+// the siblings apply the query policies of their identities to the same creator
+func siblingPublicKey(stub shim.ChaincodeStubInterface, handle string, usernames bool) (getPublicKeyResponse, error) {
+	var res getPublicKeyResponse
+
+	c, err := getConfig(stub)
+	if err != nil {
+		return res, err
+	}
+
+	// the sibling only looks locally so lookups don't go round in circles
+	reqBytes, _ := marshal(getPublicKeyRequest{Username: handle, Local: true})
+
+	for _, s := range siblingsFor(handle, c.SiblingDeployments, usernames) {
+		r := stub.InvokeChaincode(s.Chaincode, [][]byte{[]byte("GetPublicKey"), reqBytes}, s.Channel)
+		if r.Status != shim.OK {
+			continue
+		}
+
+		err = json.Unmarshal(r.Payload, &res)
+		if err != nil {
+			return res, errors.New(fmt.Sprintf("Error in parsing public key from %s %s", s.Channel, err))
+		}
+		res.Channel = s.Channel
+
+		return res, nil
+	}
+
+	return res, errors.New(fmt.Sprintf("%s not found on sibling channels", handle))
+}
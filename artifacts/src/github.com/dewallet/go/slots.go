@@ -0,0 +1,55 @@
+package main
+
+import "regexp"
+
+// slotNamePattern restricts data slot names such as profile, kyc or medical
+var slotNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// DataSlot is the metadata of a named data slot, each slot is encrypted,
+// updated and shared on its own, the ciphertext is kept like Data
+// An AppendOnly slot holds Entries ciphertexts that can't be changed,
+// see UpdateUserDataPatch
+// A slot with a Retention in seconds expires that long after its last write
+type DataSlot struct {
+	Hash       string    `json:"hash"`
+	Schema     SchemaRef `json:"schema"`
+	AppendOnly bool      `json:"appendOnly"`
+	Entries    int       `json:"entries"`
+	Retention  int64     `json:"retention"`
+	ExpiresAt  int64     `json:"expiresAt"`
+
+	Pointer *DataPointer `json:"pointer,omitempty"`
+}
+
+// slot returns the metadata of a slot, the unnamed slot is the Data of the identity
+func (i Identity) slot(name string) (DataSlot, bool) {
+	if name == "" {
+		return DataSlot{
+			Hash:      i.DataHash,
+			Schema:    i.DataSchema,
+			Retention: i.DataRetention,
+			ExpiresAt: i.DataExpiresAt,
+			Pointer:   i.DataPointer,
+		}, true
+	}
+
+	s, ok := i.Slots[name]
+	return s, ok
+}
+
+// setSlot sets the metadata of a slot
+func (i *Identity) setSlot(name string, s DataSlot) {
+	if name == "" {
+		i.DataHash = s.Hash
+		i.DataSchema = s.Schema
+		i.DataRetention = s.Retention
+		i.DataExpiresAt = s.ExpiresAt
+		i.DataPointer = s.Pointer
+		return
+	}
+
+	if i.Slots == nil {
+		i.Slots = map[string]DataSlot{}
+	}
+	i.Slots[name] = s
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// stateCache lets the handlers of an invocation read their own writes, which
+// the peer only shows once committed, and reads each key from the peer once.
+// The writes are kept until flush writes them to the stub, once per key.
+// Range and partial composite key queries see them too, merged in the page
+// they fall in when paginated. Rich queries see the state before the
+// invocation, a batch refuses them, see batchStub
+type stateCache struct {
+	shim.ChaincodeStubInterface
+
+	// values are the values read and written, nil when absent or deleted
+	values map[string][]byte
+	// writes are the keys written, in the order they were first written
+	writes  []string
+	written map[string]bool
+
+	// keys are the public keys parsed, see parsedKeys
+	keys map[string]interface{}
+	// authenticated are the usernames VerifyRequest authenticated, see checkRateLimit
+	authenticated []string
+}
+
+func newStateCache(stub shim.ChaincodeStubInterface) *stateCache {
+	return &stateCache{ChaincodeStubInterface: stub, values: map[string][]byte{}, written: map[string]bool{}, keys: map[string]interface{}{}}
+}
+
+// invocationCache is the stateCache of the invocation of stub,
+// under the stubs of the tenant and the batch
+func invocationCache(stub shim.ChaincodeStubInterface) (*stateCache, bool) {
+	for {
+		switch s := stub.(type) {
+		case *stateCache:
+			return s, true
+		case *batchStub:
+			stub = s.ChaincodeStubInterface
+		case *tenantStub:
+			stub = s.ChaincodeStubInterface
+		default:
+			return nil, false
+		}
+	}
+}
+
+func (s *stateCache) GetState(key string) ([]byte, error) {
+	if value, ok := s.values[key]; ok {
+		return value, nil
+	}
+
+	value, err := s.ChaincodeStubInterface.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+
+	s.values[key] = value
+	return value, nil
+}
+
+func (s *stateCache) write(key string, value []byte) error {
+	if key == "" {
+		return errors.New("Key must not be an empty string")
+	}
+
+	if !s.written[key] {
+		s.written[key] = true
+		s.writes = append(s.writes, key)
+	}
+	s.values[key] = value
+
+	return nil
+}
+
+// PutState keeps an empty value as a delete, as the peer does
+func (s *stateCache) PutState(key string, value []byte) error {
+	if len(value) == 0 {
+		value = nil
+	}
+
+	return s.write(key, value)
+}
+
+func (s *stateCache) DelState(key string) error {
+	return s.write(key, nil)
+}
+
+// flush writes the last value of each key written to the stub
+func (s *stateCache) flush() error {
+	for _, key := range s.writes {
+		var err error
+		if value := s.values[key]; value == nil {
+			err = s.ChaincodeStubInterface.DelState(key)
+		} else {
+			err = s.ChaincodeStubInterface.PutState(key, value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	s.writes = nil
+	s.written = map[string]bool{}
+
+	return nil
+}
+
+// emptyStartKey is the start of a range from the first key, as the peer
+// has it composite keys are left out of range queries
+const emptyStartKey = "\x01"
+
+// keyRange is the keys from start, before end unless it is empty
+type keyRange struct {
+	start string
+	end   string
+}
+
+func (r keyRange) contains(key string) bool {
+	return key >= r.start && (r.end == "" || key < r.end)
+}
+
+// page is the keys of the page of r read from bookmark, up to the
+// bookmark of the next page unless it is the last one
+func (r keyRange) page(pageSize int32, bookmark string, m *pb.QueryResponseMetadata) keyRange {
+	if bookmark != "" {
+		r.start = bookmark
+	}
+	if m != nil && m.Bookmark != "" && m.FetchedRecordsCount >= pageSize {
+		r.end = m.Bookmark
+	}
+
+	return r
+}
+
+// partialKeyRange is the keys of the partial composite key
+func (s *stateCache) partialKeyRange(objectType string, keys []string) (keyRange, error) {
+	prefix, err := s.ChaincodeStubInterface.CreateCompositeKey(objectType, keys)
+	if err != nil {
+		return keyRange{}, err
+	}
+
+	return keyRange{start: prefix, end: prefix + string(utf8.MaxRune)}, nil
+}
+
+// merge reads the results of it ahead and merges the writes in r
+func (s *stateCache) merge(it shim.StateQueryIteratorInterface, r keyRange) (*cacheIterator, error) {
+	defer it.Close()
+
+	kvs := map[string]*queryresult.KV{}
+	for it.HasNext() {
+		kv, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+		kvs[kv.Key] = kv
+	}
+
+	for _, key := range s.writes {
+		if !r.contains(key) {
+			continue
+		}
+		if s.values[key] == nil {
+			delete(kvs, key)
+			continue
+		}
+		kvs[key] = &queryresult.KV{Key: key, Value: s.values[key]}
+	}
+
+	res := &cacheIterator{}
+	for _, kv := range kvs {
+		res.kvs = append(res.kvs, kv)
+	}
+	sort.Slice(res.kvs, func(a, b int) bool { return res.kvs[a].Key < res.kvs[b].Key })
+
+	return res, nil
+}
+
+// mergePage merges the writes in the page, its records counting them
+func (s *stateCache) mergePage(it shim.StateQueryIteratorInterface, m *pb.QueryResponseMetadata, r keyRange) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	res, err := s.merge(it, r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	merged := &pb.QueryResponseMetadata{FetchedRecordsCount: int32(len(res.kvs))}
+	if m != nil {
+		merged.Bookmark = m.Bookmark
+	}
+
+	return res, merged, nil
+}
+
+// GetStateByRange merges the writes in the range
+func (s *stateCache) GetStateByRange(startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	it, err := s.ChaincodeStubInterface.GetStateByRange(startKey, endKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if startKey == "" {
+		startKey = emptyStartKey
+	}
+
+	return s.merge(it, keyRange{start: startKey, end: endKey})
+}
+
+// GetStateByRangeWithPagination merges the writes in the page
+func (s *stateCache) GetStateByRangeWithPagination(startKey, endKey string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	it, m, err := s.ChaincodeStubInterface.GetStateByRangeWithPagination(startKey, endKey, pageSize, bookmark)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if startKey == "" {
+		startKey = emptyStartKey
+	}
+
+	return s.mergePage(it, m, keyRange{start: startKey, end: endKey}.page(pageSize, bookmark, m))
+}
+
+// GetStateByPartialCompositeKey merges the writes under the prefix
+func (s *stateCache) GetStateByPartialCompositeKey(objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	r, err := s.partialKeyRange(objectType, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	it, err := s.ChaincodeStubInterface.GetStateByPartialCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.merge(it, r)
+}
+
+// GetStateByPartialCompositeKeyWithPagination merges the writes in the page
+func (s *stateCache) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	r, err := s.partialKeyRange(objectType, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	it, m, err := s.ChaincodeStubInterface.GetStateByPartialCompositeKeyWithPagination(objectType, keys, pageSize, bookmark)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return s.mergePage(it, m, r.page(pageSize, bookmark, m))
+}
+
+// cacheIterator iterates over results read ahead
+type cacheIterator struct {
+	kvs []*queryresult.KV
+}
+
+func (it *cacheIterator) HasNext() bool {
+	return len(it.kvs) > 0
+}
+
+func (it *cacheIterator) Next() (*queryresult.KV, error) {
+	if len(it.kvs) == 0 {
+		return nil, errors.New("No more results")
+	}
+
+	kv := it.kvs[0]
+	it.kvs = it.kvs[1:]
+
+	return kv, nil
+}
+
+func (it *cacheIterator) Close() error {
+	return nil
+}
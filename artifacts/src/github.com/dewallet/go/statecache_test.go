@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// TestBatchQueriesSeeWrites checks the queries of a batch list the
+// identity an operation before registered
+func TestBatchQueriesSeeWrites(t *testing.T) {
+	h := newHarness(t, time.Now().Unix())
+	h.init(Config{AdminMSPs: []string{harnessOrg}})
+
+	alice := newFixture(t, "alice")
+	h.register(alice)
+	bob := newFixture(t, "bob")
+
+	bReq, err := json.Marshal(batchRequest{Operations: []batchOperation{
+		{Function: "Register", Args: bob.request(t, h.now, bob.identity())},
+		{Function: "ListIdentities", Args: []string{"{}"}},
+		{Function: "QueryByVerification", Args: []string{`{"verified":"none"}`}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var res batchResponse
+	err = json.Unmarshal(h.mustInvoke("Batch", string(bReq)), &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range res.Results[1:] {
+		var page struct {
+			Identities []identitySummary `json:"identities"`
+		}
+		err = json.Unmarshal(r.Payload, &page)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		usernames := []string{}
+		for _, i := range page.Identities {
+			usernames = append(usernames, i.Username)
+		}
+		if len(usernames) != 2 || usernames[0] != "alice" || usernames[1] != "bob" {
+			t.Fatalf("%s listed %v", r.Function, usernames)
+		}
+	}
+}
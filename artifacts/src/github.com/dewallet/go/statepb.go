@@ -0,0 +1,513 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Encodings of the identities, key records and key material written
+// to state, values of either encoding are read whatever the setting
+const (
+	stateEncodingJSON     = "json"
+	stateEncodingProtobuf = "protobuf"
+)
+
+// Messages of state.proto
+
+type pbSchemaRef struct {
+	Id      string `protobuf:"bytes,1,opt,name=id,proto3"`
+	Version string `protobuf:"bytes,2,opt,name=version,proto3"`
+}
+
+func (m *pbSchemaRef) Reset()         { *m = pbSchemaRef{} }
+func (m *pbSchemaRef) String() string { return proto.CompactTextString(m) }
+func (*pbSchemaRef) ProtoMessage()    {}
+
+type pbKey struct {
+	For       string `protobuf:"bytes,1,opt,name=for,proto3"`
+	Key       string `protobuf:"bytes,2,opt,name=key,proto3"`
+	KeyHash   string `protobuf:"bytes,3,opt,name=key_hash,json=keyHash,proto3"`
+	Attribute string `protobuf:"bytes,4,opt,name=attribute,proto3"`
+	Slot      string `protobuf:"bytes,5,opt,name=slot,proto3"`
+	DataHash  string `protobuf:"bytes,6,opt,name=data_hash,json=dataHash,proto3"`
+	NotBefore int64  `protobuf:"varint,7,opt,name=not_before,json=notBefore,proto3"`
+	NotAfter  int64  `protobuf:"varint,8,opt,name=not_after,json=notAfter,proto3"`
+	Purpose   string `protobuf:"bytes,9,opt,name=purpose,proto3"`
+	ConsentId string `protobuf:"bytes,10,opt,name=consent_id,json=consentId,proto3"`
+}
+
+func (m *pbKey) Reset()         { *m = pbKey{} }
+func (m *pbKey) String() string { return proto.CompactTextString(m) }
+func (*pbKey) ProtoMessage()    {}
+
+type pbAttribute struct {
+	Value  string       `protobuf:"bytes,1,opt,name=value,proto3"`
+	Hash   string       `protobuf:"bytes,2,opt,name=hash,proto3"`
+	Schema *pbSchemaRef `protobuf:"bytes,3,opt,name=schema,proto3"`
+}
+
+func (m *pbAttribute) Reset()         { *m = pbAttribute{} }
+func (m *pbAttribute) String() string { return proto.CompactTextString(m) }
+func (*pbAttribute) ProtoMessage()    {}
+
+type pbDataPointer struct {
+	Uri  string `protobuf:"bytes,1,opt,name=uri,proto3"`
+	Hash string `protobuf:"bytes,2,opt,name=hash,proto3"`
+	Size int64  `protobuf:"varint,3,opt,name=size,proto3"`
+}
+
+func (m *pbDataPointer) Reset()         { *m = pbDataPointer{} }
+func (m *pbDataPointer) String() string { return proto.CompactTextString(m) }
+func (*pbDataPointer) ProtoMessage()    {}
+
+type pbDataSlot struct {
+	Hash       string         `protobuf:"bytes,1,opt,name=hash,proto3"`
+	Schema     *pbSchemaRef   `protobuf:"bytes,2,opt,name=schema,proto3"`
+	AppendOnly bool           `protobuf:"varint,3,opt,name=append_only,json=appendOnly,proto3"`
+	Entries    int64          `protobuf:"varint,4,opt,name=entries,proto3"`
+	Pointer    *pbDataPointer `protobuf:"bytes,5,opt,name=pointer,proto3"`
+	Retention  int64          `protobuf:"varint,6,opt,name=retention,proto3"`
+	ExpiresAt  int64          `protobuf:"varint,7,opt,name=expires_at,json=expiresAt,proto3"`
+}
+
+func (m *pbDataSlot) Reset()         { *m = pbDataSlot{} }
+func (m *pbDataSlot) String() string { return proto.CompactTextString(m) }
+func (*pbDataSlot) ProtoMessage()    {}
+
+type pbAttestation struct {
+	Statement string   `protobuf:"bytes,1,opt,name=statement,proto3"`
+	Verifiers []string `protobuf:"bytes,2,rep,name=verifiers,proto3"`
+	Signature string   `protobuf:"bytes,3,opt,name=signature,proto3"`
+	TxId      string   `protobuf:"bytes,4,opt,name=tx_id,json=txId,proto3"`
+}
+
+func (m *pbAttestation) Reset()         { *m = pbAttestation{} }
+func (m *pbAttestation) String() string { return proto.CompactTextString(m) }
+func (*pbAttestation) ProtoMessage()    {}
+
+type pbService struct {
+	Id              string `protobuf:"bytes,1,opt,name=id,proto3"`
+	Type            string `protobuf:"bytes,2,opt,name=type,proto3"`
+	ServiceEndpoint string `protobuf:"bytes,3,opt,name=service_endpoint,json=serviceEndpoint,proto3"`
+}
+
+func (m *pbService) Reset()         { *m = pbService{} }
+func (m *pbService) String() string { return proto.CompactTextString(m) }
+func (*pbService) ProtoMessage()    {}
+
+type pbVerification struct {
+	Level        string `protobuf:"bytes,1,opt,name=level,proto3"`
+	Verifier     string `protobuf:"bytes,2,opt,name=verifier,proto3"`
+	VerifiedAt   int64  `protobuf:"varint,3,opt,name=verified_at,json=verifiedAt,proto3"`
+	EvidenceHash string `protobuf:"bytes,4,opt,name=evidence_hash,json=evidenceHash,proto3"`
+	TxId         string `protobuf:"bytes,5,opt,name=tx_id,json=txId,proto3"`
+	ExpiresAt    int64  `protobuf:"varint,6,opt,name=expires_at,json=expiresAt,proto3"`
+	RevokedAt    int64  `protobuf:"varint,7,opt,name=revoked_at,json=revokedAt,proto3"`
+	Provider     string `protobuf:"bytes,8,opt,name=provider,proto3"`
+	SuspendedAt  int64  `protobuf:"varint,9,opt,name=suspended_at,json=suspendedAt,proto3"`
+}
+
+func (m *pbVerification) Reset()         { *m = pbVerification{} }
+func (m *pbVerification) String() string { return proto.CompactTextString(m) }
+func (*pbVerification) ProtoMessage()    {}
+
+type pbAccessPolicy struct {
+	QueryMsps     []string `protobuf:"bytes,1,rep,name=query_msps,json=queryMsps,proto3"`
+	GrantOrgs     []string `protobuf:"bytes,2,rep,name=grant_orgs,json=grantOrgs,proto3"`
+	GrantMinLevel string   `protobuf:"bytes,3,opt,name=grant_min_level,json=grantMinLevel,proto3"`
+}
+
+func (m *pbAccessPolicy) Reset()         { *m = pbAccessPolicy{} }
+func (m *pbAccessPolicy) String() string { return proto.CompactTextString(m) }
+func (*pbAccessPolicy) ProtoMessage()    {}
+
+type pbIdentity struct {
+	Username     string                  `protobuf:"bytes,1,opt,name=username,proto3"`
+	PublicKey    string                  `protobuf:"bytes,2,opt,name=public_key,json=publicKey,proto3"`
+	EPublicKey   string                  `protobuf:"bytes,3,opt,name=e_public_key,json=ePublicKey,proto3"`
+	SPublicKey   string                  `protobuf:"bytes,4,opt,name=s_public_key,json=sPublicKey,proto3"`
+	BPublicKey   string                  `protobuf:"bytes,5,opt,name=b_public_key,json=bPublicKey,proto3"`
+	QPublicKey   string                  `protobuf:"bytes,6,opt,name=q_public_key,json=qPublicKey,proto3"`
+	QAlgorithm   string                  `protobuf:"bytes,7,opt,name=q_algorithm,json=qAlgorithm,proto3"`
+	Data         string                  `protobuf:"bytes,8,opt,name=data,proto3"`
+	DataHash     string                  `protobuf:"bytes,9,opt,name=data_hash,json=dataHash,proto3"`
+	DataSchema   *pbSchemaRef            `protobuf:"bytes,10,opt,name=data_schema,json=dataSchema,proto3"`
+	Collection   string                  `protobuf:"bytes,11,opt,name=collection,proto3"`
+	Verified     string                  `protobuf:"bytes,12,opt,name=verified,proto3"`
+	Keys         []*pbKey                `protobuf:"bytes,13,rep,name=keys,proto3"`
+	DataPointer  *pbDataPointer          `protobuf:"bytes,14,opt,name=data_pointer,json=dataPointer,proto3"`
+	Slots        map[string]*pbDataSlot  `protobuf:"bytes,15,rep,name=slots,proto3" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	Attributes   map[string]*pbAttribute `protobuf:"bytes,16,rep,name=attributes,proto3" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	Attestations []*pbAttestation        `protobuf:"bytes,17,rep,name=attestations,proto3"`
+	DocType      string                  `protobuf:"bytes,18,opt,name=doc_type,json=docType,proto3"`
+	Org          string                  `protobuf:"bytes,19,opt,name=org,proto3"`
+
+	DataRetention int64 `protobuf:"varint,20,opt,name=data_retention,json=dataRetention,proto3"`
+	DataExpiresAt int64 `protobuf:"varint,21,opt,name=data_expires_at,json=dataExpiresAt,proto3"`
+	Registered    int64 `protobuf:"varint,22,opt,name=registered,proto3"`
+
+	PublicAttributes map[string]string `protobuf:"bytes,23,rep,name=public_attributes,json=publicAttributes,proto3" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	Creator          string            `protobuf:"bytes,24,opt,name=creator,proto3"`
+	Policy           *pbAccessPolicy   `protobuf:"bytes,25,opt,name=policy,proto3"`
+	EndorsingOrgs    []string          `protobuf:"bytes,26,rep,name=endorsing_orgs,json=endorsingOrgs,proto3"`
+	EmailHash        string            `protobuf:"bytes,27,opt,name=email_hash,json=emailHash,proto3"`
+	Guardians        []string          `protobuf:"bytes,28,rep,name=guardians,proto3"`
+	Verifications    []*pbVerification `protobuf:"bytes,29,rep,name=verifications,proto3"`
+	CertificateChain []string          `protobuf:"bytes,30,rep,name=certificate_chain,json=certificateChain,proto3"`
+	Services         []*pbService      `protobuf:"bytes,31,rep,name=services,proto3"`
+	Commitments      map[string]string `protobuf:"bytes,32,rep,name=commitments,proto3" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+}
+
+func (m *pbIdentity) Reset()         { *m = pbIdentity{} }
+func (m *pbIdentity) String() string { return proto.CompactTextString(m) }
+func (*pbIdentity) ProtoMessage()    {}
+
+type pbKeyMaterial struct {
+	Username   string          `protobuf:"bytes,1,opt,name=username,proto3"`
+	Org        string          `protobuf:"bytes,2,opt,name=org,proto3"`
+	PublicKey  string          `protobuf:"bytes,3,opt,name=public_key,json=publicKey,proto3"`
+	EPublicKey string          `protobuf:"bytes,4,opt,name=e_public_key,json=ePublicKey,proto3"`
+	SPublicKey string          `protobuf:"bytes,5,opt,name=s_public_key,json=sPublicKey,proto3"`
+	Policy     *pbAccessPolicy `protobuf:"bytes,6,opt,name=policy,proto3"`
+}
+
+func (m *pbKeyMaterial) Reset()         { *m = pbKeyMaterial{} }
+func (m *pbKeyMaterial) String() string { return proto.CompactTextString(m) }
+func (*pbKeyMaterial) ProtoMessage()    {}
+
+type pbPublicKeys struct {
+	PublicKey  string `protobuf:"bytes,1,opt,name=public_key,json=publicKey,proto3"`
+	EPublicKey string `protobuf:"bytes,2,opt,name=e_public_key,json=ePublicKey,proto3"`
+	Channel    string `protobuf:"bytes,3,opt,name=channel,proto3"`
+}
+
+func (m *pbPublicKeys) Reset()         { *m = pbPublicKeys{} }
+func (m *pbPublicKeys) String() string { return proto.CompactTextString(m) }
+func (*pbPublicKeys) ProtoMessage()    {}
+
+func toPBSchemaRef(s SchemaRef) *pbSchemaRef {
+	if s == (SchemaRef{}) {
+		return nil
+	}
+
+	return &pbSchemaRef{Id: s.ID, Version: s.Version}
+}
+
+func fromPBSchemaRef(m *pbSchemaRef) SchemaRef {
+	if m == nil {
+		return SchemaRef{}
+	}
+
+	return SchemaRef{ID: m.Id, Version: m.Version}
+}
+
+func toPBDataPointer(p *DataPointer) *pbDataPointer {
+	if p == nil {
+		return nil
+	}
+
+	return &pbDataPointer{Uri: p.URI, Hash: p.Hash, Size: p.Size}
+}
+
+func fromPBDataPointer(m *pbDataPointer) *DataPointer {
+	if m == nil {
+		return nil
+	}
+
+	return &DataPointer{URI: m.Uri, Hash: m.Hash, Size: m.Size}
+}
+
+func toPBAccessPolicy(p AccessPolicy) *pbAccessPolicy {
+	if len(p.QueryMSPs) == 0 && len(p.GrantOrgs) == 0 && p.GrantMinLevel == "" {
+		return nil
+	}
+
+	return &pbAccessPolicy{QueryMsps: p.QueryMSPs, GrantOrgs: p.GrantOrgs, GrantMinLevel: p.GrantMinLevel}
+}
+
+func fromPBAccessPolicy(m *pbAccessPolicy) AccessPolicy {
+	if m == nil {
+		return AccessPolicy{}
+	}
+
+	return AccessPolicy{QueryMSPs: m.QueryMsps, GrantOrgs: m.GrantOrgs, GrantMinLevel: m.GrantMinLevel}
+}
+
+func toPBKey(k Key) *pbKey {
+	return &pbKey{For: k.Owner, Key: k.Key, KeyHash: k.KeyHash, Attribute: k.Attribute, Slot: k.Slot, DataHash: k.DataHash, NotBefore: k.NotBefore, NotAfter: k.NotAfter, Purpose: k.Purpose, ConsentId: k.ConsentID}
+}
+
+func fromPBKey(m *pbKey) Key {
+	return Key{Owner: m.For, Key: m.Key, KeyHash: m.KeyHash, Attribute: m.Attribute, Slot: m.Slot, DataHash: m.DataHash, NotBefore: m.NotBefore, NotAfter: m.NotAfter, Purpose: m.Purpose, ConsentID: m.ConsentId}
+}
+
+// toPBIdentity converts an identity to its protobuf message
+func toPBIdentity(i Identity) *pbIdentity {
+	m := &pbIdentity{
+		Username:      i.Username,
+		DocType:       i.DocType,
+		Org:           i.Org,
+		PublicKey:     i.PublicKey,
+		EPublicKey:    i.EPublicKey,
+		SPublicKey:    i.SPublicKey,
+		BPublicKey:    i.BPublicKey,
+		QPublicKey:    i.QPublicKey,
+		QAlgorithm:    i.QAlgorithm,
+		Data:          i.Data,
+		DataHash:      i.DataHash,
+		DataSchema:    toPBSchemaRef(i.DataSchema),
+		Collection:    i.Collection,
+		Verified:      i.Verified,
+		Registered:    i.Registered,
+		Creator:       i.Creator,
+		DataPointer:   toPBDataPointer(i.DataPointer),
+		DataRetention: i.DataRetention,
+		DataExpiresAt: i.DataExpiresAt,
+		Slots:         map[string]*pbDataSlot{},
+		Attributes:    map[string]*pbAttribute{},
+
+		PublicAttributes: i.PublicAttributes,
+		Policy:           toPBAccessPolicy(i.Policy),
+		EndorsingOrgs:    i.EndorsingOrgs,
+		EmailHash:        i.EmailHash,
+		Guardians:        i.Guardians,
+		CertificateChain: i.CertificateChain,
+		Commitments:      i.Commitments,
+	}
+
+	for _, k := range i.Keys {
+		m.Keys = append(m.Keys, toPBKey(k))
+	}
+
+	for name, s := range i.Slots {
+		m.Slots[name] = &pbDataSlot{
+			Hash:       s.Hash,
+			Schema:     toPBSchemaRef(s.Schema),
+			AppendOnly: s.AppendOnly,
+			Entries:    int64(s.Entries),
+			Pointer:    toPBDataPointer(s.Pointer),
+			Retention:  s.Retention,
+			ExpiresAt:  s.ExpiresAt,
+		}
+	}
+
+	for name, a := range i.Attributes {
+		m.Attributes[name] = &pbAttribute{Value: a.Value, Hash: a.Hash, Schema: toPBSchemaRef(a.Schema)}
+	}
+
+	for _, a := range i.Attestations {
+		m.Attestations = append(m.Attestations, &pbAttestation{Statement: a.Statement, Verifiers: a.Verifiers, Signature: a.Signature, TxId: a.TxID})
+	}
+
+	for _, s := range i.Services {
+		m.Services = append(m.Services, &pbService{Id: s.ID, Type: s.Type, ServiceEndpoint: s.Endpoint})
+	}
+
+	for _, v := range i.Verifications {
+		m.Verifications = append(m.Verifications, &pbVerification{Level: v.Level, Verifier: v.Verifier, VerifiedAt: v.VerifiedAt, ExpiresAt: v.ExpiresAt, EvidenceHash: v.EvidenceHash, TxId: v.TxID, RevokedAt: v.RevokedAt, Provider: v.Provider, SuspendedAt: v.SuspendedAt})
+	}
+
+	return m
+}
+
+// fromPBIdentity converts a protobuf message to an identity
+func fromPBIdentity(m *pbIdentity) Identity {
+	i := Identity{
+		Username:      m.Username,
+		DocType:       m.DocType,
+		Org:           m.Org,
+		PublicKey:     m.PublicKey,
+		EPublicKey:    m.EPublicKey,
+		SPublicKey:    m.SPublicKey,
+		BPublicKey:    m.BPublicKey,
+		QPublicKey:    m.QPublicKey,
+		QAlgorithm:    m.QAlgorithm,
+		Data:          m.Data,
+		DataHash:      m.DataHash,
+		DataSchema:    fromPBSchemaRef(m.DataSchema),
+		Collection:    m.Collection,
+		Verified:      m.Verified,
+		Registered:    m.Registered,
+		Creator:       m.Creator,
+		DataPointer:   fromPBDataPointer(m.DataPointer),
+		DataRetention: m.DataRetention,
+		DataExpiresAt: m.DataExpiresAt,
+		Keys:          []Key{},
+		Slots:         map[string]DataSlot{},
+		Attributes:    map[string]Attribute{},
+
+		Attestations: []Attestation{},
+
+		PublicAttributes: map[string]string{},
+		Policy:           fromPBAccessPolicy(m.Policy),
+		EndorsingOrgs:    m.EndorsingOrgs,
+		EmailHash:        m.EmailHash,
+		Guardians:        m.Guardians,
+		CertificateChain: m.CertificateChain,
+	}
+
+	for name, value := range m.PublicAttributes {
+		i.PublicAttributes[name] = value
+	}
+
+	for name, c := range m.Commitments {
+		if i.Commitments == nil {
+			i.Commitments = map[string]string{}
+		}
+		i.Commitments[name] = c
+	}
+
+	for _, k := range m.Keys {
+		i.Keys = append(i.Keys, fromPBKey(k))
+	}
+
+	for name, s := range m.Slots {
+		i.Slots[name] = DataSlot{
+			Hash:       s.Hash,
+			Schema:     fromPBSchemaRef(s.Schema),
+			AppendOnly: s.AppendOnly,
+			Entries:    int(s.Entries),
+			Pointer:    fromPBDataPointer(s.Pointer),
+			Retention:  s.Retention,
+			ExpiresAt:  s.ExpiresAt,
+		}
+	}
+
+	for name, a := range m.Attributes {
+		i.Attributes[name] = Attribute{Value: a.Value, Hash: a.Hash, Schema: fromPBSchemaRef(a.Schema)}
+	}
+
+	for _, a := range m.Attestations {
+		i.Attestations = append(i.Attestations, Attestation{Statement: a.Statement, Verifiers: a.Verifiers, Signature: a.Signature, TxID: a.TxId})
+	}
+
+	for _, s := range m.Services {
+		i.Services = append(i.Services, Service{ID: s.Id, Type: s.Type, Endpoint: s.ServiceEndpoint})
+	}
+
+	for _, v := range m.Verifications {
+		i.Verifications = append(i.Verifications, Verification{Level: v.Level, Verifier: v.Verifier, VerifiedAt: v.VerifiedAt, ExpiresAt: v.ExpiresAt, EvidenceHash: v.EvidenceHash, TxID: v.TxId, RevokedAt: v.RevokedAt, Provider: v.Provider, SuspendedAt: v.SuspendedAt})
+	}
+
+	return i
+}
+
+// stateEncoding is the configured encoding of the values written to state
+func stateEncoding(stub shim.ChaincodeStubInterface) (string, error) {
+	c, err := getConfig(stub)
+	if err != nil {
+		return "", err
+	}
+
+	return c.StateEncoding, nil
+}
+
+// encodeState encodes a value for state, as JSON or as its message,
+// map entries are sorted so every peer writes the same bytes
+func encodeState(encoding string, v interface{}, m proto.Message) ([]byte, error) {
+	switch encoding {
+	case "", stateEncodingJSON:
+		return marshal(v)
+	case stateEncodingProtobuf:
+		b := proto.NewBuffer(nil)
+		b.SetDeterministic(true)
+		err := b.Marshal(m)
+		if err != nil {
+			return nil, err
+		}
+		return b.Bytes(), nil
+	default:
+		return nil, errors.New(fmt.Sprintf("Unsupported state encoding %s", encoding))
+	}
+}
+
+// decodeState decodes a value of either encoding,
+// JSON values always start with a brace
+func decodeState(value []byte, v interface{}, m proto.Message) (bool, error) {
+	if len(value) > 0 && value[0] == '{' {
+		return false, json.Unmarshal(value, v)
+	}
+
+	return true, proto.Unmarshal(value, m)
+}
+
+// encodeIdentity encodes an identity for state
+func encodeIdentity(encoding string, i Identity) ([]byte, error) {
+	return encodeState(encoding, i, toPBIdentity(i))
+}
+
+// decodeIdentity decodes an identity of either encoding
+func decodeIdentity(iBytes []byte) (Identity, error) {
+	var i Identity
+	var m pbIdentity
+
+	isPB, err := decodeState(iBytes, &i, &m)
+	if err != nil {
+		return Identity{}, errors.New(fmt.Sprintf("Error in parsing identity %s", err))
+	}
+	if isPB {
+		i = fromPBIdentity(&m)
+	}
+
+	return i, nil
+}
+
+// encodeKey encodes a key record for state
+func encodeKey(encoding string, k Key) ([]byte, error) {
+	return encodeState(encoding, k, toPBKey(k))
+}
+
+// decodeKey decodes a key record of either encoding
+func decodeKey(kBytes []byte) (Key, error) {
+	var k Key
+	var m pbKey
+
+	isPB, err := decodeState(kBytes, &k, &m)
+	if err != nil {
+		return Key{}, errors.New(fmt.Sprintf("Error in parsing key %s", err))
+	}
+	if isPB {
+		k = fromPBKey(&m)
+	}
+
+	return k, nil
+}
+
+// encodeKeyMaterial encodes the key material of an identity for state
+func encodeKeyMaterial(encoding string, r KeyMaterial) ([]byte, error) {
+	return encodeState(encoding, r, &pbKeyMaterial{
+		Username:   r.Username,
+		Org:        r.Org,
+		PublicKey:  r.PublicKey,
+		EPublicKey: r.EPublicKey,
+		SPublicKey: r.SPublicKey,
+		Policy:     toPBAccessPolicy(r.Policy),
+	})
+}
+
+// decodeKeyMaterial decodes key material of either encoding
+func decodeKeyMaterial(rBytes []byte) (KeyMaterial, error) {
+	var r KeyMaterial
+	var m pbKeyMaterial
+
+	isPB, err := decodeState(rBytes, &r, &m)
+	if err != nil {
+		return KeyMaterial{}, errors.New(fmt.Sprintf("Error in parsing key material %s", err))
+	}
+	if isPB {
+		r = KeyMaterial{
+			Username:   m.Username,
+			Org:        m.Org,
+			PublicKey:  m.PublicKey,
+			EPublicKey: m.EPublicKey,
+			SPublicKey: m.SPublicKey,
+			Policy:     fromPBAccessPolicy(m.Policy),
+		}
+	}
+
+	return r, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+type getIdentityStatsRequest struct {
+	pageRequest
+}
+
+// identityStats counts identities, a page of them at a time,
+// the counts of every page add up to the counts of the ledger
+type identityStats struct {
+	Total int `json:"total"`
+
+	// Private counts the identities keeping their data in a collection
+	Private int `json:"private"`
+
+	ByVerified map[string]int `json:"byVerified"`
+	ByOrg      map[string]int `json:"byOrg"`
+
+	pageResponse
+}
+
+// GetIdentityStats will count a page of the identities by verification status
+// and by org, counters aren't kept so registrations don't conflict on them,
+// the bookmark of the response counts the next page, admin only
+func (t *DewalletChaincode) GetIdentityStats(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Counting identities")
+
+	err := t.requireAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var req getIdentityStatsRequest
+	json.Unmarshal([]byte(args[0]), &req)
+
+	size, err := req.size()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	it, m, err := stub.GetStateByRangeWithPagination("", "", size, req.Bookmark)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't list identities %s", err))
+	}
+	defer it.Close()
+
+	identities, err := collectIdentities(stub, it, func(Identity) bool { return true })
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't list identities %s", err))
+	}
+
+	res := identityStats{
+		ByVerified: map[string]int{},
+		ByOrg:      map[string]int{},
+
+		pageResponse: newPageResponse(m),
+	}
+	for _, i := range identities {
+		res.Total++
+		if i.Collection != "" {
+			res.Private++
+		}
+		res.ByVerified[i.Verified]++
+		res.ByOrg[i.Org]++
+	}
+
+	resBytes, _ := marshal(res)
+
+	return shim.Success(resBytes)
+}
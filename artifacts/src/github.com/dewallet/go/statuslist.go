@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// statusListObjectType prefixes the revocation status lists by issuer and page
+const statusListObjectType = "statuslist"
+
+// suspensionListObjectType prefixes the suspension status lists by issuer and page
+const suspensionListObjectType = "statuslistsuspension"
+
+// statusListNextObjectType prefixes the status index counters of each issuer,
+// by shard, see nextStatusIndex
+const statusListNextObjectType = "statuslistnext"
+
+// statusIndexShards is how many counters give the status indexes of an issuer,
+// concurrent issuances by the same issuer seldom read the same counter so
+// they don't fail validation on it
+const statusIndexShards = 16
+
+// Purposes of a status list, a credential has the same index in both lists
+const (
+	statusPurposeRevocation = "revocation"
+	statusPurposeSuspension = "suspension"
+)
+
+// statusListObjectTypes prefixes the status lists of each purpose
+var statusListObjectTypes = map[string]string{
+	statusPurposeRevocation: statusListObjectType,
+	statusPurposeSuspension: suspensionListObjectType,
+}
+
+// statusListBits is how many credentials a page of a status list covers,
+// 16KB as the W3C status list recommends for herd privacy
+const statusListBits = 131072
+
+// StatusList is a page of a bitstring of an issuer, the bit of
+// a credential is set while it is revoked or suspended, per the purpose
+type StatusList struct {
+	Issuer  string `json:"issuer"`
+	Purpose string `json:"statusPurpose"`
+	Page    int64  `json:"page"`
+	Size    int64  `json:"size"`
+
+	// EncodedList is the base64url of the gzipped bitstring,
+	// the first bit of a byte is its most significant one
+	EncodedList string `json:"encodedList"`
+}
+
+func statusListKey(stub shim.ChaincodeStubInterface, issuer string, purpose string, page int64) (string, error) {
+	objectType, ok := statusListObjectTypes[purpose]
+	if !ok {
+		return "", errors.New(fmt.Sprintf("Unsupported status purpose %s", purpose))
+	}
+
+	return stub.CreateCompositeKey(objectType, []string{issuer, fmt.Sprintf("%08d", page)})
+}
+
+// getStatusListBits reads a page of a bitstring of an issuer,
+// all zero when no bit of it was ever set
+func getStatusListBits(stub shim.ChaincodeStubInterface, issuer string, purpose string, page int64) ([]byte, error) {
+	key, err := statusListKey(stub, issuer, purpose, page)
+	if err != nil {
+		return nil, err
+	}
+
+	bits, err := getStoredState(stub, key)
+	if err != nil {
+		return nil, err
+	}
+	if bits == nil {
+		return make([]byte, statusListBits/8), nil
+	}
+	if len(bits) != statusListBits/8 {
+		return nil, errors.New("Invalid status list")
+	}
+
+	return bits, nil
+}
+
+// statusIndexCounter reads a status index counter, 0 when it was never written
+func statusIndexCounter(stub shim.ChaincodeStubInterface, key string) (int64, error) {
+	nBytes, err := stub.GetState(key)
+	if err != nil {
+		return 0, errors.New("Failed to get state")
+	}
+	if nBytes == nil {
+		return 0, nil
+	}
+
+	n, err := strconv.ParseInt(string(nBytes), 10, 64)
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("Error in parsing status index %s", err))
+	}
+
+	return n, nil
+}
+
+// nextStatusIndex assigns the next index of the status list of an issuer.
+// The transaction id picks one of the counters of the issuer, counter s gives
+// the indexes congruent to s after those the single counter of earlier
+// versions gave, which is only read now. Indexes are no longer assigned in
+// order, a page still covers statusListBits of them
+func nextStatusIndex(stub shim.ChaincodeStubInterface, issuer string) (int64, error) {
+	legacyKey, err := stub.CreateCompositeKey(statusListNextObjectType, []string{issuer})
+	if err != nil {
+		return 0, err
+	}
+
+	base, err := statusIndexCounter(stub, legacyKey)
+	if err != nil {
+		return 0, err
+	}
+
+	h := sha256.Sum256([]byte(stub.GetTxID()))
+	shard := int64(h[0]) % statusIndexShards
+
+	key, err := stub.CreateCompositeKey(statusListNextObjectType, []string{issuer, fmt.Sprintf("%02d", shard)})
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := statusIndexCounter(stub, key)
+	if err != nil {
+		return 0, err
+	}
+
+	err = stub.PutState(key, []byte(strconv.FormatInt(n+1, 10)))
+	if err != nil {
+		return 0, err
+	}
+
+	return base + n*statusIndexShards + shard, nil
+}
+
+// setStatusBit sets or clears the bit of index in a status list of an issuer,
+// only the page of the bit is rewritten
+func setStatusBit(stub shim.ChaincodeStubInterface, issuer string, purpose string, index int64, set bool) error {
+	page := index / statusListBits
+	bits, err := getStatusListBits(stub, issuer, purpose, page)
+	if err != nil {
+		return err
+	}
+
+	bit := index % statusListBits
+	mask := byte(0x80) >> uint(bit%8)
+	if set {
+		bits[bit/8] |= mask
+	} else {
+		bits[bit/8] &^= mask
+	}
+
+	key, err := statusListKey(stub, issuer, purpose, page)
+	if err != nil {
+		return err
+	}
+
+	return putStoredState(stub, key, bits)
+}
+
+type revokeCredentialRequest struct {
+	Issuer string `json:"issuer"`
+	ID     string `json:"id"`
+}
+
+// RevokeCredential will revoke a credential and set its bit
+// in the status list, the request is signed by its issuer
+func (t *DewalletChaincode) RevokeCredential(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Revoking credential")
+
+	return t.setCredentialRevoked(stub, args, true)
+}
+
+// UnrevokeCredential will reinstate a revoked credential and clear its bit
+// in the status list, the request is signed by its issuer
+func (t *DewalletChaincode) UnrevokeCredential(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Unrevoking credential")
+
+	return t.setCredentialRevoked(stub, args, false)
+}
+
+func (t *DewalletChaincode) setCredentialRevoked(stub shim.ChaincodeStubInterface, args []string, revoked bool) pb.Response {
+	var r revokeCredentialRequest
+	env, err := t.ParseRequest(args, &r)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't parse request %s", err))
+	}
+
+	vi, err := getIdentity(stub, r.Issuer)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't get issuer %s %s", r.Issuer, err))
+	}
+
+	err = t.VerifyRequest(stub, args, env, vi)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't verify signature %s", err))
+	}
+
+	c, err := getCredential(stub, r.ID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if c == nil || c.Issuer != vi.Username {
+		return shim.Error("Credential not found")
+	}
+
+	if revoked == (c.Status == credentialRevoked) {
+		return shim.Error(fmt.Sprintf("Credential is already %s", c.Status))
+	}
+
+	err = setStatusBit(stub, c.Issuer, statusPurposeRevocation, c.StatusIndex, revoked)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// a revoked credential is no longer suspended, it is active once reinstated
+	if c.Status == credentialSuspended {
+		err = setStatusBit(stub, c.Issuer, statusPurposeSuspension, c.StatusIndex, false)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	c.Status = credentialActive
+	if revoked {
+		c.Status = credentialRevoked
+	}
+
+	err = audit(stub, c.Subject, vi.Username, "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	cBytes, err := putCredential(stub, *c)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(cBytes)
+}
+
+type getStatusListRequest struct {
+	Issuer string `json:"issuer"`
+	Page   int64  `json:"page"`
+
+	// Purpose is revocation unless given
+	Purpose string `json:"statusPurpose"`
+	// Credential picks the issuer and page of a credential instead
+	Credential string `json:"credential"`
+}
+
+// GetStatusList will query the blockchain and return a page of a status
+// list of an issuer, relying parties check many credentials with one read
+func (t *DewalletChaincode) GetStatusList(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Querying status list of issuer")
+
+	var req getStatusListRequest
+	json.Unmarshal([]byte(args[0]), &req)
+
+	if req.Purpose == "" {
+		req.Purpose = statusPurposeRevocation
+	}
+
+	if req.Credential != "" {
+		c, err := getCredential(stub, req.Credential)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if c == nil {
+			return shim.Error("Credential not found")
+		}
+
+		req.Issuer = c.Issuer
+		req.Page = c.StatusIndex / statusListBits
+	}
+
+	if req.Page < 0 {
+		return shim.Error("Invalid status list page")
+	}
+
+	err := resolveHandles(stub, &req.Issuer)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	bits, err := getStatusListBits(stub, req.Issuer, req.Purpose, req.Page)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	w.Write(bits)
+	err = w.Close()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	l := StatusList{
+		Issuer:      req.Issuer,
+		Purpose:     req.Purpose,
+		Page:        req.Page,
+		Size:        statusListBits,
+		EncodedList: base64.RawURLEncoding.EncodeToString(b.Bytes()),
+	}
+
+	lBytes, _ := marshal(l)
+
+	return shim.Success(lBytes)
+}
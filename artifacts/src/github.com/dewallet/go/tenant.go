@@ -0,0 +1,378 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Tenancy modes of the configuration, without one all
+// transactions share the same state
+const (
+	// tenancyMSP makes every org its own tenant
+	tenancyMSP = "msp"
+	// tenancyExplicit takes the tenant from the function name, tenant/Function
+	tenancyExplicit = "explicit"
+)
+
+// tenantObjectType prefixes the keys of the identities of a tenant
+const tenantObjectType = "tenant"
+
+// tenantNamePattern restricts tenant names, MSP IDs included
+var tenantNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// tenantScope confines the transaction to the state of its tenant
+// and returns the function name without the tenant
+func (t *DewalletChaincode) tenantScope(stub shim.ChaincodeStubInterface, function string) (shim.ChaincodeStubInterface, string, error) {
+	c, err := getConfig(stub)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var tenant string
+	switch c.Tenancy {
+	case "":
+		return stub, function, nil
+	case tenancyMSP:
+		tenant, err = cid.GetMSPID(stub)
+		if err != nil {
+			return nil, "", errors.New(fmt.Sprintf("Failed to get MSP ID %s", err))
+		}
+	case tenancyExplicit:
+		parts := strings.SplitN(function, "/", 2)
+		if len(parts) != 2 {
+			return nil, "", errors.New("Function must be named tenant/Function")
+		}
+		tenant, function = parts[0], parts[1]
+
+		mspID, err := cid.GetMSPID(stub)
+		if err != nil {
+			return nil, "", errors.New(fmt.Sprintf("Failed to get MSP ID %s", err))
+		}
+		if !containsString(c.Tenants[tenant], mspID) {
+			return nil, "", errors.New(fmt.Sprintf("MSP %s is not a member of tenant %s", mspID, tenant))
+		}
+	default:
+		return nil, "", errors.New(fmt.Sprintf("Unsupported tenancy %s", c.Tenancy))
+	}
+
+	if !tenantNamePattern.MatchString(tenant) {
+		return nil, "", errors.New(fmt.Sprintf("Invalid tenant %s", tenant))
+	}
+
+	return &tenantStub{ChaincodeStubInterface: stub, tenant: tenant}, function, nil
+}
+
+// tenantStub confines a transaction to the state of one tenant, the handlers
+// are unaware of it: identities are stored under tenant composite keys and
+// every other composite key gets the tenant as first attribute.
+// Other chaincodes it invokes aren't scoped, see walletID
+type tenantStub struct {
+	shim.ChaincodeStubInterface
+	tenant string
+}
+
+// tenantOf is the tenant stub of the transaction, under the one of a batch
+func tenantOf(stub shim.ChaincodeStubInterface) (*tenantStub, bool) {
+	if bs, ok := stub.(*batchStub); ok {
+		stub = bs.ChaincodeStubInterface
+	}
+
+	ts, ok := stub.(*tenantStub)
+	return ts, ok
+}
+
+// key maps a key of the handlers to the key in state
+func (s *tenantStub) key(key string) (string, error) {
+	if !strings.HasPrefix(key, compositeKeyNamespace) {
+		return s.ChaincodeStubInterface.CreateCompositeKey(tenantObjectType, []string{s.tenant, key})
+	}
+
+	_, attributes, err := s.ChaincodeStubInterface.SplitCompositeKey(key)
+	if err != nil {
+		return "", err
+	}
+	if len(attributes) == 0 || attributes[0] != s.tenant {
+		return "", errors.New("Key is outside of the tenant")
+	}
+
+	return key, nil
+}
+
+// outerKey maps a key in state back to the key of the handlers,
+// false when the key belongs to another tenant
+func (s *tenantStub) outerKey(key string) (string, bool) {
+	if !strings.HasPrefix(key, compositeKeyNamespace) {
+		return "", false
+	}
+
+	objectType, attributes, err := s.ChaincodeStubInterface.SplitCompositeKey(key)
+	if err != nil {
+		return "", false
+	}
+	if len(attributes) == 0 || attributes[0] != s.tenant {
+		return "", false
+	}
+
+	if objectType == tenantObjectType && len(attributes) == 2 {
+		return attributes[1], true
+	}
+
+	return key, true
+}
+
+func (s *tenantStub) CreateCompositeKey(objectType string, attributes []string) (string, error) {
+	return s.ChaincodeStubInterface.CreateCompositeKey(objectType, append([]string{s.tenant}, attributes...))
+}
+
+func (s *tenantStub) SplitCompositeKey(compositeKey string) (string, []string, error) {
+	objectType, attributes, err := s.ChaincodeStubInterface.SplitCompositeKey(compositeKey)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(attributes) == 0 || attributes[0] != s.tenant {
+		return "", nil, errors.New("Key is outside of the tenant")
+	}
+
+	return objectType, attributes[1:], nil
+}
+
+func (s *tenantStub) GetState(key string) ([]byte, error) {
+	k, err := s.key(key)
+	if err != nil {
+		return nil, err
+	}
+	return s.ChaincodeStubInterface.GetState(k)
+}
+
+func (s *tenantStub) PutState(key string, value []byte) error {
+	k, err := s.key(key)
+	if err != nil {
+		return err
+	}
+	return s.ChaincodeStubInterface.PutState(k, value)
+}
+
+func (s *tenantStub) DelState(key string) error {
+	k, err := s.key(key)
+	if err != nil {
+		return err
+	}
+	return s.ChaincodeStubInterface.DelState(k)
+}
+
+func (s *tenantStub) SetStateValidationParameter(key string, ep []byte) error {
+	k, err := s.key(key)
+	if err != nil {
+		return err
+	}
+	return s.ChaincodeStubInterface.SetStateValidationParameter(k, ep)
+}
+
+func (s *tenantStub) GetStateValidationParameter(key string) ([]byte, error) {
+	k, err := s.key(key)
+	if err != nil {
+		return nil, err
+	}
+	return s.ChaincodeStubInterface.GetStateValidationParameter(k)
+}
+
+func (s *tenantStub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	k, err := s.key(key)
+	if err != nil {
+		return nil, err
+	}
+	return s.ChaincodeStubInterface.GetHistoryForKey(k)
+}
+
+// GetStateByRange only ranges over the identities of the tenant
+func (s *tenantStub) GetStateByRange(startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	it, err := s.ChaincodeStubInterface.GetStateByPartialCompositeKey(tenantObjectType, []string{s.tenant})
+	if err != nil {
+		return nil, err
+	}
+	return &tenantIterator{StateQueryIteratorInterface: it, stub: s, start: startKey, end: endKey}, nil
+}
+
+// GetStateByRangeWithPagination pages through the identities of the tenant,
+// bookmarks are usernames like without tenancy
+func (s *tenantStub) GetStateByRangeWithPagination(startKey, endKey string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	if bookmark == "" {
+		bookmark = startKey
+	}
+
+	var innerBookmark string
+	if bookmark != "" {
+		var err error
+		innerBookmark, err = s.key(bookmark)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	it, m, err := s.ChaincodeStubInterface.GetStateByPartialCompositeKeyWithPagination(tenantObjectType, []string{s.tenant}, pageSize, innerBookmark)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if m != nil && m.Bookmark != "" {
+		outer, ok := s.outerKey(m.Bookmark)
+		if !ok {
+			outer = ""
+		}
+		m = &pb.QueryResponseMetadata{FetchedRecordsCount: m.FetchedRecordsCount, Bookmark: outer}
+	}
+
+	return &tenantIterator{StateQueryIteratorInterface: it, stub: s, end: endKey}, m, nil
+}
+
+func (s *tenantStub) GetStateByPartialCompositeKey(objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	return s.ChaincodeStubInterface.GetStateByPartialCompositeKey(objectType, append([]string{s.tenant}, keys...))
+}
+
+func (s *tenantStub) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	return s.ChaincodeStubInterface.GetStateByPartialCompositeKeyWithPagination(objectType, append([]string{s.tenant}, keys...), pageSize, bookmark)
+}
+
+// GetQueryResult drops the documents of other tenants from the results
+func (s *tenantStub) GetQueryResult(query string) (shim.StateQueryIteratorInterface, error) {
+	it, err := s.ChaincodeStubInterface.GetQueryResult(query)
+	if err != nil {
+		return nil, err
+	}
+	return &tenantIterator{StateQueryIteratorInterface: it, stub: s}, nil
+}
+
+func (s *tenantStub) GetQueryResultWithPagination(query string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	it, m, err := s.ChaincodeStubInterface.GetQueryResultWithPagination(query, pageSize, bookmark)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &tenantIterator{StateQueryIteratorInterface: it, stub: s}, m, nil
+}
+
+func (s *tenantStub) GetPrivateData(collection, key string) ([]byte, error) {
+	k, err := s.key(key)
+	if err != nil {
+		return nil, err
+	}
+	return s.ChaincodeStubInterface.GetPrivateData(collection, k)
+}
+
+func (s *tenantStub) GetPrivateDataHash(collection, key string) ([]byte, error) {
+	k, err := s.key(key)
+	if err != nil {
+		return nil, err
+	}
+	return s.ChaincodeStubInterface.GetPrivateDataHash(collection, k)
+}
+
+func (s *tenantStub) PutPrivateData(collection string, key string, value []byte) error {
+	k, err := s.key(key)
+	if err != nil {
+		return err
+	}
+	return s.ChaincodeStubInterface.PutPrivateData(collection, k, value)
+}
+
+func (s *tenantStub) DelPrivateData(collection, key string) error {
+	k, err := s.key(key)
+	if err != nil {
+		return err
+	}
+	return s.ChaincodeStubInterface.DelPrivateData(collection, k)
+}
+
+func (s *tenantStub) SetPrivateDataValidationParameter(collection, key string, ep []byte) error {
+	k, err := s.key(key)
+	if err != nil {
+		return err
+	}
+	return s.ChaincodeStubInterface.SetPrivateDataValidationParameter(collection, k, ep)
+}
+
+func (s *tenantStub) GetPrivateDataValidationParameter(collection, key string) ([]byte, error) {
+	k, err := s.key(key)
+	if err != nil {
+		return nil, err
+	}
+	return s.ChaincodeStubInterface.GetPrivateDataValidationParameter(collection, k)
+}
+
+func (s *tenantStub) GetPrivateDataByRange(collection, startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	it, err := s.ChaincodeStubInterface.GetPrivateDataByPartialCompositeKey(collection, tenantObjectType, []string{s.tenant})
+	if err != nil {
+		return nil, err
+	}
+	return &tenantIterator{StateQueryIteratorInterface: it, stub: s, start: startKey, end: endKey}, nil
+}
+
+func (s *tenantStub) GetPrivateDataByPartialCompositeKey(collection, objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	return s.ChaincodeStubInterface.GetPrivateDataByPartialCompositeKey(collection, objectType, append([]string{s.tenant}, keys...))
+}
+
+func (s *tenantStub) GetPrivateDataQueryResult(collection, query string) (shim.StateQueryIteratorInterface, error) {
+	it, err := s.ChaincodeStubInterface.GetPrivateDataQueryResult(collection, query)
+	if err != nil {
+		return nil, err
+	}
+	return &tenantIterator{StateQueryIteratorInterface: it, stub: s}, nil
+}
+
+// tenantIterator maps the keys of results back to the keys of the handlers,
+// skipping results of other tenants and, for ranges, outside start and end
+type tenantIterator struct {
+	shim.StateQueryIteratorInterface
+	stub  *tenantStub
+	start string
+	end   string
+
+	next *queryresult.KV
+	err  error
+	done bool
+}
+
+func (it *tenantIterator) HasNext() bool {
+	for it.next == nil && it.err == nil && !it.done && it.StateQueryIteratorInterface.HasNext() {
+		kv, err := it.StateQueryIteratorInterface.Next()
+		if err != nil {
+			it.err = err
+			break
+		}
+
+		key, ok := it.stub.outerKey(kv.Key)
+		if !ok || key < it.start {
+			continue
+		}
+		if it.end != "" && key >= it.end {
+			it.done = true
+			break
+		}
+
+		it.next = &queryresult.KV{Namespace: kv.Namespace, Key: key, Value: kv.Value}
+	}
+
+	return it.next != nil || it.err != nil
+}
+
+func (it *tenantIterator) Next() (*queryresult.KV, error) {
+	if !it.HasNext() {
+		return nil, errors.New("No more results")
+	}
+	if it.err != nil {
+		err := it.err
+		it.err = nil
+		return nil, err
+	}
+
+	kv := it.next
+	it.next = nil
+
+	return kv, nil
+}
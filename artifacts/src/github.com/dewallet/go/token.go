@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Functions of the token chaincode called by the identity operations,
+// each with a JSON argument
+const (
+	// tokenCreateWallet provisions the wallet of a registered identity
+	tokenCreateWallet = "CreateWallet"
+	// tokenSetWalletStatus blocks or unblocks the transfers of a wallet
+	tokenSetWalletStatus = "SetWalletStatus"
+)
+
+// Status of a wallet in the token chaincode
+const (
+	walletActive  = "active"
+	walletBlocked = "blocked"
+)
+
+// Wallet is the id of the wallet in the token chaincode, see walletID
+type createWalletRequest struct {
+	Wallet   string `json:"wallet"`
+	Username string `json:"username"`
+	Tenant   string `json:"tenant,omitempty"`
+	DID      string `json:"did"`
+	Org      string `json:"org"`
+}
+
+type setWalletStatusRequest struct {
+	Wallet   string `json:"wallet"`
+	Username string `json:"username"`
+	Tenant   string `json:"tenant,omitempty"`
+	Status   string `json:"status"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// walletTenant is the tenant of the transaction, none without tenancy
+func walletTenant(stub shim.ChaincodeStubInterface) string {
+	if ts, ok := tenantOf(stub); ok {
+		return ts.tenant
+	}
+
+	return ""
+}
+
+// walletID is the id of the wallet of username in the token chaincode,
+// prefixed with the tenant so tenants with the same usernames don't
+// share wallets, the token chaincode isn't scoped to a tenant
+func walletID(stub shim.ChaincodeStubInterface, username string) string {
+	if tenant := walletTenant(stub); tenant != "" {
+		return tenant + "/" + username
+	}
+
+	return username
+}
+
+// invokeToken calls a function of the configured token chaincode on the
+// channel, its writes are part of the transaction and its failure fails it,
+// nothing is called when no token chaincode is configured
+func invokeToken(stub shim.ChaincodeStubInterface, function string, req interface{}) ([]byte, error) {
+	c, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	if c.TokenChaincode == "" {
+		return nil, nil
+	}
+
+	reqBytes, err := marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	res := stub.InvokeChaincode(c.TokenChaincode, [][]byte{[]byte(function), reqBytes}, "")
+	if res.Status != shim.OK {
+		return nil, errors.New(fmt.Sprintf("Token chaincode %s failed %s %s", c.TokenChaincode, function, res.Message))
+	}
+
+	return res.Payload, nil
+}
+
+// setWalletStatus blocks or unblocks the wallet of username
+func setWalletStatus(stub shim.ChaincodeStubInterface, username string, status string, reason string) error {
+	_, err := invokeToken(stub, tokenSetWalletStatus, setWalletStatusRequest{
+		Wallet:   walletID(stub, username),
+		Username: username,
+		Tenant:   walletTenant(stub),
+		Status:   status,
+		Reason:   reason,
+	})
+	return err
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// correlationIDPattern matches the correlation ids clients may send,
+// such as W3C trace ids or UUIDs
+var correlationIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:\-]{1,128}$`)
+
+// requestCorrelationID is the correlation id of the first argument, the
+// signed envelope or the request of a query, empty when none or invalid
+func requestCorrelationID(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+
+	var r struct {
+		CorrelationID string `json:"correlationId"`
+	}
+	json.Unmarshal([]byte(args[0]), &r)
+
+	if !correlationIDPattern.MatchString(r.CorrelationID) {
+		return ""
+	}
+
+	return r.CorrelationID
+}
+
+// stubCorrelationID is the correlation id of the request of the transaction
+func stubCorrelationID(stub shim.ChaincodeStubInterface) string {
+	_, args := stub.GetFunctionAndParameters()
+
+	args, err := decodeCOSEArgs(args)
+	if err != nil {
+		return ""
+	}
+
+	return requestCorrelationID(args)
+}
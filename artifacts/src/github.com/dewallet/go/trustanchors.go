@@ -0,0 +1,385 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// trustAnchorObjectType prefixes the trust anchors by id
+const trustAnchorObjectType = "trustanchor"
+
+// trustAnchorChangeObjectType prefixes the pending changes to the trust anchors
+// by action and anchor id
+const trustAnchorChangeObjectType = "trustanchorchange"
+
+// maxCertificateChain bounds the certificates of a chain
+const maxCertificateChain = 8
+
+// Kinds of trust anchors
+const (
+	// trustAnchorCertificate is a root CA certificate, base64 DER
+	trustAnchorCertificate = "certificate"
+	// trustAnchorKey is a root public key, base64 PKIX
+	trustAnchorKey = "key"
+)
+
+// Actions of a trust anchor change
+const (
+	trustAnchorAdd    = "add"
+	trustAnchorRemove = "remove"
+)
+
+// TrustAnchor is a root certificates chains are validated against,
+// ID is the hex SHA-256 of its DER
+type TrustAnchor struct {
+	ID      string `json:"id"`
+	Kind    string `json:"kind"`
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	AddedAt int64  `json:"addedAt"`
+}
+
+// TrustAnchorChange adds or removes an anchor once admins
+// of a quorum of admin orgs voted for it
+type TrustAnchorChange struct {
+	Action string      `json:"action"`
+	Anchor TrustAnchor `json:"anchor"`
+
+	Votes
+}
+
+// getTrustAnchors reads every trust anchor
+func getTrustAnchors(stub shim.ChaincodeStubInterface) ([]TrustAnchor, error) {
+	it, err := stub.GetStateByPartialCompositeKey(trustAnchorObjectType, []string{})
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Can't query trust anchors %s", err))
+	}
+	defer it.Close()
+
+	anchors := []TrustAnchor{}
+	for it.HasNext() {
+		kv, err := it.Next()
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Can't query trust anchors %s", err))
+		}
+
+		var a TrustAnchor
+		err = json.Unmarshal(kv.Value, &a)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Error in parsing trust anchor %s", err))
+		}
+
+		anchors = append(anchors, a)
+	}
+
+	return anchors, nil
+}
+
+// keyAnchorParent stands for a root public key as the issuer of a certificate
+func keyAnchorParent(pk interface{}) (*x509.Certificate, error) {
+	parent := &x509.Certificate{PublicKey: pk, Version: 3, BasicConstraintsValid: true, IsCA: true}
+
+	switch pk.(type) {
+	case *rsa.PublicKey:
+		parent.PublicKeyAlgorithm = x509.RSA
+	case *ecdsa.PublicKey:
+		parent.PublicKeyAlgorithm = x509.ECDSA
+	case ed25519.PublicKey:
+		parent.PublicKeyAlgorithm = x509.Ed25519
+	default:
+		return nil, errors.New("Unsupported trust anchor key")
+	}
+
+	return parent, nil
+}
+
+// verifyCertificateChain checks a chain of base64 DER certificates, leaf first,
+// up to one of the trust anchors at the transaction time and returns the leaf
+func verifyCertificateChain(stub shim.ChaincodeStubInterface, chain []string) (*x509.Certificate, error) {
+	if len(chain) == 0 || len(chain) > maxCertificateChain {
+		return nil, errors.New(fmt.Sprintf("A certificate chain has 1 to %d certificates", maxCertificateChain))
+	}
+
+	certs := []*x509.Certificate{}
+	for _, c := range chain {
+		der, err := base64.StdEncoding.DecodeString(c)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Error in decoding certificate %s", err))
+		}
+
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Error in parsing certificate %s", err))
+		}
+
+		certs = append(certs, cert)
+	}
+
+	anchors, err := getTrustAnchors(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := txSeconds(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	leaf := certs[0]
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	roots := x509.NewCertPool()
+	for _, a := range anchors {
+		der, _ := base64.StdEncoding.DecodeString(a.Value)
+
+		switch a.Kind {
+		case trustAnchorCertificate:
+			root, err := x509.ParseCertificate(der)
+			if err == nil {
+				roots.AddCert(root)
+			}
+		case trustAnchorKey:
+			pk, err := x509.ParsePKIXPublicKey(der)
+			if err != nil {
+				continue
+			}
+
+			parent, err := keyAnchorParent(pk)
+			if err != nil {
+				continue
+			}
+
+			// the last certificate of the chain is signed by the root key
+			last := certs[len(certs)-1]
+			if last.CheckSignatureFrom(parent) == nil {
+				roots.AddCert(last)
+			}
+		}
+	}
+
+	_, err = leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   time.Unix(now, 0),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Certificate chain isn't trusted %s", err))
+	}
+
+	return leaf, nil
+}
+
+// checkCertificateChain checks, when the identity registered a certificate
+// chain, that it is still trusted and certifies the signing key
+func checkCertificateChain(stub shim.ChaincodeStubInterface, i Identity) error {
+	if len(i.CertificateChain) == 0 {
+		return nil
+	}
+
+	leaf, err := verifyCertificateChain(stub, i.CertificateChain)
+	if err != nil {
+		return err
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(leaf.PublicKey)
+	if err != nil || base64.StdEncoding.EncodeToString(der) != i.SPublicKey {
+		return errors.New("Certificate isn't for the signing key")
+	}
+
+	return nil
+}
+
+type changeTrustAnchorRequest struct {
+	Action string `json:"action"`
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+}
+
+// ChangeTrustAnchor will vote for adding or removing a trust anchor for the org
+// of the admin, the change is made once a quorum of admin orgs voted for it
+func (t *DewalletChaincode) ChangeTrustAnchor(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Changing trust anchors")
+
+	err := t.requireAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var r changeTrustAnchorRequest
+	json.Unmarshal([]byte(args[0]), &r)
+
+	if r.Action != trustAnchorAdd && r.Action != trustAnchorRemove {
+		return shim.Error(fmt.Sprintf("Unsupported trust anchor action %s", r.Action))
+	}
+
+	der, err := base64.StdEncoding.DecodeString(r.Value)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Error in decoding trust anchor %s", err))
+	}
+
+	switch r.Kind {
+	case trustAnchorCertificate:
+		root, err := x509.ParseCertificate(der)
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Error in parsing certificate %s", err))
+		}
+		if !root.IsCA {
+			return shim.Error("Trust anchor isn't a CA certificate")
+		}
+	case trustAnchorKey:
+		pk, err := x509.ParsePKIXPublicKey(der)
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Error in parsing public key %s", err))
+		}
+
+		_, err = keyAnchorParent(pk)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	default:
+		return shim.Error(fmt.Sprintf("Unsupported trust anchor kind %s", r.Kind))
+	}
+
+	err = checkSize("name", len(r.Name), maxDenylistValueLength)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	now, err := txSeconds(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	a := TrustAnchor{ID: sha256Hex(der), Kind: r.Kind, Name: r.Name, Value: r.Value, AddedAt: now}
+
+	aKey, err := stub.CreateCompositeKey(trustAnchorObjectType, []string{a.ID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	aBytes, err := stub.GetState(aKey)
+	if err != nil {
+		return shim.Error("Failed to get state")
+	}
+	if r.Action == trustAnchorAdd && aBytes != nil {
+		return shim.Error("Trust anchor already exists")
+	}
+	if r.Action == trustAnchorRemove && aBytes == nil {
+		return shim.Error("Trust anchor not found")
+	}
+
+	changeKey, err := stub.CreateCompositeKey(trustAnchorChangeObjectType, []string{r.Action, a.ID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	ch := TrustAnchorChange{Action: r.Action, Anchor: a}
+
+	chBytes, err := stub.GetState(changeKey)
+	if err != nil {
+		return shim.Error("Failed to get state")
+	}
+	if chBytes != nil {
+		err = json.Unmarshal(chBytes, &ch)
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Error in parsing trust anchor change %s", err))
+		}
+	}
+
+	passed, err := ch.vote(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	chBytes, _ = marshal(ch)
+
+	if !passed {
+		err = stub.PutState(changeKey, chBytes)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		return shim.Success(chBytes)
+	}
+
+	err = stub.DelState(changeKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if r.Action == trustAnchorRemove {
+		err = stub.DelState(aKey)
+	} else {
+		aBytes, _ = marshal(ch.Anchor)
+		err = stub.PutState(aKey, aBytes)
+	}
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(chBytes)
+}
+
+type getTrustAnchorsResponse struct {
+	Anchors []TrustAnchor       `json:"anchors"`
+	Pending []TrustAnchorChange `json:"pending"`
+}
+
+// GetTrustAnchors will query the blockchain and return
+// the trust anchors and the changes still collecting votes
+func (t *DewalletChaincode) GetTrustAnchors(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Querying trust anchors")
+
+	anchors, err := getTrustAnchors(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	now, err := txSeconds(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	it, err := stub.GetStateByPartialCompositeKey(trustAnchorChangeObjectType, []string{})
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't query trust anchor changes %s", err))
+	}
+	defer it.Close()
+
+	res := getTrustAnchorsResponse{Anchors: anchors, Pending: []TrustAnchorChange{}}
+	for it.HasNext() {
+		kv, err := it.Next()
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Can't query trust anchor changes %s", err))
+		}
+
+		var ch TrustAnchorChange
+		err = json.Unmarshal(kv.Value, &ch)
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Error in parsing trust anchor change %s", err))
+		}
+
+		if ch.pending(now) {
+			res.Pending = append(res.Pending, ch)
+		}
+	}
+
+	resBytes, _ := marshal(res)
+
+	return shim.Success(resBytes)
+}
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// uploadObjectType prefixes the uploads in progress by username and upload id
+const uploadObjectType = "upload"
+
+// uploadChunkObjectType prefixes the chunks of an upload by username,
+// upload id and zero padded index, so they are listed in order
+const uploadChunkObjectType = "uploadchunk"
+
+// dataManifestObjectType prefixes the manifests of the data committed
+// from an upload by username and slot, see DataManifest
+const dataManifestObjectType = "datamanifest"
+
+// Bounds of an upload, the assembled data is still checked against the limits
+const (
+	maxUploadChunks    = 256
+	maxUploadChunkSize = 256 * 1024
+	// uploadTTL is how many seconds an upload can be committed after it began
+	uploadTTL = 24 * 3600
+)
+
+// Upload is data being written to a slot across transactions, the chunks
+// are appended in any order and assembled by CommitDataUpload, which checks
+// the size and the hash declared by BeginDataUpload
+type Upload struct {
+	ID        string    `json:"id"`
+	Username  string    `json:"username"`
+	Slot      string    `json:"slot"`
+	Schema    SchemaRef `json:"schema"`
+	Retention int64     `json:"retention"`
+	Size      int       `json:"size"`
+	DataHash  string    `json:"dataHash"`
+	CreatedAt int64     `json:"createdAt"`
+	ExpiresAt int64     `json:"expiresAt"`
+}
+
+func uploadKey(stub shim.ChaincodeStubInterface, username string, id string) (string, error) {
+	return stub.CreateCompositeKey(uploadObjectType, []string{username, id})
+}
+
+func chunkKey(stub shim.ChaincodeStubInterface, username string, id string, index int) (string, error) {
+	return stub.CreateCompositeKey(uploadChunkObjectType, []string{username, id, fmt.Sprintf("%06d", index)})
+}
+
+// DataManifest is the data of a slot committed from an upload, kept as the
+// chunks of the upload and assembled on read so the commit doesn't write
+// the whole data again
+type DataManifest struct {
+	UploadID string `json:"uploadId"`
+	Chunks   int    `json:"chunks"`
+	Size     int    `json:"size"`
+	DataHash string `json:"dataHash"`
+}
+
+func dataManifestKey(stub shim.ChaincodeStubInterface, username string, slot string) (string, error) {
+	attributes := []string{username}
+	if slot != "" {
+		attributes = append(attributes, slot)
+	}
+
+	return stub.CreateCompositeKey(dataManifestObjectType, attributes)
+}
+
+// getDataManifest reads the manifest of the data in a slot, nil when
+// the data isn't kept in chunks
+func getDataManifest(stub shim.ChaincodeStubInterface, username string, slot string) (*DataManifest, error) {
+	key, err := dataManifestKey(stub, username, slot)
+	if err != nil {
+		return nil, err
+	}
+
+	mBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get state")
+	}
+	if mBytes == nil {
+		return nil, nil
+	}
+
+	var m DataManifest
+	err = json.Unmarshal(mBytes, &m)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error in parsing data manifest %s", err))
+	}
+
+	return &m, nil
+}
+
+// assembleData reads the chunks of the manifest in order
+func assembleData(stub shim.ChaincodeStubInterface, username string, m DataManifest) (string, error) {
+	var data strings.Builder
+	data.Grow(m.Size)
+
+	for index := 0; index < m.Chunks; index++ {
+		key, err := chunkKey(stub, username, m.UploadID, index)
+		if err != nil {
+			return "", err
+		}
+
+		chunk, err := stub.GetState(key)
+		if err != nil {
+			return "", errors.New("Failed to get state")
+		}
+		if chunk == nil {
+			return "", errors.New(fmt.Sprintf("Chunk %d of upload %s is missing", index, m.UploadID))
+		}
+		data.Write(chunk)
+	}
+
+	if data.Len() != m.Size {
+		return "", errors.New(fmt.Sprintf("Upload %s is %d bytes, %d were committed", m.UploadID, data.Len(), m.Size))
+	}
+
+	return data.String(), nil
+}
+
+// delDataManifest deletes the manifest of the data in a slot with its chunks
+func delDataManifest(stub shim.ChaincodeStubInterface, username string, slot string) error {
+	m, err := getDataManifest(stub, username, slot)
+	if err != nil || m == nil {
+		return err
+	}
+
+	for index := 0; index < m.Chunks; index++ {
+		key, err := chunkKey(stub, username, m.UploadID, index)
+		if err != nil {
+			return err
+		}
+
+		err = stub.DelState(key)
+		if err != nil {
+			return err
+		}
+	}
+
+	key, err := dataManifestKey(stub, username, slot)
+	if err != nil {
+		return err
+	}
+
+	return stub.DelState(key)
+}
+
+// getUpload reads an upload of the identity that can still be written at now
+func getUpload(stub shim.ChaincodeStubInterface, username string, id string, now int64) (Upload, error) {
+	key, err := uploadKey(stub, username, id)
+	if err != nil {
+		return Upload{}, err
+	}
+
+	uBytes, err := stub.GetState(key)
+	if err != nil {
+		return Upload{}, errors.New("Failed to get state")
+	}
+	if uBytes == nil {
+		return Upload{}, errors.New(fmt.Sprintf("Upload %s not found", id))
+	}
+
+	var u Upload
+	err = json.Unmarshal(uBytes, &u)
+	if err != nil {
+		return Upload{}, errors.New(fmt.Sprintf("Error in parsing upload %s", err))
+	}
+	if now >= u.ExpiresAt {
+		return Upload{}, errors.New(fmt.Sprintf("Upload %s has expired", id))
+	}
+
+	return u, nil
+}
+
+type beginDataUploadRequest struct {
+	Username  string    `json:"username"`
+	Slot      string    `json:"slot"`
+	Schema    SchemaRef `json:"schema"`
+	Retention int64     `json:"retention"`
+
+	// Size and DataHash are those of the whole ciphertext
+	Size     int    `json:"size"`
+	DataHash string `json:"dataHash"`
+}
+
+// BeginDataUpload will start writing data too large for one transaction to
+// a slot, the upload id is the transaction id. Data in private data mode
+// isn't uploaded in chunks, they would be on the ledger
+func (t *DewalletChaincode) BeginDataUpload(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Beginning data upload")
+
+	var r beginDataUploadRequest
+	env, err := t.ParseRequest(args, &r)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't parse request %s", err))
+	}
+
+	i, err := getStoredIdentity(stub, r.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = t.VerifyRequest(stub, args, env, i)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't verify signature %s", err))
+	}
+
+	if i.Collection != "" {
+		return shim.Error("Data in private data mode can't be uploaded in chunks")
+	}
+
+	if r.Slot != "" && !slotNamePattern.MatchString(r.Slot) {
+		return shim.Error(fmt.Sprintf("Invalid slot name %s", r.Slot))
+	}
+
+	err = validateSchemaRef(stub, r.Schema)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if r.Retention < 0 {
+		return shim.Error("Retention can't be negative")
+	}
+
+	if s, _ := i.slot(r.Slot); s.AppendOnly {
+		return shim.Error(fmt.Sprintf("Slot %s is append only", r.Slot))
+	}
+
+	l, err := getLimits(stub, i)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if r.Size < 1 || r.Size > maxUploadChunks*maxUploadChunkSize {
+		return shim.Error(fmt.Sprintf("Upload size must be between 1 and %d", maxUploadChunks*maxUploadChunkSize))
+	}
+
+	err = checkSize("data", r.Size, l.maxDataSize())
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if !hashPattern.MatchString(r.DataHash) {
+		return shim.Error("Data hash must be a hex SHA-256")
+	}
+
+	now, err := txSeconds(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	u := Upload{
+		ID:        stub.GetTxID(),
+		Username:  i.Username,
+		Slot:      r.Slot,
+		Schema:    r.Schema,
+		Retention: r.Retention,
+		Size:      r.Size,
+		DataHash:  r.DataHash,
+		CreatedAt: now,
+		ExpiresAt: now + uploadTTL,
+	}
+
+	key, err := uploadKey(stub, u.Username, u.ID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	uBytes, _ := marshal(u)
+	err = stub.PutState(key, uBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(uBytes)
+}
+
+type appendDataChunkRequest struct {
+	Username string `json:"username"`
+	UploadID string `json:"uploadId"`
+	Index    int    `json:"index"`
+	Chunk    string `json:"chunk"`
+}
+
+// AppendDataChunk will write a chunk of an upload at its index, chunks
+// don't depend on each other so they can be sent concurrently
+func (t *DewalletChaincode) AppendDataChunk(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Appending data upload chunk")
+
+	var r appendDataChunkRequest
+	env, err := t.ParseRequest(args, &r)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't parse request %s", err))
+	}
+
+	i, err := getStoredIdentity(stub, r.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = t.VerifyRequest(stub, args, env, i)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't verify signature %s", err))
+	}
+
+	now, err := txSeconds(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	u, err := getUpload(stub, i.Username, r.UploadID, now)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if r.Index < 0 || r.Index >= maxUploadChunks {
+		return shim.Error(fmt.Sprintf("Chunk index must be between 0 and %d", maxUploadChunks-1))
+	}
+
+	err = checkSize("chunk", len(r.Chunk), maxUploadChunkSize)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if r.Chunk == "" {
+		return shim.Error("Chunk is empty")
+	}
+
+	key, err := chunkKey(stub, u.Username, u.ID, r.Index)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = stub.PutState(key, []byte(r.Chunk))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+type commitDataUploadRequest struct {
+	Username string `json:"username"`
+	UploadID string `json:"uploadId"`
+
+	// Chunks is how many chunks the client sent, indexes 0 to Chunks-1
+	Chunks int `json:"chunks"`
+}
+
+// CommitDataUpload will make the chunks of an upload the data of its slot
+// once the data has the size and hash the upload declared. The chunks are
+// kept where they are with a manifest, see DataManifest, and the upload is deleted
+func (t *DewalletChaincode) CommitDataUpload(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Committing data upload")
+
+	var r commitDataUploadRequest
+	env, err := t.ParseRequest(args, &r)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't parse request %s", err))
+	}
+
+	i, err := getIdentity(stub, r.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = t.VerifyRequest(stub, args, env, i)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't verify signature %s", err))
+	}
+
+	now, err := txSeconds(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	u, err := getUpload(stub, i.Username, r.UploadID, now)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	it, err := stub.GetStateByPartialCompositeKey(uploadChunkObjectType, []string{u.Username, u.ID})
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't query chunks of upload %s %s", u.ID, err))
+	}
+	defer it.Close()
+
+	var data strings.Builder
+	chunks := 0
+	for it.HasNext() {
+		kv, err := it.Next()
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Can't query chunks of upload %s %s", u.ID, err))
+		}
+
+		want, err := chunkKey(stub, u.Username, u.ID, chunks)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if kv.Key != want {
+			return shim.Error(fmt.Sprintf("Chunk %d of upload %s is missing", chunks, u.ID))
+		}
+		data.Write(kv.Value)
+		chunks++
+	}
+
+	if chunks != r.Chunks {
+		return shim.Error(fmt.Sprintf("Upload %s has %d chunks, %d were sent", u.ID, chunks, r.Chunks))
+	}
+	if data.Len() != u.Size {
+		return shim.Error(fmt.Sprintf("Upload %s is %d bytes, %d were declared", u.ID, data.Len(), u.Size))
+	}
+	if sha256Hex([]byte(data.String())) != u.DataHash {
+		return shim.Error(fmt.Sprintf("Upload %s doesn't match its data hash", u.ID))
+	}
+
+	l, err := getLimits(stub, i)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = l.checkData("data", data.String())
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	prev, ok := i.slot(u.Slot)
+	if prev.AppendOnly {
+		return shim.Error(fmt.Sprintf("Slot %s is append only", u.Slot))
+	}
+	s := DataSlot{Hash: u.DataHash, Schema: u.Schema, Retention: u.Retention}
+	s.retain(now)
+
+	// the chunks replace the data of the slot, with those of an earlier upload
+	err = delSlotData(stub, i, u.Slot)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	mKey, err := dataManifestKey(stub, u.Username, u.Slot)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	mBytes, _ := marshal(DataManifest{UploadID: u.ID, Chunks: chunks, Size: u.Size, DataHash: u.DataHash})
+	err = stub.PutState(mKey, mBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	uKey, err := uploadKey(stub, u.Username, u.ID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = stub.DelState(uKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	changed := !ok || s != prev || (u.Slot == "" && i.Data != "")
+	i.setSlot(u.Slot, s)
+	if u.Slot == "" {
+		i.Data = ""
+	}
+
+	iBytes, _ := marshal(i)
+	if changed {
+		_, err = putIdentity(stub, i)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	return shim.Success(iBytes)
+}
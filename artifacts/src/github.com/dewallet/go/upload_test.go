@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestCommitDataUploadKeepsChunks checks a committed upload is read from
+// its chunks instead of being assembled, and that writing the slot again
+// drops them
+func TestCommitDataUploadKeepsChunks(t *testing.T) {
+	h := newHarness(t, time.Now().Unix())
+	alice := newFixture(t, "alice")
+	h.register(alice)
+
+	chunks := []string{strings.Repeat("a", 1000), strings.Repeat("b", 500)}
+	data := strings.Join(chunks, "")
+
+	uBytes := h.mustInvoke("BeginDataUpload", alice.request(t, h.now, beginDataUploadRequest{Username: "alice", Slot: "kyc", Size: len(data), DataHash: sha256Hex([]byte(data))})...)
+
+	var u Upload
+	err := json.Unmarshal(uBytes, &u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploadID := u.ID
+
+	for index, chunk := range chunks {
+		h.mustInvoke("AppendDataChunk", alice.request(t, h.now, appendDataChunkRequest{Username: "alice", UploadID: uploadID, Index: index, Chunk: chunk})...)
+	}
+
+	h.mustInvoke("CommitDataUpload", alice.request(t, h.now, commitDataUploadRequest{Username: "alice", UploadID: uploadID, Chunks: len(chunks)})...)
+
+	key, err := dataKey(h.stub, "alice", "kyc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.stub.State[key] != nil {
+		t.Fatal("Commit wrote the assembled data")
+	}
+
+	i, err := getIdentity(h.stub, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := getUserData(h.stub, i, "kyc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != data {
+		t.Fatal("Data read isn't the data uploaded")
+	}
+
+	// writing the slot again drops the chunks
+	h.mustInvoke("UpdateUserData", alice.request(t, h.now, updateUserDataRequest{Username: "alice", Slot: "kyc", Data: "small", DataHash: sha256Hex([]byte("small"))})...)
+
+	for index := range chunks {
+		key, err := chunkKey(h.stub, "alice", uploadID, index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.stub.State[key] != nil {
+			t.Fatalf("Chunk %d was kept", index)
+		}
+	}
+
+	i, err = getIdentity(h.stub, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = getUserData(h.stub, i, "kyc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "small" {
+		t.Fatal("Data read isn't the data written")
+	}
+}
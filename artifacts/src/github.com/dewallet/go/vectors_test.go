@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// vectorsPath is the file of the golden signature vectors, see cmd/dewallet-vectors
+var vectorsPath = filepath.Join("..", "cmd", "dewallet-vectors", "testdata", "vectors.json")
+
+// vectorsTimestamp is the timestamp of the envelopes of the vectors
+const vectorsTimestamp = 1700000000
+
+// vector is a signed Register envelope of a fixture identity,
+// the fields of cmd/dewallet-vectors the chaincode checks
+type vector struct {
+	Alg        string `json:"alg"`
+	Username   string `json:"username"`
+	PublicKey  string `json:"publicKey"`
+	QAlgorithm string `json:"qAlgorithm"`
+	Envelope   string `json:"envelope"`
+	Signature  string `json:"signature"`
+	QSignature string `json:"qSignature"`
+}
+
+// args are the arguments of Register of the vector
+func (v vector) args() []string {
+	if v.QSignature == "" {
+		return []string{v.Envelope, v.Signature}
+	}
+
+	return []string{v.Envelope, v.Signature, v.QSignature}
+}
+
+func loadVectors(t *testing.T) []vector {
+	b, err := ioutil.ReadFile(vectorsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var vectors []vector
+	err = json.Unmarshal(b, &vectors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return vectors
+}
+
+// TestVectorsCoverAlgorithms checks there is a vector for every
+// algorithm of the registry
+func TestVectorsCoverAlgorithms(t *testing.T) {
+	covered := map[string]bool{}
+	for _, v := range loadVectors(t) {
+		covered[v.Alg] = true
+		covered[v.QAlgorithm] = true
+	}
+
+	for alg := range signatureAlgorithms {
+		if !covered[alg] {
+			t.Errorf("No vector for %s", alg)
+		}
+	}
+}
+
+// TestVectorsRegister registers the fixture identity of each vector,
+// verified by VerifyRequest as a client would send it
+func TestVectorsRegister(t *testing.T) {
+	for _, v := range loadVectors(t) {
+		v := v
+		t.Run(v.Username, func(t *testing.T) {
+			h := newHarness(t, vectorsTimestamp)
+
+			h.mustInvoke("Register", v.args()...)
+
+			i, err := getIdentity(h.stub, v.Username)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if i.SPublicKey != v.PublicKey || i.QAlgorithm != v.QAlgorithm {
+				t.Fatalf("Unexpected identity %+v", i)
+			}
+
+			// the nonce of the envelope is used
+			res := h.invoke("Register", v.args()...)
+			if res.Status == shim.OK {
+				t.Fatal("Replayed envelope was accepted")
+			}
+		})
+	}
+}
+
+// TestVectorsTampered checks VerifyRequest refuses each vector
+// with a changed signature, and without its post-quantum one
+func TestVectorsTampered(t *testing.T) {
+	for _, v := range loadVectors(t) {
+		v := v
+		t.Run(v.Username, func(t *testing.T) {
+			tampered := v
+			tampered.Signature = flipHex(v.Signature)
+
+			res := newHarness(t, vectorsTimestamp).invoke("Register", tampered.args()...)
+			if res.Status == shim.OK {
+				t.Fatal("Tampered signature was accepted")
+			}
+
+			if v.QSignature == "" {
+				return
+			}
+
+			tampered = v
+			tampered.QSignature = flipHex(v.QSignature)
+
+			res = newHarness(t, vectorsTimestamp).invoke("Register", tampered.args()...)
+			if res.Status == shim.OK {
+				t.Fatal("Tampered post-quantum signature was accepted")
+			}
+
+			res = newHarness(t, vectorsTimestamp).invoke("Register", v.Envelope, v.Signature)
+			if res.Status == shim.OK {
+				t.Fatal("Missing post-quantum signature was accepted")
+			}
+		})
+	}
+}
+
+// flipHex changes the last hex digit of s
+func flipHex(s string) string {
+	last := s[len(s)-1]
+	if last == '0' {
+		last = '1'
+	} else {
+		last = '0'
+	}
+
+	return s[:len(s)-1] + string(last)
+}
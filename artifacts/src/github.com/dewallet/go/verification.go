@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// verifiedObjectType indexes the usernames by verification status
+// for LevelDB, where identities can't be selected on
+const verifiedObjectType = "verified"
+
+// Verification levels, from the lowest to the highest
+const (
+	verificationNone     = "none"
+	verificationBasic    = "basic"
+	verificationKYC      = "kyc"
+	verificationEnhanced = "enhanced"
+)
+
+var verificationLevels = []string{verificationNone, verificationBasic, verificationKYC, verificationEnhanced}
+
+// verificationRank orders the levels, -1 for an unknown level
+func verificationRank(level string) int {
+	for k, l := range verificationLevels {
+		if l == level {
+			return k
+		}
+	}
+
+	return -1
+}
+
+// atLeast tells whether level is level min or higher, any level is at least the empty one
+func atLeast(level string, min string) bool {
+	return min == "" || verificationRank(level) >= verificationRank(min)
+}
+
+// verificationLevel is the highest level of the active verifications
+// of the identity at now, none without any
+func (i Identity) verificationLevel(now int64) string {
+	return i.verificationLevelBy(now, nil)
+}
+
+// verificationLevelBy is the level of the identity counting only
+// the verifications of the given verifiers, of all when none are given
+func (i Identity) verificationLevelBy(now int64, verifiers []string) string {
+	level := verificationNone
+	for _, v := range i.Verifications {
+		if !v.active(now) || (len(verifiers) > 0 && !containsString(verifiers, v.Verifier)) {
+			continue
+		}
+		if verificationRank(v.Level) > verificationRank(level) {
+			level = v.Level
+		}
+	}
+
+	return level
+}
+
+type queryByVerificationRequest struct {
+	Verified string `json:"verified"`
+
+	// RegisteredBefore only keeps the identities registered
+	// before this unix time, when given
+	RegisteredBefore int64 `json:"registeredBefore"`
+
+	pageRequest
+	fieldMask
+}
+
+// QueryByVerification will page through the identities with a verification status,
+// an empty status lists the unverified identities, admin only
+func (t *DewalletChaincode) QueryByVerification(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Querying identities by verification")
+
+	err := t.requireAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var req queryByVerificationRequest
+	json.Unmarshal([]byte(args[0]), &req)
+
+	err = req.check(identitySummary{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	size, err := req.size()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	match := func(i Identity) bool {
+		return i.Verified == req.Verified && (req.RegisteredBefore == 0 || i.Registered < req.RegisteredBefore)
+	}
+
+	c, err := getConfig(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if richQueries(c) {
+		selector := map[string]interface{}{
+			"docType":  identityDocType,
+			"verified": req.Verified,
+		}
+		if req.RegisteredBefore != 0 {
+			selector["registered"] = map[string]interface{}{"$lt": req.RegisteredBefore}
+		}
+		q, _ := marshal(map[string]interface{}{"selector": selector})
+
+		it, m, err := stub.GetQueryResultWithPagination(string(q), size, req.Bookmark)
+		if err == nil {
+			defer it.Close()
+
+			identities, err := collectIdentities(stub, it, match)
+			if err != nil {
+				return shim.Error(fmt.Sprintf("Can't query identities %s", err))
+			}
+
+			return identityPage(identities, m, req.fieldMask)
+		}
+
+		// LevelDB doesn't support rich queries
+		logger.Debugf("Rich query failed, using the verification index %s", err)
+	}
+
+	identities, m, err := verifiedIdentities(stub, req.Verified, size, req.Bookmark, match)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't query identities %s", err))
+	}
+
+	return identityPage(identities, m, req.fieldMask)
+}
+
+// verifiedIdentities pages through the verification index of a status,
+// the identities of the page that match are returned
+func verifiedIdentities(stub shim.ChaincodeStubInterface, verified string, size int32, bookmark string, match func(Identity) bool) ([]Identity, *pb.QueryResponseMetadata, error) {
+	it, m, err := stub.GetStateByPartialCompositeKeyWithPagination(verifiedObjectType, []string{verified}, size, bookmark)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer it.Close()
+
+	identities := []Identity{}
+	for it.HasNext() {
+		kv, err := it.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		_, attrs, err := stub.SplitCompositeKey(kv.Key)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		i, err := getIdentity(stub, attrs[1])
+		if err != nil {
+			return nil, nil, errors.New(fmt.Sprintf("Can't get %s %s", attrs[1], err))
+		}
+
+		if match(i) {
+			identities = append(identities, i)
+		}
+	}
+
+	return identities, m, nil
+}
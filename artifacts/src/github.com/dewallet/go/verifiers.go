@@ -0,0 +1,765 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// verifierObjectType prefixes the entries of the verifier registry
+const verifierObjectType = "verifier"
+
+// verifierChangeObjectType prefixes the pending changes to the verifier registry
+// by action, kind and name
+const verifierChangeObjectType = "verifierchange"
+
+// Actions of a verifier change
+const (
+	verifierAdd    = "add"
+	verifierRemove = "remove"
+)
+
+// Kinds of verifier registry entries
+const (
+	// verifierKindOrg approves every identity registered by an MSP
+	verifierKindOrg = "org"
+	// verifierKindIdentity approves a single username
+	verifierKindIdentity = "identity"
+)
+
+// Verifier is an entry of the registry of those trusted
+// to set verification statuses and co-sign attestations
+type Verifier struct {
+	Kind    string `json:"kind"`
+	Name    string `json:"name"`
+	AddedAt int64  `json:"addedAt"`
+}
+
+func verifierKey(stub shim.ChaincodeStubInterface, kind string, name string) (string, error) {
+	return stub.CreateCompositeKey(verifierObjectType, []string{kind, name})
+}
+
+// getVerifier reads an entry of the registry, nil when there is none
+func getVerifier(stub shim.ChaincodeStubInterface, kind string, name string) (*Verifier, error) {
+	key, err := verifierKey(stub, kind, name)
+	if err != nil {
+		return nil, err
+	}
+
+	vBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get state")
+	}
+	if vBytes == nil {
+		return nil, nil
+	}
+
+	var v Verifier
+	err = json.Unmarshal(vBytes, &v)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error in parsing verifier %s", err))
+	}
+
+	return &v, nil
+}
+
+// requireVerifier checks that the identity is in the registry
+// by its username or by the org that registered it, and that
+// its certificate chain, if it has one, is still trusted
+func requireVerifier(stub shim.ChaincodeStubInterface, i Identity) error {
+	err := checkCertificateChain(stub, i)
+	if err != nil {
+		return err
+	}
+
+	v, err := getVerifier(stub, verifierKindIdentity, i.Username)
+	if err != nil {
+		return err
+	}
+	if v != nil {
+		return nil
+	}
+
+	if i.Org != "" {
+		v, err = getVerifier(stub, verifierKindOrg, i.Org)
+		if err != nil {
+			return err
+		}
+		if v != nil {
+			return nil
+		}
+	}
+
+	return errors.New(fmt.Sprintf("%s is not an approved verifier", i.Username))
+}
+
+// VerifierChange adds or removes a verifier once admins
+// of a quorum of admin orgs voted for it
+type VerifierChange struct {
+	Action   string   `json:"action"`
+	Verifier Verifier `json:"verifier"`
+
+	Votes
+}
+
+// voteVerifierChange records the vote of the org of the admin
+// for a change and makes it once it passed
+func voteVerifierChange(stub shim.ChaincodeStubInterface, action string, v Verifier) ([]byte, error) {
+	changeKey, err := stub.CreateCompositeKey(verifierChangeObjectType, []string{action, v.Kind, v.Name})
+	if err != nil {
+		return nil, err
+	}
+
+	ch := VerifierChange{Action: action, Verifier: v}
+
+	chBytes, err := stub.GetState(changeKey)
+	if err != nil {
+		return nil, errors.New("Failed to get state")
+	}
+	if chBytes != nil {
+		err = json.Unmarshal(chBytes, &ch)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Error in parsing verifier change %s", err))
+		}
+	}
+
+	passed, err := ch.vote(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	chBytes, _ = marshal(ch)
+
+	if !passed {
+		return chBytes, stub.PutState(changeKey, chBytes)
+	}
+
+	err = stub.DelState(changeKey)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := verifierKey(stub, v.Kind, v.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	if action == verifierRemove {
+		return chBytes, stub.DelState(key)
+	}
+
+	// the entry is added when the change passed
+	ch.Verifier.AddedAt, err = txSeconds(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	vBytes, _ := marshal(ch.Verifier)
+	return chBytes, stub.PutState(key, vBytes)
+}
+
+type verifierRequest struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+// AddVerifier will vote for approving an org or a username as verifier for
+// the org of the admin, it is approved once a quorum of admin orgs voted for it
+func (t *DewalletChaincode) AddVerifier(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Adding a verifier")
+
+	err := t.requireAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var r verifierRequest
+	json.Unmarshal([]byte(args[0]), &r)
+
+	switch r.Kind {
+	case verifierKindOrg:
+		if !tenantNamePattern.MatchString(r.Name) {
+			return shim.Error(fmt.Sprintf("Invalid MSP ID %s", r.Name))
+		}
+	case verifierKindIdentity:
+		_, err = getIdentity(stub, r.Name)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	default:
+		return shim.Error(fmt.Sprintf("Unsupported verifier kind %s", r.Kind))
+	}
+
+	v, err := getVerifier(stub, r.Kind, r.Name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if v != nil {
+		return shim.Error("Verifier already exists")
+	}
+
+	chBytes, err := voteVerifierChange(stub, verifierAdd, Verifier{Kind: r.Kind, Name: r.Name})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(chBytes)
+}
+
+// RemoveVerifier will vote for withdrawing the approval of an org or a username
+// for the org of the admin, it is withdrawn once a quorum of admin orgs voted
+// for it, the statuses and attestations it already gave are kept
+func (t *DewalletChaincode) RemoveVerifier(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Removing a verifier")
+
+	err := t.requireAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var r verifierRequest
+	json.Unmarshal([]byte(args[0]), &r)
+
+	v, err := getVerifier(stub, r.Kind, r.Name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if v == nil {
+		return shim.Error("Verifier not found")
+	}
+
+	chBytes, err := voteVerifierChange(stub, verifierRemove, *v)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(chBytes)
+}
+
+// GetVerifierChanges will query the blockchain and return
+// the changes to the verifier registry still collecting votes
+func (t *DewalletChaincode) GetVerifierChanges(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Querying verifier changes")
+
+	now, err := txSeconds(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	it, err := stub.GetStateByPartialCompositeKey(verifierChangeObjectType, []string{})
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't query verifier changes %s", err))
+	}
+	defer it.Close()
+
+	res := []VerifierChange{}
+	for it.HasNext() {
+		kv, err := it.Next()
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Can't query verifier changes %s", err))
+		}
+
+		var ch VerifierChange
+		err = json.Unmarshal(kv.Value, &ch)
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Error in parsing verifier change %s", err))
+		}
+
+		if ch.pending(now) {
+			res = append(res, ch)
+		}
+	}
+
+	resBytes, _ := marshal(res)
+
+	return shim.Success(resBytes)
+}
+
+// GetVerifiers will query the blockchain
+// and return every entry of the verifier registry
+func (t *DewalletChaincode) GetVerifiers(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Querying verifiers")
+
+	it, err := stub.GetStateByPartialCompositeKey(verifierObjectType, []string{})
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't query verifiers %s", err))
+	}
+	defer it.Close()
+
+	res := []Verifier{}
+	for it.HasNext() {
+		kv, err := it.Next()
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Can't query verifiers %s", err))
+		}
+
+		var v Verifier
+		err = json.Unmarshal(kv.Value, &v)
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Error in parsing verifier %s", err))
+		}
+
+		res = append(res, v)
+	}
+
+	resBytes, _ := marshal(res)
+
+	return shim.Success(resBytes)
+}
+
+// Verification records who gave an identity a verification level,
+// when, until when, and the hash of the evidence it was verified against,
+// an identity keeps one verification of each verifier,
+// Provider is the KYC provider the verifier gave it for, if any
+type Verification struct {
+	Level        string `json:"level"`
+	Verifier     string `json:"verifier"`
+	VerifiedAt   int64  `json:"verifiedAt"`
+	ExpiresAt    int64  `json:"expiresAt,omitempty"`
+	EvidenceHash string `json:"evidenceHash"`
+	TxID         string `json:"txId"`
+	RevokedAt    int64  `json:"revokedAt,omitempty"`
+	Provider     string `json:"provider,omitempty"`
+	SuspendedAt  int64  `json:"suspendedAt,omitempty"`
+}
+
+// active tells whether the verification counts at now
+func (v Verification) active(now int64) bool {
+	return v.RevokedAt == 0 && v.SuspendedAt == 0 && (v.ExpiresAt == 0 || now < v.ExpiresAt) && verificationRank(v.Level) >= 0
+}
+
+// putVerification replaces the verification of the same verifier
+// and sets the verification status to the level of the identity
+func (i *Identity) putVerification(v Verification, now int64) {
+	verifications := []Verification{}
+	for _, o := range i.Verifications {
+		if o.Verifier != v.Verifier {
+			verifications = append(verifications, o)
+		}
+	}
+	i.Verifications = append(verifications, v)
+	i.Verified = i.verificationLevel(now)
+}
+
+// verificationExpiry is when a verification given at now expires,
+// at expiresAt when the verifier gave it, after the configured validity otherwise
+func verificationExpiry(stub shim.ChaincodeStubInterface, now int64, expiresAt int64) (int64, error) {
+	if expiresAt != 0 {
+		if expiresAt <= now {
+			return 0, errors.New("Verification expires in the past")
+		}
+		return expiresAt, nil
+	}
+
+	c, err := getConfig(stub)
+	if err != nil {
+		return 0, err
+	}
+	if c.VerificationValidity == 0 {
+		return 0, nil
+	}
+
+	return now + c.VerificationValidity, nil
+}
+
+type setVerificationRequest struct {
+	Verifier     string `json:"verifier"`
+	Username     string `json:"username"`
+	Level        string `json:"level"`
+	ExpiresAt    int64  `json:"expiresAt"`
+	EvidenceHash string `json:"evidenceHash"`
+
+	// Provider is the id of the KYC provider the verification is for, optional
+	Provider string `json:"provider"`
+
+	// Receipt is the signature of a receipt for the user, see putReceipt
+	Receipt *receiptSignature `json:"receipt"`
+}
+
+// SetVerification will record the verification of a user by a verifier,
+// replacing the previous one of the verifier, the request is signed by an
+// approved verifier, Verified is the highest level for the queries by status
+func (t *DewalletChaincode) SetVerification(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Setting verification of user")
+
+	var r setVerificationRequest
+	env, err := t.ParseRequest(args, &r)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't parse request %s", err))
+	}
+
+	vi, err := getIdentity(stub, r.Verifier)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't get verifier %s %s", r.Verifier, err))
+	}
+
+	err = t.VerifyRequest(stub, args, env, vi)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't verify signature %s", err))
+	}
+
+	err = requireVerifier(stub, vi)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	i, err := getIdentity(stub, r.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if verificationRank(r.Level) < 0 {
+		return shim.Error(fmt.Sprintf("Unsupported verification level %s", r.Level))
+	}
+
+	if r.Provider != "" {
+		err = checkKYCProvider(stub, r.Provider, vi.Username, r.Level)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	now, err := txSeconds(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	expiresAt, err := verificationExpiry(stub, now, r.ExpiresAt)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	v := Verification{
+		Level:        r.Level,
+		Verifier:     vi.Username,
+		VerifiedAt:   now,
+		ExpiresAt:    expiresAt,
+		EvidenceHash: r.EvidenceHash,
+		TxID:         stub.GetTxID(),
+		Provider:     r.Provider,
+	}
+
+	err = putReceipt(stub, i.Username, vi, v, r.Receipt)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	prev := i
+	i.putVerification(v, now)
+
+	err = updateIndexes(stub, prev, i)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = audit(stub, i.Username, vi.Username, "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	iBytes, err := putIdentity(stub, i)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(iBytes)
+}
+
+type renewVerificationRequest struct {
+	Verifier     string `json:"verifier"`
+	Username     string `json:"username"`
+	ExpiresAt    int64  `json:"expiresAt"`
+	EvidenceHash string `json:"evidenceHash"`
+
+	// Receipt is the signature of a receipt for the user, see putReceipt
+	Receipt *receiptSignature `json:"receipt"`
+}
+
+// RenewVerification will extend the verification a verifier gave a user,
+// even once expired, at the same level and against new evidence,
+// the request is signed by the verifier which must still be approved
+func (t *DewalletChaincode) RenewVerification(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Renewing verification of user")
+
+	var r renewVerificationRequest
+	env, err := t.ParseRequest(args, &r)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't parse request %s", err))
+	}
+
+	vi, err := getIdentity(stub, r.Verifier)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't get verifier %s %s", r.Verifier, err))
+	}
+
+	err = t.VerifyRequest(stub, args, env, vi)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't verify signature %s", err))
+	}
+
+	err = requireVerifier(stub, vi)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	i, err := getIdentity(stub, r.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var v *Verification
+	for k := range i.Verifications {
+		if i.Verifications[k].Verifier == vi.Username && i.Verifications[k].RevokedAt == 0 {
+			v = &i.Verifications[k]
+		}
+	}
+	if v == nil {
+		return shim.Error("Verification not found")
+	}
+
+	if v.Provider != "" {
+		err = checkKYCProvider(stub, v.Provider, vi.Username, v.Level)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	now, err := txSeconds(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	expiresAt, err := verificationExpiry(stub, now, r.ExpiresAt)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	prev := i
+	renewed := *v
+	renewed.VerifiedAt = now
+	renewed.ExpiresAt = expiresAt
+	renewed.EvidenceHash = r.EvidenceHash
+	renewed.TxID = stub.GetTxID()
+	i.putVerification(renewed, now)
+
+	err = putReceipt(stub, i.Username, vi, renewed, r.Receipt)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = updateIndexes(stub, prev, i)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = audit(stub, i.Username, vi.Username, "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	iBytes, err := putIdentity(stub, i)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(iBytes)
+}
+
+type revokeVerificationRequest struct {
+	Verifier string `json:"verifier"`
+	Username string `json:"username"`
+}
+
+// RevokeVerification will revoke the verification a verifier gave a user,
+// those of other verifiers are kept, the request is signed by the verifier
+func (t *DewalletChaincode) RevokeVerification(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Revoking verification of user")
+
+	var r revokeVerificationRequest
+	env, err := t.ParseRequest(args, &r)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't parse request %s", err))
+	}
+
+	vi, err := getIdentity(stub, r.Verifier)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't get verifier %s %s", r.Verifier, err))
+	}
+
+	err = t.VerifyRequest(stub, args, env, vi)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Can't verify signature %s", err))
+	}
+
+	i, err := getIdentity(stub, r.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	now, err := txSeconds(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var v *Verification
+	for k := range i.Verifications {
+		if i.Verifications[k].Verifier == vi.Username && i.Verifications[k].RevokedAt == 0 {
+			v = &i.Verifications[k]
+		}
+	}
+	if v == nil {
+		return shim.Error("Verification not found")
+	}
+
+	prev := i
+	revoked := *v
+	revoked.RevokedAt = now
+	i.putVerification(revoked, now)
+
+	err = putReceipt(stub, i.Username, vi, revoked, nil)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = updateIndexes(stub, prev, i)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = audit(stub, i.Username, vi.Username, "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	iBytes, err := putIdentity(stub, i)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(iBytes)
+}
+
+type getVerificationsRequest struct {
+	Username string `json:"username"`
+
+	// Verifiers are those the relying party trusts, every verifier when empty
+	Verifiers []string `json:"verifiers"`
+}
+
+type getVerificationsResponse struct {
+	Level         string         `json:"level"`
+	Verifications []Verification `json:"verifications"`
+}
+
+// GetVerifications will query the blockchain and return the verifications
+// of a user by the given verifiers, with the level they give it
+func (t *DewalletChaincode) GetVerifications(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Querying verifications of user")
+
+	var req getVerificationsRequest
+	json.Unmarshal([]byte(args[0]), &req)
+
+	i, err := getIdentity(stub, req.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	now, err := txSeconds(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	res := getVerificationsResponse{Level: i.verificationLevelBy(now, req.Verifiers), Verifications: []Verification{}}
+	for _, v := range i.Verifications {
+		if len(req.Verifiers) == 0 || containsString(req.Verifiers, v.Verifier) {
+			res.Verifications = append(res.Verifications, v)
+		}
+	}
+
+	resBytes, _ := marshal(res)
+
+	return shim.Success(resBytes)
+}
+
+type getVerificationSummaryRequest struct {
+	Username string `json:"username"`
+
+	// Verifiers are those the relying party trusts, every verifier when empty
+	Verifiers []string `json:"verifiers"`
+	// MinLevel is the level the relying party requires, when given
+	MinLevel string `json:"minLevel"`
+}
+
+// VerificationSummary is the verification status of an identity without
+// the evidence, ExpiresAt is when its level drops, never when 0
+type VerificationSummary struct {
+	Username     string   `json:"username"`
+	Level        string   `json:"level"`
+	VerifierOrgs []string `json:"verifierOrgs"`
+	ExpiresAt    int64    `json:"expiresAt"`
+	MeetsLevel   bool     `json:"meetsLevel"`
+}
+
+// GetVerificationSummary will query the blockchain and return the level
+// of a user by the given verifiers, the orgs of the verifiers giving it
+// and until when, for the orgs the access policy of the user allows
+func (t *DewalletChaincode) GetVerificationSummary(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Querying verification summary of user")
+
+	var req getVerificationSummaryRequest
+	json.Unmarshal([]byte(args[0]), &req)
+
+	if req.MinLevel != "" && verificationRank(req.MinLevel) < 0 {
+		return shim.Error(fmt.Sprintf("Unsupported verification level %s", req.MinLevel))
+	}
+
+	i, err := getIdentity(stub, req.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = checkQueryPolicy(stub, i)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	now, err := txSeconds(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	level := i.verificationLevelBy(now, req.Verifiers)
+	res := VerificationSummary{
+		Username:     i.Username,
+		Level:        level,
+		VerifierOrgs: []string{},
+		MeetsLevel:   atLeast(level, req.MinLevel),
+	}
+
+	found := false
+	for _, v := range i.Verifications {
+		if v.Level != level || !v.active(now) || (len(req.Verifiers) > 0 && !containsString(req.Verifiers, v.Verifier)) {
+			continue
+		}
+
+		// the level lasts as long as the last verification giving it
+		if !found || res.ExpiresAt != 0 && (v.ExpiresAt == 0 || v.ExpiresAt > res.ExpiresAt) {
+			res.ExpiresAt = v.ExpiresAt
+		}
+		found = true
+
+		vi, err := getIdentity(stub, v.Verifier)
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Can't get verifier %s %s", v.Verifier, err))
+		}
+		if !containsString(res.VerifierOrgs, vi.Org) {
+			res.VerifierOrgs = append(res.VerifierOrgs, vi.Org)
+		}
+	}
+
+	resBytes, _ := marshal(res)
+
+	return shim.Success(resBytes)
+}
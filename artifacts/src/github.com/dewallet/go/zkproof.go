@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// proofAttesterPrefix is the attester of the claims recorded from verified
+// proofs, followed by the proof system, usernames can't take it
+const proofAttesterPrefix = "zkp:"
+
+// maxProofLength bounds the proofs submitted, in bytes
+const maxProofLength = 65536
+
+// proofVerifier verifies a zero-knowledge proof that the value committed to
+// satisfies the predicate with its params, such as age-over with
+// {"threshold":18}, commitment is the hex commitment of the attribute
+type proofVerifier func(predicate string, params json.RawMessage, commitment string, proof []byte) error
+
+// proofSystems is the registry of proof systems a proof may declare,
+// deployments register theirs from an init function
+var proofSystems = map[string]proofVerifier{}
+
+// registerProofSystem adds a proof system to the registry
+func registerProofSystem(name string, v proofVerifier) {
+	if _, ok := proofSystems[name]; ok {
+		panic(fmt.Sprintf("proof system %s registered twice", name))
+	}
+
+	proofSystems[name] = v
+}
+
+// verifyProof looks up the proof system in the registry and verifies with it
+func verifyProof(system string, predicate string, params json.RawMessage, commitment string, proof []byte) error {
+	v, ok := proofSystems[system]
+	if !ok {
+		return errors.New(fmt.Sprintf("Unsupported proof system %s", system))
+	}
+
+	err := v(predicate, params, commitment, proof)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error in verifying proof %s", err))
+	}
+
+	return nil
+}
+
+type verifyPredicateProofRequest struct {
+	Username  string          `json:"username"`
+	Attribute string          `json:"attribute"`
+	System    string          `json:"system"`
+	Predicate string          `json:"predicate"`
+	Params    json.RawMessage `json:"params"`
+	Proof     string          `json:"proof"`
+	ExpiresAt int64           `json:"expiresAt"`
+}
+
+// VerifyPredicateProof will verify a proof that an attribute of a user
+// satisfies a predicate against its commitment and record it as a claim
+// of the predicate, anyone the user gave the proof to can submit it
+func (t *DewalletChaincode) VerifyPredicateProof(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("Verifying predicate proof of user")
+
+	var r verifyPredicateProofRequest
+	json.Unmarshal([]byte(args[0]), &r)
+
+	i, err := getIdentity(stub, r.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if !claimTypePattern.MatchString(r.Predicate) {
+		return shim.Error(fmt.Sprintf("Invalid predicate %s", r.Predicate))
+	}
+
+	commitment, ok := i.Commitments[r.Attribute]
+	if !ok {
+		return shim.Error(fmt.Sprintf("No commitment to attribute %s", r.Attribute))
+	}
+
+	proof, err := base64.StdEncoding.DecodeString(r.Proof)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Error in decoding proof %s", err))
+	}
+
+	err = checkSize("proof", len(proof), maxProofLength)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	now, err := txSeconds(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if r.ExpiresAt != 0 && r.ExpiresAt <= now {
+		return shim.Error("Claim expires in the past")
+	}
+
+	err = verifyProof(r.System, r.Predicate, r.Params, commitment, proof)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	c := Claim{
+		Username:     i.Username,
+		Type:         r.Predicate,
+		Attester:     proofAttesterPrefix + r.System,
+		EvidenceHash: sha256Hex(proof),
+		IssuedAt:     now,
+		ExpiresAt:    r.ExpiresAt,
+	}
+
+	cBytes, err := putClaim(stub, c)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = auditCreator(stub, i.Username)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(cBytes)
+}